@@ -2,8 +2,10 @@ package block
 
 import (
 	"bytes"
+	"fmt"
 	"math/big"
 
+	"github.com/ravdin/programmingbitcoin/tx"
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
@@ -23,6 +25,8 @@ type Block struct {
 	Bits       [4]byte
 	Nonce      [4]byte
 	TxHashes   [][]byte
+	// Transactions are nil for a header on its own.
+	Transactions []*tx.Transaction
 }
 
 // NewBlock creates a new Block instance.
@@ -56,6 +60,73 @@ func Parse(s *bytes.Reader) *Block {
 	return block
 }
 
+// ParseFull parses a block with its transactions, setting TxHashes.
+// Returns an error if the data runs out or a transaction isn't encoded canonically.
+func ParseFull(s *bytes.Reader, testnet bool) (result *Block, err error) {
+	defer func() {
+		// the transaction parser panics on data that runs out
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed block: %v", r)
+		}
+	}()
+	if s.Len() < 80 {
+		return nil, fmt.Errorf("block header of %d bytes", s.Len())
+	}
+	result = Parse(s)
+	numTxs := util.ReadVarInt(s)
+	// every transaction takes more than a byte, which keeps a bad count from allocating too much
+	if numTxs > s.Len() {
+		return nil, fmt.Errorf("%d transactions in %d bytes", numTxs, s.Len())
+	}
+	txs := make([]*tx.Transaction, numTxs)
+	for i := range txs {
+		before := s.Len()
+		txs[i] = tx.ParseTransaction(s, testnet)
+		// the parser doesn't notice the lock time running out, or a non-canonical encoding
+		if before-s.Len() != len(txs[i].Serialize()) {
+			return nil, fmt.Errorf("malformed transaction %d", i)
+		}
+	}
+	result.Transactions = txs
+	result.TxHashes = txHashes(txs)
+	return result, nil
+}
+
+// ParseRawBlock parses a serialized block with its transactions, returning an error
+// if it is malformed or has bytes left over.
+func ParseRawBlock(raw []byte, testnet bool) (*Block, error) {
+	s := bytes.NewReader(raw)
+	result, err := ParseFull(s, testnet)
+	if err != nil {
+		return nil, err
+	}
+	if s.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the block", s.Len())
+	}
+	return result, nil
+}
+
+// SetTransactions sets the transactions of a block, with TxHashes and the merkle root to match.
+func (b *Block) SetTransactions(txs []*tx.Transaction) {
+	b.Transactions = txs
+	b.TxHashes = txHashes(txs)
+	hashes := make([][]byte, len(txs))
+	for i, hash := range b.TxHashes {
+		hashes[i] = util.ReverseByteArray(append([]byte{}, hash...))
+	}
+	if len(hashes) > 0 {
+		copy(b.MerkleRoot[:], util.ReverseByteArray(util.MerkleRoot(hashes)))
+	}
+}
+
+func txHashes(txs []*tx.Transaction) [][]byte {
+	result := make([][]byte, len(txs))
+	for i, t := range txs {
+		result[i] = t.Hash()
+	}
+	return result
+}
+
 // Serialize eturns the 80 byte block header
 func (b *Block) Serialize() []byte {
 	result := make([]byte, 80)
@@ -76,6 +147,34 @@ func (b *Block) Serialize() []byte {
 	return result
 }
 
+// SerializeFull returns the header followed by the transactions, with their witness data.
+func (b *Block) SerializeFull() []byte {
+	result := append(b.Serialize(), util.EncodeVarInt(len(b.Transactions))...)
+	for _, t := range b.Transactions {
+		result = append(result, t.Serialize()...)
+	}
+	return result
+}
+
+// Size returns the size of the block with its transactions, including their witness data.
+func (b *Block) Size() int {
+	return len(b.SerializeFull())
+}
+
+// StrippedSize returns the size of the block with its transactions without witness data.
+func (b *Block) StrippedSize() int {
+	result := 80 + len(util.EncodeVarInt(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		result += len(t.SerializeLegacy())
+	}
+	return result
+}
+
+// Weight returns the block weight (BIP141): the stripped size times three plus the size.
+func (b *Block) Weight() int {
+	return b.StrippedSize()*3 + b.Size()
+}
+
 // Hash returns the hash256 interpreted little endian of the block
 func (b *Block) Hash() []byte {
 	serialized := b.Serialize()
@@ -100,6 +199,12 @@ func (b *Block) Bip141() bool {
 	return b.Version&2 == 2
 }
 
+// Signals returns whether this block signals for the BIP9 deployment on a bit
+func (b *Block) Signals(bit uint) bool {
+	// the top 3 bits are 001 and the deployment's bit is 1
+	return b.Bip9() && b.Version>>bit&1 == 1
+}
+
 // Target eturns the proof-of-work target based on the bits
 func (b *Block) Target() *big.Int {
 	return util.BitsToTarget(b.Bits[:])
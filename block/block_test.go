@@ -6,6 +6,8 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
@@ -89,6 +91,18 @@ func TestBip141(t *testing.T) {
 	}
 }
 
+func TestSignals(t *testing.T) {
+	block := parseBlockFromString(`1200002028856ec5bca29cf76980d368b0a163a0bb81fc192951270100000000000000003288f32a2831833c31a25401c52093eb545d28157e200a64b21b3ae8f21c507401877b5935470118144dbfd1`)
+	if !block.Signals(1) || !block.Signals(4) || block.Signals(0) {
+		t.Errorf("Expected bits 1 and 4 only, got version %x", block.Version)
+	}
+	// version 4 sets bit 2 without the BIP9 top bits
+	block2 := parseBlockFromString(`0400000039fa821848781f027a2e6dfabbf6bda920d9ae61b63400030000000000000000ecae536a304042e3154be0e3e9a8220e5568c3433a9ab49ac4cbb74f8df8e8b0cc2acf569fb9061806652c27`)
+	if block2.Signals(2) {
+		t.Errorf("Expected false")
+	}
+}
+
 func TestTarget(t *testing.T) {
 	block := parseBlockFromString(serialized)
 	actual := block.Target()
@@ -141,8 +155,89 @@ func TestValidateMerkleRoot(t *testing.T) {
 	}
 }
 
+func TestParseFull(t *testing.T) {
+	t.Run("Test genesis block", func(t *testing.T) {
+		coinbase := `01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000`
+		raw := append(append(append([]byte{}, GenesisBlock...), 1), util.HexStringToBytes(coinbase)...)
+		block, err := ParseRawBlock(raw, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(block.Transactions) != 1 || !block.ValidateMerkleRoot() {
+			t.Errorf("Failed to validate merkle root!")
+		}
+		if !bytes.Equal(block.SerializeFull(), raw) {
+			t.Errorf("Expected %x, got %x", raw, block.SerializeFull())
+		}
+		if block.Size() != 285 || block.StrippedSize() != 285 || block.Weight() != 1140 {
+			t.Errorf("Expected 285 285 1140, got %d %d %d", block.Size(), block.StrippedSize(), block.Weight())
+		}
+		if _, err := ParseRawBlock(raw[:len(raw)-1], false); err == nil {
+			t.Errorf("Expected an error for a truncated block")
+		}
+		if _, err := ParseRawBlock(append(raw, 0), false); err == nil {
+			t.Errorf("Expected an error for bytes after the block")
+		}
+		if _, err := ParseRawBlock(GenesisBlock[:79], false); err == nil {
+			t.Errorf("Expected an error for a truncated header")
+		}
+	})
+
+	t.Run("Test segwit block", func(t *testing.T) {
+		payout := script.P2wpkhScript(make([]byte, 20))
+		spend := tx.NewTransaction(2, []*tx.Input{tx.NewInput(make([]byte, 32), 0, nil, tx.SequenceFinal)}, []*tx.Output{tx.NewOutput(1000, payout)}, 0, false)
+		spend.Inputs[0].Witness = [][]byte{make([]byte, 72), make([]byte, 33)}
+		c := tx.NewCoinbase(500000, payout)
+		c.Transactions = []*tx.Transaction{spend}
+		coinbase, err := c.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		block := parseBlockFromString(serialized)
+		block.SetTransactions([]*tx.Transaction{coinbase, spend})
+		parsed, err := ParseRawBlock(block.SerializeFull(), false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !parsed.ValidateMerkleRoot() {
+			t.Errorf("Failed to validate merkle root!")
+		}
+		if !bytes.Equal(parsed.SerializeFull(), block.SerializeFull()) {
+			t.Errorf("Expected the same serialization")
+		}
+		if parsed.Transactions[1].Wtxid() != spend.Wtxid() {
+			t.Errorf("Expected %s, got %s", spend.Wtxid(), parsed.Transactions[1].Wtxid())
+		}
+		if parsed.StrippedSize() >= parsed.Size() || parsed.Weight() != parsed.StrippedSize()*3+parsed.Size() {
+			t.Errorf("Unexpected sizes %d %d %d", parsed.Size(), parsed.StrippedSize(), parsed.Weight())
+		}
+		if expected := 4*81 + coinbase.Weight() + spend.Weight(); parsed.Weight() != expected {
+			t.Errorf("Expected %d, got %d", expected, parsed.Weight())
+		}
+	})
+}
+
 func parseBlockFromString(str string) *Block {
 	raw := util.HexStringToBytes(str)
 	reader := bytes.NewReader(raw)
 	return Parse(reader)
 }
+
+func TestMedianTimePast(t *testing.T) {
+	var chain Headers
+	for _, timestamp := range []uint32{100, 300, 200, 500, 400} {
+		chain = append(chain, NewBlock(1, nil, nil, timestamp, LowestBits, nil, nil))
+	}
+	if mtp, err := MedianTimePast(chain, 5); err != nil || mtp != 300 {
+		t.Errorf("Expected 300, got %d %v", mtp, err)
+	}
+	if mtp, err := MedianTimePast(chain, 0); err != nil || mtp != 0 {
+		t.Errorf("Expected 0, got %d %v", mtp, err)
+	}
+	if _, err := MedianTimePast(chain, 6); err == nil {
+		t.Errorf("Expected an error for a missing header")
+	}
+	if _, err := Ancestor(nil, 0); err == nil {
+		t.Errorf("Expected an error without a chain")
+	}
+}
@@ -0,0 +1,56 @@
+package block
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MedianTimeSpan is the number of blocks median time past is taken over.
+const MedianTimeSpan = 11
+
+// Chain looks up the headers of a chain by height.
+type Chain interface {
+	// HeaderAt returns the header at a height, or nil if the chain doesn't have one.
+	HeaderAt(height int) *Block
+}
+
+// Headers is a chain of headers held in memory, starting at height 0.
+type Headers []*Block
+
+// HeaderAt implements Chain.
+func (h Headers) HeaderAt(height int) *Block {
+	if height < 0 || height >= len(h) {
+		return nil
+	}
+	return h[height]
+}
+
+// Ancestor returns the header at a height of a chain, or an error if the chain doesn't have it.
+func Ancestor(chain Chain, height int) (*Block, error) {
+	var result *Block
+	if chain != nil {
+		result = chain.HeaderAt(height)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	return result, nil
+}
+
+// MedianTimePast returns the median timestamp of the MedianTimeSpan blocks before height,
+// which the timestamp of the block at height has to be above. 0 for the first block.
+func MedianTimePast(chain Chain, height int) (uint32, error) {
+	var timestamps []uint32
+	for h := height - 1; h >= 0 && h >= height-MedianTimeSpan; h-- {
+		header, err := Ancestor(chain, h)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
@@ -2,53 +2,71 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/headerchain"
 	"github.com/ravdin/programmingbitcoin/network"
-	"github.com/ravdin/programmingbitcoin/util"
+	"github.com/ravdin/programmingbitcoin/validation"
 )
 
+// Sync headers from a node into a file, resuming from the headers already in it.
 func main() {
-	previous := block.Parse(bytes.NewReader(block.GenesisBlock))
-	firstEpochTimestamp := previous.Timestamp
-	expectedBits := block.LowestBits
-	count := 1
-	node := network.NewSimpleNode(network.WithHostName("mainnet.programmingbitcoin.com"), false, false)
+	host := flag.String("host", "", "node to sync from (default mainnet.programmingbitcoin.com, or testnet.programmingbitcoin.com with -testnet)")
+	testnet := flag.Bool("testnet", false, "sync testnet3 headers")
+	filename := flag.String("headers", "headers.dat", "file the headers are saved in")
+	batches := flag.Int("batches", 19, "number of getheaders messages to send")
+	flag.Parse()
+
+	genesis := block.Parse(bytes.NewReader(block.GenesisBlock))
+	params := validation.MainNetParams
+	defaultHost := "mainnet.programmingbitcoin.com"
+	if *testnet {
+		genesis = block.Parse(bytes.NewReader(block.TestGenesisBlock))
+		params = validation.TestNet3Params
+		defaultHost = "testnet.programmingbitcoin.com"
+	}
+	if *host == "" {
+		*host = defaultHost
+	}
+	chain, err := headerchain.Open(*filename, genesis, params)
+	if err != nil {
+		exit(err)
+	}
+	defer chain.Close()
+	node := network.NewSimpleNode(network.WithHostName(*host), *testnet, false)
 	defer node.Close()
 	if ok, err := node.Handshake(); !ok {
-		panic(err)
+		exit(err)
 	}
-	for i := 0; i < 19; i++ {
-		getheaders := network.NewGetHeadersMessage(previous.Hash())
+	for i := 0; i < *batches; i++ {
+		getheaders := network.NewGetHeadersMessageFromLocator(chain.Locator())
 		if ok, err := node.Send(getheaders); !ok {
-			panic(err)
+			exit(err)
 		}
 		msg, err := node.WaitFor(network.HeadersMessageOption())
 		if err != nil {
-			panic(err)
+			exit(err)
 		}
 		headers := msg.(*network.HeadersMessage)
-		for _, header := range headers.Blocks {
-			if !header.CheckPow() {
-				panic(fmt.Errorf("Bad PoW at block %d\n", count))
-			}
-			if !bytes.Equal(header.PrevBlock[:], previous.Hash()) {
-				panic(fmt.Errorf("Discontinuous block at %d\n", count))
-			}
-			if count%2016 == 0 {
-				timeDiff := previous.Timestamp - firstEpochTimestamp
-				expectedBits = util.CalculateNewBits(previous.Bits[:], int(timeDiff))
-				fmt.Fprintf(os.Stdout, "%x\n", expectedBits)
-				firstEpochTimestamp = header.Timestamp
-			}
-			if !bytes.Equal(header.Bits[:], expectedBits) {
-				fmt.Fprintf(os.Stdout, "expected: %x, actual: %x\n", expectedBits, header.Bits[:])
-				panic(fmt.Errorf("Bad bits at block %d\n", count))
-			}
-			previous = header
-			count++
+		if len(headers.Blocks) == 0 {
+			break
 		}
+		reorg, err := chain.AddHeaders(headers.Blocks)
+		if err != nil {
+			exit(err)
+		}
+		if reorg != nil && len(reorg.Disconnected) > 0 {
+			fmt.Printf("reorg: %d headers disconnected, %d connected\n", len(reorg.Disconnected), len(reorg.Connected))
+		}
+		tip := chain.Tip()
+		fmt.Printf("%d %x\n", tip.Height, tip.Hash)
 	}
 }
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(2)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Step through the evaluation of a scriptSig against a scriptPubKey.
+//
+// Scripts are passed as hex without a length prefix. Signatures are checked
+// against -z, or with -tx, against the input -index of that transaction the
+// way Verify checks it, timelocks included. The outputs it spends are given
+// with -prevouts, one amount:scriptPubKey for every input since taproot
+// signatures commit to all of them, with -amount for the -scriptpubkey of
+// the input alone, or otherwise looked up over http. With -tx, -scriptsig and
+// -witness replace those of the input.
+// In step mode, press enter (or "s") to run the next command, "r" to run to
+// the end, "t" to trace to the end and "q" to quit.
+func main() {
+	scriptSigHex := flag.String("scriptsig", "", "scriptSig hex")
+	scriptPubKeyHex := flag.String("scriptpubkey", "", "scriptPubKey hex")
+	witnessHex := flag.String("witness", "", "comma separated witness items in hex")
+	txHex := flag.String("tx", "", "spending transaction hex")
+	inputIndex := flag.Int("index", 0, "index of the input being spent")
+	testnet := flag.Bool("testnet", false, "the transaction is on testnet")
+	amount := flag.Uint64("amount", 0, "amount in satoshis of the -scriptpubkey output being spent, with -tx")
+	prevoutsArg := flag.String("prevouts", "", "comma separated amount:scriptPubKey hex of the outputs each input spends, with -tx")
+	zHex := flag.String("z", "", "signature hash hex, without -tx")
+	mode := flag.String("mode", "step", "one of step, run or trace")
+	flag.Parse()
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	scriptSig := parseScript(*scriptSigHex)
+	scriptPubKey := parseScript(*scriptPubKeyHex)
+	var witness [][]byte
+	if *witnessHex != "" {
+		for _, item := range strings.Split(*witnessHex, ",") {
+			witness = append(witness, decodeHex(item))
+		}
+	}
+	var engine *script.Engine
+	if *txHex != "" {
+		transaction, err := tx.ParseRawTransaction(decodeHex(*txHex), *testnet)
+		if err != nil {
+			exit(err)
+		}
+		if *inputIndex < 0 || *inputIndex >= len(transaction.Inputs) {
+			exit(fmt.Errorf("input %d out of range", *inputIndex))
+		}
+		txIn := transaction.Inputs[*inputIndex]
+		if set["scriptsig"] {
+			txIn.ScriptSig = scriptSig
+		}
+		if set["witness"] {
+			txIn.Witness = witness
+		}
+		var provider tx.PrevoutProvider
+		switch {
+		case set["prevouts"]:
+			provider = parsePrevouts(transaction, *prevoutsArg)
+		case set["amount"]:
+			prevouts := tx.NewMemoryProvider()
+			prevouts.AddOutput(txIn.PrevTx, txIn.PrevIndex, tx.NewOutput(*amount, scriptPubKey))
+			provider = prevouts
+		default:
+			provider = tx.NewHTTPProvider()
+		}
+		if engine, err = transaction.InputEngine(provider, *inputIndex); err != nil {
+			exit(err)
+		}
+	} else {
+		var z []byte
+		if *zHex != "" {
+			z = decodeHex(*zHex)
+		}
+		engine = script.NewEngine(scriptSig, scriptPubKey, witness, z)
+	}
+
+	printState(engine.State())
+	switch *mode {
+	case "run":
+		engine.Run()
+		printState(engine.State())
+	case "trace":
+		trace, _ := engine.Trace()
+		for _, state := range trace {
+			printState(state)
+		}
+	case "step":
+		step(engine)
+	default:
+		exit(fmt.Errorf("unknown mode %q", *mode))
+	}
+	if err := engine.Err(); err != nil {
+		fmt.Fprintf(os.Stdout, "FAILED: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "OK\n")
+}
+
+func step(engine *script.Engine) {
+	input := bufio.NewScanner(os.Stdin)
+	for !engine.Done() {
+		fmt.Fprintf(os.Stdout, "(s)tep, (r)un, (t)race, (q)uit> ")
+		if !input.Scan() {
+			return
+		}
+		switch strings.TrimSpace(input.Text()) {
+		case "", "s":
+			engine.Step()
+			printState(engine.State())
+		case "r":
+			engine.Run()
+			printState(engine.State())
+		case "t":
+			trace, _ := engine.Trace()
+			for _, state := range trace {
+				printState(state)
+			}
+		case "q":
+			os.Exit(0)
+		}
+	}
+}
+
+func printState(state *script.State) {
+	if state.PC < 0 {
+		fmt.Fprintf(os.Stdout, "start\n")
+	} else {
+		fmt.Fprintf(os.Stdout, "pc %d [%s] %v\n", state.PC, state.Phase, state.Command)
+	}
+	fmt.Fprintf(os.Stdout, "  stack:    %s\n", formatStack(state.Stack))
+	fmt.Fprintf(os.Stdout, "  altstack: %s\n", formatStack(state.AltStack))
+	fmt.Fprintf(os.Stdout, "  cond:     %v\n", state.CondStack)
+}
+
+// formatStack lists the elements of a stack, top first.
+func formatStack(stack [][]byte) string {
+	items := make([]string, len(stack))
+	for i, item := range stack {
+		items[len(stack)-i-1] = fmt.Sprintf("<%x>", item)
+	}
+	return "[" + strings.Join(items, " ") + "]"
+}
+
+// parsePrevouts returns the outputs the inputs of transaction spend,
+// from amount:scriptPubKey pairs in the order of the inputs.
+func parsePrevouts(transaction *tx.Transaction, arg string) tx.PrevoutProvider {
+	items := strings.Split(arg, ",")
+	if len(items) != len(transaction.Inputs) {
+		exit(fmt.Errorf("%d prevouts for %d inputs", len(items), len(transaction.Inputs)))
+	}
+	result := tx.NewMemoryProvider()
+	for i, item := range items {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			exit(fmt.Errorf("prevout %q is not amount:scriptPubKey", item))
+		}
+		amount, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			exit(err)
+		}
+		txIn := transaction.Inputs[i]
+		result.AddOutput(txIn.PrevTx, txIn.PrevIndex, tx.NewOutput(amount, parseScript(parts[1])))
+	}
+	return result
+}
+
+func parseScript(s string) *script.Script {
+	result, err := script.ParseRaw(decodeHex(s))
+	if err != nil {
+		exit(err)
+	}
+	return result
+}
+
+func decodeHex(s string) []byte {
+	if _, err := hex.DecodeString(s); err != nil {
+		exit(err)
+	}
+	return util.HexStringToBytes(s)
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(2)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/signer"
+)
+
+// Run a signer daemon holding the private keys of another process.
+//
+// The keys file has a WIF private key on each line, the secret file the secret
+// clients authenticate with. Payments can be limited to the addresses on -whitelist,
+// and what a PSBT spends and what is spent in a day to -max and -daily satoshis.
+func main() {
+	network := flag.String("network", "unix", "unix or tcp")
+	address := flag.String("address", "signer.sock", "socket path or host:port to listen on")
+	keysFile := flag.String("keys", "", "file with a WIF private key on each line")
+	secretFile := flag.String("secret", "", "file with the secret shared with clients")
+	whitelist := flag.String("whitelist", "", "comma separated addresses payments can go to, any if empty")
+	maxAmount := flag.Uint64("max", 0, "most satoshis a PSBT can spend, 0 for no limit")
+	dailyAmount := flag.Uint64("daily", 0, "most satoshis spent over 24 hours, 0 for no limit")
+	allowSigHash := flag.Bool("allow-sighash", false, "allow signing signature hashes the policy can't check")
+	testnet := flag.Bool("testnet", false, "whitelist testnet addresses")
+	flag.Parse()
+
+	keys, err := readKeys(*keysFile)
+	if err != nil {
+		exit(err)
+	}
+	secret, err := ioutil.ReadFile(*secretFile)
+	if err != nil {
+		exit(err)
+	}
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) == 0 {
+		exit(fmt.Errorf("%s is empty", *secretFile))
+	}
+	policy := signer.NewPolicy()
+	policy.MaxAmount = *maxAmount
+	policy.MaxDailyAmount = *dailyAmount
+	policy.AllowSigHash = *allowSigHash
+	if *whitelist != "" {
+		for _, addr := range strings.Split(*whitelist, ",") {
+			if err := policy.AllowAddress(strings.TrimSpace(addr), *testnet); err != nil {
+				exit(fmt.Errorf("%s: %v", addr, err))
+			}
+		}
+	}
+
+	if *network == "unix" {
+		os.Remove(*address)
+	}
+	l, err := net.Listen(*network, *address)
+	if err != nil {
+		exit(err)
+	}
+	log.Printf("signing for %d keys on %s", len(keys), l.Addr())
+	log.Fatal(signer.NewServer(secret, policy, keys...).Serve(l))
+}
+
+func readKeys(filename string) ([]*ecc.PrivateKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var result []*ecc.PrivateKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pk, _, _, err := ecc.ParseWif(line)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pk)
+	}
+	return result, scanner.Err()
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(2)
+}
@@ -7,9 +7,11 @@ import (
 	"net/http"
 
 	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/fees"
 	"github.com/ravdin/programmingbitcoin/script"
 	"github.com/ravdin/programmingbitcoin/tx"
 	"github.com/ravdin/programmingbitcoin/util"
+	"github.com/ravdin/programmingbitcoin/wallet"
 )
 
 func createTx(rw http.ResponseWriter, req *http.Request) {
@@ -17,27 +19,58 @@ func createTx(rw http.ResponseWriter, req *http.Request) {
 	var t transaction
 	err := decoder.Decode(&t)
 	if err != nil {
-		panic(err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
 	}
-	txIns := make([]*tx.Input, len(t.Inputs))
+	secret := util.LittleEndianToBigInt(util.Hash256([]byte(t.Passphrase)))
+	pk := ecc.NewPrivateKey(secret)
+
+	// the inputs are the coins to choose from
+	provider := tx.NewHTTPProvider()
+	utxos := make([]*wallet.Utxo, len(t.Inputs))
 	for i, input := range t.Inputs {
 		prevTx := util.HexStringToBytes(input.PreviousValue)
-		txIns[i] = tx.NewInput(prevTx, input.PreviousIndex, nil, 0xffffffff)
+		output, err := provider.Prevout(prevTx, input.PreviousIndex, t.Testnet)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		utxos[i] = &wallet.Utxo{PrevTx: prevTx, PrevIndex: input.PreviousIndex, Output: output}
 	}
 
 	txOuts := make([]*tx.Output, len(t.Outputs))
 	for i, output := range t.Outputs {
-		script := script.P2pkhScript(util.DecodeBase58(output.Address))
+		script, err := script.FromAddress(output.Address, t.Testnet)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
 		txOuts[i] = tx.NewOutput(output.Amount, script)
 	}
 
-	txObj := tx.NewTransaction(t.Version, txIns, txOuts, 0, t.Testnet)
-	secret := util.LittleEndianToBigInt(util.Hash256([]byte(t.Passphrase)))
-	pk := ecc.NewPrivateKey(secret)
-	if txObj.SignInput(0, pk) {
-		serialized := hex.EncodeToString(txObj.Serialize())
-		rw.Write([]byte(serialized))
+	change := t.Change
+	if change == "" {
+		change = pk.Point.Address(true, t.Testnet)
+	}
+	changeScript, err := script.FromAddress(change, t.Testnet)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	builder := wallet.NewBuilder(utxos, txOuts, fees.SatPerVByte(t.FeeRate), changeScript, pk)
+	if t.Version != 0 {
+		builder.Version = t.Version
+	}
+	builder.Height = t.Height
+	builder.Testnet = t.Testnet
+	txObj, err := builder.Build()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+		return
 	}
+	serialized := hex.EncodeToString(txObj.Serialize())
+	rw.Write([]byte(serialized))
 }
 
 func main() {
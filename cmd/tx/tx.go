@@ -4,7 +4,9 @@ type transaction struct {
 	Version    uint32              `json:"version"`
 	Inputs     []transactionInput  `json:"inputs"`
 	Outputs    []transactionOutput `json:"outputs"`
-	Locktime   int                 `json:"locktime"`
+	FeeRate    float64             `json:"feerate"`
+	Change     string              `json:"change"`
+	Height     int                 `json:"height"`
 	Testnet    bool                `json:"testnet"`
 	Passphrase string              `json:"passphrase"`
 }
@@ -0,0 +1,183 @@
+// Package contract builds hash time-locked contracts and the transactions that spend them.
+package contract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// PreimageSize is the size of an HTLC preimage.
+// The script checks it so the preimage can be reused on chains with other size limits.
+const PreimageSize = 32
+
+// HashType selects the hash the preimage is checked against.
+type HashType int
+
+// Hash types for the hashlock.
+const (
+	Sha256 HashType = iota
+	Hash160
+)
+
+// HTLC is a hash time-locked contract. The recipient can claim the output by
+// revealing the preimage of Hash, or the sender can take it back after the timeout.
+type HTLC struct {
+	HashType  HashType
+	Hash      []byte
+	Recipient *ecc.S256Point
+	Sender    *ecc.S256Point
+	// Timeout is the lock time for OP_CHECKLOCKTIMEVERIFY,
+	// or the sequence for OP_CHECKSEQUENCEVERIFY if Relative is set.
+	Timeout  uint32
+	Relative bool
+}
+
+// NewHTLC initializes an HTLC.
+// Returns an error if the hash does not fit the hash type or the timeout is invalid.
+func NewHTLC(hashType HashType, hash []byte, recipient, sender *ecc.S256Point, timeout uint32, relative bool) (*HTLC, error) {
+	switch {
+	case hashType == Sha256 && len(hash) != 32:
+		return nil, errors.New("sha256 hash must be 32 bytes")
+	case hashType == Hash160 && len(hash) != 20:
+		return nil, errors.New("hash160 hash must be 20 bytes")
+	case hashType != Sha256 && hashType != Hash160:
+		return nil, fmt.Errorf("unknown hash type %d", hashType)
+	}
+	if timeout == 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	if relative && timeout&^(tx.SequenceLockTimeTypeFlag|tx.SequenceLockTimeMask) != 0 {
+		return nil, fmt.Errorf("invalid relative timeout %#x", timeout)
+	}
+	return &HTLC{
+		HashType:  hashType,
+		Hash:      hash,
+		Recipient: recipient,
+		Sender:    sender,
+		Timeout:   timeout,
+		Relative:  relative,
+	}, nil
+}
+
+// Script returns the redeem or witness script:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <hash> OP_EQUALVERIFY <recipient>
+//	OP_ELSE
+//	    <timeout> OP_CHECKLOCKTIMEVERIFY OP_DROP <sender>
+//	OP_ENDIF
+//	OP_CHECKSIG
+//
+// with OP_HASH160 for a Hash160 hashlock and OP_CHECKSEQUENCEVERIFY for a relative timeout.
+func (h *HTLC) Script() *script.Script {
+	hashOp := byte(script.OpSha256)
+	if h.HashType == Hash160 {
+		hashOp = script.OpHash160
+	}
+	timelockOp := byte(script.OpCheckLockTimeVerify)
+	if h.Relative {
+		timelockOp = script.OpCheckSequenceVerify
+	}
+	return new(script.Script).
+		AppendOp(script.OpIf).
+		AppendOp(script.OpSize).AppendInt(PreimageSize).AppendOp(script.OpEqualVerify).
+		AppendOp(hashOp).AppendData(h.Hash).AppendOp(script.OpEqualVerify).
+		AppendData(h.Recipient.Sec(true)).
+		AppendOp(script.OpElse).
+		AppendInt(int(h.Timeout)).AppendOp(timelockOp).AppendOp(script.OpDrop).
+		AppendData(h.Sender.Sec(true)).
+		AppendOp(script.OpEndIf).
+		AppendOp(script.OpCheckSig)
+}
+
+// ScriptPubKey returns the p2wsh output script if witness is set, p2sh otherwise.
+func (h *HTLC) ScriptPubKey(witness bool) *script.Script {
+	raw := h.Script().RawSerialize()
+	if witness {
+		return script.P2wshScript(util.Sha256(raw))
+	}
+	return script.P2shScript(util.Hash160(raw))
+}
+
+// Address returns the address of the p2wsh or p2sh output.
+func (h *HTLC) Address(witness, testnet bool) (string, error) {
+	return h.ScriptPubKey(witness).Address(testnet)
+}
+
+// checkPreimage returns whether the preimage unlocks the hashlock.
+func (h *HTLC) checkPreimage(preimage []byte) bool {
+	if len(preimage) != PreimageSize {
+		return false
+	}
+	if h.HashType == Hash160 {
+		return bytes.Equal(util.Hash160(preimage), h.Hash)
+	}
+	return bytes.Equal(util.Sha256(preimage), h.Hash)
+}
+
+// ClaimTransaction returns a transaction spending the contract output at prevTx:prevIndex
+// to the outputs with the preimage and the recipient's signature.
+// provider looks up the contract output.
+func (h *HTLC) ClaimTransaction(provider tx.PrevoutProvider, prevTx []byte, prevIndex int, outputs []*tx.Output, preimage []byte, pk *ecc.PrivateKey, testnet bool) (*tx.Transaction, error) {
+	if !h.checkPreimage(preimage) {
+		return nil, errors.New("preimage does not match the hash")
+	}
+	if !bytes.Equal(pk.Point.Sec(true), h.Recipient.Sec(true)) {
+		return nil, errors.New("private key is not the recipient's")
+	}
+	txIn := tx.NewInput(prevTx, prevIndex, nil, tx.SequenceFinal)
+	result := tx.NewTransaction(2, []*tx.Input{txIn}, outputs, 0, testnet)
+	if err := h.sign(provider, result, pk, preimage, []byte{1}); err != nil {
+		return nil, fmt.Errorf("claim: %v", err)
+	}
+	return result, nil
+}
+
+// RefundTransaction returns a transaction spending the contract output at prevTx:prevIndex
+// back to the outputs with the sender's signature.
+// Its lock time or sequence is set to the timeout, so it is only valid once the timeout has passed.
+// provider looks up the contract output.
+func (h *HTLC) RefundTransaction(provider tx.PrevoutProvider, prevTx []byte, prevIndex int, outputs []*tx.Output, pk *ecc.PrivateKey, testnet bool) (*tx.Transaction, error) {
+	if !bytes.Equal(pk.Point.Sec(true), h.Sender.Sec(true)) {
+		return nil, errors.New("private key is not the sender's")
+	}
+	var result *tx.Transaction
+	if h.Relative {
+		// BIP68: the sequence holds the relative lock time
+		txIn := tx.NewInput(prevTx, prevIndex, nil, h.Timeout)
+		result = tx.NewTransaction(2, []*tx.Input{txIn}, outputs, 0, testnet)
+	} else {
+		// a final sequence would disable the lock time
+		txIn := tx.NewInput(prevTx, prevIndex, nil, tx.SequenceFinal-1)
+		result = tx.NewTransaction(2, []*tx.Input{txIn}, outputs, h.Timeout, testnet)
+	}
+	if err := h.sign(provider, result, pk, []byte{}); err != nil {
+		return nil, fmt.Errorf("refund: %v", err)
+	}
+	return result, nil
+}
+
+// sign signs the transaction's input and completes it with the items that pick the branch,
+// checking the result with the script interpreter.
+func (h *HTLC) sign(provider tx.PrevoutProvider, txObj *tx.Transaction, pk *ecc.PrivateKey, items ...[]byte) error {
+	redeemScript := h.Script()
+	sig, err := txObj.SignScriptInput(provider, 0, redeemScript, pk, util.SigHashAll)
+	if err != nil {
+		return err
+	}
+	stack := append([][]byte{sig}, items...)
+	ok, err := txObj.FinalizeScriptInput(provider, 0, redeemScript, stack)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("transaction failed to verify")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package contract
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestHTLC(t *testing.T) {
+	recipient := ecc.NewPrivateKey(big.NewInt(3001))
+	sender := ecc.NewPrivateKey(big.NewInt(3002))
+	preimage := []byte("htlc test preimage of 32 bytes!!")
+	outputs := func() []*tx.Output {
+		return []*tx.Output{tx.NewOutput(90000, script.P2pkhScript(recipient.Point.Hash160(true)))}
+	}
+	provider := tx.NewMemoryProvider()
+	// fund returns the outpoint of a made up transaction paying to the contract.
+	fund := func(h *HTLC, witness bool) ([]byte, int) {
+		txIn := tx.NewInput(make([]byte, 32), 0, nil, tx.SequenceFinal)
+		txOut := tx.NewOutput(100000, h.ScriptPubKey(witness))
+		prevTx := tx.NewTransaction(1, []*tx.Input{txIn}, []*tx.Output{txOut}, 0, true)
+		provider.AddTransaction(prevTx)
+		return prevTx.Hash(), 0
+	}
+	tests := []struct {
+		hashType HashType
+		hash     []byte
+		timeout  uint32
+		relative bool
+		witness  bool
+	}{
+		{Sha256, util.Sha256(preimage), 700000, false, false},
+		{Sha256, util.Sha256(preimage), 144, true, true},
+		{Hash160, util.Hash160(preimage), 1600000000, false, true},
+		{Hash160, util.Hash160(preimage), tx.SequenceLockTimeTypeFlag | 10, true, false},
+	}
+
+	t.Run("Test claim", func(t *testing.T) {
+		for _, test := range tests {
+			h, err := NewHTLC(test.hashType, test.hash, recipient.Point, sender.Point, test.timeout, test.relative)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			prevTx, prevIndex := fund(h, test.witness)
+			claim, err := h.ClaimTransaction(provider, prevTx, prevIndex, outputs(), preimage, recipient, true)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ok, err := claim.Verify(provider); err != nil || !ok {
+				t.Errorf("Verify failed!")
+			}
+			if _, err := h.ClaimTransaction(provider, prevTx, prevIndex, outputs(), make([]byte, 32), recipient, true); err == nil {
+				t.Errorf("Expected an error for the wrong preimage")
+			}
+			if _, err := h.ClaimTransaction(provider, prevTx, prevIndex, outputs(), preimage, sender, true); err == nil {
+				t.Errorf("Expected an error for the sender's key")
+			}
+		}
+	})
+
+	t.Run("Test refund", func(t *testing.T) {
+		for _, test := range tests {
+			h, err := NewHTLC(test.hashType, test.hash, recipient.Point, sender.Point, test.timeout, test.relative)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			prevTx, prevIndex := fund(h, test.witness)
+			refund, err := h.RefundTransaction(provider, prevTx, prevIndex, outputs(), sender, true)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ok, err := refund.Verify(provider); err != nil || !ok {
+				t.Errorf("Verify failed!")
+			}
+			txIn := refund.Inputs[0]
+			if test.relative && (txIn.Sequence != test.timeout || refund.Version < 2) {
+				t.Errorf("Expected sequence %d, got %d", test.timeout, txIn.Sequence)
+			}
+			if !test.relative && (refund.Locktime != test.timeout || txIn.Sequence == tx.SequenceFinal) {
+				t.Errorf("Expected lock time %d, got %d", test.timeout, refund.Locktime)
+			}
+			// the refund is invalid before the timeout
+			if test.relative {
+				txIn.Sequence--
+			} else {
+				refund.Locktime--
+			}
+			sig, _ := refund.SignScriptInput(provider, 0, h.Script(), sender, util.SigHashAll)
+			if ok, err := refund.FinalizeScriptInput(provider, 0, h.Script(), [][]byte{sig, {}}); err != nil || ok {
+				t.Errorf("Expected the refund to fail before the timeout")
+			}
+		}
+	})
+
+	t.Run("Test invalid contracts", func(t *testing.T) {
+		if _, err := NewHTLC(Sha256, util.Hash160(preimage), recipient.Point, sender.Point, 100, false); err == nil {
+			t.Errorf("Expected an error for the hash length")
+		}
+		if _, err := NewHTLC(Sha256, util.Sha256(preimage), recipient.Point, sender.Point, 0, false); err == nil {
+			t.Errorf("Expected an error for a zero timeout")
+		}
+		if _, err := NewHTLC(Sha256, util.Sha256(preimage), recipient.Point, sender.Point, tx.SequenceLockTimeDisableFlag, true); err == nil {
+			t.Errorf("Expected an error for a disabled relative timeout")
+		}
+	})
+}
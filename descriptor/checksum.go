@@ -0,0 +1,87 @@
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	inputCharset    string = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset string = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	checksumLength  int    = 8
+)
+
+func polymod(symbols []uint64) uint64 {
+	generator := []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	chk := uint64(1)
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// expand maps each character to its position in the input charset, grouping
+// the high bits of every three characters into an extra symbol.
+func expand(desc string) ([]uint64, error) {
+	var symbols, groups []uint64
+	for _, c := range desc {
+		v := strings.IndexRune(inputCharset, c)
+		if v < 0 {
+			return nil, fmt.Errorf("invalid character %q in descriptor", c)
+		}
+		symbols = append(symbols, uint64(v&31))
+		groups = append(groups, uint64(v>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+	return symbols, nil
+}
+
+// Checksum returns the BIP380 checksum of a descriptor without its checksum.
+func Checksum(desc string) (string, error) {
+	symbols, err := expand(desc)
+	if err != nil {
+		return "", err
+	}
+	symbols = append(symbols, make([]uint64, checksumLength)...)
+	chk := polymod(symbols) ^ 1
+	result := make([]byte, checksumLength)
+	for i := range result {
+		result[i] = checksumCharset[(chk>>uint(5*(checksumLength-1-i)))&31]
+	}
+	return string(result), nil
+}
+
+// splitChecksum separates a descriptor from its checksum, verifying the checksum if there is one.
+func splitChecksum(desc string) (string, error) {
+	pos := strings.IndexByte(desc, '#')
+	if pos < 0 {
+		return desc, nil
+	}
+	body, checksum := desc[:pos], desc[pos+1:]
+	if len(checksum) != checksumLength {
+		return "", fmt.Errorf("checksum %q must be %d characters", checksum, checksumLength)
+	}
+	expected, err := Checksum(body)
+	if err != nil {
+		return "", err
+	}
+	if checksum != expected {
+		return "", fmt.Errorf("checksum %s does not match, expected %s", checksum, expected)
+	}
+	return body, nil
+}
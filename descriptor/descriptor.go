@@ -0,0 +1,411 @@
+// Package descriptor implements output script descriptors (BIP380-386).
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+const (
+	maxScriptElementSize = 520
+	maxBareMultisigKeys  = 3
+	maxMultisigKeys      = 20
+)
+
+// context is where an expression appears, which limits what it may contain.
+type context int
+
+const (
+	topContext context = iota
+	shContext
+	witnessContext
+	tapContext
+)
+
+// Descriptor is a parsed output script descriptor.
+type Descriptor struct {
+	body string
+	root *node
+}
+
+// Output is the result of expanding a descriptor at a derivation index.
+type Output struct {
+	ScriptPubKey *script.Script
+	// RedeemScript is set for sh() descriptors.
+	RedeemScript *script.Script
+	// WitnessScript is set for wsh() descriptors.
+	WitnessScript *script.Script
+	// Keys are the keys in the order they appear in the descriptor.
+	Keys []*DerivedKey
+	// InternalKey, TapScripts and MerkleRoot are set for tr() descriptors.
+	InternalKey *ecc.S256Point
+	TapScripts  []*script.Script
+	// TapDepths are the depths of the TapScripts in the script tree.
+	TapDepths  []int
+	MerkleRoot []byte
+}
+
+// node is a script expression such as pkh(KEY) or sh(SCRIPT).
+type node struct {
+	name      string
+	ctx       context
+	keys      []*keyExpr
+	threshold int
+	sub       *node
+	tree      *tapTree
+	script    *script.Script
+}
+
+// tapTree is either a branch of two trees or a leaf script.
+type tapTree struct {
+	left, right *tapTree
+	leaf        *node
+}
+
+// Parse a descriptor. The checksum is optional, but verified if present.
+func Parse(s string) (*Descriptor, error) {
+	body, err := splitChecksum(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := expand(body); err != nil {
+		return nil, err
+	}
+	root, err := parseNode(body, topContext)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{body: body, root: root}, nil
+}
+
+// String returns the descriptor with its checksum.
+func (d *Descriptor) String() string {
+	checksum, _ := Checksum(d.body)
+	return d.body + "#" + checksum
+}
+
+// IsRange returns whether the descriptor has a wildcard derivation.
+func (d *Descriptor) IsRange() bool {
+	return d.root.isRange()
+}
+
+// Expand derives the output at index. The index is ignored if the descriptor is not ranged.
+func (d *Descriptor) Expand(index uint32) (*Output, error) {
+	result := new(Output)
+	scr, err := d.root.expand(index, result)
+	if err != nil {
+		return nil, err
+	}
+	result.ScriptPubKey = scr
+	return result, nil
+}
+
+// Address returns the address of the output at index.
+func (d *Descriptor) Address(index uint32, testnet bool) (string, error) {
+	output, err := d.Expand(index)
+	if err != nil {
+		return "", err
+	}
+	return output.ScriptPubKey.Address(testnet)
+}
+
+// splitCall splits name(args) into the name and args.
+func splitCall(s string) (string, string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", "", fmt.Errorf("invalid expression %q", s)
+	}
+	return s[:open], s[open+1 : len(s)-1], nil
+}
+
+// splitArgs splits arguments on the commas that are not nested in brackets.
+func splitArgs(s string) ([]string, error) {
+	var result []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced brackets in %q", s)
+			}
+		case ',':
+			if depth == 0 {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %q", s)
+	}
+	return append(result, s[start:]), nil
+}
+
+func parseNode(s string, ctx context) (*node, error) {
+	name, argString, err := splitCall(s)
+	if err != nil {
+		return nil, err
+	}
+	args, err := splitArgs(argString)
+	if err != nil {
+		return nil, err
+	}
+	result := &node{name: name, ctx: ctx}
+	allowed := map[context][]string{
+		topContext:     {"pk", "pkh", "wpkh", "sh", "wsh", "tr", "multi", "sortedmulti", "addr", "raw"},
+		shContext:      {"pk", "pkh", "wpkh", "wsh", "multi", "sortedmulti"},
+		witnessContext: {"pk", "pkh", "multi", "sortedmulti"},
+		tapContext:     {"pk"},
+	}
+	ok := false
+	for _, allowedName := range allowed[ctx] {
+		ok = ok || name == allowedName
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s() is not allowed here", name)
+	}
+	switch name {
+	case "pk", "pkh", "wpkh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes one key", name)
+		}
+		keyCtx := ctx
+		if name == "wpkh" {
+			keyCtx = witnessContext
+		}
+		key, err := parseKey(args[0], keyCtx)
+		if err != nil {
+			return nil, err
+		}
+		result.keys = []*keyExpr{key}
+	case "sh", "wsh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes one script", name)
+		}
+		subCtx := shContext
+		if name == "wsh" {
+			subCtx = witnessContext
+		}
+		if result.sub, err = parseNode(args[0], subCtx); err != nil {
+			return nil, err
+		}
+	case "multi", "sortedmulti":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s() needs a threshold and keys", name)
+		}
+		if result.threshold, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid threshold %q", args[0])
+		}
+		for _, arg := range args[1:] {
+			key, err := parseKey(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			result.keys = append(result.keys, key)
+		}
+		maxKeys := maxMultisigKeys
+		if ctx == topContext {
+			maxKeys = maxBareMultisigKeys
+		}
+		if len(result.keys) > maxKeys {
+			return nil, fmt.Errorf("%s() has %d keys, at most %d are allowed here", name, len(result.keys), maxKeys)
+		}
+		if result.threshold < 1 || result.threshold > len(result.keys) {
+			return nil, fmt.Errorf("threshold %d out of range for %d keys", result.threshold, len(result.keys))
+		}
+	case "tr":
+		if len(args) > 2 {
+			return nil, errors.New("tr() takes a key and an optional script tree")
+		}
+		key, err := parseKey(args[0], tapContext)
+		if err != nil {
+			return nil, err
+		}
+		result.keys = []*keyExpr{key}
+		if len(args) == 2 {
+			if result.tree, err = parseTapTree(args[1]); err != nil {
+				return nil, err
+			}
+		}
+	case "addr":
+		if len(args) != 1 {
+			return nil, errors.New("addr() takes one address")
+		}
+		// the network is implied by the address itself
+		if result.script, err = script.FromAddress(args[0], false); err != nil {
+			if result.script, err = script.FromAddress(args[0], true); err != nil {
+				return nil, err
+			}
+		}
+	case "raw":
+		if len(args) != 1 {
+			return nil, errors.New("raw() takes one script")
+		}
+		raw, err := hex.DecodeString(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex %q", args[0])
+		}
+		if result.script, err = script.ParseRaw(raw); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func parseTapTree(s string) (*tapTree, error) {
+	if !strings.HasPrefix(s, "{") {
+		leaf, err := parseNode(s, tapContext)
+		if err != nil {
+			return nil, err
+		}
+		return &tapTree{leaf: leaf}, nil
+	}
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid script tree %q", s)
+	}
+	branches, err := splitArgs(s[1 : len(s)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) != 2 {
+		return nil, fmt.Errorf("script tree branch %q must have two children", s)
+	}
+	result := new(tapTree)
+	if result.left, err = parseTapTree(branches[0]); err != nil {
+		return nil, err
+	}
+	if result.right, err = parseTapTree(branches[1]); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (n *node) isRange() bool {
+	for _, key := range n.keys {
+		if key.wildcard != noWildcard {
+			return true
+		}
+	}
+	if n.sub != nil && n.sub.isRange() {
+		return true
+	}
+	return n.tree != nil && n.tree.isRange()
+}
+
+func (tree *tapTree) isRange() bool {
+	if tree.leaf != nil {
+		return tree.leaf.isRange()
+	}
+	return tree.left.isRange() || tree.right.isRange()
+}
+
+// expand returns the script for the node, adding its keys and scripts to output.
+func (n *node) expand(index uint32, output *Output) (*script.Script, error) {
+	keys := make([]*DerivedKey, len(n.keys))
+	for i, key := range n.keys {
+		var err error
+		if keys[i], err = key.derive(index); err != nil {
+			return nil, err
+		}
+	}
+	output.Keys = append(output.Keys, keys...)
+	switch n.name {
+	case "pk":
+		if n.ctx == tapContext {
+			return new(script.Script).AppendData(keys[0].PubKey.XOnly()).AppendOp(script.OpCheckSig), nil
+		}
+		return new(script.Script).AppendData(keys[0].Sec()).AppendOp(script.OpCheckSig), nil
+	case "pkh":
+		return script.P2pkhScript(util.Hash160(keys[0].Sec())), nil
+	case "wpkh":
+		return script.P2wpkhScript(util.Hash160(keys[0].Sec())), nil
+	case "sh":
+		redeemScript, err := n.sub.expand(index, output)
+		if err != nil {
+			return nil, err
+		}
+		raw := redeemScript.RawSerialize()
+		if len(raw) > maxScriptElementSize {
+			return nil, fmt.Errorf("redeem script is %d bytes, at most %d are allowed", len(raw), maxScriptElementSize)
+		}
+		output.RedeemScript = redeemScript
+		return script.P2shScript(util.Hash160(raw)), nil
+	case "wsh":
+		witnessScript, err := n.sub.expand(index, output)
+		if err != nil {
+			return nil, err
+		}
+		output.WitnessScript = witnessScript
+		return script.P2wshScript(util.Sha256(witnessScript.RawSerialize())), nil
+	case "multi", "sortedmulti":
+		pubKeys := make([][]byte, len(keys))
+		for i, key := range keys {
+			pubKeys[i] = key.Sec()
+		}
+		if n.name == "sortedmulti" {
+			sort.Slice(pubKeys, func(i, j int) bool {
+				return bytes.Compare(pubKeys[i], pubKeys[j]) < 0
+			})
+		}
+		return multisigScript(n.threshold, pubKeys), nil
+	case "tr":
+		output.InternalKey = keys[0].PubKey
+		if n.tree != nil {
+			merkleRoot, err := n.tree.expand(index, 0, output)
+			if err != nil {
+				return nil, err
+			}
+			output.MerkleRoot = merkleRoot
+		}
+		outputKey, err := script.TaprootOutputKey(output.InternalKey, output.MerkleRoot)
+		if err != nil {
+			return nil, err
+		}
+		return script.P2trScript(outputKey.XOnly()), nil
+	}
+	// addr() and raw()
+	return n.script, nil
+}
+
+// expand returns the merkle root of the tree at depth, adding its leaf scripts to output.
+func (tree *tapTree) expand(index uint32, depth int, output *Output) ([]byte, error) {
+	if tree.leaf != nil {
+		leafScript, err := tree.leaf.expand(index, output)
+		if err != nil {
+			return nil, err
+		}
+		output.TapScripts = append(output.TapScripts, leafScript)
+		output.TapDepths = append(output.TapDepths, depth)
+		return script.TapLeafHash(script.TapscriptLeafVersion, leafScript), nil
+	}
+	left, err := tree.left.expand(index, depth+1, output)
+	if err != nil {
+		return nil, err
+	}
+	right, err := tree.right.expand(index, depth+1, output)
+	if err != nil {
+		return nil, err
+	}
+	return script.TapBranchHash(left, right), nil
+}
+
+// multisigScript returns OP_m <pubkeys> OP_n OP_CHECKMULTISIG.
+func multisigScript(threshold int, pubKeys [][]byte) *script.Script {
+	result := new(script.Script).AppendInt(threshold)
+	for _, pubKey := range pubKeys {
+		result.AppendData(pubKey)
+	}
+	return result.AppendInt(len(pubKeys)).AppendOp(script.OpCheckMultiSig)
+}
@@ -0,0 +1,188 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/hd"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestChecksum(t *testing.T) {
+	t.Run("Test checksum", func(t *testing.T) {
+		checksum, err := Checksum("raw(deadbeef)")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if checksum != "89f8spxm" {
+			t.Errorf("Expected 89f8spxm, got %s", checksum)
+		}
+	})
+
+	t.Run("Test verify checksum", func(t *testing.T) {
+		if _, err := Parse("raw(deadbeef)#89f8spxm"); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		for _, desc := range []string{"raw(deadbeef)#", "raw(deadbeef)#89f8spxn", "raw(deedbeef)#89f8spxm"} {
+			if _, err := Parse(desc); err == nil {
+				t.Errorf("Expected an error parsing %s", desc)
+			}
+		}
+	})
+}
+
+func TestExpand(t *testing.T) {
+	t.Run("Test script pubkeys", func(t *testing.T) {
+		tests := []struct {
+			desc     string
+			expected string
+		}{
+			{
+				"pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)",
+				"210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac",
+			},
+			{
+				"pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)",
+				"76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac",
+			},
+			{
+				"wpkh(02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9)",
+				"00147dd65592d0ab2fe0d0257d571abf032cd9db93dc",
+			},
+			{
+				"sh(multi(2,022f01e5e15cca351daff3843fb70f3c2f0a1bdd05e5af888a67784ef3e10a2a01,03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe))",
+				"a914a6a8b030a38762f4c1f5cbe387b61a3c5da5cd2687",
+			},
+			{
+				"sh(sortedmulti(2,03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe,022f01e5e15cca351daff3843fb70f3c2f0a1bdd05e5af888a67784ef3e10a2a01))",
+				"a914a6a8b030a38762f4c1f5cbe387b61a3c5da5cd2687",
+			},
+			{
+				"addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)",
+				"76a914399c39ac90dac26965fb55fdb2035e6715fdac4e88ac",
+			},
+			{
+				"raw(deadbeef)",
+				"deadbeef",
+			},
+		}
+		for _, test := range tests {
+			desc, err := Parse(test.desc)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test.desc, err)
+			}
+			output, err := desc.Expand(0)
+			if err != nil {
+				t.Fatalf("Unexpected error expanding %s: %v", test.desc, err)
+			}
+			if actual := hex.EncodeToString(output.ScriptPubKey.RawSerialize()); actual != test.expected {
+				t.Errorf("%s: expected %s, got %s", test.desc, test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test ranged derivation", func(t *testing.T) {
+		seed := util.HexStringToBytes("5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4")
+		master, err := hd.NewMaster(seed, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		tests := []struct {
+			desc     string
+			index    uint32
+			expected string
+		}{
+			{"wpkh(" + master.String() + "/84'/0'/0'/0/*)", 0, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+			{"wpkh(" + master.String() + "/84'/0'/0'/0/*)", 1, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+			{"tr(" + master.String() + "/86'/0'/0'/0/*)", 0, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+		}
+		for _, test := range tests {
+			desc, err := Parse(test.desc)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test.desc, err)
+			}
+			if !desc.IsRange() {
+				t.Errorf("Expected %s to be ranged", test.desc)
+			}
+			actual, err := desc.Address(test.index, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test key origin", func(t *testing.T) {
+		xpub := "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+		desc, err := Parse("wsh(multi(1,[deadbeef/48'/0'/0'/2']" + xpub + "/1/*,02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5))")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output, err := desc.Expand(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output.WitnessScript == nil || len(output.Keys) != 2 {
+			t.Fatalf("Expected a witness script with 2 keys")
+		}
+		key := output.Keys[0]
+		if hex.EncodeToString(key.Fingerprint[:]) != "deadbeef" {
+			t.Errorf("Expected fingerprint deadbeef, got %x", key.Fingerprint)
+		}
+		if actual := hd.FormatPath(key.Path); actual != "m/48'/0'/0'/2'/1/7" {
+			t.Errorf("Expected m/48'/0'/0'/2'/1/7, got %s", actual)
+		}
+	})
+
+	t.Run("Test script tree", func(t *testing.T) {
+		desc, err := Parse("tr(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798," +
+			"{pk(022f01e5e15cca351daff3843fb70f3c2f0a1bdd05e5af888a67784ef3e10a2a01)," +
+			"{pk(03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe)," +
+			"pk(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)}})")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output, err := desc.Expand(0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []int{1, 2, 2}
+		if len(output.TapScripts) != 3 || len(output.TapDepths) != 3 {
+			t.Fatalf("Expected 3 leaf scripts, got %d", len(output.TapScripts))
+		}
+		for i, depth := range expected {
+			if output.TapDepths[i] != depth {
+				t.Errorf("Expected depth %d for leaf %d, got %d", depth, i, output.TapDepths[i])
+			}
+		}
+	})
+
+	t.Run("Test string", func(t *testing.T) {
+		desc, err := Parse("raw(deadbeef)")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual := desc.String(); actual != "raw(deadbeef)#89f8spxm" {
+			t.Errorf("Expected raw(deadbeef)#89f8spxm, got %s", actual)
+		}
+	})
+
+	t.Run("Test invalid descriptors", func(t *testing.T) {
+		tests := []string{
+			"wpkh(04a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd5b8dec5235a0fa8722476c7709c02559e3aa73aa03918ba2d492eea75abea235)",
+			"sh(sh(pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)))",
+			"wsh(wpkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5))",
+			"multi(3,022f01e5e15cca351daff3843fb70f3c2f0a1bdd05e5af888a67784ef3e10a2a01,03acd484e2f0c7f65309ad178a9f559abde09796974c57e714c35f110dfc27ccbe)",
+			"pkh(xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8/0'/*)",
+			"pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5",
+			"foo(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)",
+		}
+		for _, test := range tests {
+			if _, err := Parse(test); err == nil {
+				t.Errorf("Expected an error parsing %s", test)
+			}
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/hd"
+)
+
+// DerivedKey is a public key produced by a descriptor along with its origin.
+type DerivedKey struct {
+	PubKey *ecc.S256Point
+	// PrivateKey is set when the descriptor holds the private key.
+	PrivateKey *ecc.PrivateKey
+	// Compressed is false for uncompressed keys.
+	Compressed bool
+	// Fingerprint and Path are the BIP32 origin of the key.
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// Sec returns the key in the SEC format it is used with.
+func (key *DerivedKey) Sec() []byte {
+	return key.PubKey.Sec(key.Compressed)
+}
+
+type wildcard int
+
+const (
+	noWildcard wildcard = iota
+	unhardenedWildcard
+	hardenedWildcard
+)
+
+// keyExpr is a KEY expression within a descriptor.
+type keyExpr struct {
+	fingerprint [4]byte
+	originPath  []uint32
+	hasOrigin   bool
+	// a fixed key
+	pubKey     *ecc.S256Point
+	privateKey *ecc.PrivateKey
+	compressed bool
+	// or an extended key with a path and optional wildcard
+	extendedKey *hd.ExtendedKey
+	path        []uint32
+	wildcard    wildcard
+}
+
+func parseKey(s string, ctx context) (*keyExpr, error) {
+	result := &keyExpr{compressed: true}
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("key origin %s is missing ]", s)
+		}
+		parts := strings.SplitN(s[1:end], "/", 2)
+		fingerprint, err := hex.DecodeString(parts[0])
+		if err != nil || len(fingerprint) != 4 {
+			return nil, fmt.Errorf("invalid fingerprint %q", parts[0])
+		}
+		copy(result.fingerprint[:], fingerprint)
+		result.originPath = []uint32{}
+		if len(parts) == 2 {
+			if result.originPath, err = hd.ParsePath(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		result.hasOrigin = true
+		s = s[end+1:]
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) == 1 {
+		return result, result.parseFixedKey(s, ctx)
+	}
+	extendedKey, err := hd.Parse(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended key %s: %v", parts[0], err)
+	}
+	result.extendedKey = extendedKey
+	last := parts[len(parts)-1]
+	switch last {
+	case "*":
+		result.wildcard = unhardenedWildcard
+	case "*'", "*h", "*H":
+		result.wildcard = hardenedWildcard
+	}
+	pathParts := parts[1:]
+	if result.wildcard != noWildcard {
+		pathParts = pathParts[:len(pathParts)-1]
+	}
+	if result.path, err = hd.ParsePath(strings.Join(pathParts, "/")); err != nil {
+		return nil, err
+	}
+	if !extendedKey.IsPrivate() {
+		for _, index := range result.path {
+			if index >= hd.HardenedKeyStart {
+				return nil, errors.New("hardened derivation requires an extended private key")
+			}
+		}
+		if result.wildcard == hardenedWildcard {
+			return nil, errors.New("hardened derivation requires an extended private key")
+		}
+	}
+	return result, nil
+}
+
+func (key *keyExpr) parseFixedKey(s string, ctx context) error {
+	if raw, err := hex.DecodeString(s); err == nil {
+		if ctx == tapContext && len(raw) == 32 {
+			key.pubKey, err = ecc.ParseXOnly(raw)
+			return err
+		}
+		if key.pubKey, err = ecc.ParseSec(raw); err != nil {
+			return fmt.Errorf("invalid public key %s: %v", s, err)
+		}
+		key.compressed = len(raw) == 33
+		if !key.compressed && (ctx == witnessContext || ctx == tapContext) {
+			return errors.New("uncompressed keys are not allowed in segwit")
+		}
+		return nil
+	}
+	pk, compressed, _, err := ecc.ParseWif(s)
+	if err != nil {
+		return fmt.Errorf("invalid key %s: %v", s, err)
+	}
+	if !compressed && (ctx == witnessContext || ctx == tapContext) {
+		return errors.New("uncompressed keys are not allowed in segwit")
+	}
+	key.privateKey = pk
+	key.pubKey = pk.Point
+	key.compressed = compressed
+	return nil
+}
+
+// derive returns the key at a derivation index.
+func (key *keyExpr) derive(index uint32) (*DerivedKey, error) {
+	result := &DerivedKey{Compressed: key.compressed}
+	if key.extendedKey == nil {
+		result.PubKey = key.pubKey
+		result.PrivateKey = key.privateKey
+		if key.hasOrigin {
+			result.Fingerprint = key.fingerprint
+			result.Path = key.originPath
+		} else {
+			copy(result.Fingerprint[:], key.pubKey.Hash160(true))
+		}
+		return result, nil
+	}
+	path := append([]uint32{}, key.path...)
+	switch key.wildcard {
+	case unhardenedWildcard:
+		path = append(path, index)
+	case hardenedWildcard:
+		path = append(path, index+hd.HardenedKeyStart)
+	}
+	derived, err := key.extendedKey.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	result.PubKey = derived.PublicKey
+	result.PrivateKey = derived.PrivateKey
+	if key.hasOrigin {
+		result.Fingerprint = key.fingerprint
+		result.Path = append(append([]uint32{}, key.originPath...), path...)
+	} else {
+		result.Fingerprint = key.extendedKey.Fingerprint()
+		result.Path = path
+	}
+	return result, nil
+}
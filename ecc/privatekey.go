@@ -3,6 +3,7 @@ package ecc
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -20,11 +21,78 @@ func NewPrivateKey(secret *big.Int) *PrivateKey {
 	return &PrivateKey{secret: secret, Point: new(S256Point).Cmul(_G, secret)}
 }
 
+// ParsePrivateKey returns a PrivateKey from a 32 byte big endian secret.
+// Returns an error if the secret is zero or not less than the curve order.
+func ParsePrivateKey(secret []byte) (*PrivateKey, error) {
+	if len(secret) != 32 {
+		return nil, errors.New("secret must be 32 bytes")
+	}
+	num := new(big.Int).SetBytes(secret)
+	if num.Sign() == 0 || num.Cmp(_N) >= 0 {
+		return nil, errors.New("secret out of range")
+	}
+	return NewPrivateKey(num), nil
+}
+
 // Hex returns the private key in hex format.
 func Hex(pk *PrivateKey) string {
 	return fmt.Sprintf("%x", pk.secret.Bytes())
 }
 
+// Bytes returns the secret as 32 bytes in big endian.
+func (pk *PrivateKey) Bytes() []byte {
+	return util.IntToBytes(pk.secret, 32)
+}
+
+// TweakAdd returns the private key for secret + tweak.
+// Returns an error if the tweak or the resulting secret is out of range.
+func (pk *PrivateKey) TweakAdd(tweak *big.Int) (*PrivateKey, error) {
+	if tweak.Sign() < 0 || tweak.Cmp(_N) >= 0 {
+		return nil, errors.New("tweak out of range")
+	}
+	secret := new(big.Int).Add(pk.secret, tweak)
+	secret.Mod(secret, _N)
+	if secret.Sign() == 0 {
+		return nil, errors.New("tweaked secret is zero")
+	}
+	return NewPrivateKey(secret), nil
+}
+
+// EvenY returns the private key whose point is the one with an even y coordinate
+// and the same x coordinate, as BIP340 keys are.
+func (pk *PrivateKey) EvenY() *PrivateKey {
+	if pk.Point.HasEvenY() {
+		return pk
+	}
+	return NewPrivateKey(new(big.Int).Sub(_N, pk.secret))
+}
+
+// ParseWif parses a private key in wallet import format.
+// Returns the key and whether it is for a compressed public key on testnet.
+func ParseWif(wif string) (pk *PrivateKey, compressed bool, testnet bool, err error) {
+	payload, err := util.DecodeBase58Checksum(wif)
+	if err != nil {
+		return nil, false, false, err
+	}
+	switch payload[0] {
+	case 0x80:
+	case 0xef:
+		testnet = true
+	default:
+		return nil, false, false, fmt.Errorf("unknown WIF prefix %x", payload[0])
+	}
+	switch {
+	case len(payload) == 34 && payload[33] == 1:
+		compressed = true
+	case len(payload) != 33:
+		return nil, false, false, errors.New("invalid WIF length")
+	}
+	if pk, err = ParsePrivateKey(payload[1:33]); err != nil {
+		return nil, false, false, err
+	}
+	return pk, compressed, testnet, nil
+}
+
 // Sign returns a Signature instance.
 func (pk *PrivateKey) Sign(z *big.Int) *Signature {
 	k := pk.deterministicK(z)
@@ -45,4 +45,29 @@ func TestPrivateKey(t *testing.T) {
 			t.Errorf("Expected %v, got %v", expected, actual)
 		}
 	})
+
+	t.Run("Test parse WIF", func(t *testing.T) {
+		tests := []struct {
+			wif        string
+			compressed bool
+			testnet    bool
+		}{
+			{"L5oLkpV3aqBJ4BgssVAsax1iRa77G5CVYnv9adQ6Z87te7TyUdSC", true, false},
+			{"93XfLeifX7Jx7n7ELGMAf1SUR6f9kgQs8Xke8WStMwUtrDucMzn", false, true},
+			{"5HvLFPDVgFZRK9cd4C5jcWki5Skz6fmKqi1GQJf5ZoMofid2Dty", false, false},
+			{"cNYfWuhDpbNM1JWc3c6JTrtrFVxU4AGhUKgw5f93NP2QaBqmxKkg", true, true},
+		}
+		for _, test := range tests {
+			pk, compressed, testnet, err := ParseWif(test.wif)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if compressed != test.compressed || testnet != test.testnet {
+				t.Errorf("%s: expected compressed %v testnet %v", test.wif, test.compressed, test.testnet)
+			}
+			if actual := pk.Wif(compressed, testnet); actual != test.wif {
+				t.Errorf("Expected %v, got %v", test.wif, actual)
+			}
+		}
+	})
 }
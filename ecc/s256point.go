@@ -1,6 +1,7 @@
 package ecc
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -84,6 +85,38 @@ func ParseS256Point(secBin []byte) *S256Point {
 	return &S256Point{X: x, Y: oddBeta}
 }
 
+// ParseSec returns a Point object from a SEC binary.
+// Unlike ParseS256Point, returns an error if the encoding or point is invalid.
+func ParseSec(secBin []byte) (*S256Point, error) {
+	var x, y *big.Int = new(big.Int), new(big.Int)
+	switch {
+	case len(secBin) == 65 && secBin[0] == 4:
+		x.SetBytes(secBin[1:33])
+		y.SetBytes(secBin[33:65])
+	case len(secBin) == 33 && (secBin[0] == 2 || secBin[0] == 3):
+		x.SetBytes(secBin[1:])
+		if x.Cmp(_P) >= 0 {
+			return nil, errors.New("x coordinate out of range")
+		}
+		point := ParseS256Point(secBin)
+		y = point.Y.Num
+	default:
+		return nil, errors.New("invalid SEC encoding")
+	}
+	if x.Cmp(_P) >= 0 || y.Cmp(_P) >= 0 {
+		return nil, errors.New("coordinate out of range")
+	}
+	return NewS256Point(x, y)
+}
+
+// ParseXOnly returns the point with an even y coordinate for a 32 byte x coordinate.
+func ParseXOnly(x []byte) (*S256Point, error) {
+	if len(x) != 32 {
+		return nil, errors.New("x-only keys must be 32 bytes")
+	}
+	return ParseSec(append([]byte{2}, x...))
+}
+
 func (p *S256Point) point() *Point {
 	if result, err := NewPoint(p.X, p.Y, _A, _B); err == nil {
 		return result
@@ -137,6 +170,30 @@ func (p *S256Point) Cmul(r *S256Point, coefficient *big.Int) *S256Point {
 	return p
 }
 
+// TweakAdd returns p + tweak*G.
+// Returns an error if the tweak is out of range or the result is the point at infinity.
+func (p *S256Point) TweakAdd(tweak *big.Int) (*S256Point, error) {
+	if tweak.Sign() < 0 || tweak.Cmp(_N) >= 0 {
+		return nil, errors.New("tweak out of range")
+	}
+	result := new(S256Point).Cmul(_G, tweak)
+	result.Add(result, p)
+	if result.X == nil {
+		return nil, errors.New("tweaked point is at infinity")
+	}
+	return result, nil
+}
+
+// HasEvenY returns whether the y coordinate is even.
+func (p *S256Point) HasEvenY() bool {
+	return p.Y.Num.Bit(0) == 0
+}
+
+// XOnly returns the 32 byte x coordinate.
+func (p *S256Point) XOnly() []byte {
+	return util.IntToBytes(p.X.Num, 32)
+}
+
 // Verify a signature.
 func (p *S256Point) Verify(z *big.Int, sig *Signature) bool {
 	// By Fermat's Little Theorem, 1/s = pow(s, N-2, N)
@@ -125,4 +125,61 @@ func TestS256Point(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Test Parse SEC", func(t *testing.T) {
+		point := new(S256Point)
+		point.Cmul(_G, big.NewInt(997002999))
+		for _, compressed := range []bool{true, false} {
+			parsed, err := ParseSec(point.Sec(compressed))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if parsed.Ne(point) {
+				t.Errorf("Expected %v, got %v", point, parsed)
+			}
+		}
+		parsed, err := ParseXOnly(point.XOnly())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !parsed.HasEvenY() || !bytes.Equal(parsed.XOnly(), point.XOnly()) {
+			t.Errorf("Expected the even point with x %x", point.XOnly())
+		}
+		invalid := util.HexStringToBytes("039d5ca49670cbe4c3bfa84c96a8c87df086c6ea6a24ba6b809c9de2344968")
+		if _, err := ParseSec(invalid); err == nil {
+			t.Errorf("Expected an error parsing a truncated SEC")
+		}
+	})
+
+	t.Run("Test Parse Signature Lax", func(t *testing.T) {
+		px := util.HexStringToBigInt("887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c")
+		py := util.HexStringToBigInt("61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34")
+		point, _ := NewS256Point(px, py)
+		z := util.HexStringToBigInt("7c076ff316692a3d7eb3c3bb0f8b1488cf72e1afcd929e29307032997a838a3d")
+		der := util.HexStringToBytes("3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab6")
+		// long form lengths, extra zeros and trailing data that strict DER would refuse
+		lax := append([]byte{0x30, 0x81, 0x00, 0x02, 0x82, 0x00, 0x21, 0x00, 0x00}, der[5:]...)
+		lax = append(lax, 0xff)
+		for _, sig := range [][]byte{der, lax} {
+			parsed, err := ParseSignatureLax(sig)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %x: %v", sig, err)
+			}
+			if !point.Verify(z, parsed) {
+				t.Errorf("Expected %x to verify", sig)
+			}
+		}
+		invalid := [][]byte{
+			{},
+			{0x31, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01},
+			{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x05, 0x01},
+			// r is zero
+			{0x30, 0x06, 0x02, 0x01, 0x00, 0x02, 0x01, 0x01},
+		}
+		for _, sig := range invalid {
+			if _, err := ParseSignatureLax(sig); err == nil {
+				t.Errorf("Expected an error parsing %x", sig)
+			}
+		}
+	})
 }
@@ -0,0 +1,89 @@
+package ecc
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// SignSchnorr returns the 64 byte BIP340 signature of msg.
+// auxRand is 32 bytes of fresh randomness, or nil to sign without it.
+func (pk *PrivateKey) SignSchnorr(msg, auxRand []byte) ([]byte, error) {
+	if auxRand == nil {
+		auxRand = make([]byte, 32)
+	}
+	if len(auxRand) != 32 {
+		return nil, errors.New("auxiliary randomness must be 32 bytes")
+	}
+	// sign with the secret whose point has an even y coordinate
+	d := new(big.Int).Set(pk.secret)
+	if !pk.Point.HasEvenY() {
+		d.Sub(_N, d)
+	}
+	t := util.TaggedHash("BIP0340/aux", auxRand)
+	for i, b := range util.IntToBytes(d, 32) {
+		t[i] ^= b
+	}
+	px := pk.Point.XOnly()
+	nonce := util.TaggedHash("BIP0340/nonce", concat(t, px, msg))
+	k := new(big.Int).SetBytes(nonce)
+	k.Mod(k, _N)
+	if k.Sign() == 0 {
+		return nil, errors.New("nonce is zero")
+	}
+	r := new(S256Point).Cmul(_G, k)
+	if !r.HasEvenY() {
+		k.Sub(_N, k)
+	}
+	rx := r.XOnly()
+	e := schnorrChallenge(rx, px, msg)
+	// s = k + e*d
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k).Mod(s, _N)
+	result := concat(rx, util.IntToBytes(s, 32))
+	if !pk.Point.VerifySchnorr(msg, result) {
+		return nil, errors.New("signature failed to verify")
+	}
+	return result, nil
+}
+
+// VerifySchnorr returns whether sig is a valid BIP340 signature of msg
+// for the x-only public key of p.
+func (p *S256Point) VerifySchnorr(msg, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	// the key is always taken with an even y coordinate
+	point, err := ParseXOnly(p.XOnly())
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(_P) >= 0 || s.Cmp(_N) >= 0 {
+		return false
+	}
+	e := schnorrChallenge(sig[:32], point.XOnly(), msg)
+	// R = s*G - e*P
+	e.Sub(_N, e)
+	total := new(S256Point).Cmul(_G, s)
+	total.Add(total, new(S256Point).Cmul(point, e))
+	if total.X == nil || !total.HasEvenY() {
+		return false
+	}
+	return total.X.Num.Cmp(r) == 0
+}
+
+func schnorrChallenge(rx, px, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(util.TaggedHash("BIP0340/challenge", concat(rx, px, msg)))
+	return e.Mod(e, _N)
+}
+
+func concat(parts ...[]byte) []byte {
+	var result []byte
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
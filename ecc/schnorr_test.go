@@ -0,0 +1,75 @@
+package ecc
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestSchnorr(t *testing.T) {
+	// BIP340 test vectors
+	tests := []struct {
+		secret  string
+		pubKey  string
+		auxRand string
+		msg     string
+		sig     string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		},
+		{
+			"b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+			"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		},
+	}
+
+	t.Run("Test sign", func(t *testing.T) {
+		for _, test := range tests {
+			pk, err := ParsePrivateKey(util.HexStringToBytes(test.secret))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual := hex.EncodeToString(pk.Point.XOnly()); actual != test.pubKey {
+				t.Errorf("Expected %v, got %v", test.pubKey, actual)
+			}
+			sig, err := pk.SignSchnorr(util.HexStringToBytes(test.msg), util.HexStringToBytes(test.auxRand))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual := hex.EncodeToString(sig); actual != test.sig {
+				t.Errorf("Expected %v, got %v", test.sig, actual)
+			}
+		}
+	})
+
+	t.Run("Test verify", func(t *testing.T) {
+		for _, test := range tests {
+			point, err := ParseXOnly(util.HexStringToBytes(test.pubKey))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			msg := util.HexStringToBytes(test.msg)
+			sig := util.HexStringToBytes(test.sig)
+			if !point.VerifySchnorr(msg, sig) {
+				t.Errorf("Expected the signature to verify")
+			}
+			sig[63] ^= 1
+			if point.VerifySchnorr(msg, sig) {
+				t.Errorf("Expected a modified signature to fail")
+			}
+			if point.VerifySchnorr(msg, util.HexStringToBytes(strings.Repeat("ff", 64))) {
+				t.Errorf("Expected an out of range signature to fail")
+			}
+		}
+	})
+}
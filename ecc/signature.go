@@ -2,6 +2,7 @@ package ecc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/big"
 )
@@ -77,3 +78,85 @@ func ParseSignature(signatureBin []byte) *Signature {
 	}
 	return NewSignature(r, s)
 }
+
+// ParseSignatureLax parses a signature the way OpenSSL did before BIP66 made DER strict:
+// lengths can be in long form, integers can have extra leading zeros, and anything after
+// the S value is ignored.
+// Returns an error if the signature can't be parsed or r or s is out of range.
+func ParseSignatureLax(signatureBin []byte) (*Signature, error) {
+	pos := 0
+	// readLength reads a length byte, in long form if its top bit is set
+	readLength := func(skipOnly bool) (int, error) {
+		if pos == len(signatureBin) {
+			return 0, errors.New("signature too short")
+		}
+		lenByte := int(signatureBin[pos])
+		pos++
+		if lenByte&0x80 == 0 {
+			return lenByte, nil
+		}
+		lenByte -= 0x80
+		if lenByte > len(signatureBin)-pos {
+			return 0, errors.New("signature too short")
+		}
+		if skipOnly {
+			// the sequence length isn't checked
+			pos += lenByte
+			return 0, nil
+		}
+		for lenByte > 0 && signatureBin[pos] == 0 {
+			pos++
+			lenByte--
+		}
+		if lenByte >= 4 {
+			return 0, errors.New("signature length too long")
+		}
+		length := 0
+		for ; lenByte > 0; lenByte-- {
+			length = length<<8 + int(signatureBin[pos])
+			pos++
+		}
+		return length, nil
+	}
+	readInteger := func() (*big.Int, error) {
+		if pos == len(signatureBin) || signatureBin[pos] != 0x02 {
+			return nil, errors.New("bad signature integer marker")
+		}
+		pos++
+		length, err := readLength(false)
+		if err != nil {
+			return nil, err
+		}
+		if length > len(signatureBin)-pos {
+			return nil, errors.New("signature too short")
+		}
+		value := signatureBin[pos : pos+length]
+		pos += length
+		for len(value) > 0 && value[0] == 0 {
+			value = value[1:]
+		}
+		if len(value) > 32 {
+			return nil, errors.New("signature integer too long")
+		}
+		return new(big.Int).SetBytes(value), nil
+	}
+	if len(signatureBin) == 0 || signatureBin[0] != 0x30 {
+		return nil, errors.New("bad signature marker")
+	}
+	pos++
+	if _, err := readLength(true); err != nil {
+		return nil, err
+	}
+	r, err := readInteger()
+	if err != nil {
+		return nil, err
+	}
+	s, err := readInteger()
+	if err != nil {
+		return nil, err
+	}
+	if r.Sign() == 0 || r.Cmp(_N) >= 0 || s.Sign() == 0 || s.Cmp(_N) >= 0 {
+		return nil, errors.New("signature out of range")
+	}
+	return NewSignature(r, s), nil
+}
@@ -0,0 +1,422 @@
+package fees
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Settings of Bitcoin Core's fee estimator.
+const (
+	// The three horizons keep statistics with a different decay for periods of a number of blocks.
+	shortBlockPeriods = 12
+	shortScale        = 1
+	shortDecay        = .962
+	medBlockPeriods   = 24
+	medScale          = 2
+	medDecay          = .9952
+	longBlockPeriods  = 42
+	longScale         = 24
+	longDecay         = .99931
+
+	// Success rates of the estimates for half, the whole and double the target.
+	halfSuccessPct   = .6
+	successPct       = .85
+	doubleSuccessPct = .95
+
+	// sufficientFeeTxs and sufficientTxsShort are how many transactions per block
+	// a range of buckets needs to be tested for success.
+	sufficientFeeTxs   = .1
+	sufficientTxsShort = .5
+
+	// Fee rate buckets go up by feeSpacing from the minimum to the maximum.
+	minBucketFeeRate = 100
+	maxBucketFeeRate = 1e7
+	feeSpacing       = 1.05
+)
+
+// MaxTarget is the most blocks a fee rate can be estimated for.
+const MaxTarget = longBlockPeriods * longScale
+
+// txConfirmStats tracks, for each fee rate bucket, how many blocks the transactions
+// took to confirm, in periods of scale blocks, with averages that decay with every block.
+type txConfirmStats struct {
+	buckets []float64
+	decay   float64
+	scale   int
+	// txCtAvg is the number of confirmed transactions in each bucket.
+	txCtAvg []float64
+	// confAvg[p][b] is the number that confirmed within p+1 periods.
+	confAvg [][]float64
+	// failAvg[p][b] is the number that left the mempool unconfirmed after p+1 periods.
+	failAvg [][]float64
+	// feeRateAvg is the total of the fee rates of the confirmed transactions in each bucket.
+	feeRateAvg []float64
+	// unconfTxs[h][b] is the number of unconfirmed transactions that entered at a height h
+	// modulo the maximum confirmations, oldUnconfTxs the ones older than that.
+	unconfTxs    [][]int
+	oldUnconfTxs []int
+}
+
+func newTxConfirmStats(buckets []float64, maxPeriods int, decay float64, scale int) *txConfirmStats {
+	result := &txConfirmStats{
+		buckets:      buckets,
+		decay:        decay,
+		scale:        scale,
+		txCtAvg:      make([]float64, len(buckets)),
+		feeRateAvg:   make([]float64, len(buckets)),
+		confAvg:      make([][]float64, maxPeriods),
+		failAvg:      make([][]float64, maxPeriods),
+		unconfTxs:    make([][]int, maxPeriods*scale),
+		oldUnconfTxs: make([]int, len(buckets)),
+	}
+	for i := range result.confAvg {
+		result.confAvg[i] = make([]float64, len(buckets))
+		result.failAvg[i] = make([]float64, len(buckets))
+	}
+	for i := range result.unconfTxs {
+		result.unconfTxs[i] = make([]int, len(buckets))
+	}
+	return result
+}
+
+// maxConfirms returns the most blocks the statistics track.
+func (s *txConfirmStats) maxConfirms() int {
+	return s.scale * len(s.confAvg)
+}
+
+// clearCurrent makes room for the transactions entering at a new height.
+func (s *txConfirmStats) clearCurrent(height int) {
+	current := s.unconfTxs[height%len(s.unconfTxs)]
+	for b := range current {
+		s.oldUnconfTxs[b] += current[b]
+		current[b] = 0
+	}
+}
+
+// record adds a transaction that confirmed after some blocks.
+func (s *txConfirmStats) record(blocksToConfirm int, feeRate float64, bucket int) {
+	if blocksToConfirm < 1 {
+		return
+	}
+	periodsToConfirm := (blocksToConfirm + s.scale - 1) / s.scale
+	for p := periodsToConfirm; p <= len(s.confAvg); p++ {
+		s.confAvg[p-1][bucket]++
+	}
+	s.txCtAvg[bucket]++
+	s.feeRateAvg[bucket] += feeRate
+}
+
+func (s *txConfirmStats) updateMovingAverages() {
+	for b := range s.buckets {
+		for p := range s.confAvg {
+			s.confAvg[p][b] *= s.decay
+			s.failAvg[p][b] *= s.decay
+		}
+		s.feeRateAvg[b] *= s.decay
+		s.txCtAvg[b] *= s.decay
+	}
+}
+
+// newTx adds an unconfirmed transaction.
+func (s *txConfirmStats) newTx(height int, bucket int) {
+	s.unconfTxs[height%len(s.unconfTxs)][bucket]++
+}
+
+// removeTx removes an unconfirmed transaction, counting a failure if it left the mempool without confirming.
+func (s *txConfirmStats) removeTx(entryHeight, bestHeight int, bucket int, inBlock bool) {
+	blocksAgo := bestHeight - entryHeight
+	if blocksAgo < 0 {
+		return
+	}
+	if blocksAgo >= len(s.unconfTxs) {
+		if s.oldUnconfTxs[bucket] > 0 {
+			s.oldUnconfTxs[bucket]--
+		}
+	} else if unconf := s.unconfTxs[entryHeight%len(s.unconfTxs)]; unconf[bucket] > 0 {
+		unconf[bucket]--
+	}
+	if !inBlock && blocksAgo >= s.scale {
+		periodsAgo := blocksAgo / s.scale
+		for p := 0; p < periodsAgo && p < len(s.failAvg); p++ {
+			s.failAvg[p][bucket]++
+		}
+	}
+}
+
+// estimateMedianVal returns the lowest fee rate that confirmed within target blocks at the success rate,
+// or -1 if there isn't one. Starting from the highest bucket, buckets are grouped until they have
+// sufficientTxVal transactions per block, and each group passing the success rate moves the answer down.
+// The answer is the average fee rate of the bucket holding the median transaction of the last passing group.
+func (s *txConfirmStats) estimateMedianVal(target int, sufficientTxVal, successBreakPoint float64, height int) float64 {
+	var nConf, totalNum, failNum, partialNum float64
+	extraNum := 0
+	periodTarget := (target + s.scale - 1) / s.scale
+	maxBucket := len(s.buckets) - 1
+	curNearBucket, curFarBucket := maxBucket, maxBucket
+	bestNearBucket, bestFarBucket := maxBucket, maxBucket
+	foundAnswer := false
+	newBucketRange := true
+	bins := len(s.unconfTxs)
+	for b := maxBucket; b >= 0; b-- {
+		if newBucketRange {
+			curNearBucket = b
+			newBucketRange = false
+		}
+		curFarBucket = b
+		nConf += s.confAvg[periodTarget-1][b]
+		partialNum += s.txCtAvg[b]
+		totalNum += s.txCtAvg[b]
+		failNum += s.failAvg[periodTarget-1][b]
+		// transactions still waiting for at least target blocks
+		for confirms := target; confirms < s.maxConfirms(); confirms++ {
+			extraNum += s.unconfTxs[((height-confirms)%bins+bins)%bins][b]
+		}
+		extraNum += s.oldUnconfTxs[b]
+		if partialNum < sufficientTxVal/(1-s.decay) {
+			continue
+		}
+		partialNum = 0
+		if nConf/(totalNum+failNum+float64(extraNum)) < successBreakPoint {
+			continue
+		}
+		foundAnswer = true
+		nConf, totalNum, failNum, extraNum = 0, 0, 0, 0
+		bestNearBucket, bestFarBucket = curNearBucket, curFarBucket
+		newBucketRange = true
+	}
+	if !foundAnswer {
+		return -1
+	}
+	minBucket, maxBucket := bestFarBucket, bestNearBucket
+	txSum := 0.0
+	for b := minBucket; b <= maxBucket; b++ {
+		txSum += s.txCtAvg[b]
+	}
+	if txSum == 0 {
+		return -1
+	}
+	txSum /= 2
+	for b := minBucket; b <= maxBucket; b++ {
+		if s.txCtAvg[b] < txSum {
+			txSum -= s.txCtAvg[b]
+			continue
+		}
+		return s.feeRateAvg[b] / s.txCtAvg[b]
+	}
+	return -1
+}
+
+// trackedTx is a mempool transaction the estimator is waiting to see confirmed.
+type trackedTx struct {
+	height  int
+	bucket  int
+	feeRate float64
+}
+
+// Estimator estimates the fee rate that confirms a transaction within a number of blocks
+// with Bitcoin Core's estimatesmartfee algorithm. It learns from how many blocks transactions
+// take to confirm: transactions are added when they are first seen unconfirmed, and the
+// blocks as they connect. To replay a local block store, process each block and add the
+// transactions first seen at its height before processing the next.
+// It is safe for concurrent use.
+type Estimator struct {
+	mu                  sync.Mutex
+	buckets             []float64
+	shortStats          *txConfirmStats
+	feeStats            *txConfirmStats
+	longStats           *txConfirmStats
+	tracked             map[string]*trackedTx
+	bestHeight          int
+	firstRecordedHeight int
+}
+
+// NewEstimator returns an estimator that hasn't seen any blocks.
+func NewEstimator() *Estimator {
+	var buckets []float64
+	for boundary := float64(minBucketFeeRate); boundary <= maxBucketFeeRate; boundary *= feeSpacing {
+		buckets = append(buckets, boundary)
+	}
+	buckets = append(buckets, math.Inf(1))
+	return &Estimator{
+		buckets:    buckets,
+		shortStats: newTxConfirmStats(buckets, shortBlockPeriods, shortDecay, shortScale),
+		feeStats:   newTxConfirmStats(buckets, medBlockPeriods, medDecay, medScale),
+		longStats:  newTxConfirmStats(buckets, longBlockPeriods, longDecay, longScale),
+		tracked:    make(map[string]*trackedTx),
+	}
+}
+
+func (e *Estimator) allStats() []*txConfirmStats {
+	return []*txConfirmStats{e.shortStats, e.feeStats, e.longStats}
+}
+
+// AddTransaction adds an unconfirmed transaction first seen when the best block was at height.
+// It is ignored unless height is the height of the last processed block:
+// the time to confirm isn't known for transactions seen earlier or during a reorg.
+func (e *Estimator) AddTransaction(txID string, feeRate FeeRate, height int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.tracked[txID]; ok || height != e.bestHeight || e.bestHeight == 0 {
+		return
+	}
+	// the first bucket with a boundary at or above the fee rate
+	bucket := sort.SearchFloat64s(e.buckets, float64(feeRate))
+	e.tracked[txID] = &trackedTx{height: height, bucket: bucket, feeRate: float64(feeRate)}
+	for _, stats := range e.allStats() {
+		stats.newTx(height, bucket)
+	}
+}
+
+// RemoveTransaction removes a transaction that left the mempool without confirming,
+// e.g. a replaced or expired one.
+func (e *Estimator) RemoveTransaction(txID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removeTx(txID, false)
+}
+
+func (e *Estimator) removeTx(txID string, inBlock bool) *trackedTx {
+	entry, ok := e.tracked[txID]
+	if !ok {
+		return nil
+	}
+	for _, stats := range e.allStats() {
+		stats.removeTx(entry.height, e.bestHeight, entry.bucket, inBlock)
+	}
+	delete(e.tracked, txID)
+	return entry
+}
+
+// ProcessBlock records the transactions confirmed by the block at height.
+// Blocks that aren't above the last processed one are ignored.
+func (e *Estimator) ProcessBlock(height int, txIDs []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if height <= e.bestHeight {
+		return
+	}
+	e.bestHeight = height
+	for _, stats := range e.allStats() {
+		stats.clearCurrent(height)
+		stats.updateMovingAverages()
+	}
+	counted := 0
+	for _, txID := range txIDs {
+		entry := e.removeTx(txID, true)
+		if entry == nil {
+			continue
+		}
+		blocksToConfirm := height - entry.height
+		if blocksToConfirm <= 0 {
+			continue
+		}
+		counted++
+		for _, stats := range e.allStats() {
+			stats.record(blocksToConfirm, entry.feeRate, entry.bucket)
+		}
+	}
+	if e.firstRecordedHeight == 0 && counted > 0 {
+		e.firstRecordedHeight = height
+	}
+}
+
+// maxUsableTarget returns the highest target there is enough history for: half the blocks recorded.
+func (e *Estimator) maxUsableTarget() int {
+	span := 0
+	if e.firstRecordedHeight > 0 {
+		span = e.bestHeight - e.firstRecordedHeight
+	}
+	if span/2 < e.longStats.maxConfirms() {
+		return span / 2
+	}
+	return e.longStats.maxConfirms()
+}
+
+// estimateCombinedFee returns the estimate for a target from the shortest horizon tracking it,
+// or a lower estimate for the longest target of a shorter horizon if checkShorterHorizon is set.
+func (e *Estimator) estimateCombinedFee(target int, successThreshold float64, checkShorterHorizon bool) float64 {
+	estimate := -1.0
+	if target < 1 || target > e.longStats.maxConfirms() {
+		return estimate
+	}
+	switch {
+	case target <= e.shortStats.maxConfirms():
+		estimate = e.shortStats.estimateMedianVal(target, sufficientTxsShort, successThreshold, e.bestHeight)
+	case target <= e.feeStats.maxConfirms():
+		estimate = e.feeStats.estimateMedianVal(target, sufficientFeeTxs, successThreshold, e.bestHeight)
+	default:
+		estimate = e.longStats.estimateMedianVal(target, sufficientFeeTxs, successThreshold, e.bestHeight)
+	}
+	if !checkShorterHorizon {
+		return estimate
+	}
+	if target > e.feeStats.maxConfirms() {
+		medMax := e.feeStats.estimateMedianVal(e.feeStats.maxConfirms(), sufficientFeeTxs, successThreshold, e.bestHeight)
+		if medMax > 0 && (estimate == -1 || medMax < estimate) {
+			estimate = medMax
+		}
+	}
+	if target > e.shortStats.maxConfirms() {
+		shortMax := e.shortStats.estimateMedianVal(e.shortStats.maxConfirms(), sufficientTxsShort, successThreshold, e.bestHeight)
+		if shortMax > 0 && (estimate == -1 || shortMax < estimate) {
+			estimate = shortMax
+		}
+	}
+	return estimate
+}
+
+// estimateConservativeFee returns the highest estimate of the medium and long horizons
+// for double the target at the double success rate.
+func (e *Estimator) estimateConservativeFee(doubleTarget int) float64 {
+	estimate := -1.0
+	if doubleTarget <= e.shortStats.maxConfirms() {
+		estimate = e.feeStats.estimateMedianVal(doubleTarget, sufficientFeeTxs, doubleSuccessPct, e.bestHeight)
+	}
+	if doubleTarget <= e.feeStats.maxConfirms() {
+		if longEstimate := e.longStats.estimateMedianVal(doubleTarget, sufficientFeeTxs, doubleSuccessPct, e.bestHeight); longEstimate > estimate {
+			estimate = longEstimate
+		}
+	}
+	return estimate
+}
+
+// EstimateSmartFee returns the fee rate that confirms a transaction within target blocks, like estimatesmartfee:
+// the highest of the estimates for half the target at a 60% success rate, the target at 85%
+// and double the target at 95%. Conservative estimates also consider the longer horizons for double the target.
+// Returns the target the estimate is for, which is lower than target if there isn't enough history,
+// or an error if there isn't an estimate.
+func (e *Estimator) EstimateSmartFee(target int, conservative bool) (FeeRate, int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if target <= 0 || target > e.longStats.maxConfirms() {
+		return 0, 0, errors.New("target out of range")
+	}
+	// one block can't be estimated
+	if target == 1 {
+		target = 2
+	}
+	if maxUsable := e.maxUsableTarget(); target > maxUsable {
+		target = maxUsable
+	}
+	if target <= 1 {
+		return 0, 0, errors.New("insufficient data")
+	}
+	median := e.estimateCombinedFee(target/2, halfSuccessPct, true)
+	if actual := e.estimateCombinedFee(target, successPct, true); actual > median {
+		median = actual
+	}
+	if double := e.estimateCombinedFee(2*target, doubleSuccessPct, !conservative); double > median {
+		median = double
+	}
+	if conservative || median == -1 {
+		if cons := e.estimateConservativeFee(2 * target); cons > median {
+			median = cons
+		}
+	}
+	if median < 0 {
+		return 0, target, errors.New("insufficient data")
+	}
+	return FeeRate(math.Round(median)), target, nil
+}
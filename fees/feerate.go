@@ -0,0 +1,48 @@
+package fees
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// FeeRate is a fee rate in satoshis per 1000 virtual bytes, as Bitcoin Core keeps them.
+type FeeRate uint64
+
+// NewFeeRate returns the fee rate of a fee paid for a virtual size.
+func NewFeeRate(fee uint64, vsize int) FeeRate {
+	if vsize <= 0 {
+		return 0
+	}
+	return FeeRate(fee * 1000 / uint64(vsize))
+}
+
+// SatPerVByte returns the fee rate of an amount of satoshis per virtual byte.
+func SatPerVByte(satPerVByte float64) FeeRate {
+	return FeeRate(math.Round(satPerVByte * 1000))
+}
+
+// TxFeeRate returns the fee rate a transaction pays.
+// provider looks up the amounts of the outputs the inputs spend.
+func TxFeeRate(t *tx.Transaction, provider tx.PrevoutProvider) (FeeRate, error) {
+	fee, err := t.Fee(provider)
+	if err != nil {
+		return 0, err
+	}
+	return NewFeeRate(fee, t.VSize()), nil
+}
+
+// Fee returns the fee for a virtual size, rounded up.
+func (r FeeRate) Fee(vsize int) uint64 {
+	return (uint64(r)*uint64(vsize) + 999) / 1000
+}
+
+// SatPerVByte returns the fee rate in satoshis per virtual byte.
+func (r FeeRate) SatPerVByte() float64 {
+	return float64(r) / 1000
+}
+
+func (r FeeRate) String() string {
+	return fmt.Sprintf("%.3f sat/vB", r.SatPerVByte())
+}
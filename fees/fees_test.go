@@ -0,0 +1,204 @@
+package fees
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestEstimateSize(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(7001)),
+		ecc.NewPrivateKey(big.NewInt(7002)),
+		ecc.NewPrivateKey(big.NewInt(7003)),
+	}
+	pk := keys[0]
+	h160 := pk.Point.Hash160(true)
+	p2wpkh := script.P2wpkhScript(h160)
+	multisig, _ := script.MultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	outputKey, _ := script.TaprootOutputKey(pk.EvenY().Point, nil)
+	p2tr := script.P2trScript(outputKey.XOnly())
+	prevTx := util.Hash256([]byte("size test"))
+	tests := []struct {
+		name         string
+		scriptPubKey *script.Script
+		redeemScript *script.Script
+		expected     Input
+	}{
+		{"p2pkh", script.P2pkhScript(h160), nil, Input{Type: P2pkh}},
+		{"p2sh multisig", script.P2shScript(util.Hash160(multisig.RawSerialize())), multisig, Input{Type: P2shMultisig, M: 2, N: 3}},
+		{"p2wpkh", p2wpkh, nil, Input{Type: P2wpkh}},
+		{"p2sh-p2wpkh", script.P2shScript(util.Hash160(p2wpkh.RawSerialize())), p2wpkh, Input{Type: P2shP2wpkh}},
+		{"p2wsh multisig", script.P2wshScript(util.Sha256(multisig.RawSerialize())), multisig, Input{Type: P2wshMultisig, M: 2, N: 3}},
+		{"p2tr", p2tr, nil, Input{Type: P2trKeyPath}},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			in, err := InputFor(test.scriptPubKey, test.redeemScript)
+			if err != nil || in != test.expected {
+				t.Fatalf("Expected %v, got %v %v", test.expected, in, err)
+			}
+			provider := tx.NewMemoryProvider()
+			// a legacy input next to the one tested covers mixed witness transactions
+			provider.AddOutput(prevTx, 0, tx.NewOutput(100000, test.scriptPubKey))
+			provider.AddOutput(prevTx, 1, tx.NewOutput(100000, script.P2pkhScript(h160)))
+			txIns := []*tx.Input{tx.NewInput(prevTx, 0, nil, tx.SequenceFinal), tx.NewInput(prevTx, 1, nil, tx.SequenceFinal)}
+			txOuts := []*tx.Output{tx.NewOutput(190000, p2wpkh), tx.NewOutput(5000, p2tr)}
+			txObj := tx.NewTransaction(2, txIns, txOuts, 0, false)
+			// ECDSA signatures can be a byte shorter than estimated, p2tr signatures can't
+			sigs := 1
+			switch {
+			case test.expected.M > 0:
+				sigs = test.expected.M
+				for _, key := range keys[:test.expected.M] {
+					if _, err := txObj.SignMultisigInput(provider, 0, multisig, key, util.SigHashAll); err != nil {
+						t.Fatalf("Unexpected error: %v", err)
+					}
+				}
+			case test.expected.Type == P2trKeyPath:
+				sigs = 0
+				if _, err := txObj.SignInput(provider, 0, pk, util.SigHashDefault); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			default:
+				if _, err := txObj.SignInput(provider, 0, pk, util.SigHashAll); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if _, err := txObj.SignInput(provider, 1, pk, util.SigHashAll); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ok, err := txObj.Verify(provider); !ok {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			estimate := EstimateWeight([]Input{in, {Type: P2pkh}}, txOuts)
+			// a byte is 4 weight units outside the witness
+			if actual := txObj.Weight(); estimate < actual || estimate-actual > 4*(sigs+1) {
+				t.Errorf("Expected about %d, got an estimate of %d", actual, estimate)
+			}
+		})
+	}
+
+	t.Run("Test typical sizes", func(t *testing.T) {
+		p2wpkhOut := []*tx.Output{tx.NewOutput(1000, p2wpkh)}
+		if actual := EstimateVSize([]Input{{Type: P2wpkh}}, p2wpkhOut); actual != 110 {
+			t.Errorf("Expected 110, got %d", actual)
+		}
+		if actual := EstimateVSize([]Input{{Type: P2trKeyPath}}, []*tx.Output{tx.NewOutput(1000, p2tr)}); actual != 111 {
+			t.Errorf("Expected 111, got %d", actual)
+		}
+		if actual := EstimateVSize([]Input{{Type: P2pkh}}, []*tx.Output{tx.NewOutput(1000, script.P2pkhScript(h160))}); actual != 192 {
+			t.Errorf("Expected 192, got %d", actual)
+		}
+	})
+
+	t.Run("Test unknown inputs", func(t *testing.T) {
+		if _, err := InputFor(script.P2shScript(make([]byte, 20)), nil); err == nil {
+			t.Errorf("Expected an error without the redeem script")
+		}
+		if _, err := InputFor(script.P2shScript(make([]byte, 20)), script.P2pkhScript(h160)); err == nil {
+			t.Errorf("Expected an error for an unsupported redeem script")
+		}
+		if _, err := InputFor(new(script.Script).AppendOp(script.Op1), nil); err == nil {
+			t.Errorf("Expected an error for a nonstandard script")
+		}
+	})
+}
+
+func TestFeeRate(t *testing.T) {
+	rate := NewFeeRate(1000, 250)
+	if rate != 4000 || rate.String() != "4.000 sat/vB" {
+		t.Errorf("Expected 4.000 sat/vB, got %s", rate)
+	}
+	if fee := rate.Fee(141); fee != 564 {
+		t.Errorf("Expected 564, got %d", fee)
+	}
+	if fee := SatPerVByte(1.5).Fee(141); fee != 212 {
+		t.Errorf("Expected the fee to round up to 212, got %d", fee)
+	}
+	if NewFeeRate(1000, 0) != 0 {
+		t.Errorf("Expected 0 for an empty size")
+	}
+	prevTx := util.Hash256([]byte("fee rate test"))
+	provider := tx.NewMemoryProvider()
+	provider.AddOutput(prevTx, 0, tx.NewOutput(10000, script.P2pkhScript(make([]byte, 20))))
+	txIns := []*tx.Input{tx.NewInput(prevTx, 0, nil, tx.SequenceFinal)}
+	txObj := tx.NewTransaction(1, txIns, []*tx.Output{tx.NewOutput(9000, script.P2pkhScript(make([]byte, 20)))}, 0, false)
+	if actual, err := TxFeeRate(txObj, provider); err != nil || actual != NewFeeRate(1000, txObj.VSize()) {
+		t.Errorf("Unexpected fee rate %s %v", actual, err)
+	}
+	if _, err := TxFeeRate(txObj, tx.NewMemoryProvider()); err == nil {
+		t.Errorf("Expected an error for an unknown prevout")
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	e := NewEstimator()
+	if _, _, err := e.EstimateSmartFee(6, false); err == nil {
+		t.Errorf("Expected an error without any blocks")
+	}
+	if _, _, err := e.EstimateSmartFee(MaxTarget+1, false); err == nil {
+		t.Errorf("Expected an error for a target out of range")
+	}
+	// each block has transactions paying 50 sat/vB confirming in the next block,
+	// 10 sat/vB in 3 blocks and 2 sat/vB in 10 blocks
+	classes := []struct {
+		feeRate FeeRate
+		blocks  int
+	}{
+		{SatPerVByte(50), 1},
+		{SatPerVByte(10), 3},
+		{SatPerVByte(2), 10},
+	}
+	confirms := make(map[int][]string)
+	for height := 1; height <= 300; height++ {
+		e.ProcessBlock(height, confirms[height])
+		for i, class := range classes {
+			for j := 0; j < 5; j++ {
+				txID := fmt.Sprintf("%d-%d-%d", height, i, j)
+				e.AddTransaction(txID, class.feeRate, height)
+				confirms[height+class.blocks] = append(confirms[height+class.blocks], txID)
+			}
+		}
+		// replaced transactions never confirm
+		e.AddTransaction(fmt.Sprintf("%d-replaced", height), SatPerVByte(1), height)
+		e.RemoveTransaction(fmt.Sprintf("%d-replaced", height))
+		if height == 10 {
+			if _, target, err := e.EstimateSmartFee(25, false); err != nil || target != 4 {
+				t.Errorf("Expected an estimate for 4 blocks with little history, got %d %v", target, err)
+			}
+		}
+	}
+	tests := []struct {
+		target       int
+		conservative bool
+		expected     FeeRate
+	}{
+		{1, false, SatPerVByte(50)},
+		{2, false, SatPerVByte(50)},
+		{6, false, SatPerVByte(10)},
+		{6, true, SatPerVByte(10)},
+		{25, false, SatPerVByte(2)},
+		{144, false, SatPerVByte(2)},
+	}
+	for _, test := range tests {
+		actual, target, err := e.EstimateSmartFee(test.target, test.conservative)
+		if err != nil || actual != test.expected {
+			t.Errorf("Expected %s for %d blocks, got %s %v", test.expected, test.target, actual, err)
+		}
+		if test.target > 1 && target != test.target {
+			t.Errorf("Expected the estimate for %d blocks, got %d", test.target, target)
+		}
+	}
+	// older blocks and transactions seen before the best block are ignored
+	e.ProcessBlock(200, nil)
+	e.AddTransaction("late", SatPerVByte(1), 299)
+	if actual, _, _ := e.EstimateSmartFee(2, false); actual != SatPerVByte(50) {
+		t.Errorf("Expected the estimate not to change, got %s", actual)
+	}
+}
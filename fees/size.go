@@ -0,0 +1,167 @@
+// Package fees estimates transaction sizes before they are signed,
+// computes fee rates and estimates the fee rate that confirms in a number of blocks.
+package fees
+
+import (
+	"errors"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Sizes of the parts of a signed input, the largest they can be.
+const (
+	// MaxSigSize is a DER ECDSA signature with its hash type byte.
+	MaxSigSize = 72
+	// PubKeySize is a compressed SEC public key.
+	PubKeySize = 33
+	// SchnorrSigSize is a BIP340 signature with the default hash type, one more with another.
+	SchnorrSigSize = 64
+)
+
+// InputType is how an input spends its output, which decides the size of its ScriptSig and witness.
+type InputType int
+
+// Input types with a known size.
+const (
+	P2pkh InputType = iota
+	P2shMultisig
+	P2wpkh
+	P2shP2wpkh
+	P2wshMultisig
+	P2trKeyPath
+)
+
+// Input describes an input before it is signed.
+type Input struct {
+	Type InputType
+	// M and N are the threshold and the number of keys of a multisig input.
+	M, N int
+}
+
+// InputFor returns how an output is spent. redeemScript is the redeem script of a p2sh output
+// or the witness script of a p2wsh output, nil for other outputs.
+func InputFor(scriptPubKey, redeemScript *script.Script) (Input, error) {
+	version, program, isWitness := scriptPubKey.WitnessProgram()
+	switch {
+	case scriptPubKey.IsP2pkhScriptPubKey():
+		return Input{Type: P2pkh}, nil
+	case isWitness && version == 0 && len(program) == 20:
+		return Input{Type: P2wpkh}, nil
+	case isWitness && version == 1 && len(program) == 32:
+		return Input{Type: P2trKeyPath}, nil
+	case redeemScript == nil:
+		return Input{}, errors.New("the size of the input depends on a script that wasn't given")
+	case isWitness && version == 0 && len(program) == 32:
+		if m, pubKeys, ok := redeemScript.Multisig(); ok {
+			return Input{Type: P2wshMultisig, M: m, N: len(pubKeys)}, nil
+		}
+	case scriptPubKey.IsP2shScriptPubKey():
+		if version, program, ok := redeemScript.WitnessProgram(); ok && version == 0 && len(program) == 20 {
+			return Input{Type: P2shP2wpkh}, nil
+		}
+		if m, pubKeys, ok := redeemScript.Multisig(); ok {
+			return Input{Type: P2shMultisig, M: m, N: len(pubKeys)}, nil
+		}
+	}
+	return Input{}, errors.New("unsupported input type")
+}
+
+// pushSize returns the size of a push of data of a length.
+func pushSize(length int) int {
+	switch {
+	case length < script.OpPushData1:
+		return 1 + length
+	case length < 0x100:
+		return 2 + length
+	}
+	return 3 + length
+}
+
+// multisigScriptSize returns the size of an m-of-n script with compressed keys.
+func multisigScriptSize(n int) int {
+	return 1 + n*pushSize(PubKeySize) + 1 + 1
+}
+
+// scriptSigSize returns the size of the ScriptSig, without its length.
+func (in Input) scriptSigSize() int {
+	switch in.Type {
+	case P2pkh:
+		return pushSize(MaxSigSize) + pushSize(PubKeySize)
+	case P2shMultisig:
+		// OP_0 for the OP_CHECKMULTISIG bug, the signatures and the redeem script
+		return 1 + in.M*pushSize(MaxSigSize) + pushSize(multisigScriptSize(in.N))
+	case P2shP2wpkh:
+		return pushSize(22)
+	}
+	return 0
+}
+
+// witnessSize returns the size of the witness with its item count, 0 without one.
+func (in Input) witnessSize() int {
+	var items []int
+	switch in.Type {
+	case P2wpkh, P2shP2wpkh:
+		items = []int{MaxSigSize, PubKeySize}
+	case P2wshMultisig:
+		items = []int{0}
+		for i := 0; i < in.M; i++ {
+			items = append(items, MaxSigSize)
+		}
+		items = append(items, multisigScriptSize(in.N))
+	case P2trKeyPath:
+		items = []int{SchnorrSigSize}
+	default:
+		return 0
+	}
+	result := len(util.EncodeVarInt(len(items)))
+	for _, item := range items {
+		result += len(util.EncodeVarInt(item)) + item
+	}
+	return result
+}
+
+// HasWitness returns whether the input is signed with a witness.
+func (in Input) HasWitness() bool {
+	return in.witnessSize() > 0
+}
+
+// Weight returns the weight of the signed input.
+func (in Input) Weight() int {
+	scriptSigSize := in.scriptSigSize()
+	// outpoint, ScriptSig and sequence
+	size := 32 + 4 + len(util.EncodeVarInt(scriptSigSize)) + scriptSigSize + 4
+	return size*tx.WitnessScaleFactor + in.witnessSize()
+}
+
+// EstimateWeight returns the weight of a transaction once its inputs are signed.
+// The estimate is at most a few bytes over, as ECDSA signatures can be shorter than their maximum size.
+func EstimateWeight(inputs []Input, outputs []*tx.Output) int {
+	// version, locktime and the counts
+	size := 4 + 4 + len(util.EncodeVarInt(len(inputs))) + len(util.EncodeVarInt(len(outputs)))
+	for _, txOut := range outputs {
+		size += len(txOut.Serialize())
+	}
+	result := size * tx.WitnessScaleFactor
+	witness := false
+	for _, in := range inputs {
+		result += in.Weight()
+		witness = witness || in.HasWitness()
+	}
+	if witness {
+		// the marker and flag, and an empty witness for each input without one
+		result += 2
+		for _, in := range inputs {
+			if !in.HasWitness() {
+				result++
+			}
+		}
+	}
+	return result
+}
+
+// EstimateVSize returns the virtual size of a transaction once its inputs are signed.
+func EstimateVSize(inputs []Input, outputs []*tx.Output) int {
+	return (EstimateWeight(inputs, outputs) + tx.WitnessScaleFactor - 1) / tx.WitnessScaleFactor
+}
@@ -0,0 +1,247 @@
+package hd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// HardenedKeyStart is the first hardened child index.
+const HardenedKeyStart uint32 = 0x80000000
+
+// Version bytes for serialized extended keys.
+var (
+	mainnetPrivate = [4]byte{0x04, 0x88, 0xad, 0xe4}
+	mainnetPublic  = [4]byte{0x04, 0x88, 0xb2, 0x1e}
+	testnetPrivate = [4]byte{0x04, 0x35, 0x83, 0x94}
+	testnetPublic  = [4]byte{0x04, 0x35, 0x87, 0xcf}
+)
+
+// ExtendedKey is a BIP32 extended private or public key.
+type ExtendedKey struct {
+	Testnet           bool
+	Depth             byte
+	ParentFingerprint [4]byte
+	ChildNumber       uint32
+	ChainCode         [32]byte
+	// PrivateKey is nil for an extended public key.
+	PrivateKey *ecc.PrivateKey
+	PublicKey  *ecc.S256Point
+}
+
+// NewMaster returns the master key for a seed.
+func NewMaster(seed []byte, testnet bool) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed must be between 16 and 64 bytes")
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	i := mac.Sum(nil)
+	pk, err := ecc.ParsePrivateKey(i[:32])
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key, use another seed: %v", err)
+	}
+	result := &ExtendedKey{Testnet: testnet, PrivateKey: pk, PublicKey: pk.Point}
+	copy(result.ChainCode[:], i[32:])
+	return result, nil
+}
+
+// Parse an extended key from its base58 serialization.
+func Parse(s string) (*ExtendedKey, error) {
+	payload, err := util.DecodeBase58Checksum(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != 78 {
+		return nil, fmt.Errorf("extended key is %d bytes, expected 78", len(payload))
+	}
+	var version [4]byte
+	copy(version[:], payload[:4])
+	result := &ExtendedKey{Depth: payload[4]}
+	copy(result.ParentFingerprint[:], payload[5:9])
+	result.ChildNumber = binary.BigEndian.Uint32(payload[9:13])
+	copy(result.ChainCode[:], payload[13:45])
+	keyData := payload[45:]
+	private := false
+	switch version {
+	case mainnetPrivate:
+		private = true
+	case testnetPrivate:
+		private = true
+		result.Testnet = true
+	case mainnetPublic:
+	case testnetPublic:
+		result.Testnet = true
+	default:
+		return nil, fmt.Errorf("unknown extended key version %x", version)
+	}
+	if private {
+		if keyData[0] != 0 {
+			return nil, errors.New("invalid private key data")
+		}
+		if result.PrivateKey, err = ecc.ParsePrivateKey(keyData[1:]); err != nil {
+			return nil, err
+		}
+		result.PublicKey = result.PrivateKey.Point
+	} else {
+		if result.PublicKey, err = ecc.ParseSec(keyData); err != nil {
+			return nil, err
+		}
+	}
+	if result.Depth == 0 && (result.ParentFingerprint != [4]byte{} || result.ChildNumber != 0) {
+		return nil, errors.New("master key with a parent")
+	}
+	return result, nil
+}
+
+// String returns the base58 serialization of the key.
+func (key *ExtendedKey) String() string {
+	var version [4]byte
+	switch {
+	case key.IsPrivate() && key.Testnet:
+		version = testnetPrivate
+	case key.IsPrivate():
+		version = mainnetPrivate
+	case key.Testnet:
+		version = testnetPublic
+	default:
+		version = mainnetPublic
+	}
+	result := make([]byte, 0, 78)
+	result = append(result, version[:]...)
+	result = append(result, key.Depth)
+	result = append(result, key.ParentFingerprint[:]...)
+	childNumber := make([]byte, 4)
+	binary.BigEndian.PutUint32(childNumber, key.ChildNumber)
+	result = append(result, childNumber...)
+	result = append(result, key.ChainCode[:]...)
+	if key.IsPrivate() {
+		result = append(result, 0)
+		result = append(result, key.PrivateKey.Bytes()...)
+	} else {
+		result = append(result, key.PublicKey.Sec(true)...)
+	}
+	return util.EncodeBase58Checksum(result)
+}
+
+// IsPrivate returns whether this is an extended private key.
+func (key *ExtendedKey) IsPrivate() bool {
+	return key.PrivateKey != nil
+}
+
+// Neuter returns the extended public key.
+func (key *ExtendedKey) Neuter() *ExtendedKey {
+	result := *key
+	result.PrivateKey = nil
+	return &result
+}
+
+// Fingerprint returns the first 4 bytes of the hash160 of the public key.
+func (key *ExtendedKey) Fingerprint() [4]byte {
+	var result [4]byte
+	copy(result[:], key.PublicKey.Hash160(true))
+	return result
+}
+
+// Child derives the child key at index.
+// Indexes from HardenedKeyStart on are hardened and require a private key.
+func (key *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if key.Depth == 0xff {
+		return nil, errors.New("maximum depth reached")
+	}
+	data := make([]byte, 0, 37)
+	if index >= HardenedKeyStart {
+		if !key.IsPrivate() {
+			return nil, errors.New("cannot derive a hardened child from a public key")
+		}
+		data = append(data, 0)
+		data = append(data, key.PrivateKey.Bytes()...)
+	} else {
+		data = append(data, key.PublicKey.Sec(true)...)
+	}
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	data = append(data, indexBytes...)
+	mac := hmac.New(sha512.New, key.ChainCode[:])
+	mac.Write(data)
+	i := mac.Sum(nil)
+	tweak := new(big.Int).SetBytes(i[:32])
+	result := &ExtendedKey{
+		Testnet:           key.Testnet,
+		Depth:             key.Depth + 1,
+		ParentFingerprint: key.Fingerprint(),
+		ChildNumber:       index,
+	}
+	copy(result.ChainCode[:], i[32:])
+	var err error
+	if key.IsPrivate() {
+		if result.PrivateKey, err = key.PrivateKey.TweakAdd(tweak); err != nil {
+			return nil, fmt.Errorf("invalid child %d: %v", index, err)
+		}
+		result.PublicKey = result.PrivateKey.Point
+	} else if result.PublicKey, err = key.PublicKey.TweakAdd(tweak); err != nil {
+		return nil, fmt.Errorf("invalid child %d: %v", index, err)
+	}
+	return result, nil
+}
+
+// Derive follows a path of child indexes from this key.
+func (key *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	result := key
+	for _, index := range path {
+		var err error
+		if result, err = result.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// ParsePath parses a derivation path such as m/84'/0'/0'/0/1.
+// Hardened indexes may be marked with ' or h. The leading m/ is optional.
+func ParsePath(path string) ([]uint32, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "m"), "/")
+	if path == "" {
+		return []uint32{}, nil
+	}
+	parts := strings.Split(path, "/")
+	result := make([]uint32, len(parts))
+	for i, part := range parts {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= uint64(HardenedKeyStart) {
+			return nil, fmt.Errorf("invalid path element %q", parts[i])
+		}
+		result[i] = uint32(index)
+		if hardened {
+			result[i] += HardenedKeyStart
+		}
+	}
+	return result, nil
+}
+
+// FormatPath returns a derivation path as a string such as m/84'/0'/0'/0/1.
+func FormatPath(path []uint32) string {
+	var buf bytes.Buffer
+	buf.WriteString("m")
+	for _, index := range path {
+		if index >= HardenedKeyStart {
+			fmt.Fprintf(&buf, "/%d'", index-HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&buf, "/%d", index)
+		}
+	}
+	return buf.String()
+}
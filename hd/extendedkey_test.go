@@ -0,0 +1,90 @@
+package hd
+
+import (
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestExtendedKey(t *testing.T) {
+	t.Run("Test BIP32 vector 1", func(t *testing.T) {
+		master, err := NewMaster(util.HexStringToBytes("000102030405060708090a0b0c0d0e0f"), false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		tests := []struct {
+			path string
+			xpub string
+			xprv string
+		}{
+			{
+				"m",
+				"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+				"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+			},
+			{
+				"m/0'",
+				"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+				"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+			},
+			{
+				"m/0'/1",
+				"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+				"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs",
+			},
+		}
+		for _, test := range tests {
+			path, err := ParsePath(test.path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			key, err := master.Derive(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual := key.String(); actual != test.xprv {
+				t.Errorf("Expected %s, got %s", test.xprv, actual)
+			}
+			if actual := key.Neuter().String(); actual != test.xpub {
+				t.Errorf("Expected %s, got %s", test.xpub, actual)
+			}
+			parsed, err := Parse(test.xprv)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if parsed.String() != test.xprv {
+				t.Errorf("Failed to round trip %s", test.xprv)
+			}
+		}
+	})
+
+	t.Run("Test public derivation", func(t *testing.T) {
+		xprv, _ := Parse("xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi")
+		xpub := xprv.Neuter()
+		path := []uint32{1, 2}
+		fromPrivate, _ := xprv.Derive(path)
+		fromPublic, err := xpub.Derive(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if fromPrivate.Neuter().String() != fromPublic.String() {
+			t.Errorf("Expected %s, got %s", fromPrivate.Neuter(), fromPublic)
+		}
+		if _, err := xpub.Child(HardenedKeyStart); err == nil {
+			t.Errorf("Expected an error deriving a hardened child from an xpub")
+		}
+	})
+
+	t.Run("Test path", func(t *testing.T) {
+		path, err := ParsePath("m/84h/0'/0'/0/1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual := FormatPath(path); actual != "m/84'/0'/0'/0/1" {
+			t.Errorf("Expected m/84'/0'/0'/0/1, got %s", actual)
+		}
+		if _, err := ParsePath("m/2147483648"); err == nil {
+			t.Errorf("Expected an error for an index out of range")
+		}
+	})
+}
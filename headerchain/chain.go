@@ -0,0 +1,364 @@
+// Package headerchain keeps the headers of every branch of a block chain and follows the one
+// with the most work, as a node syncing headers first does.
+package headerchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/validation"
+)
+
+// headerSize is the size of a serialized header.
+const headerSize = 80
+
+// Node is a header in the chain.
+type Node struct {
+	Header *block.Block
+	Hash   []byte
+	Height int
+	// Work is the chainwork up to and including the header.
+	Work   *big.Int
+	Parent *Node
+}
+
+// Work returns the expected number of hashes it takes to find a header meeting a target:
+// 2^256 / (target + 1).
+func Work(target *big.Int) *big.Int {
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// Reorg is how the best chain changed when a header was added: the nodes that left it,
+// from the old tip down, and the nodes that joined it, from the fork up to the new tip.
+type Reorg struct {
+	Disconnected []*Node
+	Connected    []*Node
+}
+
+// Chain holds headers from a genesis header, indexed by hash, and the best chain, the branch with
+// the most work, indexed by height. It is safe for concurrent use.
+type Chain struct {
+	mu     sync.RWMutex
+	nodes  map[string]*Node
+	best   []*Node
+	leaves map[*Node]bool
+	// Params, if set, has headers checked against the consensus rules of their branch.
+	// Otherwise headers only need to meet their own target.
+	Params *validation.Params
+	file   *os.File
+}
+
+// New returns a chain with just the genesis header.
+func New(genesis *block.Block, params *validation.Params) *Chain {
+	node := &Node{Header: genesis, Hash: genesis.Hash(), Work: Work(genesis.Target())}
+	return &Chain{
+		nodes:  map[string]*Node{hex.EncodeToString(node.Hash): node},
+		best:   []*Node{node},
+		leaves: map[*Node]bool{node: true},
+		Params: params,
+	}
+}
+
+// Open returns a chain with the headers saved in a file, which each header added is appended to.
+// The file is created if it doesn't exist. A header cut short at the end of the file is dropped.
+func Open(filename string, genesis *block.Block, params *validation.Params) (*Chain, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := New(genesis, params)
+	buffer := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(file, buffer); err != nil {
+			break
+		}
+		if _, err := c.add(block.Parse(bytes.NewReader(buffer))); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: header at %d: %v", filename, offset, err)
+		}
+		offset += headerSize
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+// Close closes the file of a chain from Open.
+func (c *Chain) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// Add adds a header whose parent is in the chain, and saves it if the chain has a file.
+// Returns how the best chain changed, nil if it didn't, or an error if the header
+// is already in the chain, its parent isn't, or it breaks the consensus rules.
+func (c *Chain) Add(header *block.Block) (*Reorg, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reorg, err := c.add(header)
+	if err != nil {
+		return nil, err
+	}
+	if c.file != nil {
+		if _, err := c.file.Write(header.Serialize()); err != nil {
+			return reorg, err
+		}
+	}
+	return reorg, nil
+}
+
+func (c *Chain) add(header *block.Block) (*Reorg, error) {
+	hash := header.Hash()
+	if _, ok := c.nodes[hex.EncodeToString(hash)]; ok {
+		return nil, fmt.Errorf("header %x is already in the chain", hash)
+	}
+	parent, ok := c.nodes[hex.EncodeToString(header.PrevBlock[:])]
+	if !ok {
+		return nil, fmt.Errorf("parent %x of header %x isn't in the chain", header.PrevBlock, hash)
+	}
+	if !header.CheckPow() {
+		return nil, fmt.Errorf("header %x doesn't meet its target", hash)
+	}
+	if c.Params != nil {
+		ctx := validation.NewContext(c.Params, parent.Height+1, &branch{c, parent}, nil)
+		if err := validation.CheckHeader(header, ctx); err != nil {
+			return nil, err
+		}
+	}
+	node := &Node{
+		Header: header,
+		Hash:   hash,
+		Height: parent.Height + 1,
+		Work:   new(big.Int).Add(parent.Work, Work(header.Target())),
+		Parent: parent,
+	}
+	c.nodes[hex.EncodeToString(hash)] = node
+	delete(c.leaves, parent)
+	c.leaves[node] = true
+	// the first branch to reach the most work stays the best
+	if node.Work.Cmp(c.tip().Work) <= 0 {
+		return nil, nil
+	}
+	return c.setTip(node), nil
+}
+
+// setTip makes the best chain end at node.
+func (c *Chain) setTip(node *Node) *Reorg {
+	reorg := &Reorg{}
+	fork := node
+	for !c.inBest(fork) {
+		reorg.Connected = append(reorg.Connected, fork)
+		fork = fork.Parent
+	}
+	for height := len(c.best) - 1; height > fork.Height; height-- {
+		reorg.Disconnected = append(reorg.Disconnected, c.best[height])
+	}
+	c.best = c.best[:fork.Height+1]
+	for i := len(reorg.Connected) - 1; i >= 0; i-- {
+		c.best = append(c.best, reorg.Connected[i])
+	}
+	// connected from the fork up
+	for i, j := 0, len(reorg.Connected)-1; i < j; i, j = i+1, j-1 {
+		reorg.Connected[i], reorg.Connected[j] = reorg.Connected[j], reorg.Connected[i]
+	}
+	return reorg
+}
+
+func (c *Chain) inBest(node *Node) bool {
+	return node.Height < len(c.best) && c.best[node.Height] == node
+}
+
+func (c *Chain) tip() *Node {
+	return c.best[len(c.best)-1]
+}
+
+// ancestor returns the node at height on the branch of node.
+func (c *Chain) ancestor(node *Node, height int) *Node {
+	if height < 0 || height > node.Height {
+		return nil
+	}
+	for !c.inBest(node) {
+		if node.Height == height {
+			return node
+		}
+		node = node.Parent
+	}
+	return c.best[height]
+}
+
+// branch is the chain ending at a node, for checking a header on it.
+type branch struct {
+	chain *Chain
+	tip   *Node
+}
+
+// HeaderAt implements block.Chain.
+func (b *branch) HeaderAt(height int) *block.Block {
+	if node := b.chain.ancestor(b.tip, height); node != nil {
+		return node.Header
+	}
+	return nil
+}
+
+// Tip returns the last node of the best chain.
+func (c *Chain) Tip() *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tip()
+}
+
+// Height returns the height of the best chain.
+func (c *Chain) Height() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.best) - 1
+}
+
+// NodeAt returns the node at a height of the best chain, nil if it's higher than the tip.
+func (c *Chain) NodeAt(height int) *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height < 0 || height >= len(c.best) {
+		return nil
+	}
+	return c.best[height]
+}
+
+// HeaderAt implements block.Chain with the best chain.
+func (c *Chain) HeaderAt(height int) *block.Block {
+	if node := c.NodeAt(height); node != nil {
+		return node.Header
+	}
+	return nil
+}
+
+// Node returns the node of a header in any branch, nil if it isn't in the chain.
+func (c *Chain) Node(hash []byte) *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodes[hex.EncodeToString(hash)]
+}
+
+// InBest returns whether a header is in the best chain.
+func (c *Chain) InBest(hash []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	node, ok := c.nodes[hex.EncodeToString(hash)]
+	return ok && c.inBest(node)
+}
+
+// Tips returns the last node of each branch, the most work first.
+func (c *Chain) Tips() []*Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]*Node, 0, len(c.leaves))
+	for node := range c.leaves {
+		result = append(result, node)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if cmp := result[i].Work.Cmp(result[j].Work); cmp != 0 {
+			return cmp > 0
+		}
+		// the best chain's tip first among equals
+		return c.inBest(result[i])
+	})
+	return result
+}
+
+// Locator returns the hashes of a block locator for the best chain: the last ten headers,
+// then back twice as far each step, ending with the genesis header.
+func (c *Chain) Locator() [][]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var result [][]byte
+	step := 1
+	for height := len(c.best) - 1; height > 0; height -= step {
+		result = append(result, c.best[height].Hash)
+		if len(result) >= 10 {
+			step *= 2
+		}
+	}
+	return append(result, c.best[0].Hash)
+}
+
+// FindFork returns the last node of the best chain that is in a locator, the genesis node if none is.
+func (c *Chain) FindFork(locator [][]byte) *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, hash := range locator {
+		if node, ok := c.nodes[hex.EncodeToString(hash)]; ok && c.inBest(node) {
+			return node
+		}
+	}
+	return c.best[0]
+}
+
+// ErrUnknownParent is returned by AddHeaders for a batch that doesn't connect to the chain.
+var ErrUnknownParent = errors.New("headers don't connect to the chain")
+
+// AddHeaders adds a batch of headers in order, as a headers message holds them, and returns how the
+// best chain changed overall. Headers already in the chain are skipped.
+func (c *Chain) AddHeaders(headers []*block.Block) (*Reorg, error) {
+	if len(headers) > 0 && c.Node(headers[0].PrevBlock[:]) == nil {
+		return nil, ErrUnknownParent
+	}
+	oldTip := c.Tip()
+	for _, header := range headers {
+		if c.Node(header.Hash()) != nil {
+			continue
+		}
+		if _, err := c.Add(header); err != nil {
+			return nil, err
+		}
+	}
+	newTip := c.Tip()
+	if newTip == oldTip {
+		return nil, nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	reorg := &Reorg{}
+	fork := c.ancestor(newTip, minInt(oldTip.Height, newTip.Height))
+	old := oldTip
+	for old.Height > fork.Height {
+		reorg.Disconnected = append(reorg.Disconnected, old)
+		old = old.Parent
+	}
+	// the old tip's branch and the best chain meet below the heights they share
+	for old != fork {
+		reorg.Disconnected = append(reorg.Disconnected, old)
+		old, fork = old.Parent, fork.Parent
+	}
+	for node := newTip; node != fork; node = node.Parent {
+		reorg.Connected = append([]*Node{node}, reorg.Connected...)
+	}
+	return reorg, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
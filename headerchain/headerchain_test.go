@@ -0,0 +1,209 @@
+package headerchain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/util"
+	"github.com/ravdin/programmingbitcoin/validation"
+)
+
+var regTestBits = util.HexStringToBytes(`ffff7f20`)
+
+// mineHeader returns a header on top of parent meeting its target, tag makes branches differ.
+func mineHeader(parent *block.Block, tag string) *block.Block {
+	prevBlock := make([]byte, 32)
+	timestamp := uint32(1600000000)
+	if parent != nil {
+		prevBlock = parent.Hash()
+		timestamp = parent.Timestamp + 600
+	}
+	header := block.NewBlock(0x20000000, prevBlock, util.Hash256([]byte(tag)), timestamp, regTestBits, nil, nil)
+	for nonce := uint32(0); !header.CheckPow(); nonce++ {
+		copy(header.Nonce[:], util.Int32ToLittleEndian(nonce))
+	}
+	return header
+}
+
+// mineBranch returns n headers on top of parent.
+func mineBranch(parent *block.Block, n int, tag string) []*block.Block {
+	var result []*block.Block
+	for i := 0; i < n; i++ {
+		parent = mineHeader(parent, tag)
+		result = append(result, parent)
+	}
+	return result
+}
+
+func TestWork(t *testing.T) {
+	target := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1))
+	if actual := Work(target); actual.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("Expected 2, got %d", actual)
+	}
+	// the genesis header's target of 0xffff * 2^208 takes 2^32 + 2^16 + 1 hashes
+	genesis := block.Parse(bytes.NewReader(block.GenesisBlock))
+	if actual := Work(genesis.Target()); actual.Cmp(big.NewInt(0x100010001)) != 0 {
+		t.Errorf("Expected %d, got %d", 0x100010001, actual)
+	}
+}
+
+func TestChain(t *testing.T) {
+	genesis := mineHeader(nil, "genesis")
+	main := mineBranch(genesis, 10, "main")
+	fork := mineBranch(main[4], 6, "fork")
+
+	t.Run("Test reorg", func(t *testing.T) {
+		chain := New(genesis, validation.RegTestParams)
+		reorg, err := chain.AddHeaders(main)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(reorg.Connected) != 10 || len(reorg.Disconnected) != 0 || chain.Height() != 10 {
+			t.Errorf("Expected 10 headers connected, got %d %d", len(reorg.Connected), len(reorg.Disconnected))
+		}
+		// the fork has as much work as the best chain after 5 headers, the first stays the best
+		for _, header := range fork[:5] {
+			if reorg, err := chain.Add(header); reorg != nil || err != nil {
+				t.Fatalf("Expected no reorg, got %+v %v", reorg, err)
+			}
+		}
+		if !bytes.Equal(chain.Tip().Hash, main[9].Hash()) {
+			t.Errorf("Expected the first branch to stay the best")
+		}
+		if tips := chain.Tips(); len(tips) != 2 || tips[0] != chain.Tip() {
+			t.Errorf("Expected two tips, the best first, got %d", len(tips))
+		}
+		reorg, err = chain.Add(fork[5])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(reorg.Disconnected) != 5 || !bytes.Equal(reorg.Disconnected[0].Hash, main[9].Hash()) {
+			t.Errorf("Expected 5 headers disconnected from the old tip down, got %d", len(reorg.Disconnected))
+		}
+		if len(reorg.Connected) != 6 || !bytes.Equal(reorg.Connected[0].Hash, fork[0].Hash()) || reorg.Connected[5].Height != 11 {
+			t.Errorf("Expected 6 headers connected from the fork up, got %d", len(reorg.Connected))
+		}
+		if chain.InBest(main[9].Hash()) || !chain.InBest(main[4].Hash()) || !chain.InBest(fork[5].Hash()) {
+			t.Errorf("Expected the best chain to follow the fork")
+		}
+		if node := chain.Node(main[9].Hash()); node == nil || node.Height != 10 {
+			t.Errorf("Expected the old branch to be kept")
+		}
+		if !bytes.Equal(chain.HeaderAt(6).Hash(), fork[0].Hash()) {
+			t.Errorf("Expected the fork at height 6")
+		}
+		expectedWork := big.NewInt(2 * 12)
+		if chain.Tip().Work.Cmp(expectedWork) != 0 {
+			t.Errorf("Expected %d, got %d", expectedWork, chain.Tip().Work)
+		}
+		// back to the first branch
+		more := mineBranch(main[9], 2, "main")
+		reorg, err = chain.AddHeaders(more)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(reorg.Disconnected) != 6 || len(reorg.Connected) != 7 || chain.Height() != 12 {
+			t.Errorf("Expected 6 disconnected and 7 connected, got %d %d", len(reorg.Disconnected), len(reorg.Connected))
+		}
+	})
+
+	t.Run("Test invalid headers", func(t *testing.T) {
+		chain := New(genesis, validation.RegTestParams)
+		if _, err := chain.Add(main[1]); err == nil {
+			t.Errorf("Expected an error for a header without its parent")
+		}
+		if _, err := chain.AddHeaders(main[1:]); err != ErrUnknownParent {
+			t.Errorf("Expected %v, got %v", ErrUnknownParent, err)
+		}
+		if _, err := chain.Add(main[0]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := chain.Add(main[0]); err == nil {
+			t.Errorf("Expected an error for a header already in the chain")
+		}
+		early := block.NewBlock(0x20000000, main[0].Hash(), nil, genesis.Timestamp, regTestBits, nil, nil)
+		for nonce := uint32(0); !early.CheckPow(); nonce++ {
+			copy(early.Nonce[:], util.Int32ToLittleEndian(nonce))
+		}
+		if _, err := chain.Add(early); err == nil {
+			t.Errorf("Expected an error for a header before the median time past")
+		}
+		// without params only the target is checked
+		if _, err := New(genesis, nil).Add(mineBranch(genesis, 1, "x")[0]); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test locator", func(t *testing.T) {
+		chain := New(genesis, nil)
+		long := mineBranch(genesis, 40, "long")
+		if _, err := chain.AddHeaders(long); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		locator := chain.Locator()
+		// heights 40 to 31, then 29, 25, 17, 1, and the genesis header
+		expected := []int{40, 39, 38, 37, 36, 35, 34, 33, 32, 31, 29, 25, 17, 1, 0}
+		if len(locator) != len(expected) {
+			t.Fatalf("Expected %d hashes, got %d", len(expected), len(locator))
+		}
+		for i, height := range expected {
+			if !bytes.Equal(locator[i], chain.NodeAt(height).Hash) {
+				t.Errorf("Expected height %d at %d", height, i)
+			}
+		}
+		other := New(genesis, nil)
+		other.AddHeaders(long[:20])
+		other.AddHeaders(mineBranch(long[19], 3, "other"))
+		if node := chain.FindFork(other.Locator()); node.Height != 20 {
+			t.Errorf("Expected the fork at 20, got %d", node.Height)
+		}
+		if node := chain.FindFork([][]byte{make([]byte, 32)}); node.Height != 0 {
+			t.Errorf("Expected the genesis node, got %d", node.Height)
+		}
+	})
+
+	t.Run("Test persistence", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "headerchain")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "headers")
+		chain, err := Open(filename, genesis, validation.RegTestParams)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		chain.AddHeaders(main)
+		chain.AddHeaders(fork)
+		chain.Close()
+		// a header cut short by a crash
+		file, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		file.Write(make([]byte, 40))
+		file.Close()
+		chain, err = Open(filename, genesis, validation.RegTestParams)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(chain.Tip().Hash, fork[5].Hash()) || len(chain.Tips()) != 2 {
+			t.Errorf("Expected the chain to resume at the fork's tip")
+		}
+		more := mineBranch(fork[5], 1, "fork")
+		if _, err := chain.Add(more[0]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		chain.Close()
+		chain, err = Open(filename, genesis, validation.RegTestParams)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer chain.Close()
+		if chain.Height() != 12 {
+			t.Errorf("Expected 12, got %d", chain.Height())
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package miniscript
+
+import (
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Script compiles the expression to a witness script.
+func (n *Node) Script() *script.Script {
+	return script.NewScriptFromCommands(n.commands())
+}
+
+// keyHash returns the hash160 of the key of a pk_h.
+func (n *Node) keyHash() []byte {
+	if len(n.Keys[0]) == 20 {
+		return n.Keys[0]
+	}
+	return util.Hash160(n.Keys[0])
+}
+
+func op(opcode byte) script.Command {
+	return script.Command{Opcode: opcode}
+}
+
+func concat(parts ...[]script.Command) []script.Command {
+	var result []script.Command
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// hashOps returns the opcode of a hash fragment.
+var hashOps = map[string]byte{
+	FragmentSha256:    script.OpSha256,
+	FragmentHash256:   script.OpHash256,
+	FragmentRipemd160: script.OpRipemd160,
+	FragmentHash160:   script.OpHash160,
+}
+
+// verifyOps maps an opcode to its VERIFY form.
+var verifyOps = map[byte]byte{
+	script.OpEqual:         script.OpEqualVerify,
+	script.OpCheckSig:      script.OpCheckSigVerify,
+	script.OpCheckMultiSig: script.OpCheckMultiSigVerify,
+}
+
+func (n *Node) commands() []script.Command {
+	var args [][]script.Command
+	for _, arg := range n.Args {
+		args = append(args, arg.commands())
+	}
+	switch n.Fragment {
+	case Fragment0:
+		return []script.Command{op(script.Op0)}
+	case Fragment1:
+		return []script.Command{op(script.Op1)}
+	case FragmentPkK:
+		return []script.Command{script.DataCommand(n.Keys[0])}
+	case FragmentPkH:
+		return []script.Command{op(script.OpDup), op(script.OpHash160), script.DataCommand(n.keyHash()), op(script.OpEqualVerify)}
+	case FragmentOlder:
+		return []script.Command{script.IntCommand(int(n.Value)), op(script.OpCheckSequenceVerify)}
+	case FragmentAfter:
+		return []script.Command{script.IntCommand(int(n.Value)), op(script.OpCheckLockTimeVerify)}
+	case FragmentSha256, FragmentHash256, FragmentRipemd160, FragmentHash160:
+		return []script.Command{
+			op(script.OpSize), script.IntCommand(32), op(script.OpEqualVerify),
+			op(hashOps[n.Fragment]), script.DataCommand(n.Hash), op(script.OpEqual),
+		}
+	case FragmentAndOr:
+		// [X] NOTIF [Z] ELSE [Y] ENDIF
+		return concat(args[0], []script.Command{op(script.OpNotIf)}, args[2],
+			[]script.Command{op(script.OpElse)}, args[1], []script.Command{op(script.OpEndIf)})
+	case FragmentAndV:
+		return concat(args[0], args[1])
+	case FragmentAndB:
+		return concat(args[0], args[1], []script.Command{op(script.OpBoolAnd)})
+	case FragmentOrB:
+		return concat(args[0], args[1], []script.Command{op(script.OpBoolOr)})
+	case FragmentOrC:
+		return concat(args[0], []script.Command{op(script.OpNotIf)}, args[1], []script.Command{op(script.OpEndIf)})
+	case FragmentOrD:
+		return concat(args[0], []script.Command{op(script.OpIfDup), op(script.OpNotIf)}, args[1], []script.Command{op(script.OpEndIf)})
+	case FragmentOrI:
+		return concat([]script.Command{op(script.OpIf)}, args[0], []script.Command{op(script.OpElse)}, args[1], []script.Command{op(script.OpEndIf)})
+	case FragmentThresh:
+		// [X1] [X2] ADD ... [Xn] ADD <k> EQUAL
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = concat(result, arg, []script.Command{op(script.OpAdd)})
+		}
+		return concat(result, []script.Command{script.IntCommand(n.K), op(script.OpEqual)})
+	case FragmentMulti:
+		result := []script.Command{script.IntCommand(n.K)}
+		for _, key := range n.Keys {
+			result = append(result, script.DataCommand(key))
+		}
+		return append(result, script.IntCommand(len(n.Keys)), op(script.OpCheckMultiSig))
+	case WrapperA:
+		return concat([]script.Command{op(script.OpToAltStack)}, args[0], []script.Command{op(script.OpFromAltStack)})
+	case WrapperS:
+		return concat([]script.Command{op(script.OpSwap)}, args[0])
+	case WrapperC:
+		return concat(args[0], []script.Command{op(script.OpCheckSig)})
+	case WrapperD:
+		return concat([]script.Command{op(script.OpDup), op(script.OpIf)}, args[0], []script.Command{op(script.OpEndIf)})
+	case WrapperV:
+		result := args[0]
+		last := result[len(result)-1]
+		if verify, ok := verifyOps[last.Opcode]; ok && !last.IsData() {
+			// fold the VERIFY into the last opcode
+			result[len(result)-1] = op(verify)
+			return result
+		}
+		return append(result, op(script.OpVerify))
+	case WrapperJ:
+		return concat([]script.Command{op(script.OpSize), op(script.Op0NotEqual), op(script.OpIf)}, args[0], []script.Command{op(script.OpEndIf)})
+	case WrapperN:
+		return concat(args[0], []script.Command{op(script.Op0NotEqual)})
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package miniscript
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/script"
+)
+
+// lifter decodes a script into an expression by reading its commands from the end.
+//
+// Expressions are terms that end in a distinctive command, joined by and_v where
+// one simply follows another. The first argument of a combinator is always read
+// as a single term, so a chain of and_v is kept at the outermost level.
+type lifter struct {
+	cmds []script.Command
+	pos  int
+}
+
+// Lift decodes a witness script into the miniscript expression it was compiled from.
+func Lift(scr *script.Script) (*Node, error) {
+	cmds := scr.Commands()
+	l := &lifter{cmds: cmds, pos: len(cmds)}
+	result, err := l.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if l.pos != 0 {
+		return nil, fmt.Errorf("unexpected %s at %d", cmds[l.pos-1], l.pos-1)
+	}
+	return result, nil
+}
+
+// peek returns the command before the current position, if any.
+func (l *lifter) peek() (script.Command, bool) {
+	if l.pos == 0 {
+		return script.Command{}, false
+	}
+	return l.cmds[l.pos-1], true
+}
+
+// next consumes the command before the current position.
+func (l *lifter) next() (script.Command, error) {
+	cmd, ok := l.peek()
+	if !ok {
+		return cmd, errors.New("unexpected start of script")
+	}
+	l.pos--
+	return cmd, nil
+}
+
+// expect consumes the given opcodes, which must appear in this order before the current position.
+func (l *lifter) expect(opcodes ...byte) error {
+	for i := len(opcodes) - 1; i >= 0; i-- {
+		cmd, err := l.next()
+		if err != nil {
+			return err
+		}
+		if cmd.IsData() || cmd.Opcode != opcodes[i] {
+			return fmt.Errorf("expected %s, got %s", script.Command{Opcode: opcodes[i]}, cmd)
+		}
+	}
+	return nil
+}
+
+// match returns whether the given opcodes appear before the current position, consuming them if so.
+func (l *lifter) match(opcodes ...byte) bool {
+	if l.pos < len(opcodes) {
+		return false
+	}
+	for i, opcode := range opcodes {
+		cmd := l.cmds[l.pos-len(opcodes)+i]
+		if cmd.IsData() || cmd.Opcode != opcode {
+			return false
+		}
+	}
+	l.pos -= len(opcodes)
+	return true
+}
+
+func (l *lifter) number() (int, error) {
+	cmd, err := l.next()
+	if err != nil {
+		return 0, err
+	}
+	return cmd.Number()
+}
+
+func (l *lifter) data(length int) ([]byte, error) {
+	cmd, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.IsData() || len(cmd.Data) != length {
+		return nil, fmt.Errorf("expected a %d byte push, got %s", length, cmd)
+	}
+	return cmd.Data, nil
+}
+
+// sequence reads terms joined by and_v until it reaches the start of the
+// script or a command that opens an enclosing expression.
+func (l *lifter) sequence() (*Node, error) {
+	result, err := l.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		cmd, ok := l.peek()
+		if !ok {
+			return result, nil
+		}
+		if !cmd.IsData() {
+			switch cmd.Opcode {
+			case script.OpIf, script.OpNotIf, script.OpElse, script.OpToAltStack, script.OpSwap:
+				return result, nil
+			}
+		}
+		x, err := l.term()
+		if err != nil {
+			return nil, err
+		}
+		if result, err = combine(FragmentAndV, x, result); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wrap applies a wrapper to the term before the current position.
+func (l *lifter) wrap(wrapper string) (*Node, error) {
+	x, err := l.term()
+	if err != nil {
+		return nil, err
+	}
+	return NewNode(wrapper, 0, 0, nil, nil, x)
+}
+
+// verify lifts a VERIFY opcode as v: wrapping the term ending in the plain opcode.
+func (l *lifter) verify(opcode byte) (*Node, error) {
+	l.cmds[l.pos] = script.Command{Opcode: opcode}
+	l.pos++
+	x, err := l.term()
+	if err != nil {
+		return nil, err
+	}
+	return NewNode(WrapperV, 0, 0, nil, nil, x)
+}
+
+// term reads a single expression ending at the current position.
+func (l *lifter) term() (*Node, error) {
+	cmd, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if cmd.IsData() {
+		if len(cmd.Data) != 33 {
+			return nil, fmt.Errorf("unexpected push of %d bytes", len(cmd.Data))
+		}
+		return NewNode(FragmentPkK, 0, 0, [][]byte{cmd.Data}, nil)
+	}
+	switch cmd.Opcode {
+	case script.Op0:
+		return leaf(Fragment0), nil
+	case script.Op1:
+		return leaf(Fragment1), nil
+	case script.OpCheckSequenceVerify, script.OpCheckLockTimeVerify:
+		value, err := l.number()
+		if err != nil {
+			return nil, err
+		}
+		fragment := FragmentOlder
+		if cmd.Opcode == script.OpCheckLockTimeVerify {
+			fragment = FragmentAfter
+		}
+		return NewNode(fragment, 0, uint32(value), nil, nil)
+	case script.OpEqualVerify:
+		// pk_h is the only term to end in EQUALVERIFY itself
+		if l.pos >= 3 && l.cmds[l.pos-1].IsData() && l.cmds[l.pos-2].Opcode == script.OpHash160 && l.cmds[l.pos-3].Opcode == script.OpDup {
+			hash, _ := l.data(20)
+			l.pos -= 2
+			return NewNode(FragmentPkH, 0, 0, [][]byte{hash}, nil)
+		}
+		return l.verify(script.OpEqual)
+	case script.OpEqual:
+		if prev, ok := l.peek(); ok && prev.IsData() && (len(prev.Data) == 32 || len(prev.Data) == 20) {
+			return l.hash()
+		}
+		return l.thresh()
+	case script.OpCheckSig:
+		return l.wrap(WrapperC)
+	case script.OpCheckSigVerify:
+		return l.verify(script.OpCheckSig)
+	case script.OpCheckMultiSig:
+		return l.multi()
+	case script.OpCheckMultiSigVerify:
+		return l.verify(script.OpCheckMultiSig)
+	case script.OpVerify:
+		return l.wrap(WrapperV)
+	case script.Op0NotEqual:
+		return l.wrap(WrapperN)
+	case script.OpBoolAnd, script.OpBoolOr:
+		y, err := l.wexpr()
+		if err != nil {
+			return nil, err
+		}
+		x, err := l.term()
+		if err != nil {
+			return nil, err
+		}
+		fragment := FragmentAndB
+		if cmd.Opcode == script.OpBoolOr {
+			fragment = FragmentOrB
+		}
+		return combine(fragment, x, y)
+	case script.OpFromAltStack:
+		x, err := l.sequence()
+		if err != nil {
+			return nil, err
+		}
+		if err := l.expect(script.OpToAltStack); err != nil {
+			return nil, err
+		}
+		return NewNode(WrapperA, 0, 0, nil, nil, x)
+	case script.OpEndIf:
+		return l.endIf()
+	}
+	return nil, fmt.Errorf("unexpected %s", cmd)
+}
+
+// wexpr reads a W expression: a: or s: wrapping a sequence.
+func (l *lifter) wexpr() (*Node, error) {
+	if cmd, ok := l.peek(); ok && !cmd.IsData() && cmd.Opcode == script.OpFromAltStack {
+		return l.term()
+	}
+	x, err := l.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.expect(script.OpSwap); err != nil {
+		return nil, err
+	}
+	return NewNode(WrapperS, 0, 0, nil, nil, x)
+}
+
+// hash reads SIZE <32> EQUALVERIFY <hash op> <h> EQUAL without the final EQUAL.
+func (l *lifter) hash() (*Node, error) {
+	cmd, _ := l.next()
+	hashCmd, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	fragment := ""
+	for name, opcode := range hashOps {
+		if !hashCmd.IsData() && hashCmd.Opcode == opcode {
+			fragment = name
+		}
+	}
+	if fragment == "" {
+		return nil, fmt.Errorf("unexpected %s", hashCmd)
+	}
+	if err := l.expect(script.OpEqualVerify); err != nil {
+		return nil, err
+	}
+	if size, err := l.number(); err != nil || size != 32 {
+		return nil, errors.New("expected a preimage size of 32")
+	}
+	if err := l.expect(script.OpSize); err != nil {
+		return nil, err
+	}
+	return NewNode(fragment, 0, 0, nil, cmd.Data)
+}
+
+// thresh reads [X1] [X2] ADD ... [Xn] ADD <k> without the final EQUAL.
+func (l *lifter) thresh() (*Node, error) {
+	k, err := l.number()
+	if err != nil {
+		return nil, err
+	}
+	var args []*Node
+	for l.match(script.OpAdd) {
+		w, err := l.wexpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append([]*Node{w}, args...)
+	}
+	x, err := l.term()
+	if err != nil {
+		return nil, err
+	}
+	args = append([]*Node{x}, args...)
+	return NewNode(FragmentThresh, k, 0, nil, nil, args...)
+}
+
+// multi reads <k> <keys> <n> without the final CHECKMULTISIG.
+func (l *lifter) multi() (*Node, error) {
+	n, err := l.number()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > maxMultisigKeys {
+		return nil, fmt.Errorf("multi: %d keys out of range", n)
+	}
+	keys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if keys[i], err = l.data(33); err != nil {
+			return nil, err
+		}
+	}
+	k, err := l.number()
+	if err != nil {
+		return nil, err
+	}
+	return NewNode(FragmentMulti, k, 0, keys, nil)
+}
+
+// endIf reads the expressions that end in ENDIF: andor, or_c, or_d, or_i, d: and j:.
+func (l *lifter) endIf() (*Node, error) {
+	last, err := l.sequence()
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cmd.Opcode == script.OpElse:
+		first, err := l.sequence()
+		if err != nil {
+			return nil, err
+		}
+		if l.match(script.OpIf) {
+			return combine(FragmentOrI, first, last)
+		}
+		if err := l.expect(script.OpNotIf); err != nil {
+			return nil, err
+		}
+		x, err := l.term()
+		if err != nil {
+			return nil, err
+		}
+		return NewNode(FragmentAndOr, 0, 0, nil, nil, x, last, first)
+	case cmd.Opcode == script.OpIf:
+		if l.match(script.OpDup) {
+			return NewNode(WrapperD, 0, 0, nil, nil, last)
+		}
+		if l.match(script.OpSize, script.Op0NotEqual) {
+			return NewNode(WrapperJ, 0, 0, nil, nil, last)
+		}
+	case cmd.Opcode == script.OpNotIf:
+		fragment := FragmentOrC
+		if l.match(script.OpIfDup) {
+			fragment = FragmentOrD
+		}
+		x, err := l.term()
+		if err != nil {
+			return nil, err
+		}
+		return combine(fragment, x, last)
+	}
+	return nil, fmt.Errorf("unexpected %s", cmd)
+}
@@ -0,0 +1,386 @@
+// Package miniscript implements miniscript for P2WSH scripts:
+// parsing, type checking, compiling to script, lifting from script and satisfying.
+package miniscript
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fragment names.
+const (
+	Fragment0         = "0"
+	Fragment1         = "1"
+	FragmentPkK       = "pk_k"
+	FragmentPkH       = "pk_h"
+	FragmentOlder     = "older"
+	FragmentAfter     = "after"
+	FragmentSha256    = "sha256"
+	FragmentHash256   = "hash256"
+	FragmentRipemd160 = "ripemd160"
+	FragmentHash160   = "hash160"
+	FragmentAndOr     = "andor"
+	FragmentAndV      = "and_v"
+	FragmentAndB      = "and_b"
+	FragmentOrB       = "or_b"
+	FragmentOrC       = "or_c"
+	FragmentOrD       = "or_d"
+	FragmentOrI       = "or_i"
+	FragmentThresh    = "thresh"
+	FragmentMulti     = "multi"
+	WrapperA          = "a"
+	WrapperS          = "s"
+	WrapperC          = "c"
+	WrapperD          = "d"
+	WrapperV          = "v"
+	WrapperJ          = "j"
+	WrapperN          = "n"
+)
+
+const (
+	maxMultisigKeys          = 20
+	maxStandardScriptSize    = 3600
+	lockTimeThreshold        = 500000000
+	sequenceLockTimeTypeFlag = 1 << 22
+)
+
+// Node is a miniscript expression.
+type Node struct {
+	Fragment string
+	// K is the threshold of thresh and multi.
+	K int
+	// Value is the timelock of older and after.
+	Value uint32
+	// Keys are the compressed SEC public keys of pk_k, pk_h and multi.
+	// A pk_h lifted from a script only knows the 20 byte hash of its key.
+	Keys [][]byte
+	// Hash is the digest of the hash fragments.
+	Hash []byte
+	// Args are the subexpressions of combinators and wrappers.
+	Args []*Node
+	typ  Type
+}
+
+// NewNode returns a type checked expression.
+func NewNode(fragment string, k int, value uint32, keys [][]byte, hash []byte, args ...*Node) (*Node, error) {
+	result := &Node{Fragment: fragment, K: k, Value: value, Keys: keys, Hash: hash, Args: args}
+	if err := result.checkArgs(); err != nil {
+		return nil, err
+	}
+	typ, err := typeCheck(result)
+	if err != nil {
+		return nil, err
+	}
+	result.typ = typ
+	return result, nil
+}
+
+// checkArgs checks the number of arguments and the sizes of keys and hashes.
+func (n *Node) checkArgs() error {
+	args, keys, hashLength := 0, 0, 0
+	switch n.Fragment {
+	case Fragment0, Fragment1, FragmentOlder, FragmentAfter:
+	case FragmentPkK:
+		keys = 1
+	case FragmentPkH:
+		keys = 1
+		if len(n.Keys) == 1 && len(n.Keys[0]) == 20 {
+			// a bare key hash
+			return nil
+		}
+	case FragmentSha256, FragmentHash256:
+		hashLength = 32
+	case FragmentRipemd160, FragmentHash160:
+		hashLength = 20
+	case FragmentAndOr:
+		args = 3
+	case FragmentAndV, FragmentAndB, FragmentOrB, FragmentOrC, FragmentOrD, FragmentOrI:
+		args = 2
+	case FragmentThresh:
+		args = len(n.Args)
+		if args == 0 {
+			return errors.New("thresh: no arguments")
+		}
+	case FragmentMulti:
+		keys = len(n.Keys)
+	case WrapperA, WrapperS, WrapperC, WrapperD, WrapperV, WrapperJ, WrapperN:
+		args = 1
+	default:
+		return fmt.Errorf("unknown fragment %s", n.Fragment)
+	}
+	if len(n.Args) != args {
+		return fmt.Errorf("%s: expected %d arguments, got %d", n.Fragment, args, len(n.Args))
+	}
+	if len(n.Keys) != keys {
+		return fmt.Errorf("%s: expected %d keys, got %d", n.Fragment, keys, len(n.Keys))
+	}
+	for _, key := range n.Keys {
+		if len(key) != 33 || (key[0] != 2 && key[0] != 3) {
+			return fmt.Errorf("%s: keys must be compressed", n.Fragment)
+		}
+	}
+	if len(n.Hash) != hashLength {
+		return fmt.Errorf("%s: expected a %d byte hash, got %d", n.Fragment, hashLength, len(n.Hash))
+	}
+	return nil
+}
+
+// Type returns the type of the expression.
+func (n *Node) Type() Type {
+	return n.typ
+}
+
+// IsValid returns whether the expression can be used as a script: it must be type B.
+func (n *Node) IsValid() bool {
+	return n.typ.Basic == TypeB
+}
+
+// IsSane returns whether the expression is valid, every satisfaction requires a
+// signature, satisfactions are non-malleable, timelock types are not mixed and
+// the script is standard.
+func (n *Node) IsSane() bool {
+	return n.IsValid() && n.typ.Safe && n.typ.NonMalleable && n.typ.noTimelockMix &&
+		len(n.Script().RawSerialize()) <= maxStandardScriptSize
+}
+
+// Parse a miniscript expression such as and_v(v:pk(K),older(144)).
+// Keys are hex compressed SEC public keys.
+func Parse(s string) (*Node, error) {
+	var wrappers string
+	if colon := strings.IndexByte(s, ':'); colon >= 0 {
+		if paren := strings.IndexByte(s, '('); paren < 0 || colon < paren {
+			wrappers, s = s[:colon], s[colon+1:]
+		}
+	}
+	result, err := parseFragment(s)
+	if err != nil {
+		return nil, err
+	}
+	// wrappers apply right to left
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		var err error
+		switch wrappers[i] {
+		case 'a', 's', 'c', 'd', 'v', 'j', 'n':
+			result, err = NewNode(wrappers[i:i+1], 0, 0, nil, nil, result)
+		case 't':
+			result, err = combine(FragmentAndV, result, leaf(Fragment1))
+		case 'l':
+			result, err = combine(FragmentOrI, leaf(Fragment0), result)
+		case 'u':
+			result, err = combine(FragmentOrI, result, leaf(Fragment0))
+		default:
+			return nil, fmt.Errorf("unknown wrapper %c", wrappers[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func leaf(fragment string) *Node {
+	result, _ := NewNode(fragment, 0, 0, nil, nil)
+	return result
+}
+
+func combine(fragment string, x, y *Node) (*Node, error) {
+	return NewNode(fragment, 0, 0, nil, nil, x, y)
+}
+
+func parseFragment(s string) (*Node, error) {
+	if s == Fragment0 || s == Fragment1 {
+		return leaf(s), nil
+	}
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("invalid expression %q", s)
+	}
+	name := s[:open]
+	args, err := splitArgs(s[open+1 : len(s)-1])
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "pk", "pkh", FragmentPkK, FragmentPkH:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: expected one key", name)
+		}
+		key, err := hex.DecodeString(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid key %q", name, args[0])
+		}
+		fragment := FragmentPkK
+		if name == "pkh" || name == FragmentPkH {
+			fragment = FragmentPkH
+		}
+		result, err := NewNode(fragment, 0, 0, [][]byte{key}, nil)
+		if err != nil || name == fragment {
+			return result, err
+		}
+		return NewNode(WrapperC, 0, 0, nil, nil, result)
+	case FragmentOlder, FragmentAfter:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: expected one argument", name)
+		}
+		value, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q", name, args[0])
+		}
+		return NewNode(name, 0, uint32(value), nil, nil)
+	case FragmentSha256, FragmentHash256, FragmentRipemd160, FragmentHash160:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: expected one hash", name)
+		}
+		hash, err := hex.DecodeString(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid hash %q", name, args[0])
+		}
+		return NewNode(name, 0, 0, nil, hash)
+	case FragmentMulti:
+		if len(args) < 2 {
+			return nil, errors.New("multi: expected a threshold and keys")
+		}
+		k, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("multi: invalid threshold %q", args[0])
+		}
+		keys := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			if keys[i], err = hex.DecodeString(arg); err != nil {
+				return nil, fmt.Errorf("multi: invalid key %q", arg)
+			}
+		}
+		return NewNode(name, k, 0, keys, nil)
+	case FragmentThresh:
+		if len(args) < 2 {
+			return nil, errors.New("thresh: expected a threshold and arguments")
+		}
+		k, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("thresh: invalid threshold %q", args[0])
+		}
+		subs, err := parseAll(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return NewNode(name, k, 0, nil, nil, subs...)
+	case "and_n":
+		subs, err := parseAll(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(subs) != 2 {
+			return nil, errors.New("and_n: expected two arguments")
+		}
+		return NewNode(FragmentAndOr, 0, 0, nil, nil, subs[0], subs[1], leaf(Fragment0))
+	case FragmentAndOr, FragmentAndV, FragmentAndB, FragmentOrB, FragmentOrC, FragmentOrD, FragmentOrI:
+		subs, err := parseAll(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewNode(name, 0, 0, nil, nil, subs...)
+	}
+	return nil, fmt.Errorf("unknown fragment %s", name)
+}
+
+func parseAll(args []string) ([]*Node, error) {
+	result := make([]*Node, len(args))
+	for i, arg := range args {
+		var err error
+		if result[i], err = Parse(arg); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// splitArgs splits arguments on the commas that are not nested in parentheses.
+func splitArgs(s string) ([]string, error) {
+	var result []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+			}
+		case ',':
+			if depth == 0 {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+	}
+	return append(result, s[start:]), nil
+}
+
+// String returns the expression using the pk, pkh, and_n, t, l and u aliases where they apply.
+func (n *Node) String() string {
+	wrappers, inner := n.unwrap()
+	if wrappers == "" {
+		return inner
+	}
+	return wrappers + ":" + inner
+}
+
+// unwrap returns the wrappers of the expression and the string of what they wrap.
+func (n *Node) unwrap() (string, string) {
+	switch n.Fragment {
+	case WrapperC:
+		switch x := n.Args[0]; x.Fragment {
+		case FragmentPkK:
+			return "", "pk(" + hex.EncodeToString(x.Keys[0]) + ")"
+		case FragmentPkH:
+			return "", "pkh(" + hex.EncodeToString(x.Keys[0]) + ")"
+		}
+		fallthrough
+	case WrapperA, WrapperS, WrapperD, WrapperV, WrapperJ, WrapperN:
+		wrappers, inner := n.Args[0].unwrap()
+		return n.Fragment + wrappers, inner
+	case FragmentAndV:
+		if n.Args[1].Fragment == Fragment1 {
+			wrappers, inner := n.Args[0].unwrap()
+			return "t" + wrappers, inner
+		}
+	case FragmentOrI:
+		if n.Args[0].Fragment == Fragment0 {
+			wrappers, inner := n.Args[1].unwrap()
+			return "l" + wrappers, inner
+		}
+		if n.Args[1].Fragment == Fragment0 {
+			wrappers, inner := n.Args[0].unwrap()
+			return "u" + wrappers, inner
+		}
+	case FragmentAndOr:
+		if n.Args[2].Fragment == Fragment0 {
+			return "", "and_n(" + n.Args[0].String() + "," + n.Args[1].String() + ")"
+		}
+	}
+	var args []string
+	switch n.Fragment {
+	case Fragment0, Fragment1:
+		return "", n.Fragment
+	case FragmentOlder, FragmentAfter:
+		args = []string{strconv.FormatUint(uint64(n.Value), 10)}
+	case FragmentSha256, FragmentHash256, FragmentRipemd160, FragmentHash160:
+		args = []string{hex.EncodeToString(n.Hash)}
+	case FragmentThresh, FragmentMulti:
+		args = []string{strconv.Itoa(n.K)}
+	}
+	for _, key := range n.Keys {
+		args = append(args, hex.EncodeToString(key))
+	}
+	for _, arg := range n.Args {
+		args = append(args, arg.String())
+	}
+	return "", n.Fragment + "(" + strings.Join(args, ",") + ")"
+}
@@ -0,0 +1,286 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+var (
+	testKeys = []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(1001)),
+		ecc.NewPrivateKey(big.NewInt(1002)),
+		ecc.NewPrivateKey(big.NewInt(1003)),
+	}
+	testPreimage = []byte("miniscript test preimage 32 byte")
+	testZ        = util.Hash256([]byte("miniscript"))
+)
+
+// expand replaces A, B and C with the test keys and H with the sha256 of the test preimage.
+func expand(s string) string {
+	return strings.NewReplacer(
+		"A", hex.EncodeToString(testKeys[0].Point.Sec(true)),
+		"B", hex.EncodeToString(testKeys[1].Point.Sec(true)),
+		"C", hex.EncodeToString(testKeys[2].Point.Sec(true)),
+		"H", hex.EncodeToString(util.Sha256(testPreimage)),
+	).Replace(s)
+}
+
+// satisfier returns a Satisfier with signatures from the given test keys.
+func satisfier(keys ...int) *Satisfier {
+	result := &Satisfier{Signatures: map[string][]byte{}, Preimages: map[string][]byte{}}
+	z := new(big.Int).SetBytes(testZ)
+	for _, i := range keys {
+		sig := append(testKeys[i].Sign(z).Der(), 1)
+		result.Signatures[hex.EncodeToString(testKeys[i].Point.Sec(true))] = sig
+	}
+	return result
+}
+
+func TestParse(t *testing.T) {
+	t.Run("Test types", func(t *testing.T) {
+		tests := []struct {
+			expr     string
+			expected string
+			sane     bool
+		}{
+			{"pk(A)", "Bondusemk", true},
+			{"and_v(v:pk(A),older(144))", "Bonsfmk", true},
+			{"or_d(pk(A),and_v(v:pkh(B),older(144)))", "Bsfmk", true},
+			{"thresh(2,pk(A),s:pk(B),s:pk(C))", "Bdusemk", true},
+			{"multi(2,A,B,C)", "Bndusemk", true},
+			{"andor(pk(A),sha256(H),pk(B))", "Bdusemk", true},
+			{"or_i(pk(A),older(144))", "Bdemk", false},
+			{"and_b(after(100),a:after(500000001))", "Bufm", false},
+		}
+		for _, test := range tests {
+			node, err := Parse(expand(test.expr))
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test.expr, err)
+			}
+			if actual := node.Type().String(); actual != test.expected {
+				t.Errorf("%s: expected type %s, got %s", test.expr, test.expected, actual)
+			}
+			if node.IsSane() != test.sane {
+				t.Errorf("%s: expected sane %v", test.expr, test.sane)
+			}
+		}
+	})
+
+	t.Run("Test aliases", func(t *testing.T) {
+		tests := []struct {
+			expr     string
+			expected string
+		}{
+			{"c:pk_k(A)", "pk(A)"},
+			{"c:pk_h(A)", "pkh(A)"},
+			{"andor(pk(A),pk(B),0)", "and_n(pk(A),pk(B))"},
+			{"and_v(v:pk(A),1)", "tv:pk(A)"},
+			{"or_i(0,pk(A))", "l:pk(A)"},
+			{"or_i(c:pk_k(A),0)", "u:pk(A)"},
+		}
+		for _, test := range tests {
+			node, err := Parse(expand(test.expr))
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test.expr, err)
+			}
+			if actual := node.String(); actual != expand(test.expected) {
+				t.Errorf("Expected %s, got %s", expand(test.expected), actual)
+			}
+		}
+	})
+
+	t.Run("Test invalid expressions", func(t *testing.T) {
+		tests := []string{
+			"and_v(pk(A),pk(B))",
+			"or_b(pk(A),pk(B))",
+			"thresh(3,pk(A),s:pk(B))",
+			"multi(0,A,B)",
+			"older(0)",
+			"sha256(0011)",
+			"pk(A",
+			"x:pk(A)",
+			"pk(04" + strings.Repeat("00", 64) + ")",
+		}
+		for _, test := range tests {
+			if _, err := Parse(expand(test)); err == nil {
+				t.Errorf("Expected an error parsing %s", test)
+			}
+		}
+	})
+}
+
+func TestScript(t *testing.T) {
+	t.Run("Test compile", func(t *testing.T) {
+		node, err := Parse(expand("or_d(pk(A),and_v(v:pkh(B),older(144)))"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := expand("A") + " OP_CHECKSIG OP_IFDUP OP_NOTIF OP_DUP OP_HASH160 " +
+			hex.EncodeToString(testKeys[1].Point.Hash160(true)) +
+			" OP_EQUALVERIFY OP_CHECKSIGVERIFY 9000 OP_CHECKSEQUENCEVERIFY OP_ENDIF"
+		if actual := node.Script().String(); actual != expected {
+			t.Errorf("Expected %s, got %s", expected, actual)
+		}
+	})
+
+	t.Run("Test lift", func(t *testing.T) {
+		tests := []string{
+			"pk(A)",
+			"and_v(v:pk(A),older(144))",
+			"and_v(v:pk(A),and_v(v:pk(B),after(500000001)))",
+			"or_d(pk(A),and_v(v:pk(B),older(144)))",
+			"or_b(pk(A),s:pk(B))",
+			"and_v(v:pk(C),or_b(pk(A),s:pk(B)))",
+			"or_c(pk(A),v:hash160(" + strings.Repeat("11", 20) + "))",
+			"or_i(and_v(v:pk(A),sha256(H)),pk(B))",
+			"andor(pk(A),sha256(H),pk(B))",
+			"and_n(pk(A),pk(B))",
+			"and_b(pk(A),a:pk(B))",
+			"thresh(2,pk(A),s:pk(B),sln:older(144))",
+			"thresh(1,pk(A),a:hash256(H),aln:older(20))",
+			"multi(2,A,B,C)",
+			"and_v(v:pkh(" + strings.Repeat("33", 20) + "),j:ripemd160(" + strings.Repeat("22", 20) + "))",
+			"v:multi(1,A,B)",
+		}
+		for _, test := range tests {
+			node, err := Parse(expand(test))
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test, err)
+			}
+			lifted, err := Lift(node.Script())
+			if err != nil {
+				t.Fatalf("Unexpected error lifting %s: %v", test, err)
+			}
+			if actual := lifted.String(); actual != expand(test) {
+				t.Errorf("Expected %s, got %s", expand(test), actual)
+			}
+		}
+	})
+
+	t.Run("Test lift key hash", func(t *testing.T) {
+		node, _ := Parse(expand("pkh(A)"))
+		lifted, err := Lift(node.Script())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := "pkh(" + hex.EncodeToString(testKeys[0].Point.Hash160(true)) + ")"
+		if actual := lifted.String(); actual != expected {
+			t.Errorf("Expected %s, got %s", expected, actual)
+		}
+	})
+
+	t.Run("Test lift invalid scripts", func(t *testing.T) {
+		tests := []*script.Script{
+			script.P2pkhScript(testKeys[0].Point.Hash160(true)).AppendOp(script.OpNop),
+			new(script.Script).AppendOp(script.OpCheckSig),
+			new(script.Script).AppendData(testKeys[0].Point.Sec(true)).AppendData(testKeys[1].Point.Sec(true)).AppendOp(script.OpCheckSig),
+		}
+		for _, test := range tests {
+			if _, err := Lift(test); err == nil {
+				t.Errorf("Expected an error lifting %s", test)
+			}
+		}
+	})
+}
+
+func TestSatisfy(t *testing.T) {
+	t.Run("Test satisfy", func(t *testing.T) {
+		tests := []struct {
+			expr      string
+			keys      []int
+			preimage  bool
+			items     int
+			satisfied bool
+		}{
+			{"pk(A)", []int{0}, false, 1, true},
+			{"pk(A)", []int{1}, false, 0, false},
+			{"pkh(A)", []int{0}, false, 2, true},
+			{"or_d(pk(A),and_v(v:pkh(B),sha256(H)))", []int{0}, false, 1, true},
+			{"or_d(pk(A),and_v(v:pkh(B),sha256(H)))", []int{1}, true, 4, true},
+			{"or_d(pk(A),and_v(v:pkh(B),sha256(H)))", []int{1}, false, 0, false},
+			{"thresh(2,pk(A),s:pk(B),s:pk(C))", []int{0, 2}, false, 3, true},
+			{"thresh(2,pk(A),s:pk(B),s:pk(C))", []int{1}, false, 0, false},
+			{"multi(2,A,B,C)", []int{1, 2}, false, 3, true},
+			{"multi(2,A,B,C)", []int{0, 1, 2}, false, 3, true},
+			{"andor(pk(A),sha256(H),pk(B))", []int{1}, false, 2, true},
+			{"andor(pk(A),sha256(H),pk(B))", []int{0}, true, 2, true},
+			{"and_b(pk(A),a:pk(B))", []int{0, 1}, false, 2, true},
+			{"or_i(pk(A),pkh(B))", []int{1}, false, 3, true},
+			{"or_b(pk(A),s:pk(B))", []int{0}, false, 2, true},
+		}
+		for _, test := range tests {
+			node, err := Parse(expand(test.expr))
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", test.expr, err)
+			}
+			s := satisfier(test.keys...)
+			if test.preimage {
+				s.Preimages[hex.EncodeToString(util.Sha256(testPreimage))] = testPreimage
+			}
+			stack, err := node.Satisfy(s)
+			if !test.satisfied {
+				if err == nil {
+					t.Errorf("%s: expected an error satisfying with keys %v", test.expr, test.keys)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.expr, err)
+			}
+			if len(stack) != test.items {
+				t.Errorf("%s: expected %d items, got %d", test.expr, test.items, len(stack))
+			}
+			// run the witness through the interpreter as a p2wsh spend
+			witnessScript := node.Script().RawSerialize()
+			witness := append(stack, witnessScript)
+			scriptPubKey := script.P2wshScript(util.Sha256(witnessScript))
+			if err := script.NewEngine(new(script.Script), scriptPubKey, witness, testZ).Run(); err != nil {
+				t.Errorf("%s: unexpected error evaluating the witness: %v", test.expr, err)
+			}
+		}
+	})
+
+	t.Run("Test timelocks", func(t *testing.T) {
+		node, err := Parse(expand("or_d(pk(A),and_v(v:pk(B),older(144)))"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		s := satisfier(1)
+		s.Sequence = 100
+		if _, err := node.Satisfy(s); err == nil {
+			t.Errorf("Expected an error before the timelock")
+		}
+		s.Sequence = 144
+		stack, err := node.Satisfy(s)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(stack) != 2 || len(stack[0]) == 0 || len(stack[1]) != 0 {
+			t.Errorf("Expected a signature for B and a dissatisfaction for A, got %x", stack)
+		}
+		node, _ = Parse("after(500000001)")
+		if _, err := node.Satisfy(&Satisfier{LockTime: 700000}); err == nil {
+			t.Errorf("Expected an error mixing a height with a time lock")
+		}
+	})
+
+	t.Run("Test malleable satisfaction", func(t *testing.T) {
+		// either branch can be taken without a signature
+		node, err := Parse(expand("or_i(older(10),sha256(H))"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		s := satisfier()
+		s.Sequence = 10
+		s.Preimages[hex.EncodeToString(util.Sha256(testPreimage))] = testPreimage
+		if _, err := node.Satisfy(s); err == nil {
+			t.Errorf("Expected an error for a malleable satisfaction")
+		}
+	})
+}
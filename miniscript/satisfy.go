@@ -0,0 +1,252 @@
+package miniscript
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Satisfier holds what is available to satisfy an expression.
+type Satisfier struct {
+	// Signatures maps hex compressed SEC public keys to signatures with their hash type byte.
+	Signatures map[string][]byte
+	// Preimages maps hex hashes to their 32 byte preimages.
+	Preimages map[string][]byte
+	// Sequence and LockTime are the input's sequence and the transaction's lock time,
+	// which satisfy older and after.
+	Sequence uint32
+	LockTime uint32
+}
+
+// witness is a candidate stack for satisfying or dissatisfying an expression.
+type witness struct {
+	stack     [][]byte
+	available bool
+	hasSig    bool
+	malleable bool
+}
+
+var unavailable = witness{}
+
+func newWitness(items ...[]byte) witness {
+	return witness{stack: items, available: true}
+}
+
+// then returns the witness for running w after other: w's items end up on top.
+func (w witness) then(other witness) witness {
+	if !w.available || !other.available {
+		return unavailable
+	}
+	stack := make([][]byte, 0, len(w.stack)+len(other.stack))
+	stack = append(stack, other.stack...)
+	stack = append(stack, w.stack...)
+	return witness{
+		stack:     stack,
+		available: true,
+		hasSig:    w.hasSig || other.hasSig,
+		malleable: w.malleable || other.malleable,
+	}
+}
+
+func (w witness) size() int {
+	result := 0
+	for _, item := range w.stack {
+		result += len(util.EncodeVarInt(len(item))) + len(item)
+	}
+	return result
+}
+
+// choose returns the better of two alternative witnesses.
+func choose(a, b witness) witness {
+	if !a.available {
+		return b
+	}
+	if !b.available {
+		return a
+	}
+	// a third party can always use an option without a signature,
+	// so that is the one to pick
+	if !a.hasSig && b.hasSig {
+		return a
+	}
+	if a.hasSig && !b.hasSig {
+		return b
+	}
+	if !a.hasSig && !b.hasSig {
+		// either could be swapped for the other
+		a.malleable = true
+		b.malleable = true
+	} else {
+		if b.malleable && !a.malleable {
+			return a
+		}
+		if a.malleable && !b.malleable {
+			return b
+		}
+	}
+	if b.size() < a.size() {
+		return b
+	}
+	return a
+}
+
+// Satisfy returns the witness stack that satisfies the expression, bottom first,
+// without the witness script.
+// Returns an error if there is no satisfaction or every satisfaction can be malleated.
+func (n *Node) Satisfy(satisfier *Satisfier) ([][]byte, error) {
+	sat, _ := n.satisfy(satisfier)
+	if !sat.available {
+		return nil, errors.New("not enough signatures, preimages or timelocks to satisfy")
+	}
+	if sat.malleable {
+		return nil, errors.New("every satisfaction is malleable")
+	}
+	return sat.stack, nil
+}
+
+func (s *Satisfier) signature(key []byte) witness {
+	if sig, ok := s.Signatures[hex.EncodeToString(key)]; ok {
+		result := newWitness(sig)
+		result.hasSig = true
+		return result
+	}
+	return unavailable
+}
+
+// keyForHash returns the key with a signature whose hash160 matches.
+func (s *Satisfier) keyForHash(hash []byte) []byte {
+	for key := range s.Signatures {
+		raw, err := hex.DecodeString(key)
+		if err == nil && bytes.Equal(util.Hash160(raw), hash) {
+			return raw
+		}
+	}
+	return nil
+}
+
+// checkOlder returns whether the sequence satisfies a relative timelock.
+func (s *Satisfier) checkOlder(value uint32) bool {
+	const disableFlag, mask = 1 << 31, sequenceLockTimeTypeFlag | 0xffff
+	if s.Sequence&disableFlag != 0 {
+		return false
+	}
+	if s.Sequence&sequenceLockTimeTypeFlag != value&sequenceLockTimeTypeFlag {
+		return false
+	}
+	return s.Sequence&mask >= value&mask
+}
+
+// checkAfter returns whether the lock time satisfies an absolute timelock.
+func (s *Satisfier) checkAfter(value uint32) bool {
+	if (s.LockTime < lockTimeThreshold) != (value < lockTimeThreshold) {
+		return false
+	}
+	return s.LockTime >= value
+}
+
+// satisfy returns the best satisfaction and dissatisfaction of the expression.
+func (n *Node) satisfy(s *Satisfier) (witness, witness) {
+	var sats, dissats []witness
+	for _, arg := range n.Args {
+		sat, dissat := arg.satisfy(s)
+		sats = append(sats, sat)
+		dissats = append(dissats, dissat)
+	}
+	empty := newWitness()
+	zero, one := newWitness([]byte{}), newWitness([]byte{1})
+	switch n.Fragment {
+	case Fragment0:
+		return unavailable, empty
+	case Fragment1:
+		return empty, unavailable
+	case FragmentPkK:
+		return s.signature(n.Keys[0]), zero
+	case FragmentPkH:
+		key := n.Keys[0]
+		if len(key) == 20 {
+			if key = s.keyForHash(key); key == nil {
+				return unavailable, unavailable
+			}
+		}
+		push := newWitness(key)
+		return push.then(s.signature(key)), push.then(zero)
+	case FragmentOlder:
+		if s.checkOlder(n.Value) {
+			return empty, unavailable
+		}
+		return unavailable, unavailable
+	case FragmentAfter:
+		if s.checkAfter(n.Value) {
+			return empty, unavailable
+		}
+		return unavailable, unavailable
+	case FragmentSha256, FragmentHash256, FragmentRipemd160, FragmentHash160:
+		// any other 32 bytes dissatisfy, so that is malleable
+		dissat := newWitness(make([]byte, 32))
+		dissat.malleable = true
+		if preimage, ok := s.Preimages[hex.EncodeToString(n.Hash)]; ok {
+			return newWitness(preimage), dissat
+		}
+		return unavailable, dissat
+	case FragmentAndOr:
+		return choose(sats[0].then(sats[1]), dissats[0].then(sats[2])), dissats[0].then(dissats[2])
+	case FragmentAndV:
+		return sats[0].then(sats[1]), unavailable
+	case FragmentAndB:
+		return sats[0].then(sats[1]), dissats[0].then(dissats[1])
+	case FragmentOrB:
+		return choose(sats[0].then(dissats[1]), dissats[0].then(sats[1])), dissats[0].then(dissats[1])
+	case FragmentOrC:
+		return choose(sats[0], dissats[0].then(sats[1])), unavailable
+	case FragmentOrD:
+		return choose(sats[0], dissats[0].then(sats[1])), dissats[0].then(dissats[1])
+	case FragmentOrI:
+		return choose(one.then(sats[0]), zero.then(sats[1])), choose(one.then(dissats[0]), zero.then(dissats[1]))
+	case FragmentThresh:
+		// best[j] is the best witness satisfying exactly j of the arguments so far
+		best := []witness{empty}
+		for i := range n.Args {
+			next := make([]witness, len(best)+1)
+			for j := range next {
+				next[j] = unavailable
+				if j < len(best) {
+					next[j] = best[j].then(dissats[i])
+				}
+				if j > 0 {
+					next[j] = choose(next[j], best[j-1].then(sats[i]))
+				}
+			}
+			best = next
+		}
+		return best[n.K], best[0]
+	case FragmentMulti:
+		// the extra element consumed by the OP_CHECKMULTISIG bug
+		sat := zero
+		count := 0
+		for _, key := range n.Keys {
+			if sig := s.signature(key); sig.available && count < n.K {
+				sat = sig.then(sat)
+				count++
+			}
+		}
+		if count < n.K {
+			sat = unavailable
+		}
+		dissat := zero
+		for i := 0; i < n.K; i++ {
+			dissat = zero.then(dissat)
+		}
+		return sat, dissat
+	case WrapperA, WrapperS, WrapperC, WrapperN:
+		return sats[0], dissats[0]
+	case WrapperD:
+		return one.then(sats[0]), zero
+	case WrapperV:
+		return sats[0], unavailable
+	case WrapperJ:
+		return sats[0], zero
+	}
+	return unavailable, unavailable
+}
@@ -0,0 +1,385 @@
+package miniscript
+
+import (
+	"fmt"
+)
+
+// BasicType is one of the four basic miniscript types.
+type BasicType byte
+
+// Basic types.
+const (
+	// TypeB pushes nonzero on satisfaction and exactly zero on dissatisfaction.
+	TypeB BasicType = 'B'
+	// TypeV continues on satisfaction and aborts otherwise.
+	TypeV BasicType = 'V'
+	// TypeK pushes a public key for a signature check.
+	TypeK BasicType = 'K'
+	// TypeW takes its input from one below the top of the stack.
+	TypeW BasicType = 'W'
+)
+
+// Type is the basic type of an expression along with its correctness,
+// malleability and timelock properties.
+type Type struct {
+	Basic BasicType
+	// z: consumes exactly 0 stack elements.
+	ZeroArg bool
+	// o: consumes exactly 1 stack element.
+	OneArg bool
+	// n: the top stack element is nonzero when satisfying.
+	NonZero bool
+	// d: has a dissatisfaction that does not require a signature.
+	Dissatisfiable bool
+	// u: pushes exactly 1 on satisfaction.
+	Unit bool
+	// s: every satisfaction requires a signature.
+	Safe bool
+	// f: every dissatisfaction requires a signature.
+	Forced bool
+	// e: the dissatisfaction is unique and every other requires a signature.
+	Expressive bool
+	// m: there is a non-malleable satisfaction for every choice of inputs.
+	NonMalleable bool
+
+	relativeTime, relativeHeight, absoluteTime, absoluteHeight bool
+	// k: no branch mixes height and time locks.
+	noTimelockMix bool
+}
+
+func (t Type) String() string {
+	result := string(t.Basic)
+	for _, prop := range []struct {
+		set  bool
+		name string
+	}{
+		{t.ZeroArg, "z"}, {t.OneArg, "o"}, {t.NonZero, "n"}, {t.Dissatisfiable, "d"}, {t.Unit, "u"},
+		{t.Safe, "s"}, {t.Forced, "f"}, {t.Expressive, "e"}, {t.NonMalleable, "m"}, {t.noTimelockMix, "k"},
+	} {
+		if prop.set {
+			result += prop.name
+		}
+	}
+	return result
+}
+
+// conflicts returns whether two expressions that must both be satisfied mix timelock types.
+func conflicts(x, y Type) bool {
+	return (x.relativeTime && y.relativeHeight) || (x.relativeHeight && y.relativeTime) ||
+		(x.absoluteTime && y.absoluteHeight) || (x.absoluteHeight && y.absoluteTime)
+}
+
+// mergeTimelocks combines the timelock properties of the subexpressions.
+// all is true when every subexpression has to be satisfied together.
+func mergeTimelocks(result *Type, all bool, args ...Type) {
+	result.noTimelockMix = true
+	for i, x := range args {
+		result.relativeTime = result.relativeTime || x.relativeTime
+		result.relativeHeight = result.relativeHeight || x.relativeHeight
+		result.absoluteTime = result.absoluteTime || x.absoluteTime
+		result.absoluteHeight = result.absoluteHeight || x.absoluteHeight
+		result.noTimelockMix = result.noTimelockMix && x.noTimelockMix
+		if !all {
+			continue
+		}
+		for _, y := range args[:i] {
+			if conflicts(x, y) {
+				result.noTimelockMix = false
+			}
+		}
+	}
+}
+
+func expect(n *Node, x Type, basic BasicType, props string) error {
+	if x.Basic != basic {
+		return fmt.Errorf("%s: argument must be type %c, got %c", n.Fragment, basic, x.Basic)
+	}
+	for _, prop := range props {
+		ok := true
+		switch prop {
+		case 'z':
+			ok = x.ZeroArg
+		case 'o':
+			ok = x.OneArg
+		case 'n':
+			ok = x.NonZero
+		case 'd':
+			ok = x.Dissatisfiable
+		case 'u':
+			ok = x.Unit
+		}
+		if !ok {
+			return fmt.Errorf("%s: argument must have property %c, got %s", n.Fragment, prop, x)
+		}
+	}
+	return nil
+}
+
+// typeCheck computes the type of a node from the types of its arguments.
+func typeCheck(n *Node) (Type, error) {
+	args := make([]Type, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.typ
+	}
+	var t Type
+	switch n.Fragment {
+	case Fragment0:
+		t = Type{Basic: TypeB, ZeroArg: true, Unit: true, Dissatisfiable: true,
+			Expressive: true, Safe: true, NonMalleable: true}
+	case Fragment1:
+		t = Type{Basic: TypeB, ZeroArg: true, Unit: true, Forced: true, NonMalleable: true}
+	case FragmentPkK:
+		t = Type{Basic: TypeK, OneArg: true, NonZero: true, Dissatisfiable: true, Unit: true,
+			Expressive: true, Safe: true, NonMalleable: true}
+	case FragmentPkH:
+		t = Type{Basic: TypeK, NonZero: true, Dissatisfiable: true, Unit: true,
+			Expressive: true, Safe: true, NonMalleable: true}
+	case FragmentOlder, FragmentAfter:
+		if n.Value < 1 || n.Value >= 0x80000000 {
+			return t, fmt.Errorf("%s: %d out of range", n.Fragment, n.Value)
+		}
+		t = Type{Basic: TypeB, ZeroArg: true, Forced: true, NonMalleable: true}
+		if n.Fragment == FragmentOlder {
+			t.relativeTime = n.Value&sequenceLockTimeTypeFlag != 0
+			t.relativeHeight = !t.relativeTime
+		} else {
+			t.absoluteTime = n.Value >= lockTimeThreshold
+			t.absoluteHeight = !t.absoluteTime
+		}
+	case FragmentSha256, FragmentHash256, FragmentRipemd160, FragmentHash160:
+		t = Type{Basic: TypeB, OneArg: true, NonZero: true, Dissatisfiable: true, Unit: true, NonMalleable: true}
+	case FragmentAndOr:
+		x, y, z := args[0], args[1], args[2]
+		if err := expect(n, x, TypeB, "du"); err != nil {
+			return t, err
+		}
+		if y.Basic != z.Basic || y.Basic == TypeW {
+			return t, fmt.Errorf("andor: branches must both be B, K or V")
+		}
+		t = Type{
+			Basic:          y.Basic,
+			ZeroArg:        x.ZeroArg && y.ZeroArg && z.ZeroArg,
+			OneArg:         (x.ZeroArg && y.OneArg && z.OneArg) || (x.OneArg && y.ZeroArg && z.ZeroArg),
+			Unit:           y.Unit && z.Unit,
+			Dissatisfiable: z.Dissatisfiable,
+			Safe:           z.Safe && (x.Safe || y.Safe),
+			Forced:         z.Forced && (x.Safe || y.Forced),
+			Expressive:     z.Expressive && (x.Safe || y.Forced),
+			NonMalleable:   x.NonMalleable && y.NonMalleable && z.NonMalleable && x.Expressive && (x.Safe || y.Safe || z.Safe),
+		}
+		mergeTimelocks(&t, false, x, y, z)
+		// x and y are satisfied together
+		if conflicts(x, y) {
+			t.noTimelockMix = false
+		}
+	case FragmentAndV:
+		x, y := args[0], args[1]
+		if err := expect(n, x, TypeV, ""); err != nil {
+			return t, err
+		}
+		if y.Basic == TypeW {
+			return t, fmt.Errorf("and_v: second argument must be B, K or V")
+		}
+		t = Type{
+			Basic:        y.Basic,
+			ZeroArg:      x.ZeroArg && y.ZeroArg,
+			OneArg:       (x.ZeroArg && y.OneArg) || (x.OneArg && y.ZeroArg),
+			NonZero:      x.NonZero || (x.ZeroArg && y.NonZero),
+			Unit:         y.Unit,
+			Safe:         x.Safe || y.Safe,
+			Forced:       x.Safe || y.Forced,
+			NonMalleable: x.NonMalleable && y.NonMalleable,
+		}
+		mergeTimelocks(&t, true, x, y)
+	case FragmentAndB:
+		x, y := args[0], args[1]
+		if err := expect(n, x, TypeB, ""); err != nil {
+			return t, err
+		}
+		if err := expect(n, y, TypeW, ""); err != nil {
+			return t, err
+		}
+		t = Type{
+			Basic:          TypeB,
+			ZeroArg:        x.ZeroArg && y.ZeroArg,
+			OneArg:         (x.ZeroArg && y.OneArg) || (x.OneArg && y.ZeroArg),
+			NonZero:        x.NonZero || (x.ZeroArg && y.NonZero),
+			Dissatisfiable: x.Dissatisfiable && y.Dissatisfiable,
+			Unit:           true,
+			Safe:           x.Safe || y.Safe,
+			Forced:         (x.Forced && y.Forced) || (x.Safe && x.Forced) || (y.Safe && y.Forced),
+			Expressive:     x.Expressive && y.Expressive && x.Safe && y.Safe,
+			NonMalleable:   x.NonMalleable && y.NonMalleable,
+		}
+		mergeTimelocks(&t, true, x, y)
+	case FragmentOrB:
+		x, z := args[0], args[1]
+		if err := expect(n, x, TypeB, "d"); err != nil {
+			return t, err
+		}
+		if err := expect(n, z, TypeW, "d"); err != nil {
+			return t, err
+		}
+		t = Type{
+			Basic:          TypeB,
+			ZeroArg:        x.ZeroArg && z.ZeroArg,
+			OneArg:         (x.ZeroArg && z.OneArg) || (x.OneArg && z.ZeroArg),
+			Dissatisfiable: true,
+			Unit:           true,
+			Safe:           x.Safe && z.Safe,
+			Expressive:     x.Expressive && z.Expressive,
+			NonMalleable:   x.NonMalleable && z.NonMalleable && x.Expressive && z.Expressive && (x.Safe || z.Safe),
+		}
+		mergeTimelocks(&t, false, x, z)
+	case FragmentOrC, FragmentOrD:
+		x, z := args[0], args[1]
+		if err := expect(n, x, TypeB, "du"); err != nil {
+			return t, err
+		}
+		basic := TypeV
+		if n.Fragment == FragmentOrD {
+			basic = TypeB
+		}
+		if err := expect(n, z, basic, ""); err != nil {
+			return t, err
+		}
+		t = Type{
+			Basic:        basic,
+			ZeroArg:      x.ZeroArg && z.ZeroArg,
+			OneArg:       x.OneArg && z.ZeroArg,
+			Safe:         x.Safe && z.Safe,
+			Forced:       true,
+			NonMalleable: x.NonMalleable && z.NonMalleable && x.Expressive && (x.Safe || z.Safe),
+		}
+		if n.Fragment == FragmentOrD {
+			t.Dissatisfiable = z.Dissatisfiable
+			t.Unit = z.Unit
+			t.Forced = z.Forced
+			t.Expressive = z.Expressive
+		}
+		mergeTimelocks(&t, false, x, z)
+	case FragmentOrI:
+		x, z := args[0], args[1]
+		if x.Basic != z.Basic || x.Basic == TypeW {
+			return t, fmt.Errorf("or_i: branches must both be B, K or V")
+		}
+		t = Type{
+			Basic:          x.Basic,
+			OneArg:         x.ZeroArg && z.ZeroArg,
+			Dissatisfiable: x.Dissatisfiable || z.Dissatisfiable,
+			Unit:           x.Unit && z.Unit,
+			Safe:           x.Safe && z.Safe,
+			Forced:         x.Forced && z.Forced,
+			Expressive:     (x.Expressive && z.Forced) || (z.Expressive && x.Forced),
+			NonMalleable:   x.NonMalleable && z.NonMalleable && (x.Safe || z.Safe),
+		}
+		mergeTimelocks(&t, false, x, z)
+	case FragmentThresh:
+		if n.K < 1 || n.K > len(args) {
+			return t, fmt.Errorf("thresh: threshold %d out of range for %d arguments", n.K, len(args))
+		}
+		t = Type{Basic: TypeB, Dissatisfiable: true, Unit: true, Expressive: true, NonMalleable: true}
+		// count the stack elements the arguments consume, 2 standing for more than one
+		consumed, safe := 0, 0
+		for i, x := range args {
+			basic := TypeW
+			if i == 0 {
+				basic = TypeB
+			}
+			if err := expect(n, x, basic, "du"); err != nil {
+				return t, err
+			}
+			switch {
+			case x.OneArg:
+				consumed++
+			case !x.ZeroArg:
+				consumed += 2
+			}
+			if x.Safe {
+				safe++
+			}
+			t.Expressive = t.Expressive && x.Expressive && x.Safe
+			t.NonMalleable = t.NonMalleable && x.NonMalleable && x.Expressive
+		}
+		t.ZeroArg = consumed == 0
+		t.OneArg = consumed == 1
+		t.Safe = safe >= len(args)-n.K+1
+		t.NonMalleable = t.NonMalleable && safe >= len(args)-n.K
+		mergeTimelocks(&t, n.K > 1, args...)
+	case FragmentMulti:
+		if len(n.Keys) < 1 || len(n.Keys) > maxMultisigKeys {
+			return t, fmt.Errorf("multi: %d keys, expected 1 to %d", len(n.Keys), maxMultisigKeys)
+		}
+		if n.K < 1 || n.K > len(n.Keys) {
+			return t, fmt.Errorf("multi: threshold %d out of range for %d keys", n.K, len(n.Keys))
+		}
+		t = Type{Basic: TypeB, NonZero: true, Dissatisfiable: true, Unit: true,
+			Expressive: true, Safe: true, NonMalleable: true}
+	case WrapperA, WrapperS:
+		x := args[0]
+		props := ""
+		if n.Fragment == WrapperS {
+			props = "o"
+		}
+		if err := expect(n, x, TypeB, props); err != nil {
+			return t, err
+		}
+		t = x
+		t.Basic = TypeW
+		t.ZeroArg, t.OneArg, t.NonZero = false, false, false
+	case WrapperC:
+		x := args[0]
+		if err := expect(n, x, TypeK, ""); err != nil {
+			return t, err
+		}
+		t = x
+		t.Basic = TypeB
+		t.Unit = true
+		t.Safe = true
+	case WrapperD:
+		x := args[0]
+		if err := expect(n, x, TypeV, "z"); err != nil {
+			return t, err
+		}
+		t = x
+		t.Basic = TypeB
+		t.ZeroArg, t.OneArg, t.NonZero = false, true, true
+		t.Dissatisfiable = true
+		// OP_IF only requires a minimal argument in tapscript
+		t.Unit = false
+		t.Expressive = x.Forced
+		t.Forced = false
+	case WrapperV:
+		x := args[0]
+		if err := expect(n, x, TypeB, ""); err != nil {
+			return t, err
+		}
+		t = x
+		t.Basic = TypeV
+		t.Dissatisfiable, t.Unit, t.Expressive = false, false, false
+		t.Forced = true
+	case WrapperJ:
+		x := args[0]
+		if err := expect(n, x, TypeB, "n"); err != nil {
+			return t, err
+		}
+		t = x
+		t.ZeroArg, t.NonZero = false, true
+		t.Dissatisfiable = true
+		t.Expressive = x.Forced
+		t.Forced = false
+	case WrapperN:
+		x := args[0]
+		if err := expect(n, x, TypeB, ""); err != nil {
+			return t, err
+		}
+		t = x
+		t.Unit = true
+	default:
+		return t, fmt.Errorf("unknown fragment %s", n.Fragment)
+	}
+	if len(args) == 0 {
+		t.noTimelockMix = true
+	}
+	return t, nil
+}
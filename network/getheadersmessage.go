@@ -12,6 +12,8 @@ type GetHeadersMessage struct {
 	NumHashes  int
 	StartBlock [32]byte
 	EndBlock   [32]byte
+	// Locator holds the hashes of a block locator, newest first, sent instead of StartBlock when set.
+	Locator [][]byte
 }
 
 const (
@@ -33,6 +35,15 @@ func NewGetHeadersMessage(startBlock []byte) *GetHeadersMessage {
 	}
 }
 
+// NewGetHeadersMessageFromLocator creates a NewGetHeadersMessage asking for the headers after
+// the first block of a locator the peer has.
+func NewGetHeadersMessageFromLocator(locator [][]byte) *GetHeadersMessage {
+	result := NewGetHeadersMessage(locator[0])
+	result.NumHashes = len(locator)
+	result.Locator = locator
+	return result
+}
+
 // Command sequence that identifies this type of message.
 func (*GetHeadersMessage) Command() []byte {
 	return []byte("getheaders")
@@ -42,6 +53,14 @@ func (*GetHeadersMessage) Command() []byte {
 func (msg *GetHeadersMessage) Serialize() []byte {
 	version := util.Int32ToLittleEndian(msg.Version)
 	numHashes := util.EncodeVarInt(msg.NumHashes)
+	if msg.Locator != nil {
+		result := append(version, util.EncodeVarInt(len(msg.Locator))...)
+		for _, hash := range msg.Locator {
+			result = append(result, util.ReverseByteArray(append([]byte{}, hash...))...)
+		}
+		endBlock := append([]byte{}, msg.EndBlock[:]...)
+		return append(result, util.ReverseByteArray(endBlock)...)
+	}
 	startBlock := make([]byte, 32)
 	endBlock := make([]byte, 32)
 	copy(startBlock, msg.StartBlock[:])
@@ -62,9 +81,18 @@ func (msg *GetHeadersMessage) Parse(reader *bytes.Reader) Message {
 	reader.Read(version)
 	msg.Version = util.LittleEndianToInt32(version)
 	msg.NumHashes = util.ReadVarInt(reader)
+	msg.Locator = nil
 	blockData := make([]byte, 32)
-	reader.Read(blockData)
-	copy(msg.StartBlock[:], util.ReverseByteArray(blockData))
+	for i := 0; i < msg.NumHashes && reader.Len() >= 64; i++ {
+		reader.Read(blockData)
+		msg.Locator = append(msg.Locator, util.ReverseByteArray(append([]byte{}, blockData...)))
+	}
+	if len(msg.Locator) > 0 {
+		copy(msg.StartBlock[:], msg.Locator[0])
+	}
+	if len(msg.Locator) == 1 {
+		msg.Locator = nil
+	}
 	reader.Read(blockData)
 	copy(msg.EndBlock[:], util.ReverseByteArray(blockData))
 	return msg
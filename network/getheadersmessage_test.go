@@ -1,6 +1,7 @@
 package network
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
 
@@ -16,3 +17,20 @@ func TestGetHeadersMessage(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expected, actual)
 	}
 }
+
+func TestGetHeadersMessageFromLocator(t *testing.T) {
+	locator := [][]byte{
+		util.HexStringToBytes(`0000000000000000001237f46acddf58578a37e213d2a6edc4884a2fcad05ba3`),
+		util.HexStringToBytes(`000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f`),
+	}
+	ghm := NewGetHeadersMessageFromLocator(locator)
+	serialized := ghm.Serialize()
+	expected := `7f11010002a35bd0ca2f4a88c4eda6d213e2378a5758dfcd6af437120000000000000000006fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d61900000000000000000000000000000000000000000000000000000000000000000000000000`
+	if actual := hex.EncodeToString(serialized); actual != expected {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+	parsed := new(GetHeadersMessage).Parse(bytes.NewReader(serialized)).(*GetHeadersMessage)
+	if len(parsed.Locator) != 2 || !bytes.Equal(parsed.Locator[1], locator[1]) || !bytes.Equal(parsed.StartBlock[:], locator[0]) {
+		t.Errorf("Expected the locator back, got %x", parsed.Locator)
+	}
+}
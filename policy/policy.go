@@ -0,0 +1,231 @@
+// Package policy checks whether nodes relay a transaction, with the standardness
+// rules of Bitcoin Core's mempool on top of the consensus rules.
+package policy
+
+import (
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// Default relay settings of Bitcoin Core.
+const (
+	// MaxStandardTxWeight is the largest weight of a relayed transaction.
+	MaxStandardTxWeight = 400000
+	// MinStandardTxNonWitnessSize is the smallest size without witness data of a relayed transaction.
+	MinStandardTxNonWitnessSize = 65
+	// MaxStandardScriptSigSize fits a 15-of-15 p2sh multisig spend with compressed keys.
+	MaxStandardScriptSigSize = 1650
+	// MaxStandardTxSigOpsCost is the most signature operation cost of a relayed transaction.
+	MaxStandardTxSigOpsCost = 16000
+	// MaxP2shSigOps is the most signature operations of a relayed p2sh redeem script.
+	MaxP2shSigOps = 15
+	// MaxStandardVersion is the highest relayed transaction version.
+	MaxStandardVersion = 3
+	// BytesPerSigOp is the size each signature operation counts as towards the fee.
+	BytesPerSigOp = 20
+	// DefaultMinRelayFeeRate is the fee rate of relayed transactions, in satoshis per 1000 virtual bytes.
+	DefaultMinRelayFeeRate = 1000
+	// DefaultDustRelayFeeRate is the fee rate dust thresholds are computed at.
+	DefaultDustRelayFeeRate = 3000
+	// DefaultIncrementalRelayFeeRate is the fee rate a replacement has to add.
+	DefaultIncrementalRelayFeeRate = 1000
+	// DefaultMaxDataCarrierBytes is the size of the largest relayed OP_RETURN output script.
+	DefaultMaxDataCarrierBytes = 83
+)
+
+// Reject reasons, as Bitcoin Core reports them.
+const (
+	RejectConsensus            = "consensus"
+	RejectCoinbase             = "coinbase"
+	RejectVersion              = "version"
+	RejectTxSize               = "tx-size"
+	RejectTxSizeSmall          = "tx-size-small"
+	RejectScriptSigSize        = "scriptsig-size"
+	RejectScriptSigNotPushOnly = "scriptsig-not-pushonly"
+	RejectScriptPubKey         = "scriptpubkey"
+	RejectBareMultisig         = "bare-multisig"
+	RejectDust                 = "dust"
+	RejectMultiOpReturn        = "multi-op-return"
+	RejectNonstandardInputs    = "bad-txns-nonstandard-inputs"
+	RejectTooManySigOps        = "bad-txns-too-many-sigops"
+	RejectMinRelayFee          = "min relay fee not met"
+	RejectScriptVerify         = "mandatory-script-verify-flag-failed"
+	RejectMempoolConflict      = "txn-mempool-conflict"
+	RejectInsufficientFee      = "insufficient fee"
+	RejectTooManyReplacements  = "too many potential replacements"
+)
+
+// RejectError is the reason nodes don't relay a transaction.
+type RejectError struct {
+	// Reason is one of the Reject constants.
+	Reason string
+	// Index is the input or output the reason applies to, -1 for the whole transaction.
+	Index  int
+	Detail string
+}
+
+func (e *RejectError) Error() string {
+	result := e.Reason
+	if e.Index >= 0 {
+		result += fmt.Sprintf(" (index %d)", e.Index)
+	}
+	if e.Detail != "" {
+		result += ": " + e.Detail
+	}
+	return result
+}
+
+func reject(reason string, index int, format string, a ...interface{}) *RejectError {
+	return &RejectError{Reason: reason, Index: index, Detail: fmt.Sprintf(format, a...)}
+}
+
+// Policy holds the relay settings of a node.
+type Policy struct {
+	MaxTxWeight int
+	// MinRelayFeeRate, DustRelayFeeRate and IncrementalRelayFeeRate are in satoshis per 1000 virtual bytes.
+	MinRelayFeeRate         uint64
+	DustRelayFeeRate        uint64
+	IncrementalRelayFeeRate uint64
+	// MaxDataCarrierBytes is the size of the largest OP_RETURN output script.
+	MaxDataCarrierBytes int
+	PermitBareMultisig  bool
+}
+
+// NewPolicy returns the default settings of Bitcoin Core.
+func NewPolicy() *Policy {
+	return &Policy{
+		MaxTxWeight:             MaxStandardTxWeight,
+		MinRelayFeeRate:         DefaultMinRelayFeeRate,
+		DustRelayFeeRate:        DefaultDustRelayFeeRate,
+		IncrementalRelayFeeRate: DefaultIncrementalRelayFeeRate,
+		MaxDataCarrierBytes:     DefaultMaxDataCarrierBytes,
+		PermitBareMultisig:      true,
+	}
+}
+
+// Check runs all the checks a node runs before relaying a transaction:
+// the consensus checks, CheckStandard, CheckInputs and the scripts.
+// provider looks up the outputs the inputs spend.
+// Returns a *RejectError if nodes won't relay the transaction,
+// or another error if an output can't be looked up.
+func (p *Policy) Check(t *tx.Transaction, provider tx.PrevoutProvider) error {
+	if err := t.Check(); err != nil {
+		return reject(RejectConsensus, -1, "%v", err)
+	}
+	if t.IsCoinbase() {
+		return reject(RejectCoinbase, -1, "")
+	}
+	if err := p.CheckStandard(t); err != nil {
+		return err
+	}
+	// look up each output once
+	prevouts := tx.NewMemoryProvider()
+	for _, txIn := range t.Inputs {
+		prevout, err := provider.Prevout(txIn.PrevTx, txIn.PrevIndex, t.Testnet)
+		if err != nil {
+			return err
+		}
+		prevouts.AddOutput(txIn.PrevTx, txIn.PrevIndex, prevout)
+	}
+	if err := p.CheckInputs(t, prevouts); err != nil {
+		return err
+	}
+	if ok, err := t.Verify(prevouts); !ok {
+		return reject(RejectScriptVerify, -1, "%v", err)
+	}
+	return nil
+}
+
+// CheckStandard checks the rules that don't need the outputs being spent,
+// like IsStandardTx in Bitcoin Core.
+func (p *Policy) CheckStandard(t *tx.Transaction) error {
+	if t.Version < 1 || t.Version > MaxStandardVersion {
+		return reject(RejectVersion, -1, "version %d", t.Version)
+	}
+	if weight := t.Weight(); weight > p.MaxTxWeight {
+		return reject(RejectTxSize, -1, "weight %d is over %d", weight, p.MaxTxWeight)
+	}
+	// too small transactions could be mistaken for merkle tree nodes (CVE-2017-12842)
+	if size := len(t.SerializeLegacy()); size < MinStandardTxNonWitnessSize {
+		return reject(RejectTxSizeSmall, -1, "size %d is under %d", size, MinStandardTxNonWitnessSize)
+	}
+	for i, txIn := range t.Inputs {
+		if size := len(txIn.ScriptSig.RawSerialize()); size > MaxStandardScriptSigSize {
+			return reject(RejectScriptSigSize, i, "size %d is over %d", size, MaxStandardScriptSigSize)
+		}
+		if !txIn.ScriptSig.IsPushOnly() {
+			return reject(RejectScriptSigNotPushOnly, i, "")
+		}
+	}
+	nullData := 0
+	for i, txOut := range t.Outputs {
+		scriptType, ok := p.isStandardOutput(txOut.ScriptPubKey)
+		if !ok {
+			return reject(RejectScriptPubKey, i, "%s output", scriptType)
+		}
+		switch {
+		case scriptType == NullData:
+			nullData++
+		case scriptType == Multisig && !p.PermitBareMultisig:
+			return reject(RejectBareMultisig, i, "")
+		case p.IsDust(txOut):
+			return reject(RejectDust, i, "%d is under %d", txOut.Amount, p.DustThreshold(txOut))
+		}
+	}
+	if nullData > 1 {
+		return reject(RejectMultiOpReturn, -1, "%d OP_RETURN outputs", nullData)
+	}
+	return nil
+}
+
+// CheckInputs checks the rules on the outputs being spent, the signature operations
+// and the fee, like AreInputsStandard and the mempool checks in Bitcoin Core.
+// provider looks up the outputs the inputs spend.
+func (p *Policy) CheckInputs(t *tx.Transaction, provider tx.PrevoutProvider) error {
+	for i, txIn := range t.Inputs {
+		scriptPubKey, err := txIn.ScriptPubKey(provider, t.Testnet)
+		if err != nil {
+			return err
+		}
+		switch Classify(scriptPubKey) {
+		case NonStandard, WitnessUnknown:
+			return reject(RejectNonstandardInputs, i, "spends a %s output", Classify(scriptPubKey))
+		case ScriptHash:
+			redeemScript, err := script.ParseRaw(lastPush(txIn.ScriptSig))
+			if err != nil {
+				return reject(RejectNonstandardInputs, i, "%v", err)
+			}
+			if sigOps := redeemScript.SigOpCount(true); sigOps > MaxP2shSigOps {
+				return reject(RejectNonstandardInputs, i, "%d redeem script signature operations", sigOps)
+			}
+		}
+	}
+	sigOpCost, err := t.SigOpCost(provider)
+	if err != nil {
+		return err
+	}
+	if sigOpCost > MaxStandardTxSigOpsCost {
+		return reject(RejectTooManySigOps, -1, "cost %d is over %d", sigOpCost, MaxStandardTxSigOpsCost)
+	}
+	fee, err := t.Fee(provider)
+	if err != nil {
+		return reject(RejectConsensus, -1, "%v", err)
+	}
+	minFee := feeForSize(p.MinRelayFeeRate, VSize(t, sigOpCost))
+	if fee < minFee {
+		return reject(RejectMinRelayFee, -1, "fee %d is under %d", fee, minFee)
+	}
+	return nil
+}
+
+// VSize returns the virtual size that relay fees are paid for: the virtual size,
+// or more if the transaction has many signature operations for its size.
+func VSize(t *tx.Transaction, sigOpCost int) int {
+	weight := t.Weight()
+	if sigOpCost*BytesPerSigOp > weight {
+		weight = sigOpCost * BytesPerSigOp
+	}
+	return (weight + tx.WitnessScaleFactor - 1) / tx.WitnessScaleFactor
+}
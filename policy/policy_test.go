@@ -0,0 +1,248 @@
+package policy
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// rejectReason returns the reason of a *RejectError, "" for nil or another error.
+func rejectReason(err error) string {
+	if rejectErr, ok := err.(*RejectError); ok {
+		return rejectErr.Reason
+	}
+	return ""
+}
+
+func TestClassify(t *testing.T) {
+	pk := ecc.NewPrivateKey(big.NewInt(8675309))
+	multisig, _ := script.MultisigScript(1, []*ecc.S256Point{pk.Point, pk.Point})
+	tests := []struct {
+		scriptPubKey *script.Script
+		expected     string
+	}{
+		{script.P2pkhScript(make([]byte, 20)), "pubkeyhash"},
+		{script.P2shScript(make([]byte, 20)), "scripthash"},
+		{script.P2wpkhScript(make([]byte, 20)), "witness_v0_keyhash"},
+		{script.P2wshScript(make([]byte, 32)), "witness_v0_scripthash"},
+		{script.P2trScript(make([]byte, 32)), "witness_v1_taproot"},
+		{new(script.Script).AppendOp(script.Op1).AppendData([]byte{0x4e, 0x73}), "witness_unknown"},
+		{new(script.Script).AppendOp(script.Op0).AppendData(make([]byte, 25)), "nonstandard"},
+		{new(script.Script).AppendData(pk.Point.Sec(true)).AppendOp(script.OpCheckSig), "pubkey"},
+		{new(script.Script).AppendData(pk.Point.Sec(false)).AppendOp(script.OpCheckSig), "pubkey"},
+		{multisig, "multisig"},
+		{new(script.Script).AppendOp(script.OpReturn).AppendData([]byte("hello")), "nulldata"},
+		{new(script.Script).AppendOp(script.OpReturn).AppendOp(script.OpDup), "nonstandard"},
+		{new(script.Script).AppendOp(script.Op1), "nonstandard"},
+	}
+	for _, test := range tests {
+		if actual := Classify(test.scriptPubKey).String(); actual != test.expected {
+			t.Errorf("Expected %s for %s, got %s", test.expected, test.scriptPubKey, actual)
+		}
+	}
+}
+
+func TestDust(t *testing.T) {
+	p := NewPolicy()
+	tests := []struct {
+		scriptPubKey *script.Script
+		expected     uint64
+	}{
+		{script.P2pkhScript(make([]byte, 20)), 546},
+		{script.P2shScript(make([]byte, 20)), 540},
+		{script.P2wpkhScript(make([]byte, 20)), 294},
+		{script.P2wshScript(make([]byte, 32)), 330},
+		{script.P2trScript(make([]byte, 32)), 330},
+		{new(script.Script).AppendOp(script.OpReturn), 0},
+	}
+	for _, test := range tests {
+		txOut := tx.NewOutput(test.expected, test.scriptPubKey)
+		if actual := p.DustThreshold(txOut); actual != test.expected {
+			t.Errorf("Expected %d for %s, got %d", test.expected, test.scriptPubKey, actual)
+		}
+		if p.IsDust(txOut) {
+			t.Errorf("Expected %d not to be dust", test.expected)
+		}
+		if txOut.Amount--; test.expected > 0 && !p.IsDust(txOut) {
+			t.Errorf("Expected %d to be dust", txOut.Amount)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := NewPolicy()
+	pk := ecc.NewPrivateKey(big.NewInt(8675309))
+	prevTx := util.Hash256([]byte("policy test"))
+	provider := tx.NewMemoryProvider()
+	provider.AddOutput(prevTx, 0, tx.NewOutput(100000, script.P2pkhScript(pk.Point.Hash160(true))))
+	provider.AddOutput(prevTx, 1, tx.NewOutput(100000, new(script.Script).AppendOp(script.Op1).AppendData([]byte{0x4e, 0x73})))
+	p2wpkh := script.P2wpkhScript(pk.Point.Hash160(true))
+	// build returns a signed transaction spending prevTx:0
+	build := func(fee uint64, extra ...*tx.Output) *tx.Transaction {
+		txIns := []*tx.Input{tx.NewInput(prevTx, 0, nil, tx.SequenceFinal-2)}
+		txOuts := append([]*tx.Output{tx.NewOutput(100000-fee, p2wpkh)}, extra...)
+		result := tx.NewTransaction(2, txIns, txOuts, 0, false)
+		if ok, err := result.SignInput(provider, 0, pk, util.SigHashAll); !ok || err != nil {
+			t.Fatalf("Unexpected error signing: %v", err)
+		}
+		return result
+	}
+	if err := p.Check(build(1000), provider); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	opReturn := func(data []byte) *tx.Output {
+		return tx.NewOutput(0, new(script.Script).AppendOp(script.OpReturn).AppendData(data))
+	}
+	multisig, _ := script.MultisigScript(1, []*ecc.S256Point{pk.Point, pk.Point, pk.Point, pk.Point})
+	tests := []struct {
+		name     string
+		tx       func() *tx.Transaction
+		expected string
+	}{
+		{"version", func() *tx.Transaction {
+			result := build(1000)
+			result.Version = 4
+			return result
+		}, RejectVersion},
+		{"too small", func() *tx.Transaction {
+			txIns := []*tx.Input{tx.NewInput(prevTx, 0, nil, tx.SequenceFinal)}
+			return tx.NewTransaction(2, txIns, []*tx.Output{opReturn(nil)}, 0, false)
+		}, RejectTxSizeSmall},
+		{"too large", func() *tx.Transaction {
+			return build(1000, opReturn(nil), tx.NewOutput(0, new(script.Script).AppendData(make([]byte, MaxStandardTxWeight/4))))
+		}, RejectTxSize},
+		{"ScriptSig too large", func() *tx.Transaction {
+			result := build(1000)
+			result.Inputs[0].ScriptSig.AppendData(make([]byte, MaxStandardScriptSigSize))
+			return result
+		}, RejectScriptSigSize},
+		{"ScriptSig not push only", func() *tx.Transaction {
+			result := build(1000)
+			result.Inputs[0].ScriptSig.AppendOp(script.OpDup)
+			return result
+		}, RejectScriptSigNotPushOnly},
+		{"nonstandard output", func() *tx.Transaction {
+			return build(1000, tx.NewOutput(1000, new(script.Script).AppendOp(script.Op1)))
+		}, RejectScriptPubKey},
+		{"bare multisig of 4 keys", func() *tx.Transaction {
+			return build(1000, tx.NewOutput(1000, multisig))
+		}, RejectScriptPubKey},
+		{"OP_RETURN too large", func() *tx.Transaction {
+			return build(1000, opReturn(make([]byte, 81)))
+		}, RejectScriptPubKey},
+		{"two OP_RETURN outputs", func() *tx.Transaction {
+			return build(1000, opReturn(nil), opReturn(nil))
+		}, RejectMultiOpReturn},
+		{"dust", func() *tx.Transaction {
+			return build(1000, tx.NewOutput(293, p2wpkh))
+		}, RejectDust},
+		{"nonstandard input", func() *tx.Transaction {
+			result := build(1000)
+			result.Inputs = append(result.Inputs, tx.NewInput(prevTx, 1, nil, tx.SequenceFinal))
+			return result
+		}, RejectNonstandardInputs},
+		{"fee", func() *tx.Transaction {
+			return build(100)
+		}, RejectMinRelayFee},
+		{"signature", func() *tx.Transaction {
+			result := build(1000)
+			result.Locktime++
+			return result
+		}, RejectScriptVerify},
+		{"consensus", func() *tx.Transaction {
+			result := build(1000)
+			result.Outputs = nil
+			return result
+		}, RejectConsensus},
+		{"coinbase", func() *tx.Transaction {
+			txIn := tx.NewInput(make([]byte, 32), 0xffffffff, new(script.Script).AppendData([]byte{1, 2}), tx.SequenceFinal)
+			return tx.NewTransaction(1, []*tx.Input{txIn}, []*tx.Output{tx.NewOutput(1000, p2wpkh)}, 0, false)
+		}, RejectCoinbase},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			err := p.Check(test.tx(), provider)
+			if reason := rejectReason(err); reason != test.expected {
+				t.Errorf("Expected %s, got %v", test.expected, err)
+			}
+		})
+	}
+
+	t.Run("Test settings", func(t *testing.T) {
+		strict := NewPolicy()
+		strict.PermitBareMultisig = false
+		bare, _ := script.MultisigScript(1, []*ecc.S256Point{pk.Point})
+		txObj := build(2000, tx.NewOutput(1000, bare))
+		if err := p.Check(txObj, provider); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if err := strict.Check(txObj, provider); rejectReason(err) != RejectBareMultisig {
+			t.Errorf("Expected %s, got %v", RejectBareMultisig, err)
+		}
+		strict.MinRelayFeeRate = 10000
+		if err := strict.CheckInputs(build(1000), provider); rejectReason(err) != RejectMinRelayFee {
+			t.Errorf("Expected %s, got %v", RejectMinRelayFee, err)
+		}
+		if err := p.Check(build(1000), tx.NewMemoryProvider()); err == nil || rejectReason(err) != "" {
+			t.Errorf("Expected a lookup error, got %v", err)
+		}
+	})
+
+	t.Run("Test sigops", func(t *testing.T) {
+		// each bare OP_CHECKMULTISIG counts 20 times 4
+		heavy := new(script.Script)
+		for i := 0; i < MaxStandardTxSigOpsCost/80+1; i++ {
+			heavy.AppendOp(script.OpCheckMultiSig)
+		}
+		txObj := build(1000)
+		txObj.Outputs[0].ScriptPubKey = heavy
+		if err := p.CheckInputs(txObj, provider); rejectReason(err) != RejectTooManySigOps {
+			t.Errorf("Expected %s, got %v", RejectTooManySigOps, err)
+		}
+		if VSize(txObj, 4000) != 20000 || VSize(txObj, 0) != txObj.VSize() {
+			t.Errorf("Unexpected sigop adjusted size %d", VSize(txObj, 4000))
+		}
+	})
+}
+
+func TestReplacement(t *testing.T) {
+	p := NewPolicy()
+	prevTx := util.Hash256([]byte("replacement test"))
+	spend := func(sequence uint32, amount uint64) *tx.Transaction {
+		txIns := []*tx.Input{tx.NewInput(prevTx, 0, script.NewScript([][]byte{make([]byte, 72), make([]byte, 33)}), sequence)}
+		return tx.NewTransaction(2, txIns, []*tx.Output{tx.NewOutput(amount, script.P2wpkhScript(make([]byte, 20)))}, 0, false)
+	}
+	original := spend(tx.SequenceFinal-2, 99000)
+	if !SignalsReplacement(original) || SignalsReplacement(spend(tx.SequenceFinal-1, 99000)) {
+		t.Errorf("Unexpected replacement signal")
+	}
+	child := tx.NewTransaction(2, []*tx.Input{tx.NewInput(original.Hash(), 0, nil, tx.SequenceFinal)}, original.Outputs, 0, false)
+	replaced := []*Replaced{{Tx: original, Fee: 1000}, {Tx: child, Fee: 500}}
+	vsize := uint64(spend(0, 0).VSize())
+	tests := []struct {
+		name     string
+		fee      uint64
+		replaced []*Replaced
+		expected string
+	}{
+		{"replacement", 1500 + vsize, replaced, ""},
+		{"fee under the replaced fees", 1400, replaced, RejectInsufficientFee},
+		{"fee not paying for relay", 1500 + vsize - 1, replaced, RejectInsufficientFee},
+		{"fee rate not higher", 1000, replaced[:1], RejectInsufficientFee},
+		{"not signaling", 2000, []*Replaced{{Tx: spend(tx.SequenceFinal, 99000), Fee: 1000}}, RejectMempoolConflict},
+		{"too many", 1000000, make([]*Replaced, MaxReplacementEvictions+1), RejectTooManyReplacements},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			replacement := spend(tx.SequenceFinal, 100000-test.fee)
+			err := p.CheckReplacement(replacement, test.fee, test.replaced)
+			if reason := rejectReason(err); reason != test.expected || (err == nil) != (test.expected == "") {
+				t.Errorf("Expected %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// MaxReplacementEvictions is the most mempool transactions a replacement can evict (BIP125 rule 5).
+const MaxReplacementEvictions = 100
+
+// SignalsReplacement returns whether a transaction opts in to replacement (BIP125):
+// whether an input has a sequence below 0xfffffffe.
+func SignalsReplacement(t *tx.Transaction) bool {
+	for _, txIn := range t.Inputs {
+		if txIn.Sequence < tx.SequenceFinal-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaced is a mempool transaction that a replacement evicts:
+// one spending an output the replacement spends or a descendant of one.
+type Replaced struct {
+	Tx  *tx.Transaction
+	Fee uint64
+}
+
+// CheckReplacement checks that a transaction paying fee can replace mempool transactions (BIP125).
+// Whether the replacement spends unconfirmed outputs that the replaced transactions don't
+// (rule 2) can't be checked without the mempool.
+func (p *Policy) CheckReplacement(replacement *tx.Transaction, fee uint64, replaced []*Replaced) error {
+	spends := make(map[string]bool, len(replacement.Inputs))
+	for _, txIn := range replacement.Inputs {
+		spends[outpoint(txIn)] = true
+	}
+	// rule 5
+	if len(replaced) > MaxReplacementEvictions {
+		return reject(RejectTooManyReplacements, -1, "%d evicted, the most is %d", len(replaced), MaxReplacementEvictions)
+	}
+	vsize := replacement.VSize()
+	var replacedFees uint64
+	for i, r := range replaced {
+		replacedFees += r.Fee
+		conflicts := false
+		for _, txIn := range r.Tx.Inputs {
+			conflicts = conflicts || spends[outpoint(txIn)]
+		}
+		// rule 1, descendants inherit the signal
+		if !conflicts {
+			continue
+		}
+		if !SignalsReplacement(r.Tx) {
+			return reject(RejectMempoolConflict, i, "%s doesn't signal replacement", r.Tx.ID())
+		}
+		// rule 6: the fee rate has to go up, compared as fee/vsize > r.Fee/r.VSize
+		if fee*uint64(r.Tx.VSize()) <= r.Fee*uint64(vsize) {
+			return reject(RejectInsufficientFee, i, "fee rate isn't above the fee rate of %s", r.Tx.ID())
+		}
+	}
+	// rule 3: at least the fees of everything evicted
+	if fee < replacedFees {
+		return reject(RejectInsufficientFee, -1, "fee %d is under the replaced fees of %d", fee, replacedFees)
+	}
+	// rule 4: plus the relay of the replacement itself
+	if extra := feeForSize(p.IncrementalRelayFeeRate, vsize); fee-replacedFees < extra {
+		return reject(RejectInsufficientFee, -1, "fee %d doesn't add %d to the replaced fees of %d", fee, extra, replacedFees)
+	}
+	return nil
+}
+
+func outpoint(txIn *tx.Input) string {
+	return fmt.Sprintf("%x:%d", txIn.PrevTx, txIn.PrevIndex)
+}
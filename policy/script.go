@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// ScriptType is the kind of a ScriptPubKey.
+type ScriptType int
+
+// Script types, named as Bitcoin Core names them.
+const (
+	NonStandard ScriptType = iota
+	PubKey
+	PubKeyHash
+	ScriptHash
+	Multisig
+	NullData
+	WitnessV0KeyHash
+	WitnessV0ScriptHash
+	WitnessV1Taproot
+	WitnessUnknown
+)
+
+var scriptTypeNames = map[ScriptType]string{
+	NonStandard:         "nonstandard",
+	PubKey:              "pubkey",
+	PubKeyHash:          "pubkeyhash",
+	ScriptHash:          "scripthash",
+	Multisig:            "multisig",
+	NullData:            "nulldata",
+	WitnessV0KeyHash:    "witness_v0_keyhash",
+	WitnessV0ScriptHash: "witness_v0_scripthash",
+	WitnessV1Taproot:    "witness_v1_taproot",
+	WitnessUnknown:      "witness_unknown",
+}
+
+func (t ScriptType) String() string {
+	return scriptTypeNames[t]
+}
+
+// Classify returns the type of a ScriptPubKey.
+func Classify(scriptPubKey *script.Script) ScriptType {
+	if version, program, ok := scriptPubKey.WitnessProgram(); ok {
+		switch {
+		case version == 0 && len(program) == 20:
+			return WitnessV0KeyHash
+		case version == 0 && len(program) == 32:
+			return WitnessV0ScriptHash
+		case version == 0:
+			return NonStandard
+		case version == 1 && len(program) == 32:
+			return WitnessV1Taproot
+		}
+		return WitnessUnknown
+	}
+	switch {
+	case scriptPubKey.IsP2pkhScriptPubKey():
+		return PubKeyHash
+	case scriptPubKey.IsP2shScriptPubKey():
+		return ScriptHash
+	case isNullData(scriptPubKey):
+		return NullData
+	case isPubKey(scriptPubKey):
+		return PubKey
+	}
+	if _, _, ok := scriptPubKey.Multisig(); ok {
+		return Multisig
+	}
+	return NonStandard
+}
+
+// isNullData returns whether the script is OP_RETURN followed by pushes only.
+func isNullData(scriptPubKey *script.Script) bool {
+	cmds := scriptPubKey.Commands()
+	return len(cmds) > 0 && cmds[0].Opcode == script.OpReturn &&
+		script.NewScriptFromCommands(cmds[1:]).IsPushOnly()
+}
+
+// isPubKey returns whether the script is <sec> OP_CHECKSIG.
+func isPubKey(scriptPubKey *script.Script) bool {
+	cmds := scriptPubKey.Commands()
+	if len(cmds) != 2 || cmds[1].Opcode != script.OpCheckSig || !cmds[0].IsData() {
+		return false
+	}
+	sec := cmds[0].Data
+	switch len(sec) {
+	case 33:
+		return sec[0] == 2 || sec[0] == 3
+	case 65:
+		return sec[0] == 4
+	}
+	return false
+}
+
+// isStandardOutput returns the type of a ScriptPubKey and whether nodes relay outputs paying to it.
+func (p *Policy) isStandardOutput(scriptPubKey *script.Script) (ScriptType, bool) {
+	scriptType := Classify(scriptPubKey)
+	switch scriptType {
+	case NonStandard:
+		return scriptType, false
+	case Multisig:
+		// only up to 1-of-3 to 3-of-3 bare multisig
+		_, pubKeys, _ := scriptPubKey.Multisig()
+		return scriptType, len(pubKeys) <= 3
+	case NullData:
+		return scriptType, len(scriptPubKey.RawSerialize()) <= p.MaxDataCarrierBytes
+	}
+	return scriptType, true
+}
+
+// DustThreshold returns the smallest amount of an output that isn't dust:
+// what it costs at the dust relay fee rate to create the output and spend it later.
+// Unspendable outputs have no threshold.
+func (p *Policy) DustThreshold(txOut *tx.Output) uint64 {
+	raw := txOut.ScriptPubKey.RawSerialize()
+	if (len(raw) > 0 && raw[0] == script.OpReturn) || len(raw) > script.MaxScriptSize {
+		return 0
+	}
+	size := len(txOut.Serialize())
+	if _, _, ok := txOut.ScriptPubKey.WitnessProgram(); ok {
+		// outpoint, empty ScriptSig, sequence and a witness of a signature and a public key
+		size += 32 + 4 + 1 + 107/tx.WitnessScaleFactor + 4
+	} else {
+		// outpoint, ScriptSig of a signature and a public key, sequence
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return feeForSize(p.DustRelayFeeRate, size)
+}
+
+// IsDust returns whether an output pays less than its dust threshold.
+func (p *Policy) IsDust(txOut *tx.Output) bool {
+	return txOut.Amount < p.DustThreshold(txOut)
+}
+
+// feeForSize returns the fee for a size in virtual bytes at a rate in satoshis per 1000 virtual bytes,
+// rounded up.
+func feeForSize(feeRate uint64, vsize int) uint64 {
+	return (feeRate*uint64(vsize) + 999) / 1000
+}
+
+// lastPush returns the data of the last push of a ScriptSig, nil if it is empty.
+func lastPush(scriptSig *script.Script) []byte {
+	if scriptSig.Len() == 0 {
+		return nil
+	}
+	return scriptSig.Peek(scriptSig.Len() - 1)
+}
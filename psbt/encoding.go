@@ -0,0 +1,905 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// magic starts every PSBT: "psbt" followed by 0xff.
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// Global key types.
+const (
+	globalUnsignedTx       = 0x00
+	globalXPub             = 0x01
+	globalTxVersion        = 0x02
+	globalFallbackLocktime = 0x03
+	globalInputCount       = 0x04
+	globalOutputCount      = 0x05
+	globalTxModifiable     = 0x06
+	globalVersion          = 0xfb
+)
+
+// Input key types.
+const (
+	inNonWitnessUtxo     = 0x00
+	inWitnessUtxo        = 0x01
+	inPartialSig         = 0x02
+	inSigHashType        = 0x03
+	inRedeemScript       = 0x04
+	inWitnessScript      = 0x05
+	inBip32Derivation    = 0x06
+	inFinalScriptSig     = 0x07
+	inFinalScriptWitness = 0x08
+	inRipemd160          = 0x0a
+	inSha256             = 0x0b
+	inHash160            = 0x0c
+	inHash256            = 0x0d
+	inPreviousTxID       = 0x0e
+	inOutputIndex        = 0x0f
+	inSequence           = 0x10
+	inRequiredTime       = 0x11
+	inRequiredHeight     = 0x12
+	inTapKeySig          = 0x13
+	inTapScriptSig       = 0x14
+	inTapLeafScript      = 0x15
+	inTapBip32Derivation = 0x16
+	inTapInternalKey     = 0x17
+	inTapMerkleRoot      = 0x18
+)
+
+// Output key types.
+const (
+	outRedeemScript       = 0x00
+	outWitnessScript      = 0x01
+	outBip32Derivation    = 0x02
+	outAmount             = 0x03
+	outScript             = 0x04
+	outTapInternalKey     = 0x05
+	outTapTree            = 0x06
+	outTapBip32Derivation = 0x07
+)
+
+// xpubSize is the size of a serialized extended key.
+const xpubSize = 78
+
+// entry is a key-value pair of a map.
+type entry struct {
+	key   []byte
+	value []byte
+}
+
+func (e *entry) keyType() byte {
+	return e.key[0]
+}
+
+func (e *entry) keyData() []byte {
+	return e.key[1:]
+}
+
+// ParseBase64 parses a base64 encoded PSBT.
+func ParseBase64(s string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses a PSBT in the binary format.
+func Parse(data []byte) (result *PSBT, err error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, errors.New("missing psbt magic bytes")
+	}
+	defer func() {
+		// the readers panic on data that runs out
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed psbt: %v", r)
+		}
+	}()
+	s := bytes.NewReader(data[len(magic):])
+	entries, err := readMap(s)
+	if err != nil {
+		return nil, err
+	}
+	result = new(PSBT)
+	inputCount, outputCount, err := result.parseGlobal(entries)
+	if err != nil {
+		return nil, err
+	}
+	unsignedTx := result.UnsignedTx
+	for i := 0; i < inputCount; i++ {
+		entries, err := readMap(s)
+		if err != nil {
+			return nil, err
+		}
+		in, txIn, err := parseInput(entries, result.Version)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		if txIn != nil {
+			unsignedTx.Inputs = append(unsignedTx.Inputs, txIn)
+		}
+		result.Inputs = append(result.Inputs, in)
+	}
+	for i := 0; i < outputCount; i++ {
+		entries, err := readMap(s)
+		if err != nil {
+			return nil, err
+		}
+		out, txOut, err := parseOutput(entries, result.Version)
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %v", i, err)
+		}
+		if txOut != nil {
+			unsignedTx.Outputs = append(unsignedTx.Outputs, txOut)
+		}
+		result.Outputs = append(result.Outputs, out)
+	}
+	if s.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the last map", s.Len())
+	}
+	for i, in := range result.Inputs {
+		if err := in.checkLocktimes(); err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+	if err := result.syncLocktime(); err != nil {
+		return nil, err
+	}
+	for i := range result.Inputs {
+		if result.Inputs[i].NonWitnessUtxo == nil {
+			continue
+		}
+		if _, err := result.utxo(i); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// readMap reads key-value pairs up to the 0x00 separator.
+func readMap(s *bytes.Reader) ([]*entry, error) {
+	var result []*entry
+	seen := make(map[string]bool)
+	for {
+		keyLen := util.ReadVarInt(s)
+		if keyLen == 0 {
+			return result, nil
+		}
+		key, err := readBytes(s, keyLen)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(s, util.ReadVarInt(s))
+		if err != nil {
+			return nil, err
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("duplicate key %x", key)
+		}
+		seen[string(key)] = true
+		result = append(result, &entry{key: key, value: value})
+	}
+}
+
+func readBytes(s *bytes.Reader, n int) ([]byte, error) {
+	if n < 0 || n > s.Len() {
+		return nil, fmt.Errorf("expected %d bytes, %d left", n, s.Len())
+	}
+	result := make([]byte, n)
+	s.Read(result)
+	return result, nil
+}
+
+// parseGlobal parses the global map and returns the number of inputs and outputs.
+// Version 2 PSBTs get an unsigned transaction without inputs and outputs
+// for the input and output maps to fill in.
+func (p *PSBT) parseGlobal(entries []*entry) (int, int, error) {
+	var txVersion, inputCount, outputCount *uint32
+	v2Field := false
+	for _, e := range entries {
+		var err error
+		switch e.keyType() {
+		case globalUnsignedTx:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				p.UnsignedTx, err = parseTransaction(e.value, false)
+			}
+			if err == nil {
+				err = checkUnsigned(p.UnsignedTx)
+			}
+		case globalXPub:
+			err = checkKeyLen(e, xpubSize)
+			if err == nil {
+				var d *Bip32Derivation
+				d, err = parseDerivation(e.keyData(), e.value)
+				p.XPubs = append(p.XPubs, d)
+			}
+		case globalTxVersion:
+			v2Field = true
+			txVersion, err = parseUint32(e)
+		case globalFallbackLocktime:
+			v2Field = true
+			var locktime *uint32
+			locktime, err = parseUint32(e)
+			if err == nil {
+				p.FallbackLocktime = *locktime
+			}
+		case globalInputCount:
+			v2Field = true
+			inputCount, err = parseCount(e)
+		case globalOutputCount:
+			v2Field = true
+			outputCount, err = parseCount(e)
+		case globalTxModifiable:
+			v2Field = true
+			err = checkKeyLen(e, 0)
+			if err == nil && len(e.value) != 1 {
+				err = errors.New("tx modifiable flags must be 1 byte")
+			}
+			if err == nil {
+				p.TxModifiable = e.value[0]
+			}
+		case globalVersion:
+			var version *uint32
+			version, err = parseUint32(e)
+			if err == nil {
+				p.Version = *version
+			}
+		default:
+			p.Unknowns = append(p.Unknowns, &Unknown{Key: e.key, Value: e.value})
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("global key %x: %v", e.key, err)
+		}
+	}
+	switch p.Version {
+	case 0:
+		if p.UnsignedTx == nil {
+			return 0, 0, errors.New("missing unsigned transaction")
+		}
+		if v2Field {
+			return 0, 0, errors.New("version 0 PSBTs can't have version 2 global fields")
+		}
+		return len(p.UnsignedTx.Inputs), len(p.UnsignedTx.Outputs), nil
+	case 2:
+		if p.UnsignedTx != nil {
+			return 0, 0, errors.New("version 2 PSBTs can't have an unsigned transaction")
+		}
+		if txVersion == nil || inputCount == nil || outputCount == nil {
+			return 0, 0, errors.New("missing transaction version, input count or output count")
+		}
+		if *txVersion < 2 {
+			return 0, 0, fmt.Errorf("version 2 PSBTs need transaction version 2 or higher, got %d", *txVersion)
+		}
+		p.UnsignedTx = tx.NewTransaction(*txVersion, nil, nil, p.FallbackLocktime, false)
+		return int(*inputCount), int(*outputCount), nil
+	}
+	return 0, 0, fmt.Errorf("unsupported version %d", p.Version)
+}
+
+// parseInput parses an input map. Version 2 input maps also hold
+// the outpoint and sequence, which are returned as the transaction's input.
+func parseInput(entries []*entry, version uint32) (*Input, *tx.Input, error) {
+	result := new(Input)
+	var prevTx []byte
+	var prevIndex *uint32
+	sequence := uint32(0xffffffff)
+	v2Field := false
+	for _, e := range entries {
+		var err error
+		switch e.keyType() {
+		case inNonWitnessUtxo:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.NonWitnessUtxo, err = parseTransaction(e.value, true)
+			}
+		case inWitnessUtxo:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.WitnessUtxo, err = parseTxOutput(e.value)
+			}
+		case inPartialSig:
+			err = checkPubKey(e)
+			if err == nil {
+				result.PartialSigs = append(result.PartialSigs, &PartialSig{PubKey: e.keyData(), Signature: e.value})
+			}
+		case inSigHashType:
+			err = checkKeyLen(e, 0)
+			if err == nil && len(e.value) != 4 {
+				err = errors.New("sighash type must be 4 bytes")
+			}
+			if err == nil {
+				result.SigHashType = util.LittleEndianToInt32(e.value)
+			}
+		case inRedeemScript:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.RedeemScript, err = script.ParseRaw(e.value)
+			}
+		case inWitnessScript:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.WitnessScript, err = script.ParseRaw(e.value)
+			}
+		case inBip32Derivation:
+			err = checkPubKey(e)
+			if err == nil {
+				var d *Bip32Derivation
+				d, err = parseDerivation(e.keyData(), e.value)
+				result.Bip32Derivations = append(result.Bip32Derivations, d)
+			}
+		case inFinalScriptSig:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.FinalScriptSig, err = script.ParseRaw(e.value)
+			}
+		case inFinalScriptWitness:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.FinalScriptWitness, err = parseWitness(e.value)
+			}
+		case inRipemd160:
+			result.Ripemd160Preimages, err = addPreimage(result.Ripemd160Preimages, e, util.Ripemd160)
+		case inSha256:
+			result.Sha256Preimages, err = addPreimage(result.Sha256Preimages, e, util.Sha256)
+		case inHash160:
+			result.Hash160Preimages, err = addPreimage(result.Hash160Preimages, e, util.Hash160)
+		case inHash256:
+			result.Hash256Preimages, err = addPreimage(result.Hash256Preimages, e, util.Hash256)
+		case inPreviousTxID:
+			v2Field = true
+			prevTx, err = parseFixed(e, 32)
+			if err == nil {
+				prevTx = util.ReverseByteArray(prevTx)
+			}
+		case inOutputIndex:
+			v2Field = true
+			prevIndex, err = parseUint32(e)
+		case inSequence:
+			v2Field = true
+			var n *uint32
+			n, err = parseUint32(e)
+			if err == nil {
+				sequence = *n
+			}
+		case inRequiredTime:
+			v2Field = true
+			var n *uint32
+			n, err = parseUint32(e)
+			if err == nil {
+				result.RequiredTimeLocktime = *n
+			}
+		case inRequiredHeight:
+			v2Field = true
+			var n *uint32
+			n, err = parseUint32(e)
+			if err == nil {
+				result.RequiredHeightLocktime = *n
+			}
+		case inTapKeySig:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				err = checkSchnorrSig(e.value)
+			}
+			if err == nil {
+				result.TapKeySig = e.value
+			}
+		case inTapScriptSig:
+			err = checkKeyLen(e, 64)
+			if err == nil {
+				err = checkSchnorrSig(e.value)
+			}
+			if err == nil {
+				keyData := e.keyData()
+				result.TapScriptSigs = append(result.TapScriptSigs, &TapScriptSig{XOnlyKey: keyData[:32], LeafHash: keyData[32:], Signature: e.value})
+			}
+		case inTapLeafScript:
+			var leaf *TapLeafScript
+			leaf, err = parseTapLeafScript(e)
+			if err == nil {
+				result.TapLeafScripts = append(result.TapLeafScripts, leaf)
+			}
+		case inTapBip32Derivation:
+			var d *TapBip32Derivation
+			d, err = parseTapDerivation(e)
+			if err == nil {
+				result.TapBip32Derivations = append(result.TapBip32Derivations, d)
+			}
+		case inTapInternalKey:
+			result.TapInternalKey, err = parseFixed(e, 32)
+		case inTapMerkleRoot:
+			result.TapMerkleRoot, err = parseFixed(e, 32)
+		default:
+			result.Unknowns = append(result.Unknowns, &Unknown{Key: e.key, Value: e.value})
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %x: %v", e.key, err)
+		}
+	}
+	if version != 2 {
+		if v2Field {
+			return nil, nil, errors.New("version 0 PSBTs can't have version 2 input fields")
+		}
+		return result, nil, nil
+	}
+	if prevTx == nil || prevIndex == nil {
+		return nil, nil, errors.New("missing previous txid or output index")
+	}
+	return result, tx.NewInput(prevTx, int(*prevIndex), nil, sequence), nil
+}
+
+// parseOutput parses an output map. Version 2 output maps also hold
+// the amount and script, which are returned as the transaction's output.
+func parseOutput(entries []*entry, version uint32) (*Output, *tx.Output, error) {
+	result := new(Output)
+	var amount []byte
+	var scriptPubKey *script.Script
+	for _, e := range entries {
+		var err error
+		switch e.keyType() {
+		case outRedeemScript:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.RedeemScript, err = script.ParseRaw(e.value)
+			}
+		case outWitnessScript:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.WitnessScript, err = script.ParseRaw(e.value)
+			}
+		case outBip32Derivation:
+			err = checkPubKey(e)
+			if err == nil {
+				var d *Bip32Derivation
+				d, err = parseDerivation(e.keyData(), e.value)
+				result.Bip32Derivations = append(result.Bip32Derivations, d)
+			}
+		case outAmount:
+			amount, err = parseFixed(e, 8)
+		case outScript:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				scriptPubKey, err = script.ParseRaw(e.value)
+			}
+		case outTapInternalKey:
+			result.TapInternalKey, err = parseFixed(e, 32)
+		case outTapTree:
+			err = checkKeyLen(e, 0)
+			if err == nil {
+				result.TapTree, err = parseTapTree(e.value)
+			}
+		case outTapBip32Derivation:
+			var d *TapBip32Derivation
+			d, err = parseTapDerivation(e)
+			if err == nil {
+				result.TapBip32Derivations = append(result.TapBip32Derivations, d)
+			}
+		default:
+			result.Unknowns = append(result.Unknowns, &Unknown{Key: e.key, Value: e.value})
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %x: %v", e.key, err)
+		}
+	}
+	if version != 2 {
+		if amount != nil || scriptPubKey != nil {
+			return nil, nil, errors.New("version 0 PSBTs can't have version 2 output fields")
+		}
+		return result, nil, nil
+	}
+	if amount == nil || scriptPubKey == nil {
+		return nil, nil, errors.New("missing amount or script")
+	}
+	return result, tx.NewOutput(util.LittleEndianToInt64(amount), scriptPubKey), nil
+}
+
+func checkKeyLen(e *entry, n int) error {
+	if len(e.keyData()) != n {
+		return fmt.Errorf("expected %d bytes of key data, got %d", n, len(e.keyData()))
+	}
+	return nil
+}
+
+func checkPubKey(e *entry) error {
+	if n := len(e.keyData()); n != 33 && n != 65 {
+		return fmt.Errorf("invalid public key length %d", n)
+	}
+	return nil
+}
+
+// parseFixed returns the value of an entry without key data, which must be n bytes.
+func parseFixed(e *entry, n int) ([]byte, error) {
+	if err := checkKeyLen(e, 0); err != nil {
+		return nil, err
+	}
+	if len(e.value) != n {
+		return nil, fmt.Errorf("expected a %d byte value, got %d", n, len(e.value))
+	}
+	return e.value, nil
+}
+
+func parseUint32(e *entry) (*uint32, error) {
+	value, err := parseFixed(e, 4)
+	if err != nil {
+		return nil, err
+	}
+	result := util.LittleEndianToInt32(value)
+	return &result, nil
+}
+
+// parseCount parses a compact size count.
+func parseCount(e *entry) (*uint32, error) {
+	if err := checkKeyLen(e, 0); err != nil {
+		return nil, err
+	}
+	s := bytes.NewReader(e.value)
+	result := uint32(util.ReadVarInt(s))
+	if s.Len() > 0 || !bytes.Equal(util.EncodeVarInt(int(result)), e.value) {
+		return nil, errors.New("invalid count")
+	}
+	return &result, nil
+}
+
+// checkSchnorrSig checks the length of a Schnorr signature with an optional hash type byte.
+func checkSchnorrSig(sig []byte) error {
+	if len(sig) != 64 && len(sig) != 65 {
+		return fmt.Errorf("invalid schnorr signature length %d", len(sig))
+	}
+	return nil
+}
+
+// parseTapLeafScript parses a leaf script keyed by its control block:
+// the leaf version and parity byte, the internal key and the merkle path.
+func parseTapLeafScript(e *entry) (*TapLeafScript, error) {
+	controlBlock := e.keyData()
+	if len(controlBlock) < 33 || (len(controlBlock)-33)%32 != 0 || (len(controlBlock)-33)/32 > maxTapTreeDepth {
+		return nil, fmt.Errorf("invalid control block length %d", len(controlBlock))
+	}
+	if len(e.value) == 0 {
+		return nil, errors.New("missing leaf version")
+	}
+	leafVersion := e.value[len(e.value)-1]
+	if controlBlock[0]&0xfe != leafVersion {
+		return nil, errors.New("leaf version doesn't match the control block")
+	}
+	leafScript, err := script.ParseRaw(e.value[:len(e.value)-1])
+	if err != nil {
+		return nil, err
+	}
+	return &TapLeafScript{ControlBlock: controlBlock, Script: leafScript, LeafVersion: leafVersion}, nil
+}
+
+// parseTapDerivation parses the origin of an x-only key: the leaf hashes, then the fingerprint and path.
+func parseTapDerivation(e *entry) (*TapBip32Derivation, error) {
+	if err := checkKeyLen(e, 32); err != nil {
+		return nil, err
+	}
+	s := bytes.NewReader(e.value)
+	count := util.ReadVarInt(s)
+	result := new(TapBip32Derivation)
+	for i := 0; i < count; i++ {
+		leafHash, err := readBytes(s, 32)
+		if err != nil {
+			return nil, err
+		}
+		result.LeafHashes = append(result.LeafHashes, leafHash)
+	}
+	rest, _ := readBytes(s, s.Len())
+	d, err := parseDerivation(e.keyData(), rest)
+	if err != nil {
+		return nil, err
+	}
+	result.Bip32Derivation = *d
+	return result, nil
+}
+
+// parseTapTree parses the leaves of a script tree, each a depth, a leaf version and a script.
+func parseTapTree(raw []byte) ([]*TapLeaf, error) {
+	s := bytes.NewReader(raw)
+	var result []*TapLeaf
+	for s.Len() > 0 {
+		header, err := readBytes(s, 2)
+		if err != nil {
+			return nil, err
+		}
+		rawScript, err := readBytes(s, util.ReadVarInt(s))
+		if err != nil {
+			return nil, err
+		}
+		leafScript, err := script.ParseRaw(rawScript)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &TapLeaf{Depth: int(header[0]), LeafVersion: header[1], Script: leafScript})
+	}
+	if _, _, err := tapTree(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseTransaction parses a serialized transaction, which must have no bytes left over.
+// witness allows the BIP144 format.
+func parseTransaction(raw []byte, witness bool) (*tx.Transaction, error) {
+	result := tx.ParseTransaction(bytes.NewReader(raw), false)
+	serialized := result.SerializeLegacy()
+	if witness {
+		serialized = result.Serialize()
+	}
+	if !bytes.Equal(serialized, raw) {
+		return nil, errors.New("invalid transaction")
+	}
+	return result, nil
+}
+
+func parseTxOutput(raw []byte) (*tx.Output, error) {
+	result := tx.ParseOutput(bytes.NewReader(raw))
+	if !bytes.Equal(result.Serialize(), raw) {
+		return nil, errors.New("invalid output")
+	}
+	return result, nil
+}
+
+func parseDerivation(key, value []byte) (*Bip32Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, fmt.Errorf("invalid derivation length %d", len(value))
+	}
+	result := &Bip32Derivation{Key: key, Path: []uint32{}}
+	copy(result.Fingerprint[:], value)
+	for i := 4; i < len(value); i += 4 {
+		result.Path = append(result.Path, util.LittleEndianToInt32(value[i:i+4]))
+	}
+	return result, nil
+}
+
+func parseWitness(raw []byte) ([][]byte, error) {
+	s := bytes.NewReader(raw)
+	result := make([][]byte, util.ReadVarInt(s))
+	for i := range result {
+		item, err := readBytes(s, util.ReadVarInt(s))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = item
+	}
+	if s.Len() > 0 {
+		return nil, errors.New("invalid witness")
+	}
+	return result, nil
+}
+
+// addPreimage adds a preimage after checking it against the hash in the key.
+func addPreimage(preimages map[string][]byte, e *entry, hash func([]byte) []byte) (map[string][]byte, error) {
+	if !bytes.Equal(hash(e.value), e.keyData()) {
+		return nil, errors.New("preimage does not match the hash")
+	}
+	if preimages == nil {
+		preimages = make(map[string][]byte)
+	}
+	preimages[hex.EncodeToString(e.keyData())] = e.value
+	return preimages, nil
+}
+
+// Base64 returns the base64 encoding of the PSBT.
+func (p *PSBT) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+// Serialize returns the PSBT in the binary format.
+func (p *PSBT) Serialize() []byte {
+	result := make([]byte, len(magic))
+	copy(result, magic)
+	unsignedTx := p.UnsignedTx
+	v2 := p.Version == 2
+	if !v2 {
+		result = append(result, serializeEntry(globalUnsignedTx, nil, unsignedTx.SerializeLegacy())...)
+	}
+	for _, d := range p.XPubs {
+		result = append(result, serializeEntry(globalXPub, d.Key, d.serializeValue())...)
+	}
+	if v2 {
+		result = append(result, serializeEntry(globalTxVersion, nil, util.Int32ToLittleEndian(unsignedTx.Version))...)
+		if p.FallbackLocktime != 0 {
+			result = append(result, serializeEntry(globalFallbackLocktime, nil, util.Int32ToLittleEndian(p.FallbackLocktime))...)
+		}
+		result = append(result, serializeEntry(globalInputCount, nil, util.EncodeVarInt(len(unsignedTx.Inputs)))...)
+		result = append(result, serializeEntry(globalOutputCount, nil, util.EncodeVarInt(len(unsignedTx.Outputs)))...)
+		if p.TxModifiable != 0 {
+			result = append(result, serializeEntry(globalTxModifiable, nil, []byte{p.TxModifiable})...)
+		}
+	}
+	if p.Version != 0 {
+		result = append(result, serializeEntry(globalVersion, nil, util.Int32ToLittleEndian(p.Version))...)
+	}
+	result = append(result, serializeUnknowns(p.Unknowns)...)
+	result = append(result, 0)
+	for i, in := range p.Inputs {
+		var txIn *tx.Input
+		if v2 {
+			txIn = unsignedTx.Inputs[i]
+		}
+		result = append(result, in.serialize(txIn)...)
+	}
+	for i, out := range p.Outputs {
+		var txOut *tx.Output
+		if v2 {
+			txOut = unsignedTx.Outputs[i]
+		}
+		result = append(result, out.serialize(txOut)...)
+	}
+	return result
+}
+
+// serialize serializes an input map, with the outpoint and sequence of txIn for version 2.
+func (in *Input) serialize(txIn *tx.Input) []byte {
+	var result []byte
+	if in.NonWitnessUtxo != nil {
+		result = append(result, serializeEntry(inNonWitnessUtxo, nil, in.NonWitnessUtxo.Serialize())...)
+	}
+	if in.WitnessUtxo != nil {
+		result = append(result, serializeEntry(inWitnessUtxo, nil, in.WitnessUtxo.Serialize())...)
+	}
+	for _, sig := range in.PartialSigs {
+		result = append(result, serializeEntry(inPartialSig, sig.PubKey, sig.Signature)...)
+	}
+	if in.SigHashType != 0 {
+		result = append(result, serializeEntry(inSigHashType, nil, util.Int32ToLittleEndian(in.SigHashType))...)
+	}
+	if in.RedeemScript != nil {
+		result = append(result, serializeEntry(inRedeemScript, nil, in.RedeemScript.RawSerialize())...)
+	}
+	if in.WitnessScript != nil {
+		result = append(result, serializeEntry(inWitnessScript, nil, in.WitnessScript.RawSerialize())...)
+	}
+	for _, d := range in.Bip32Derivations {
+		result = append(result, serializeEntry(inBip32Derivation, d.Key, d.serializeValue())...)
+	}
+	if in.FinalScriptSig != nil {
+		result = append(result, serializeEntry(inFinalScriptSig, nil, in.FinalScriptSig.RawSerialize())...)
+	}
+	if in.FinalScriptWitness != nil {
+		result = append(result, serializeEntry(inFinalScriptWitness, nil, serializeWitness(in.FinalScriptWitness))...)
+	}
+	result = append(result, serializePreimages(inRipemd160, in.Ripemd160Preimages)...)
+	result = append(result, serializePreimages(inSha256, in.Sha256Preimages)...)
+	result = append(result, serializePreimages(inHash160, in.Hash160Preimages)...)
+	result = append(result, serializePreimages(inHash256, in.Hash256Preimages)...)
+	if txIn != nil {
+		prevTx := util.ReverseByteArray(append([]byte{}, txIn.PrevTx...))
+		result = append(result, serializeEntry(inPreviousTxID, nil, prevTx)...)
+		result = append(result, serializeEntry(inOutputIndex, nil, util.Int32ToLittleEndian(uint32(txIn.PrevIndex)))...)
+		if txIn.Sequence != 0xffffffff {
+			result = append(result, serializeEntry(inSequence, nil, util.Int32ToLittleEndian(txIn.Sequence))...)
+		}
+		if in.RequiredTimeLocktime != 0 {
+			result = append(result, serializeEntry(inRequiredTime, nil, util.Int32ToLittleEndian(in.RequiredTimeLocktime))...)
+		}
+		if in.RequiredHeightLocktime != 0 {
+			result = append(result, serializeEntry(inRequiredHeight, nil, util.Int32ToLittleEndian(in.RequiredHeightLocktime))...)
+		}
+	}
+	if in.TapKeySig != nil {
+		result = append(result, serializeEntry(inTapKeySig, nil, in.TapKeySig)...)
+	}
+	for _, sig := range in.TapScriptSigs {
+		keyData := append(append([]byte{}, sig.XOnlyKey...), sig.LeafHash...)
+		result = append(result, serializeEntry(inTapScriptSig, keyData, sig.Signature)...)
+	}
+	for _, leaf := range in.TapLeafScripts {
+		value := append(leaf.Script.RawSerialize(), leaf.LeafVersion)
+		result = append(result, serializeEntry(inTapLeafScript, leaf.ControlBlock, value)...)
+	}
+	for _, d := range in.TapBip32Derivations {
+		result = append(result, serializeEntry(inTapBip32Derivation, d.Key, d.serializeValue())...)
+	}
+	if in.TapInternalKey != nil {
+		result = append(result, serializeEntry(inTapInternalKey, nil, in.TapInternalKey)...)
+	}
+	if in.TapMerkleRoot != nil {
+		result = append(result, serializeEntry(inTapMerkleRoot, nil, in.TapMerkleRoot)...)
+	}
+	result = append(result, serializeUnknowns(in.Unknowns)...)
+	return append(result, 0)
+}
+
+// serialize serializes an output map, with the amount and script of txOut for version 2.
+func (out *Output) serialize(txOut *tx.Output) []byte {
+	var result []byte
+	if out.RedeemScript != nil {
+		result = append(result, serializeEntry(outRedeemScript, nil, out.RedeemScript.RawSerialize())...)
+	}
+	if out.WitnessScript != nil {
+		result = append(result, serializeEntry(outWitnessScript, nil, out.WitnessScript.RawSerialize())...)
+	}
+	for _, d := range out.Bip32Derivations {
+		result = append(result, serializeEntry(outBip32Derivation, d.Key, d.serializeValue())...)
+	}
+	if txOut != nil {
+		result = append(result, serializeEntry(outAmount, nil, util.Int64ToLittleEndian(txOut.Amount))...)
+		result = append(result, serializeEntry(outScript, nil, txOut.ScriptPubKey.RawSerialize())...)
+	}
+	if out.TapInternalKey != nil {
+		result = append(result, serializeEntry(outTapInternalKey, nil, out.TapInternalKey)...)
+	}
+	if out.TapTree != nil {
+		var value []byte
+		for _, leaf := range out.TapTree {
+			rawScript := leaf.Script.RawSerialize()
+			value = append(value, byte(leaf.Depth), leaf.LeafVersion)
+			value = append(value, util.EncodeVarInt(len(rawScript))...)
+			value = append(value, rawScript...)
+		}
+		result = append(result, serializeEntry(outTapTree, nil, value)...)
+	}
+	for _, d := range out.TapBip32Derivations {
+		result = append(result, serializeEntry(outTapBip32Derivation, d.Key, d.serializeValue())...)
+	}
+	result = append(result, serializeUnknowns(out.Unknowns)...)
+	return append(result, 0)
+}
+
+// serializeEntry serializes a key-value pair with the key made of the key type and key data.
+func serializeEntry(keyType byte, keyData, value []byte) []byte {
+	result := util.EncodeVarInt(len(keyData) + 1)
+	result = append(result, keyType)
+	result = append(result, keyData...)
+	result = append(result, util.EncodeVarInt(len(value))...)
+	return append(result, value...)
+}
+
+func serializeUnknowns(unknowns []*Unknown) []byte {
+	var result []byte
+	for _, u := range unknowns {
+		result = append(result, serializeEntry(u.Key[0], u.Key[1:], u.Value)...)
+	}
+	return result
+}
+
+// serializePreimages serializes the preimages in the order of their hashes.
+func serializePreimages(keyType byte, preimages map[string][]byte) []byte {
+	hashes := make([]string, 0, len(preimages))
+	for hash := range preimages {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var result []byte
+	for _, hash := range hashes {
+		result = append(result, serializeEntry(keyType, util.HexStringToBytes(hash), preimages[hash])...)
+	}
+	return result
+}
+
+func (d *Bip32Derivation) serializeValue() []byte {
+	result := append([]byte{}, d.Fingerprint[:]...)
+	for _, index := range d.Path {
+		result = append(result, util.Int32ToLittleEndian(index)...)
+	}
+	return result
+}
+
+func (d *TapBip32Derivation) serializeValue() []byte {
+	result := util.EncodeVarInt(len(d.LeafHashes))
+	for _, leafHash := range d.LeafHashes {
+		result = append(result, leafHash...)
+	}
+	return append(result, d.Bip32Derivation.serializeValue()...)
+}
+
+func serializeWitness(items [][]byte) []byte {
+	result := util.EncodeVarInt(len(items))
+	for _, item := range items {
+		result = append(result, util.EncodeVarInt(len(item))...)
+		result = append(result, item...)
+	}
+	return result
+}
@@ -0,0 +1,162 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/miniscript"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Finalize finalizes every input that isn't final yet.
+func (p *PSBT) Finalize() error {
+	for i := range p.Inputs {
+		if err := p.FinalizeInput(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinalizeInput builds the final ScriptSig and witness of an input from its signatures
+// and preimages (the Finalizer role), then drops the data that was only needed to sign.
+// Scripts other than p2pkh and p2wpkh are satisfied as miniscript, which covers multisig.
+// Taproot inputs use the key path signature if there is one, or else a leaf script
+// with a single key or a multisig of OP_CHECKSIGADDs that has enough signatures.
+func (p *PSBT) FinalizeInput(inputIndex int) error {
+	in := p.Inputs[inputIndex]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		return nil
+	}
+	utxo, err := p.utxo(inputIndex)
+	if err != nil {
+		return err
+	}
+	if err := p.syncLocktime(); err != nil {
+		return err
+	}
+	program := utxo.ScriptPubKey
+	p2sh := program.IsP2shScriptPubKey()
+	if p2sh {
+		if in.RedeemScript == nil {
+			return fmt.Errorf("input %d has no redeem script", inputIndex)
+		}
+		program = in.RedeemScript
+	}
+	var stack, witness [][]byte
+	version, witnessProgram, segwit := program.WitnessProgram()
+	switch {
+	case segwit && version == 0 && len(witnessProgram) == 20:
+		witness, err = in.satisfyKeyHash(witnessProgram)
+	case segwit && version == 1 && len(witnessProgram) == 32 && !p2sh:
+		witness, err = in.satisfyTaproot()
+	case segwit && version == 0 && len(witnessProgram) == 32:
+		if in.WitnessScript == nil {
+			return fmt.Errorf("input %d has no witness script", inputIndex)
+		}
+		witness, err = p.satisfy(inputIndex, in.WitnessScript)
+		witness = append(witness, in.WitnessScript.RawSerialize())
+	case segwit:
+		return fmt.Errorf("input %d: unsupported witness version %d", inputIndex, version)
+	case program.IsP2pkhScriptPubKey():
+		stack, err = in.satisfyKeyHash(program.Peek(2))
+	default:
+		stack, err = p.satisfy(inputIndex, program)
+	}
+	if err != nil {
+		return fmt.Errorf("input %d: %v", inputIndex, err)
+	}
+	scriptSig := new(script.Script)
+	for _, item := range stack {
+		scriptSig.AppendData(item)
+	}
+	if p2sh {
+		scriptSig.AppendData(in.RedeemScript.RawSerialize())
+	}
+	if scriptSig.Len() > 0 {
+		in.FinalScriptSig = scriptSig
+	}
+	in.FinalScriptWitness = witness
+	in.PartialSigs = nil
+	in.SigHashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivations = nil
+	in.Ripemd160Preimages = nil
+	in.Sha256Preimages = nil
+	in.Hash160Preimages = nil
+	in.Hash256Preimages = nil
+	in.TapKeySig = nil
+	in.TapScriptSigs = nil
+	in.TapLeafScripts = nil
+	in.TapBip32Derivations = nil
+	in.TapInternalKey = nil
+	in.TapMerkleRoot = nil
+	return nil
+}
+
+// satisfyKeyHash returns the signature and key for a p2pkh or p2wpkh output.
+func (in *Input) satisfyKeyHash(h160 []byte) ([][]byte, error) {
+	for _, sig := range in.PartialSigs {
+		if bytes.Equal(util.Hash160(sig.PubKey), h160) {
+			return [][]byte{sig.Signature, sig.PubKey}, nil
+		}
+	}
+	return nil, errors.New("no signature for the key hash")
+}
+
+// satisfy returns the stack items that satisfy a script, bottom first.
+func (p *PSBT) satisfy(inputIndex int, scr *script.Script) ([][]byte, error) {
+	node, err := miniscript.Lift(scr)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported script: %v", err)
+	}
+	in := p.Inputs[inputIndex]
+	satisfier := &miniscript.Satisfier{
+		Signatures: make(map[string][]byte),
+		Preimages:  make(map[string][]byte),
+		Sequence:   p.UnsignedTx.Inputs[inputIndex].Sequence,
+		LockTime:   p.UnsignedTx.Locktime,
+	}
+	for _, sig := range in.PartialSigs {
+		satisfier.Signatures[hex.EncodeToString(sig.PubKey)] = sig.Signature
+	}
+	for _, preimages := range []map[string][]byte{in.Ripemd160Preimages, in.Sha256Preimages, in.Hash160Preimages, in.Hash256Preimages} {
+		for hash, preimage := range preimages {
+			satisfier.Preimages[hash] = preimage
+		}
+	}
+	return node.Satisfy(satisfier)
+}
+
+// Extract returns the signed transaction once every input is finalized (the Extractor role).
+// Returns an error if an input isn't final or the transaction fails to verify.
+func (p *PSBT) Extract() (*tx.Transaction, error) {
+	if err := p.syncLocktime(); err != nil {
+		return nil, err
+	}
+	unsignedTx := p.UnsignedTx
+	inputs := make([]*tx.Input, len(unsignedTx.Inputs))
+	for i, txIn := range unsignedTx.Inputs {
+		in := p.Inputs[i]
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return nil, fmt.Errorf("input %d is not finalized", i)
+		}
+		if _, err := p.utxo(i); err != nil {
+			return nil, err
+		}
+		inputs[i] = tx.NewInput(txIn.PrevTx, txIn.PrevIndex, in.FinalScriptSig, txIn.Sequence)
+		inputs[i].Witness = in.FinalScriptWitness
+	}
+	outputs := make([]*tx.Output, len(unsignedTx.Outputs))
+	copy(outputs, unsignedTx.Outputs)
+	result := tx.NewTransaction(unsignedTx.Version, inputs, outputs, unsignedTx.Locktime, unsignedTx.Testnet)
+	if ok, err := result.Verify(p.prevouts()); err != nil || !ok {
+		return nil, errors.New("transaction failed to verify")
+	}
+	return result, nil
+}
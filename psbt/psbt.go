@@ -0,0 +1,377 @@
+// Package psbt implements partially signed bitcoin transactions:
+// version 0 (BIP174), version 2 (BIP370) and the taproot fields (BIP371).
+package psbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// Flags of PSBT.TxModifiable (BIP370).
+const (
+	InputsModifiable  = 1 << 0
+	OutputsModifiable = 1 << 1
+	// HasSigHashSingle is set once an input is signed with SIGHASH_SINGLE,
+	// which ties it to the output at the same index.
+	HasSigHashSingle = 1 << 2
+)
+
+// PSBT is a transaction with the data needed to sign it and the signatures collected so far.
+type PSBT struct {
+	// UnsignedTx is the transaction with empty ScriptSigs and witnesses.
+	// Version 2 PSBTs serialize its fields in the input and output maps instead,
+	// and its lock time is determined from the inputs.
+	UnsignedTx *tx.Transaction
+	// XPubs are the extended public keys the inputs and outputs are derived from.
+	XPubs []*Bip32Derivation
+	// Version is 0 or 2.
+	Version uint32
+	// FallbackLocktime is the lock time of a version 2 PSBT when no input requires one.
+	FallbackLocktime uint32
+	// TxModifiable holds the flags saying what can still be added to a version 2 PSBT.
+	TxModifiable byte
+	Unknowns     []*Unknown
+	Inputs       []*Input
+	Outputs      []*Output
+}
+
+// Input holds what is known about an input of the unsigned transaction.
+type Input struct {
+	// NonWitnessUtxo is the transaction holding the output being spent.
+	NonWitnessUtxo *tx.Transaction
+	// WitnessUtxo is the output being spent, which is enough to sign a segwit input.
+	WitnessUtxo *tx.Output
+	PartialSigs []*PartialSig
+	// SigHashType is the hash type to sign with, 0 if not set.
+	SigHashType      uint32
+	RedeemScript     *script.Script
+	WitnessScript    *script.Script
+	Bip32Derivations []*Bip32Derivation
+	// FinalScriptSig and FinalScriptWitness are set by the finalizer.
+	FinalScriptSig     *script.Script
+	FinalScriptWitness [][]byte
+	// The preimages map hex hashes to the data that hashes to them.
+	Ripemd160Preimages map[string][]byte
+	Sha256Preimages    map[string][]byte
+	Hash160Preimages   map[string][]byte
+	Hash256Preimages   map[string][]byte
+	// RequiredTimeLocktime and RequiredHeightLocktime are the lock times
+	// an input of a version 2 PSBT needs, 0 if none.
+	RequiredTimeLocktime   uint32
+	RequiredHeightLocktime uint32
+	// TapKeySig is the signature of a taproot key path spend.
+	TapKeySig           []byte
+	TapScriptSigs       []*TapScriptSig
+	TapLeafScripts      []*TapLeafScript
+	TapBip32Derivations []*TapBip32Derivation
+	// TapInternalKey is the x-only internal key and TapMerkleRoot the script tree root of a taproot output.
+	TapInternalKey []byte
+	TapMerkleRoot  []byte
+	Unknowns       []*Unknown
+}
+
+// Output holds what is known about an output of the unsigned transaction.
+type Output struct {
+	RedeemScript     *script.Script
+	WitnessScript    *script.Script
+	Bip32Derivations []*Bip32Derivation
+	// TapInternalKey is the x-only internal key and TapTree the script tree of a taproot output.
+	TapInternalKey      []byte
+	TapTree             []*TapLeaf
+	TapBip32Derivations []*TapBip32Derivation
+	Unknowns            []*Unknown
+}
+
+// PartialSig is a signature with its hash type byte.
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// Bip32Derivation is the BIP32 origin of a key.
+type Bip32Derivation struct {
+	// Key is the SEC public key, or the serialized extended key for a global xpub.
+	Key         []byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// TapScriptSig is a signature for a leaf script of a taproot script path spend.
+type TapScriptSig struct {
+	XOnlyKey  []byte
+	LeafHash  []byte
+	Signature []byte
+}
+
+// TapLeafScript is a leaf script with the control block that proves it is in the script tree.
+type TapLeafScript struct {
+	ControlBlock []byte
+	Script       *script.Script
+	LeafVersion  byte
+}
+
+// TapBip32Derivation is the BIP32 origin of an x-only key, with the hashes of the leaf scripts using it.
+type TapBip32Derivation struct {
+	Bip32Derivation
+	LeafHashes [][]byte
+}
+
+// TapLeaf is a leaf of a taproot script tree.
+// A tree is described by its leaves in depth-first order.
+type TapLeaf struct {
+	Depth       int
+	LeafVersion byte
+	Script      *script.Script
+}
+
+// Unknown is an entry of a type this package doesn't handle, kept as is.
+type Unknown struct {
+	// Key is the key type followed by the key data.
+	Key   []byte
+	Value []byte
+}
+
+// New creates a PSBT for an unsigned transaction (the Creator role).
+// Returns an error if any input has a ScriptSig or witness.
+func New(unsignedTx *tx.Transaction) (*PSBT, error) {
+	if err := checkUnsigned(unsignedTx); err != nil {
+		return nil, err
+	}
+	result := &PSBT{UnsignedTx: unsignedTx}
+	for range unsignedTx.Inputs {
+		result.Inputs = append(result.Inputs, new(Input))
+	}
+	for range unsignedTx.Outputs {
+		result.Outputs = append(result.Outputs, new(Output))
+	}
+	return result, nil
+}
+
+func checkUnsigned(unsignedTx *tx.Transaction) error {
+	for i, txIn := range unsignedTx.Inputs {
+		if txIn.ScriptSig.Len() > 0 || len(txIn.Witness) > 0 {
+			return fmt.Errorf("input %d of the unsigned transaction is signed", i)
+		}
+	}
+	return nil
+}
+
+// Combine merges the data of other PSBTs for the same transaction (the Combiner role).
+func (p *PSBT) Combine(others ...*PSBT) error {
+	for _, other := range others {
+		if other.UnsignedTx.ID() != p.UnsignedTx.ID() {
+			return errors.New("cannot combine PSBTs for different transactions")
+		}
+		if other.Version != p.Version {
+			return fmt.Errorf("cannot combine PSBT versions %d and %d", p.Version, other.Version)
+		}
+		// inputs and outputs can only be added if every PSBT allows it
+		modifiable := p.TxModifiable & other.TxModifiable & (InputsModifiable | OutputsModifiable)
+		p.TxModifiable = modifiable | (p.TxModifiable|other.TxModifiable)&HasSigHashSingle
+		p.XPubs = mergeDerivations(p.XPubs, other.XPubs)
+		p.Unknowns = mergeUnknowns(p.Unknowns, other.Unknowns)
+		for i, in := range p.Inputs {
+			in.merge(other.Inputs[i])
+		}
+		for i, out := range p.Outputs {
+			out.merge(other.Outputs[i])
+		}
+	}
+	return nil
+}
+
+func (in *Input) merge(other *Input) {
+	if in.NonWitnessUtxo == nil {
+		in.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if in.WitnessUtxo == nil {
+		in.WitnessUtxo = other.WitnessUtxo
+	}
+	for _, sig := range other.PartialSigs {
+		in.addPartialSig(sig.PubKey, sig.Signature)
+	}
+	if in.SigHashType == 0 {
+		in.SigHashType = other.SigHashType
+	}
+	if in.RedeemScript == nil {
+		in.RedeemScript = other.RedeemScript
+	}
+	if in.WitnessScript == nil {
+		in.WitnessScript = other.WitnessScript
+	}
+	in.Bip32Derivations = mergeDerivations(in.Bip32Derivations, other.Bip32Derivations)
+	if in.FinalScriptSig == nil {
+		in.FinalScriptSig = other.FinalScriptSig
+	}
+	if in.FinalScriptWitness == nil {
+		in.FinalScriptWitness = other.FinalScriptWitness
+	}
+	in.Ripemd160Preimages = mergePreimages(in.Ripemd160Preimages, other.Ripemd160Preimages)
+	in.Sha256Preimages = mergePreimages(in.Sha256Preimages, other.Sha256Preimages)
+	in.Hash160Preimages = mergePreimages(in.Hash160Preimages, other.Hash160Preimages)
+	in.Hash256Preimages = mergePreimages(in.Hash256Preimages, other.Hash256Preimages)
+	if in.RequiredTimeLocktime == 0 {
+		in.RequiredTimeLocktime = other.RequiredTimeLocktime
+	}
+	if in.RequiredHeightLocktime == 0 {
+		in.RequiredHeightLocktime = other.RequiredHeightLocktime
+	}
+	if in.TapKeySig == nil {
+		in.TapKeySig = other.TapKeySig
+	}
+	for _, sig := range other.TapScriptSigs {
+		in.addTapScriptSig(sig.XOnlyKey, sig.LeafHash, sig.Signature)
+	}
+	for _, leaf := range other.TapLeafScripts {
+		found := false
+		for _, existing := range in.TapLeafScripts {
+			found = found || bytes.Equal(existing.ControlBlock, leaf.ControlBlock)
+		}
+		if !found {
+			in.TapLeafScripts = append(in.TapLeafScripts, leaf)
+		}
+	}
+	in.TapBip32Derivations = mergeTapDerivations(in.TapBip32Derivations, other.TapBip32Derivations)
+	if in.TapInternalKey == nil {
+		in.TapInternalKey = other.TapInternalKey
+	}
+	if in.TapMerkleRoot == nil {
+		in.TapMerkleRoot = other.TapMerkleRoot
+	}
+	in.Unknowns = mergeUnknowns(in.Unknowns, other.Unknowns)
+}
+
+func (out *Output) merge(other *Output) {
+	if out.RedeemScript == nil {
+		out.RedeemScript = other.RedeemScript
+	}
+	if out.WitnessScript == nil {
+		out.WitnessScript = other.WitnessScript
+	}
+	out.Bip32Derivations = mergeDerivations(out.Bip32Derivations, other.Bip32Derivations)
+	if out.TapInternalKey == nil {
+		out.TapInternalKey = other.TapInternalKey
+	}
+	if out.TapTree == nil {
+		out.TapTree = other.TapTree
+	}
+	out.TapBip32Derivations = mergeTapDerivations(out.TapBip32Derivations, other.TapBip32Derivations)
+	out.Unknowns = mergeUnknowns(out.Unknowns, other.Unknowns)
+}
+
+// addPartialSig adds a signature, replacing any signature from the same key.
+func (in *Input) addPartialSig(pubKey, sig []byte) {
+	for _, existing := range in.PartialSigs {
+		if bytes.Equal(existing.PubKey, pubKey) {
+			existing.Signature = sig
+			return
+		}
+	}
+	in.PartialSigs = append(in.PartialSigs, &PartialSig{PubKey: pubKey, Signature: sig})
+}
+
+// addTapScriptSig adds a leaf script signature, replacing any signature from the same key for the leaf.
+func (in *Input) addTapScriptSig(xOnlyKey, leafHash, sig []byte) {
+	for _, existing := range in.TapScriptSigs {
+		if bytes.Equal(existing.XOnlyKey, xOnlyKey) && bytes.Equal(existing.LeafHash, leafHash) {
+			existing.Signature = sig
+			return
+		}
+	}
+	in.TapScriptSigs = append(in.TapScriptSigs, &TapScriptSig{XOnlyKey: xOnlyKey, LeafHash: leafHash, Signature: sig})
+}
+
+func mergeDerivations(derivations, others []*Bip32Derivation) []*Bip32Derivation {
+	for _, other := range others {
+		found := false
+		for _, d := range derivations {
+			found = found || bytes.Equal(d.Key, other.Key)
+		}
+		if !found {
+			derivations = append(derivations, other)
+		}
+	}
+	return derivations
+}
+
+func mergeTapDerivations(derivations, others []*TapBip32Derivation) []*TapBip32Derivation {
+	for _, other := range others {
+		found := false
+		for _, d := range derivations {
+			found = found || bytes.Equal(d.Key, other.Key)
+		}
+		if !found {
+			derivations = append(derivations, other)
+		}
+	}
+	return derivations
+}
+
+func mergeUnknowns(unknowns, others []*Unknown) []*Unknown {
+	for _, other := range others {
+		found := false
+		for _, u := range unknowns {
+			found = found || bytes.Equal(u.Key, other.Key)
+		}
+		if !found {
+			unknowns = append(unknowns, other)
+		}
+	}
+	return unknowns
+}
+
+func mergePreimages(preimages, others map[string][]byte) map[string][]byte {
+	for hash, preimage := range others {
+		if preimages == nil {
+			preimages = make(map[string][]byte)
+		}
+		preimages[hash] = preimage
+	}
+	return preimages
+}
+
+// utxo returns the output spent by an input.
+func (p *PSBT) utxo(inputIndex int) (*tx.Output, error) {
+	in := p.Inputs[inputIndex]
+	txIn := p.UnsignedTx.Inputs[inputIndex]
+	if in.NonWitnessUtxo != nil {
+		if in.NonWitnessUtxo.ID() != hex.EncodeToString(txIn.PrevTx) {
+			return nil, fmt.Errorf("input %d: utxo transaction %s does not match", inputIndex, in.NonWitnessUtxo.ID())
+		}
+		if txIn.PrevIndex >= len(in.NonWitnessUtxo.Outputs) {
+			return nil, fmt.Errorf("input %d: utxo transaction has no output %d", inputIndex, txIn.PrevIndex)
+		}
+		return in.NonWitnessUtxo.Outputs[txIn.PrevIndex], nil
+	}
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo, nil
+	}
+	return nil, fmt.Errorf("input %d has no utxo", inputIndex)
+}
+
+// Fee returns the fee of the transaction, which needs the utxos of every input.
+func (p *PSBT) Fee() (uint64, error) {
+	for i := range p.Inputs {
+		if _, err := p.utxo(i); err != nil {
+			return 0, err
+		}
+	}
+	return p.UnsignedTx.Fee(p.prevouts())
+}
+
+// prevouts returns a provider of the outputs spent by the inputs whose utxo is known,
+// for the transaction's signature hashes.
+func (p *PSBT) prevouts() *tx.MemoryProvider {
+	result := tx.NewMemoryProvider()
+	for i, txIn := range p.UnsignedTx.Inputs {
+		if utxo, err := p.utxo(i); err == nil {
+			result.AddOutput(txIn.PrevTx, txIn.PrevIndex, utxo)
+		}
+	}
+	return result
+}
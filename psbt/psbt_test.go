@@ -0,0 +1,643 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/descriptor"
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/hd"
+	"github.com/ravdin/programmingbitcoin/miniscript"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+var testKeys = []*ecc.PrivateKey{
+	ecc.NewPrivateKey(big.NewInt(4001)),
+	ecc.NewPrivateKey(big.NewInt(4002)),
+	ecc.NewPrivateKey(big.NewInt(4003)),
+}
+
+// fund returns a made up transaction paying to the ScriptPubKeys.
+func fund(seed byte, scriptPubKeys ...*script.Script) *tx.Transaction {
+	txIn := tx.NewInput(bytes.Repeat([]byte{seed}, 32), 0, nil, tx.SequenceFinal)
+	var outputs []*tx.Output
+	for _, scriptPubKey := range scriptPubKeys {
+		outputs = append(outputs, tx.NewOutput(100000, scriptPubKey))
+	}
+	return tx.NewTransaction(1, []*tx.Input{txIn}, outputs, 0, true)
+}
+
+func TestParse(t *testing.T) {
+	t.Run("Test BIP174 example", func(t *testing.T) {
+		// one p2pkh input with its utxo transaction
+		b64 := "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+		p, err := ParseBase64(b64)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := "af2cac1e0e33d896d9d0751d66fcb2fa54b737c7a13199281fb57e4f497bb652"
+		if p.UnsignedTx.ID() != expected {
+			t.Errorf("Expected %s, got %s", expected, p.UnsignedTx.ID())
+		}
+		if len(p.Inputs) != 1 || len(p.Outputs) != 2 || p.Inputs[0].NonWitnessUtxo == nil {
+			t.Errorf("Unexpected maps: %d inputs, %d outputs", len(p.Inputs), len(p.Outputs))
+		}
+		if p.Base64() != b64 {
+			t.Errorf("Expected %s, got %s", b64, p.Base64())
+		}
+	})
+
+	t.Run("Test round trip", func(t *testing.T) {
+		prevTx := fund(1, script.P2pkhScript(testKeys[0].Point.Hash160(true)))
+		txIn := tx.NewInput(prevTx.Hash(), 0, nil, tx.SequenceFinal)
+		txOut := tx.NewOutput(90000, script.P2wpkhScript(testKeys[1].Point.Hash160(true)))
+		p, err := New(tx.NewTransaction(2, []*tx.Input{txIn}, []*tx.Output{txOut}, 0, true))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := p.SetUtxo(0, prevTx); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		preimage := []byte("psbt preimage")
+		in := p.Inputs[0]
+		in.SigHashType = util.SigHashSingle
+		in.Sha256Preimages = map[string][]byte{hex.EncodeToString(util.Sha256(preimage)): preimage}
+		in.Unknowns = []*Unknown{{Key: []byte{0xfc, 1, 2}, Value: []byte{3}}}
+		p.XPubs = []*Bip32Derivation{{Key: make([]byte, 78), Fingerprint: [4]byte{1, 2, 3, 4}, Path: []uint32{hd.HardenedKeyStart}}}
+		p.AddOutputDerivation(0, &Bip32Derivation{Key: testKeys[1].Point.Sec(true), Path: []uint32{0, 5}})
+		if err := p.Sign(0, testKeys[0]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		parsed, err := Parse(p.Serialize())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(parsed.Serialize(), p.Serialize()) {
+			t.Errorf("Expected %x, got %x", p.Serialize(), parsed.Serialize())
+		}
+		if parsed.Inputs[0].SigHashType != util.SigHashSingle || len(parsed.Inputs[0].PartialSigs) != 1 {
+			t.Errorf("Input fields were not parsed")
+		}
+		if len(parsed.XPubs) != 1 || parsed.XPubs[0].Path[0] != hd.HardenedKeyStart {
+			t.Errorf("Global xpub was not parsed")
+		}
+	})
+
+	t.Run("Test invalid", func(t *testing.T) {
+		prevTx := fund(2, script.P2wpkhScript(testKeys[0].Point.Hash160(true)))
+		txIn := tx.NewInput(prevTx.Hash(), 0, nil, tx.SequenceFinal)
+		txOut := tx.NewOutput(90000, script.P2wpkhScript(testKeys[1].Point.Hash160(true)))
+		unsignedTx := tx.NewTransaction(2, []*tx.Input{txIn}, []*tx.Output{txOut}, 0, true)
+		p, _ := New(unsignedTx)
+		valid := p.Serialize()
+		unsignedEntry := serializeEntry(globalUnsignedTx, nil, unsignedTx.SerializeLegacy())
+		wrongUtxo := serializeEntry(inNonWitnessUtxo, nil, fund(3).Serialize())
+		tests := map[string][]byte{
+			"magic":         valid[1:],
+			"truncated":     valid[:len(valid)-1],
+			"trailing data": append(append([]byte{}, valid...), 0),
+			"duplicate key": append(append(append([]byte{}, magic...), unsignedEntry...), unsignedEntry...),
+			"missing tx":    append(append([]byte{}, magic...), 0, 0, 0),
+			"version":       bytes.Replace(valid, unsignedEntry, append(unsignedEntry, serializeEntry(globalVersion, nil, []byte{9, 0, 0, 0})...), 1),
+			"utxo":          append(bytes.TrimSuffix(valid, []byte{0, 0}), append(wrongUtxo, 0, 0)...),
+		}
+		for name, data := range tests {
+			if _, err := Parse(data); err == nil {
+				t.Errorf("Expected an error for %s", name)
+			}
+		}
+		if _, err := ParseBase64("not base64"); err == nil {
+			t.Errorf("Expected an error for invalid base64")
+		}
+		txIn.ScriptSig = new(script.Script).AppendData([]byte{1, 2})
+		if _, err := New(unsignedTx); err == nil {
+			t.Errorf("Expected an error for a signed transaction")
+		}
+	})
+}
+
+func TestRoles(t *testing.T) {
+	a, b, c := testKeys[0], testKeys[1], testKeys[2]
+	secA, secB, secC := a.Point.Sec(true), b.Point.Sec(true), c.Point.Sec(true)
+	multisigAB, _ := script.MultisigScript(2, []*ecc.S256Point{a.Point, b.Point})
+	multisigBC, _ := script.MultisigScript(2, []*ecc.S256Point{b.Point, c.Point})
+	node, err := miniscript.Parse(fmt.Sprintf("or_d(pk(%x),and_v(v:pkh(%x),older(10)))", secA, secB))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	timelocked := node.Script()
+	p2wpkhC := script.P2wpkhScript(util.Hash160(secC))
+	p2wshTimelocked := script.P2wshScript(util.Sha256(timelocked.RawSerialize()))
+	// the legacy outputs are spent with their whole transaction, the segwit ones with just the output
+	legacyTx := fund(4,
+		script.P2pkhScript(util.Hash160(secA)),
+		script.P2shScript(util.Hash160(multisigAB.RawSerialize())))
+	segwitTx := fund(5,
+		script.P2wpkhScript(util.Hash160(secB)),
+		script.P2shScript(util.Hash160(p2wpkhC.RawSerialize())),
+		script.P2wshScript(util.Sha256(multisigBC.RawSerialize())),
+		script.P2shScript(util.Hash160(p2wshTimelocked.RawSerialize())))
+	var inputs []*tx.Input
+	for i := range legacyTx.Outputs {
+		inputs = append(inputs, tx.NewInput(legacyTx.Hash(), i, nil, tx.SequenceFinal))
+	}
+	for i := range segwitTx.Outputs {
+		inputs = append(inputs, tx.NewInput(segwitTx.Hash(), i, nil, tx.SequenceFinal))
+	}
+	inputs[5].Sequence = 10
+	outputs := []*tx.Output{tx.NewOutput(550000, script.P2wpkhScript(util.Hash160(secC)))}
+	p, err := New(tx.NewTransaction(2, inputs, outputs, 0, true))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	scripts := []struct {
+		redeemScript  *script.Script
+		witnessScript *script.Script
+	}{
+		{nil, nil},
+		{multisigAB, nil},
+		{nil, nil},
+		{p2wpkhC, nil},
+		{nil, multisigBC},
+		{p2wshTimelocked, timelocked},
+	}
+	for i := range inputs {
+		if i < len(legacyTx.Outputs) {
+			err = p.SetUtxo(i, legacyTx)
+		} else {
+			p.SetWitnessUtxo(i, segwitTx.Outputs[i-len(legacyTx.Outputs)])
+		}
+		if err == nil {
+			err = p.SetInputScripts(i, scripts[i].redeemScript, scripts[i].witnessScript)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	t.Run("Test sign, combine, finalize and extract", func(t *testing.T) {
+		b64 := p.Base64()
+		// each signer works on its own copy
+		signers := []struct {
+			pk     *ecc.PrivateKey
+			inputs []int
+		}{
+			{a, []int{0, 1}},
+			{b, []int{1, 2, 4, 5}},
+			{c, []int{3, 4}},
+		}
+		var signed []*PSBT
+		for _, signer := range signers {
+			copied, err := ParseBase64(b64)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, i := range signer.inputs {
+				if err := copied.Sign(i, signer.pk); err != nil {
+					t.Fatalf("Unexpected error signing input %d: %v", i, err)
+				}
+			}
+			signed = append(signed, copied)
+		}
+		if err := signed[0].FinalizeInput(1); err == nil {
+			t.Errorf("Expected an error finalizing a multisig input with one signature")
+		}
+		combined, _ := ParseBase64(b64)
+		if err := combined.Combine(signed...); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(combined.Inputs[1].PartialSigs) != 2 || len(combined.Inputs[4].PartialSigs) != 2 {
+			t.Errorf("Expected two signatures for the multisig inputs")
+		}
+		if _, err := combined.Extract(); err == nil {
+			t.Errorf("Expected an error extracting before finalizing")
+		}
+		if err := combined.Finalize(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if in := combined.Inputs[4]; in.PartialSigs != nil || in.WitnessScript != nil || len(in.FinalScriptWitness) != 4 {
+			t.Errorf("Unexpected finalized input: %v", in.FinalScriptWitness)
+		}
+		final, err := ParseBase64(combined.Base64())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result, err := final.Extract()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok, err := result.Verify(tx.NewMemoryProvider(legacyTx, segwitTx)); err != nil || !ok {
+			t.Errorf("Verify failed!")
+		}
+		if result.Inputs[3].ScriptSig.Len() != 1 || len(result.Inputs[3].Witness) != 2 {
+			t.Errorf("Unexpected p2sh-p2wpkh input: %s", result.Inputs[3].ScriptSig)
+		}
+	})
+
+	t.Run("Test sign errors", func(t *testing.T) {
+		copied, _ := ParseBase64(p.Base64())
+		if err := copied.Sign(0, b); err == nil {
+			t.Errorf("Expected an error for a key the input doesn't use")
+		}
+		copied.Inputs[0].NonWitnessUtxo = nil
+		copied.Inputs[0].WitnessUtxo = legacyTx.Outputs[0]
+		if err := copied.Sign(0, a); err == nil {
+			t.Errorf("Expected an error for a legacy input without its utxo transaction")
+		}
+		copied.Inputs[1].RedeemScript = multisigBC
+		if err := copied.Sign(1, b); err == nil {
+			t.Errorf("Expected an error for the wrong redeem script")
+		}
+		if err := copied.SetInputScripts(4, nil, multisigAB); err == nil {
+			t.Errorf("Expected an error for the wrong witness script")
+		}
+		if err := copied.SetUtxo(2, legacyTx); err == nil {
+			t.Errorf("Expected an error for the wrong utxo transaction")
+		}
+		other, _ := New(tx.NewTransaction(2, inputs[:1], outputs, 0, true))
+		if err := copied.Combine(other); err == nil {
+			t.Errorf("Expected an error combining PSBTs for different transactions")
+		}
+	})
+}
+
+func TestDescriptorUpdate(t *testing.T) {
+	master, err := hd.NewMaster(bytes.Repeat([]byte{7}, 32), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	accountPath := []uint32{hd.HardenedKeyStart + 84, hd.HardenedKeyStart + 1, hd.HardenedKeyStart}
+	account, err := master.Derive(accountPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fingerprint := master.Fingerprint()
+	origin := fmt.Sprintf("[%x/84h/1h/0h]%s", fingerprint, account.Neuter().String())
+	receive, err := descriptor.Parse(fmt.Sprintf("wpkh(%s/0/*)", origin))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	change, err := descriptor.Parse(fmt.Sprintf("sh(wpkh(%s/1/*))", origin))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	receiveOut, _ := receive.Expand(3)
+	changeOut, _ := change.Expand(0)
+	prevTx := fund(6, receiveOut.ScriptPubKey)
+	txIn := tx.NewInput(prevTx.Hash(), 0, nil, tx.SequenceFinal)
+	outputs := []*tx.Output{
+		tx.NewOutput(40000, script.P2wpkhScript(testKeys[0].Point.Hash160(true))),
+		tx.NewOutput(50000, changeOut.ScriptPubKey),
+	}
+	p, _ := New(tx.NewTransaction(2, []*tx.Input{txIn}, outputs, 0, true))
+	if err := p.UpdateInputFromDescriptor(0, receiveOut); err == nil {
+		t.Errorf("Expected an error without a utxo")
+	}
+	p.SetWitnessUtxo(0, prevTx.Outputs[0])
+	if err := p.UpdateInputFromDescriptor(0, changeOut); err == nil {
+		t.Errorf("Expected an error for the wrong descriptor")
+	}
+	if err := p.UpdateInputFromDescriptor(0, receiveOut); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.UpdateOutputFromDescriptor(1, changeOut); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	derivations := p.Inputs[0].Bip32Derivations
+	if len(derivations) != 1 || derivations[0].Fingerprint != fingerprint || hd.FormatPath(derivations[0].Path) != "m/84'/1'/0'/0/3" {
+		t.Fatalf("Unexpected derivations: %v", derivations)
+	}
+	if p.Outputs[1].RedeemScript == nil || len(p.Outputs[1].Bip32Derivations) != 1 {
+		t.Errorf("Expected the change output's redeem script and derivation")
+	}
+	// the air-gapped signer finds its key from the derivation
+	signer, _ := ParseBase64(p.Base64())
+	key, err := master.Derive(signer.Inputs[0].Bip32Derivations[0].Path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := signer.Sign(0, key.PrivateKey); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := signer.Finalize(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := signer.Extract(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(signer.Base64())
+	if !bytes.Equal(raw, signer.Serialize()) {
+		t.Errorf("Base64 does not match the binary serialization")
+	}
+}
+
+func TestV2(t *testing.T) {
+	a, b := testKeys[0], testKeys[1]
+	prevTx := fund(7,
+		script.P2wpkhScript(a.Point.Hash160(true)),
+		script.P2wpkhScript(b.Point.Hash160(true)),
+		script.P2wpkhScript(a.Point.Hash160(true)))
+	outpoint := func(i int) *tx.Input {
+		return tx.NewInput(prevTx.Hash(), i, nil, 0xfffffffd)
+	}
+	newV2 := func() *PSBT {
+		p, err := NewV2(2, 100)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := p.AddInput(outpoint(0), &Input{RequiredHeightLocktime: 800000}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := p.AddInput(outpoint(1), &Input{RequiredHeightLocktime: 700000, RequiredTimeLocktime: 1600000000}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := p.AddOutput(tx.NewOutput(150000, script.P2wpkhScript(b.Point.Hash160(true))), nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i := range p.Inputs {
+			p.SetWitnessUtxo(i, prevTx.Outputs[i])
+		}
+		return p
+	}
+
+	t.Run("Test constructor", func(t *testing.T) {
+		if _, err := NewV2(1, 0); err == nil {
+			t.Errorf("Expected an error for transaction version 1")
+		}
+		p := newV2()
+		if p.UnsignedTx.Locktime != 800000 {
+			t.Errorf("Expected lock time 800000, got %d", p.UnsignedTx.Locktime)
+		}
+		if err := p.AddInput(outpoint(2), &Input{RequiredTimeLocktime: 1600000000}); err == nil {
+			t.Errorf("Expected an error for an input that only allows a time lock time")
+		}
+		if err := p.AddInput(outpoint(0), nil); err == nil {
+			t.Errorf("Expected an error for an outpoint that is already spent")
+		}
+		if err := p.AddInput(outpoint(2), &Input{RequiredHeightLocktime: 1600000000}); err == nil {
+			t.Errorf("Expected an error for a height that is a time")
+		}
+		if len(p.Inputs) != 2 || len(p.UnsignedTx.Inputs) != 2 || p.UnsignedTx.Locktime != 800000 {
+			t.Errorf("Rejected inputs were added")
+		}
+		empty, _ := NewV2(2, 100)
+		if locktime, _ := empty.locktime(); locktime != 100 {
+			t.Errorf("Expected the fallback lock time 100, got %d", locktime)
+		}
+	})
+
+	t.Run("Test round trip", func(t *testing.T) {
+		p := newV2()
+		parsed, err := Parse(p.Serialize())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(parsed.Serialize(), p.Serialize()) {
+			t.Errorf("Expected %x, got %x", p.Serialize(), parsed.Serialize())
+		}
+		if parsed.UnsignedTx.ID() != p.UnsignedTx.ID() || parsed.FallbackLocktime != 100 || parsed.TxModifiable != InputsModifiable|OutputsModifiable {
+			t.Errorf("Global fields were not parsed")
+		}
+		if in := parsed.Inputs[1]; in.RequiredHeightLocktime != 700000 || in.RequiredTimeLocktime != 1600000000 {
+			t.Errorf("Required lock times were not parsed")
+		}
+	})
+
+	t.Run("Test conversion", func(t *testing.T) {
+		p := newV2()
+		id := p.UnsignedTx.ID()
+		if err := p.ConvertTo(0); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		v0, err := Parse(p.Serialize())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if v0.Version != 0 || v0.UnsignedTx.ID() != id || v0.Inputs[0].RequiredHeightLocktime != 0 {
+			t.Errorf("Unexpected version 0 PSBT")
+		}
+		if err := v0.AddInput(outpoint(2), nil); err == nil {
+			t.Errorf("Expected an error adding an input to a version 0 PSBT")
+		}
+		if err := v0.ConvertTo(2); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		v2, err := Parse(v0.Serialize())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if v2.Version != 2 || v2.UnsignedTx.ID() != id || v2.FallbackLocktime != 800000 {
+			t.Errorf("Unexpected version 2 PSBT")
+		}
+		legacy, _ := New(fund(8))
+		if err := legacy.ConvertTo(2); err == nil {
+			t.Errorf("Expected an error converting transaction version 1")
+		}
+	})
+
+	t.Run("Test sign and extract", func(t *testing.T) {
+		p := newV2()
+		p.Inputs[0].SigHashType = util.SigHashSingle | util.SigHashAnyoneCanPay
+		if err := p.Sign(0, a); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if p.TxModifiable != InputsModifiable|OutputsModifiable|HasSigHashSingle {
+			t.Errorf("Unexpected flags %d", p.TxModifiable)
+		}
+		if err := p.Sign(1, b); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if p.TxModifiable != HasSigHashSingle {
+			t.Errorf("Unexpected flags %d", p.TxModifiable)
+		}
+		if err := p.AddInput(outpoint(2), nil); err == nil {
+			t.Errorf("Expected an error adding an input after SIGHASH_ALL")
+		}
+		if err := p.AddOutput(tx.NewOutput(1000, script.P2wpkhScript(a.Point.Hash160(true))), nil); err == nil {
+			t.Errorf("Expected an error adding an output after SIGHASH_ALL")
+		}
+		if err := p.Finalize(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result, err := p.Extract()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok, err := result.Verify(tx.NewMemoryProvider(prevTx)); err != nil || !ok || result.Locktime != 800000 {
+			t.Errorf("Unexpected transaction %s", result.ID())
+		}
+	})
+
+	t.Run("Test invalid", func(t *testing.T) {
+		v2 := newV2().Serialize()
+		v0Input, _ := New(fund(8, script.P2wpkhScript(a.Point.Hash160(true))))
+		v0 := v0Input.Serialize()
+		inputCount := serializeEntry(globalInputCount, nil, []byte{2})
+		unsignedEntry := serializeEntry(globalUnsignedTx, nil, v0Input.UnsignedTx.SerializeLegacy())
+		tests := map[string][]byte{
+			"unsigned tx in version 2": bytes.Replace(v2, inputCount, append(unsignedEntry, inputCount...), 1),
+			"missing input count":      bytes.Replace(v2, inputCount, nil, 1),
+			"input count in version 0": bytes.Replace(v0, unsignedEntry, append(unsignedEntry, inputCount...), 1),
+			"sequence in version 0":    append(bytes.TrimSuffix(v0, []byte{0, 0}), append(serializeEntry(inSequence, nil, []byte{0, 0, 0, 0}), 0, 0)...),
+			"transaction version 1":    bytes.Replace(v2, serializeEntry(globalTxVersion, nil, []byte{2, 0, 0, 0}), serializeEntry(globalTxVersion, nil, []byte{1, 0, 0, 0}), 1),
+			"conflicting lock times":   bytes.Replace(v2, serializeEntry(inRequiredHeight, nil, util.Int32ToLittleEndian(700000)), nil, 1),
+		}
+		for name, data := range tests {
+			if _, err := Parse(data); err == nil {
+				t.Errorf("Expected an error for %s", name)
+			}
+		}
+	})
+}
+
+func TestTaproot(t *testing.T) {
+	a, b, c := testKeys[0], testKeys[1], testKeys[2]
+	master, err := hd.NewMaster(bytes.Repeat([]byte{8}, 32), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	account, err := master.Derive([]uint32{hd.HardenedKeyStart + 86, hd.HardenedKeyStart + 1, hd.HardenedKeyStart})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	desc, err := descriptor.Parse(fmt.Sprintf("tr([%x/86h/1h/0h]%s/0/*,pk(%x))", master.Fingerprint(), account.Neuter().String(), b.Point.Sec(true)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyPathOut, _ := desc.Expand(0)
+	scriptPathOut, _ := desc.Expand(1)
+	// a 2-of-2 of A and C next to a leaf for B, with B as the internal key
+	multiA := new(script.Script).AppendData(a.Point.XOnly()).AppendOp(script.OpCheckSig).
+		AppendData(c.Point.XOnly()).AppendOp(script.OpCheckSigAdd).AppendOp(script.Op2).AppendOp(script.OpNumEqual)
+	pkB := new(script.Script).AppendData(b.Point.XOnly()).AppendOp(script.OpCheckSig)
+	leaves := []*TapLeaf{
+		{Depth: 1, LeafVersion: script.TapscriptLeafVersion, Script: pkB},
+		{Depth: 1, LeafVersion: script.TapscriptLeafVersion, Script: multiA},
+	}
+	leafScripts, merkleRoot, err := tapLeafScripts(b.Point, leaves)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	outputKey, _ := script.TaprootOutputKey(b.Point, merkleRoot)
+	prevTx := fund(9, keyPathOut.ScriptPubKey, scriptPathOut.ScriptPubKey, script.P2trScript(outputKey.XOnly()))
+	var inputs []*tx.Input
+	for i := range prevTx.Outputs {
+		inputs = append(inputs, tx.NewInput(prevTx.Hash(), i, nil, tx.SequenceFinal))
+	}
+	change, _ := desc.Expand(2)
+	outputs := []*tx.Output{tx.NewOutput(290000, change.ScriptPubKey)}
+	p, err := New(tx.NewTransaction(2, inputs, outputs, 0, true))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := range inputs {
+		p.SetWitnessUtxo(i, prevTx.Outputs[i])
+	}
+	for i, out := range []*descriptor.Output{keyPathOut, scriptPathOut} {
+		if err := p.UpdateInputFromDescriptor(i, out); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	p.Inputs[2].TapInternalKey = b.Point.XOnly()
+	p.Inputs[2].TapMerkleRoot = merkleRoot
+	p.Inputs[2].TapLeafScripts = leafScripts
+	if err := p.UpdateOutputFromDescriptor(0, change); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("Test descriptor update", func(t *testing.T) {
+		in := p.Inputs[0]
+		// the internal key, and B with the hash of its leaf
+		if len(in.TapLeafScripts) != 1 || in.TapMerkleRoot == nil || len(in.TapBip32Derivations) != 2 {
+			t.Fatalf("Unexpected taproot input fields")
+		}
+		if leafHashes := in.TapBip32Derivations[1].LeafHashes; len(leafHashes) != 1 {
+			t.Errorf("Expected 1 leaf hash for B, got %d", len(leafHashes))
+		}
+		if path := hd.FormatPath(in.TapBip32Derivations[0].Path); path != "m/86'/1'/0'/0/0" {
+			t.Errorf("Expected m/86'/1'/0'/0/0, got %s", path)
+		}
+		if out := p.Outputs[0]; len(out.TapTree) != 1 || out.TapInternalKey == nil {
+			t.Errorf("Unexpected taproot output fields")
+		}
+		parsed, err := Parse(p.Serialize())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(parsed.Serialize(), p.Serialize()) {
+			t.Errorf("Expected %x, got %x", p.Serialize(), parsed.Serialize())
+		}
+	})
+
+	t.Run("Test sign, combine, finalize and extract", func(t *testing.T) {
+		b64 := p.Base64()
+		keyPathKey, err := master.Derive(p.Inputs[0].TapBip32Derivations[0].Path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		signers := []struct {
+			pk     *ecc.PrivateKey
+			inputs []int
+		}{
+			{keyPathKey.PrivateKey, []int{0}},
+			{a, []int{2}},
+			{b, []int{1}},
+			{c, []int{2}},
+		}
+		var signed []*PSBT
+		for _, signer := range signers {
+			copied, _ := ParseBase64(b64)
+			for _, i := range signer.inputs {
+				if err := copied.Sign(i, signer.pk); err != nil {
+					t.Fatalf("Unexpected error signing input %d: %v", i, err)
+				}
+			}
+			signed = append(signed, copied)
+		}
+		if signed[0].Inputs[0].TapKeySig == nil || len(signed[2].Inputs[1].TapScriptSigs) != 1 {
+			t.Errorf("Expected a key path and a script path signature")
+		}
+		if err := signed[1].FinalizeInput(2); err == nil {
+			t.Errorf("Expected an error finalizing a 2-of-2 with one signature")
+		}
+		combined, _ := ParseBase64(b64)
+		if err := combined.Combine(signed...); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := combined.Finalize(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// the key path, B's leaf, and the 2-of-2 leaf
+		for i, size := range []int{1, 3, 4} {
+			if witness := combined.Inputs[i].FinalScriptWitness; len(witness) != size {
+				t.Errorf("Expected %d witness items for input %d, got %d", size, i, len(witness))
+			}
+		}
+		final, _ := ParseBase64(combined.Base64())
+		result, err := final.Extract()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok, err := result.Verify(tx.NewMemoryProvider(prevTx)); err != nil || !ok {
+			t.Errorf("Verify failed!")
+		}
+	})
+
+	t.Run("Test sign errors", func(t *testing.T) {
+		copied, _ := ParseBase64(p.Base64())
+		if err := copied.Sign(0, c); err == nil {
+			t.Errorf("Expected an error for a key the input doesn't use")
+		}
+		copied.Inputs[0].TapMerkleRoot = nil
+		keyPathKey, _ := master.Derive(copied.Inputs[0].TapBip32Derivations[0].Path)
+		if err := copied.Sign(0, keyPathKey.PrivateKey); err == nil {
+			t.Errorf("Expected an error for the wrong merkle root")
+		}
+	})
+}
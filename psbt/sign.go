@@ -0,0 +1,110 @@
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Sign adds a signature from pk to an input (the Signer role).
+// The input's utxo and, for p2sh and p2wsh outputs, its scripts need to be attached.
+// Taproot inputs are signed for a key path spend if pk is the internal key and for the
+// leaf scripts that use it; they need the utxos of every input.
+// Legacy inputs need the whole previous transaction, so its amount can't be misrepresented.
+// Returns an error if the input is finalized or pk can't sign it.
+func (p *PSBT) Sign(inputIndex int, pk *ecc.PrivateKey) error {
+	in := p.Inputs[inputIndex]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		return fmt.Errorf("input %d is finalized", inputIndex)
+	}
+	utxo, err := p.utxo(inputIndex)
+	if err != nil {
+		return err
+	}
+	if err := checkScripts(utxo.ScriptPubKey, in.RedeemScript, in.WitnessScript); err != nil {
+		return fmt.Errorf("input %d: %v", inputIndex, err)
+	}
+	if err := p.syncLocktime(); err != nil {
+		return err
+	}
+	if version, outputKey, ok := utxo.ScriptPubKey.WitnessProgram(); ok && version == 1 && len(outputKey) == 32 {
+		if err := p.signTaproot(inputIndex, pk, outputKey); err != nil {
+			return err
+		}
+		p.updateModifiable(in.SigHashType)
+		return nil
+	}
+	hashType := in.SigHashType
+	if hashType == 0 {
+		hashType = util.SigHashAll
+	}
+	program := utxo.ScriptPubKey
+	if program.IsP2shScriptPubKey() {
+		if in.RedeemScript == nil {
+			return fmt.Errorf("input %d has no redeem script", inputIndex)
+		}
+		program = in.RedeemScript
+	}
+	unsignedTx := p.UnsignedTx
+	var sec, z []byte
+	version, witnessProgram, segwit := program.WitnessProgram()
+	switch {
+	case segwit && version == 0 && len(witnessProgram) == 20:
+		sec = keyForHash(pk, witnessProgram)
+		z, err = unsignedTx.SigHashBip143(p.prevouts(), inputIndex, script.P2pkhScript(witnessProgram), hashType)
+	case segwit && version == 0 && len(witnessProgram) == 32:
+		if in.WitnessScript == nil {
+			return fmt.Errorf("input %d has no witness script", inputIndex)
+		}
+		sec = keyInScript(pk, in.WitnessScript)
+		z, err = unsignedTx.SigHashBip143(p.prevouts(), inputIndex, in.WitnessScript, hashType)
+	case segwit:
+		return fmt.Errorf("input %d: unsupported witness version %d", inputIndex, version)
+	case in.NonWitnessUtxo == nil:
+		return fmt.Errorf("input %d spends a legacy output and has no utxo transaction", inputIndex)
+	case program.IsP2pkhScriptPubKey():
+		sec = keyForHash(pk, program.Peek(2))
+		z = unsignedTx.SigHash(inputIndex, program, hashType)
+	default:
+		sec = keyInScript(pk, program)
+		z = unsignedTx.SigHash(inputIndex, program, hashType)
+	}
+	if err != nil {
+		return err
+	}
+	if sec == nil {
+		return fmt.Errorf("input %d does not use the key", inputIndex)
+	}
+	der := pk.Sign(new(big.Int).SetBytes(z)).Der()
+	in.addPartialSig(sec, append(der, byte(hashType)))
+	p.updateModifiable(hashType)
+	return nil
+}
+
+// keyForHash returns the SEC key of pk whose hash160 is h160, nil if neither matches.
+func keyForHash(pk *ecc.PrivateKey, h160 []byte) []byte {
+	for _, compressed := range []bool{true, false} {
+		if bytes.Equal(pk.Point.Hash160(compressed), h160) {
+			return pk.Point.Sec(compressed)
+		}
+	}
+	return nil
+}
+
+// keyInScript returns the SEC key of pk that the script pushes, or whose hash160 it pushes.
+// Returns nil if the script uses neither.
+func keyInScript(pk *ecc.PrivateKey, scr *script.Script) []byte {
+	for _, compressed := range []bool{true, false} {
+		sec := pk.Point.Sec(compressed)
+		for _, cmd := range scr.Commands() {
+			if cmd.IsData() && (bytes.Equal(cmd.Data, sec) || bytes.Equal(cmd.Data, util.Hash160(sec))) {
+				return sec
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+)
+
+// maxTapTreeDepth is the deepest a leaf can be in a taproot script tree (BIP341).
+const maxTapTreeDepth = 128
+
+// tapNode is a subtree while a script tree is put together from its leaves.
+type tapNode struct {
+	depth  int
+	hash   []byte
+	leaves []int
+}
+
+// tapTree returns the merkle root of a script tree given its leaves in depth-first order,
+// and the merkle path of each leaf from the leaf up.
+func tapTree(leaves []*TapLeaf) ([]byte, [][][]byte, error) {
+	var stack []*tapNode
+	paths := make([][][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if leaf.Depth < 0 || leaf.Depth > maxTapTreeDepth {
+			return nil, nil, fmt.Errorf("invalid leaf depth %d", leaf.Depth)
+		}
+		node := &tapNode{depth: leaf.Depth, hash: script.TapLeafHash(leaf.LeafVersion, leaf.Script), leaves: []int{i}}
+		// combine siblings until the node is a left child
+		for len(stack) > 0 && stack[len(stack)-1].depth == node.depth {
+			left := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, j := range left.leaves {
+				paths[j] = append(paths[j], node.hash)
+			}
+			for _, j := range node.leaves {
+				paths[j] = append(paths[j], left.hash)
+			}
+			combined := make([]int, 0, len(left.leaves)+len(node.leaves))
+			combined = append(append(combined, left.leaves...), node.leaves...)
+			node = &tapNode{depth: node.depth - 1, hash: script.TapBranchHash(left.hash, node.hash), leaves: combined}
+		}
+		stack = append(stack, node)
+	}
+	if len(stack) != 1 || stack[0].depth != 0 {
+		return nil, nil, errors.New("the leaves don't make a complete script tree")
+	}
+	return stack[0].hash, paths, nil
+}
+
+// tapLeafScripts returns the leaf scripts of a script tree with their control blocks,
+// and the tree's merkle root.
+func tapLeafScripts(internalKey *ecc.S256Point, leaves []*TapLeaf) ([]*TapLeafScript, []byte, error) {
+	merkleRoot, paths, err := tapTree(leaves)
+	if err != nil {
+		return nil, nil, err
+	}
+	outputKey, err := script.TaprootOutputKey(internalKey, merkleRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	var parity byte
+	if !outputKey.HasEvenY() {
+		parity = 1
+	}
+	var result []*TapLeafScript
+	for i, leaf := range leaves {
+		controlBlock := append([]byte{leaf.LeafVersion | parity}, internalKey.XOnly()...)
+		for _, hash := range paths[i] {
+			controlBlock = append(controlBlock, hash...)
+		}
+		result = append(result, &TapLeafScript{ControlBlock: controlBlock, Script: leaf.Script, LeafVersion: leaf.LeafVersion})
+	}
+	return result, merkleRoot, nil
+}
+
+// signTaproot adds signatures from pk for a key path spend if it is the internal key,
+// and for the leaf scripts that use it.
+func (p *PSBT) signTaproot(inputIndex int, pk *ecc.PrivateKey, outputKey []byte) error {
+	in := p.Inputs[inputIndex]
+	// the signature hash commits to every output being spent
+	for i := range p.Inputs {
+		if _, err := p.utxo(i); err != nil {
+			return err
+		}
+	}
+	prevouts := p.prevouts()
+	hashType := in.SigHashType
+	xOnly := pk.Point.XOnly()
+	signed := false
+	if bytes.Equal(in.TapInternalKey, xOnly) {
+		even := pk.EvenY()
+		tweaked, err := even.TweakAdd(script.TapTweak(even.Point, in.TapMerkleRoot))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tweaked.Point.XOnly(), outputKey) {
+			return fmt.Errorf("input %d: internal key and merkle root don't match the output", inputIndex)
+		}
+		z, err := p.UnsignedTx.SigHashTaproot(prevouts, inputIndex, hashType, nil)
+		if err != nil {
+			return err
+		}
+		sig, err := tweaked.SignSchnorr(z, nil)
+		if err != nil {
+			return err
+		}
+		in.TapKeySig = appendHashType(sig, hashType)
+		signed = true
+	}
+	for _, leaf := range in.TapLeafScripts {
+		if !pushesData(leaf.Script, xOnly) {
+			continue
+		}
+		leafHash := script.TapLeafHash(leaf.LeafVersion, leaf.Script)
+		z, err := p.UnsignedTx.SigHashTaproot(prevouts, inputIndex, hashType, leafHash)
+		if err != nil {
+			return err
+		}
+		sig, err := pk.SignSchnorr(z, nil)
+		if err != nil {
+			return err
+		}
+		in.addTapScriptSig(xOnly, leafHash, appendHashType(sig, hashType))
+		signed = true
+	}
+	if !signed {
+		return fmt.Errorf("input %d does not use the key", inputIndex)
+	}
+	return nil
+}
+
+// appendHashType appends the hash type to a Schnorr signature unless it is SIGHASH_DEFAULT.
+func appendHashType(sig []byte, hashType uint32) []byte {
+	if hashType == 0 {
+		return sig
+	}
+	return append(sig, byte(hashType))
+}
+
+func pushesData(scr *script.Script, data []byte) bool {
+	for _, cmd := range scr.Commands() {
+		if cmd.IsData() && bytes.Equal(cmd.Data, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfyTaproot returns the witness of a key path spend if there is a key signature,
+// otherwise the smallest witness for a leaf script that has enough signatures.
+func (in *Input) satisfyTaproot() ([][]byte, error) {
+	if in.TapKeySig != nil {
+		return [][]byte{in.TapKeySig}, nil
+	}
+	var result [][]byte
+	size := 0
+	for _, leaf := range in.TapLeafScripts {
+		stack, ok := in.satisfyTapscript(leaf)
+		if !ok {
+			continue
+		}
+		witness := append(stack, leaf.Script.RawSerialize(), leaf.ControlBlock)
+		if witnessSize := len(serializeWitness(witness)); result == nil || witnessSize < size {
+			result, size = witness, witnessSize
+		}
+	}
+	if result == nil {
+		return nil, errors.New("no signatures for the key or a leaf script")
+	}
+	return result, nil
+}
+
+// satisfyTapscript returns the stack items, bottom first, that satisfy a leaf script
+// of the form <key> OP_CHECKSIG, or a k-of-n multisig:
+//
+//	<key 1> OP_CHECKSIG <key 2> OP_CHECKSIGADD ... <key n> OP_CHECKSIGADD <k> OP_NUMEQUAL
+func (in *Input) satisfyTapscript(leaf *TapLeafScript) ([][]byte, bool) {
+	keys, threshold, ok := tapscriptKeys(leaf.Script)
+	if !ok {
+		return nil, false
+	}
+	leafHash := script.TapLeafHash(leaf.LeafVersion, leaf.Script)
+	sigs := make([][]byte, len(keys))
+	count := 0
+	for i, key := range keys {
+		for _, sig := range in.TapScriptSigs {
+			if count < threshold && bytes.Equal(sig.XOnlyKey, key) && bytes.Equal(sig.LeafHash, leafHash) {
+				sigs[i] = sig.Signature
+				count++
+			}
+		}
+	}
+	if count < threshold {
+		return nil, false
+	}
+	// the first key is checked first, so its signature goes on top
+	stack := make([][]byte, len(keys))
+	for i, sig := range sigs {
+		if sig == nil {
+			sig = []byte{}
+		}
+		stack[len(keys)-1-i] = sig
+	}
+	return stack, true
+}
+
+// tapscriptKeys returns the keys and threshold of a leaf script that satisfyTapscript handles.
+func tapscriptKeys(scr *script.Script) ([][]byte, int, bool) {
+	cmds := scr.Commands()
+	if len(cmds) == 2 && cmds[0].IsData() && len(cmds[0].Data) == 32 && cmds[1].Opcode == script.OpCheckSig {
+		return [][]byte{cmds[0].Data}, 1, true
+	}
+	if len(cmds) < 4 || len(cmds)%2 != 0 || cmds[len(cmds)-1].Opcode != script.OpNumEqual {
+		return nil, 0, false
+	}
+	var keys [][]byte
+	for i := 0; i < len(cmds)-2; i += 2 {
+		op := byte(script.OpCheckSigAdd)
+		if i == 0 {
+			op = script.OpCheckSig
+		}
+		if !cmds[i].IsData() || len(cmds[i].Data) != 32 || cmds[i+1].Opcode != op {
+			return nil, 0, false
+		}
+		keys = append(keys, cmds[i].Data)
+	}
+	threshold, err := cmds[len(cmds)-2].Number()
+	if err != nil || threshold < 1 || threshold > len(keys) {
+		return nil, 0, false
+	}
+	return keys, threshold, true
+}
@@ -0,0 +1,192 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/descriptor"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// SetUtxo attaches the transaction holding the output spent by an input (the Updater role).
+// The output itself is attached as well if it is a witness program.
+func (p *PSBT) SetUtxo(inputIndex int, prevTx *tx.Transaction) error {
+	in := p.Inputs[inputIndex]
+	previous := in.NonWitnessUtxo
+	in.NonWitnessUtxo = prevTx
+	utxo, err := p.utxo(inputIndex)
+	if err != nil {
+		in.NonWitnessUtxo = previous
+		return err
+	}
+	if _, _, ok := utxo.ScriptPubKey.WitnessProgram(); ok {
+		in.WitnessUtxo = utxo
+	}
+	return nil
+}
+
+// SetWitnessUtxo attaches the output spent by a segwit input.
+func (p *PSBT) SetWitnessUtxo(inputIndex int, utxo *tx.Output) {
+	p.Inputs[inputIndex].WitnessUtxo = utxo
+}
+
+// SetInputScripts attaches the redeem and witness scripts of an input,
+// either of which can be nil. The input's utxo needs to be attached first.
+// Returns an error if the scripts don't match the output being spent.
+func (p *PSBT) SetInputScripts(inputIndex int, redeemScript, witnessScript *script.Script) error {
+	utxo, err := p.utxo(inputIndex)
+	if err != nil {
+		return err
+	}
+	if err := checkScripts(utxo.ScriptPubKey, redeemScript, witnessScript); err != nil {
+		return fmt.Errorf("input %d: %v", inputIndex, err)
+	}
+	in := p.Inputs[inputIndex]
+	in.RedeemScript = redeemScript
+	in.WitnessScript = witnessScript
+	return nil
+}
+
+// SetOutputScripts attaches the redeem and witness scripts of an output, either of which can be nil.
+// Returns an error if the scripts don't match the output.
+func (p *PSBT) SetOutputScripts(outputIndex int, redeemScript, witnessScript *script.Script) error {
+	scriptPubKey := p.UnsignedTx.Outputs[outputIndex].ScriptPubKey
+	if err := checkScripts(scriptPubKey, redeemScript, witnessScript); err != nil {
+		return fmt.Errorf("output %d: %v", outputIndex, err)
+	}
+	out := p.Outputs[outputIndex]
+	out.RedeemScript = redeemScript
+	out.WitnessScript = witnessScript
+	return nil
+}
+
+// AddInputDerivation attaches the BIP32 origin of a key used by an input.
+func (p *PSBT) AddInputDerivation(inputIndex int, d *Bip32Derivation) {
+	in := p.Inputs[inputIndex]
+	in.Bip32Derivations = mergeDerivations(in.Bip32Derivations, []*Bip32Derivation{d})
+}
+
+// AddOutputDerivation attaches the BIP32 origin of a key used by an output.
+func (p *PSBT) AddOutputDerivation(outputIndex int, d *Bip32Derivation) {
+	out := p.Outputs[outputIndex]
+	out.Bip32Derivations = mergeDerivations(out.Bip32Derivations, []*Bip32Derivation{d})
+}
+
+// UpdateInputFromDescriptor attaches the scripts and key origins of an expanded descriptor
+// to an input, or for tr() descriptors the internal key and the leaf scripts with their control blocks.
+// The input's utxo needs to be attached first.
+func (p *PSBT) UpdateInputFromDescriptor(inputIndex int, out *descriptor.Output) error {
+	utxo, err := p.utxo(inputIndex)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(utxo.ScriptPubKey.RawSerialize(), out.ScriptPubKey.RawSerialize()) {
+		return fmt.Errorf("input %d does not spend the descriptor's output", inputIndex)
+	}
+	in := p.Inputs[inputIndex]
+	if out.InternalKey != nil {
+		in.TapInternalKey = out.InternalKey.XOnly()
+		if out.TapScripts != nil {
+			leafScripts, merkleRoot, err := tapLeafScripts(out.InternalKey, tapLeaves(out))
+			if err != nil {
+				return err
+			}
+			in.TapMerkleRoot = merkleRoot
+			in.TapLeafScripts = leafScripts
+		}
+		in.TapBip32Derivations = mergeTapDerivations(in.TapBip32Derivations, tapDerivations(out))
+		return nil
+	}
+	if err := p.SetInputScripts(inputIndex, out.RedeemScript, out.WitnessScript); err != nil {
+		return err
+	}
+	for _, d := range derivations(out) {
+		p.AddInputDerivation(inputIndex, d)
+	}
+	return nil
+}
+
+// UpdateOutputFromDescriptor attaches the scripts and key origins of an expanded descriptor
+// to an output, or for tr() descriptors the internal key and script tree,
+// e.g. for the wallet to recognize its change.
+func (p *PSBT) UpdateOutputFromDescriptor(outputIndex int, out *descriptor.Output) error {
+	scriptPubKey := p.UnsignedTx.Outputs[outputIndex].ScriptPubKey
+	if !bytes.Equal(scriptPubKey.RawSerialize(), out.ScriptPubKey.RawSerialize()) {
+		return fmt.Errorf("output %d is not the descriptor's output", outputIndex)
+	}
+	if out.InternalKey != nil {
+		output := p.Outputs[outputIndex]
+		output.TapInternalKey = out.InternalKey.XOnly()
+		output.TapTree = tapLeaves(out)
+		output.TapBip32Derivations = mergeTapDerivations(output.TapBip32Derivations, tapDerivations(out))
+		return nil
+	}
+	if err := p.SetOutputScripts(outputIndex, out.RedeemScript, out.WitnessScript); err != nil {
+		return err
+	}
+	for _, d := range derivations(out) {
+		p.AddOutputDerivation(outputIndex, d)
+	}
+	return nil
+}
+
+// derivations returns the origins of the descriptor's keys that have one.
+func derivations(out *descriptor.Output) []*Bip32Derivation {
+	var result []*Bip32Derivation
+	for _, key := range out.Keys {
+		if key.Path == nil && key.Fingerprint == [4]byte{} {
+			continue
+		}
+		result = append(result, &Bip32Derivation{Key: key.Sec(), Fingerprint: key.Fingerprint, Path: key.Path})
+	}
+	return result
+}
+
+// tapLeaves returns the script tree of a tr() descriptor, nil if it has none.
+func tapLeaves(out *descriptor.Output) []*TapLeaf {
+	var result []*TapLeaf
+	for i, leafScript := range out.TapScripts {
+		result = append(result, &TapLeaf{Depth: out.TapDepths[i], LeafVersion: script.TapscriptLeafVersion, Script: leafScript})
+	}
+	return result
+}
+
+// tapDerivations returns the origins of the keys of a tr() descriptor that have one,
+// with the leaf scripts that use each key.
+func tapDerivations(out *descriptor.Output) []*TapBip32Derivation {
+	var result []*TapBip32Derivation
+	for _, d := range derivations(out) {
+		xOnly := d.Key[1:]
+		tapDerivation := &TapBip32Derivation{Bip32Derivation: Bip32Derivation{Key: xOnly, Fingerprint: d.Fingerprint, Path: d.Path}}
+		for _, leafScript := range out.TapScripts {
+			if pushesData(leafScript, xOnly) {
+				tapDerivation.LeafHashes = append(tapDerivation.LeafHashes, script.TapLeafHash(script.TapscriptLeafVersion, leafScript))
+			}
+		}
+		result = mergeTapDerivations(result, []*TapBip32Derivation{tapDerivation})
+	}
+	return result
+}
+
+// checkScripts returns an error unless the redeem and witness scripts
+// are the ones the ScriptPubKey commits to.
+func checkScripts(scriptPubKey, redeemScript, witnessScript *script.Script) error {
+	program := scriptPubKey
+	if redeemScript != nil {
+		raw := redeemScript.RawSerialize()
+		if !bytes.Equal(scriptPubKey.RawSerialize(), script.P2shScript(util.Hash160(raw)).RawSerialize()) {
+			return errors.New("redeem script does not match")
+		}
+		program = redeemScript
+	}
+	if witnessScript != nil {
+		raw := witnessScript.RawSerialize()
+		if !bytes.Equal(program.RawSerialize(), script.P2wshScript(util.Sha256(raw)).RawSerialize()) {
+			return errors.New("witness script does not match")
+		}
+	}
+	return nil
+}
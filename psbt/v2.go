@@ -0,0 +1,178 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// NewV2 creates an empty version 2 PSBT (BIP370) that inputs and outputs can be added to.
+// fallbackLocktime is the lock time used when no input requires one.
+func NewV2(txVersion, fallbackLocktime uint32) (*PSBT, error) {
+	if txVersion < 2 {
+		return nil, fmt.Errorf("version 2 PSBTs need transaction version 2 or higher, got %d", txVersion)
+	}
+	return &PSBT{
+		UnsignedTx:       tx.NewTransaction(txVersion, nil, nil, fallbackLocktime, false),
+		Version:          2,
+		FallbackLocktime: fallbackLocktime,
+		TxModifiable:     InputsModifiable | OutputsModifiable,
+	}, nil
+}
+
+// AddInput adds an input to a version 2 PSBT (the Constructor role).
+// txIn holds the outpoint and sequence, in anything known about the input or nil.
+// Returns an error if inputs can't be added, the outpoint is already spent by another input,
+// or the input's required lock time conflicts with the other inputs.
+func (p *PSBT) AddInput(txIn *tx.Input, in *Input) error {
+	if p.Version != 2 || p.TxModifiable&InputsModifiable == 0 {
+		return errors.New("inputs can't be added to the PSBT")
+	}
+	if txIn.ScriptSig.Len() > 0 || len(txIn.Witness) > 0 {
+		return errors.New("the input is signed")
+	}
+	for _, existing := range p.UnsignedTx.Inputs {
+		if bytes.Equal(existing.PrevTx, txIn.PrevTx) && existing.PrevIndex == txIn.PrevIndex {
+			return fmt.Errorf("%x:%d is already spent", txIn.PrevTx, txIn.PrevIndex)
+		}
+	}
+	if in == nil {
+		in = new(Input)
+	}
+	if err := in.checkLocktimes(); err != nil {
+		return err
+	}
+	unsignedTx := p.UnsignedTx
+	unsignedTx.Inputs = append(unsignedTx.Inputs, tx.NewInput(txIn.PrevTx, txIn.PrevIndex, nil, txIn.Sequence))
+	p.Inputs = append(p.Inputs, in)
+	if err := p.syncLocktime(); err != nil {
+		unsignedTx.Inputs = unsignedTx.Inputs[:len(unsignedTx.Inputs)-1]
+		p.Inputs = p.Inputs[:len(p.Inputs)-1]
+		return err
+	}
+	return nil
+}
+
+// AddOutput adds an output to a version 2 PSBT (the Constructor role).
+// out holds anything known about the output or nil.
+// Returns an error if outputs can't be added.
+func (p *PSBT) AddOutput(txOut *tx.Output, out *Output) error {
+	if p.Version != 2 || p.TxModifiable&OutputsModifiable == 0 {
+		return errors.New("outputs can't be added to the PSBT")
+	}
+	if out == nil {
+		out = new(Output)
+	}
+	p.UnsignedTx.Outputs = append(p.UnsignedTx.Outputs, tx.NewOutput(txOut.Amount, txOut.ScriptPubKey))
+	p.Outputs = append(p.Outputs, out)
+	return nil
+}
+
+// ConvertTo converts the PSBT to version 0 or 2.
+// Converting to version 0 fixes the lock time and drops the fields only version 2 has,
+// converting to version 2 makes the current lock time the fallback.
+func (p *PSBT) ConvertTo(version uint32) error {
+	if version == p.Version {
+		return nil
+	}
+	switch version {
+	case 0:
+		if err := p.syncLocktime(); err != nil {
+			return err
+		}
+		p.FallbackLocktime = 0
+		p.TxModifiable = 0
+		for _, in := range p.Inputs {
+			in.RequiredTimeLocktime = 0
+			in.RequiredHeightLocktime = 0
+		}
+	case 2:
+		if p.UnsignedTx.Version < 2 {
+			return fmt.Errorf("version 2 PSBTs need transaction version 2 or higher, got %d", p.UnsignedTx.Version)
+		}
+		p.FallbackLocktime = p.UnsignedTx.Locktime
+	default:
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	p.Version = version
+	return nil
+}
+
+// syncLocktime sets the lock time of a version 2 PSBT's transaction from the inputs.
+// Version 0 PSBTs are left alone.
+func (p *PSBT) syncLocktime() error {
+	if p.Version != 2 {
+		return nil
+	}
+	locktime, err := p.locktime()
+	if err != nil {
+		return err
+	}
+	p.UnsignedTx.Locktime = locktime
+	return nil
+}
+
+// locktime determines the lock time from the inputs' required lock times (BIP370):
+// the largest required height if every input that requires a lock time allows a height,
+// otherwise the largest required time.
+func (p *PSBT) locktime() (uint32, error) {
+	var height, time uint32
+	required, heightOK, timeOK := false, true, true
+	for _, in := range p.Inputs {
+		if in.RequiredHeightLocktime == 0 && in.RequiredTimeLocktime == 0 {
+			continue
+		}
+		required = true
+		if in.RequiredHeightLocktime == 0 {
+			heightOK = false
+		} else if in.RequiredHeightLocktime > height {
+			height = in.RequiredHeightLocktime
+		}
+		if in.RequiredTimeLocktime == 0 {
+			timeOK = false
+		} else if in.RequiredTimeLocktime > time {
+			time = in.RequiredTimeLocktime
+		}
+	}
+	switch {
+	case !required:
+		return p.FallbackLocktime, nil
+	case heightOK:
+		return height, nil
+	case timeOK:
+		return time, nil
+	}
+	return 0, errors.New("inputs require both a height and a time lock time")
+}
+
+// checkLocktimes returns an error if a required lock time is of the wrong kind.
+func (in *Input) checkLocktimes() error {
+	if in.RequiredTimeLocktime != 0 && in.RequiredTimeLocktime < tx.LockTimeThreshold {
+		return fmt.Errorf("required time lock time %d is a height", in.RequiredTimeLocktime)
+	}
+	if in.RequiredHeightLocktime >= tx.LockTimeThreshold {
+		return fmt.Errorf("required height lock time %d is a time", in.RequiredHeightLocktime)
+	}
+	return nil
+}
+
+// updateModifiable clears the flags a signature with the hash type
+// no longer allows in a version 2 PSBT.
+func (p *PSBT) updateModifiable(hashType uint32) {
+	if p.Version != 2 {
+		return
+	}
+	if hashType&util.SigHashAnyoneCanPay == 0 {
+		p.TxModifiable &^= InputsModifiable
+	}
+	switch hashType &^ util.SigHashAnyoneCanPay {
+	case util.SigHashNone:
+	case util.SigHashSingle:
+		p.TxModifiable |= HasSigHashSingle
+	default:
+		p.TxModifiable &^= OutputsModifiable
+	}
+}
@@ -0,0 +1,149 @@
+// Package retarget works out the bits a header needs from the chain before it,
+// with the difficulty adjustment rules of each network.
+package retarget
+
+import (
+	"math/big"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// MaxTimeWarp is how far before its parent the first block of a period can be timestamped (BIP94).
+const MaxTimeWarp = 600
+
+// Params are the proof of work rules of a network.
+type Params struct {
+	// PowLimit is the highest target a header can have.
+	PowLimit *big.Int
+	// TargetTimespan is the time a period should take, in seconds.
+	TargetTimespan int64
+	// TargetSpacing is the time a block should take, in seconds.
+	TargetSpacing int64
+	// AllowMinDifficultyBlocks lets a block more than twice TargetSpacing after its parent
+	// have the PowLimit bits, as on testnet.
+	AllowMinDifficultyBlocks bool
+	// EnforceBIP94 retargets from the bits of the first block of a period rather than the last,
+	// and limits how far before its parent the first block of a period can be timestamped.
+	EnforceBIP94 bool
+	// NoRetargeting keeps the difficulty of the first block, as on regtest.
+	NoRetargeting bool
+}
+
+// Params of the networks.
+var (
+	MainNetParams = &Params{
+		PowLimit:       util.HexStringToBigInt(`00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff`),
+		TargetTimespan: 14 * 24 * 60 * 60,
+		TargetSpacing:  10 * 60,
+	}
+	TestNet3Params = &Params{
+		PowLimit:                 util.HexStringToBigInt(`00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff`),
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+	}
+	TestNet4Params = &Params{
+		PowLimit:                 util.HexStringToBigInt(`00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff`),
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+		EnforceBIP94:             true,
+	}
+	RegTestParams = &Params{
+		PowLimit:                 util.HexStringToBigInt(`7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`),
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+		NoRetargeting:            true,
+	}
+)
+
+// Interval returns the number of blocks in a period, between difficulty adjustments.
+func (p *Params) Interval() int {
+	return int(p.TargetTimespan / p.TargetSpacing)
+}
+
+// PowLimitBits returns the bits of the highest target, the lowest difficulty.
+func (p *Params) PowLimitBits() []byte {
+	return util.TargetToBits(p.PowLimit)
+}
+
+// NextBits returns the bits the header at height with timestamp needs, like GetNextWorkRequired
+// in Bitcoin Core: the parent's, except at the start of a period when the difficulty adjusts
+// to the time the last period took. On networks that allow min difficulty blocks, a header more
+// than twice TargetSpacing after its parent can have the PowLimit bits, and the header after one
+// goes back to the bits of the last block of its period that didn't.
+func NextBits(chain block.Chain, height int, timestamp uint32, params *Params) ([]byte, error) {
+	if height == 0 {
+		return params.PowLimitBits(), nil
+	}
+	parent, err := block.Ancestor(chain, height-1)
+	if err != nil {
+		return nil, err
+	}
+	interval := params.Interval()
+	if height%interval != 0 {
+		if !params.AllowMinDifficultyBlocks {
+			return parent.Bits[:], nil
+		}
+		if int64(timestamp) > int64(parent.Timestamp)+2*params.TargetSpacing {
+			return params.PowLimitBits(), nil
+		}
+		powLimitBits := params.PowLimitBits()
+		last := parent
+		for lastHeight := height - 1; lastHeight > 0 && lastHeight%interval != 0 && string(last.Bits[:]) == string(powLimitBits); {
+			lastHeight--
+			if last, err = block.Ancestor(chain, lastHeight); err != nil {
+				return nil, err
+			}
+		}
+		return last.Bits[:], nil
+	}
+	if params.NoRetargeting {
+		return parent.Bits[:], nil
+	}
+	// the period runs from the first block to the parent, one block short of a full period of time
+	first, err := block.Ancestor(chain, height-interval)
+	if err != nil {
+		return nil, err
+	}
+	bits := parent.Bits[:]
+	if params.EnforceBIP94 {
+		bits = first.Bits[:]
+	}
+	return CalculateBits(bits, int64(parent.Timestamp)-int64(first.Timestamp), params), nil
+}
+
+// CalculateBits returns the bits for a period after one with bits that took timespan seconds:
+// the target scaled by timespan over TargetTimespan, adjusting by at most a factor of 4
+// and never above PowLimit.
+func CalculateBits(bits []byte, timespan int64, params *Params) []byte {
+	if timespan < params.TargetTimespan/4 {
+		timespan = params.TargetTimespan / 4
+	}
+	if timespan > params.TargetTimespan*4 {
+		timespan = params.TargetTimespan * 4
+	}
+	target := util.BitsToTarget(bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(params.TargetTimespan))
+	if target.Cmp(params.PowLimit) > 0 {
+		target = params.PowLimit
+	}
+	return util.TargetToBits(target)
+}
+
+// CheckTimeWarp returns whether the header at height with timestamp keeps to the time warp rule
+// of BIP94: the first block of a period can't be more than MaxTimeWarp seconds before its parent.
+// It always does on networks that don't enforce BIP94.
+func CheckTimeWarp(chain block.Chain, height int, timestamp uint32, params *Params) (bool, error) {
+	if !params.EnforceBIP94 || height == 0 || height%params.Interval() != 0 {
+		return true, nil
+	}
+	parent, err := block.Ancestor(chain, height-1)
+	if err != nil {
+		return false, err
+	}
+	return int64(timestamp) >= int64(parent.Timestamp)-MaxTimeWarp, nil
+}
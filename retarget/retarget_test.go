@@ -0,0 +1,219 @@
+package retarget
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// headers is a chain with only some of its headers, enough to work out the bits of one.
+type headers map[int]*block.Block
+
+func (h headers) HeaderAt(height int) *block.Block {
+	return h[height]
+}
+
+// testChain returns headers ten minutes apart with bits, up to height length-1.
+func testChain(length int, bits []byte) headers {
+	result := make(headers)
+	for height := 0; height < length; height++ {
+		result[height] = block.NewBlock(0x20000000, make([]byte, 32), make([]byte, 32), uint32(1600000000+height*600), bits, nil, nil)
+	}
+	return result
+}
+
+func TestFixtures(t *testing.T) {
+	networks := []struct {
+		name   string
+		params *Params
+	}{
+		{"mainnet", MainNetParams},
+		{"testnet3", TestNet3Params},
+		{"testnet4", TestNet4Params},
+	}
+	for _, network := range networks {
+		raw, err := ioutil.ReadFile("testdata/" + network.name + ".json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var fixtures []struct {
+			Name      string
+			Height    int
+			Timestamp uint32
+			Headers   map[string]string
+			Bits      string
+		}
+		if err := json.Unmarshal(raw, &fixtures); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, fixture := range fixtures {
+			params := network.params
+			t.Run(network.name+" "+fixture.Name, func(t *testing.T) {
+				chain := make(headers)
+				for key, value := range fixture.Headers {
+					height, _ := strconv.Atoi(key)
+					header := block.Parse(bytes.NewReader(util.HexStringToBytes(value)))
+					if !header.CheckPow() {
+						t.Fatalf("Header at %d doesn't meet its target", height)
+					}
+					chain[height] = header
+				}
+				for height, header := range chain {
+					if parent, ok := chain[height-1]; ok && !bytes.Equal(header.PrevBlock[:], parent.Hash()) {
+						t.Fatalf("Header at %d doesn't follow the one before it", height)
+					}
+				}
+				// the timestamp of the block, or ten minutes after its parent if the fixture leaves it out
+				timestamp := fixture.Timestamp
+				if timestamp == 0 {
+					timestamp = chain[fixture.Height-1].Timestamp + 600
+				}
+				actual, err := NextBits(chain, fixture.Height, timestamp, params)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if expected := util.HexStringToBytes(fixture.Bits); !bytes.Equal(actual, expected) {
+					t.Errorf("Expected %x, got %x", expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	bits := util.HexStringToBytes(`e93c0118`)
+	powLimitBits := util.HexStringToBytes(`ffff001d`)
+	interval := MainNetParams.Interval()
+
+	t.Run("Test params", func(t *testing.T) {
+		if interval != 2016 {
+			t.Errorf("Expected 2016, got %d", interval)
+		}
+		if actual := TestNet4Params.PowLimitBits(); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", powLimitBits, actual)
+		}
+		if actual := RegTestParams.PowLimitBits(); !bytes.Equal(actual, util.HexStringToBytes(`ffff7f20`)) {
+			t.Errorf("Expected ffff7f20, got %x", actual)
+		}
+		if actual, _ := NextBits(nil, 0, 0, MainNetParams); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", powLimitBits, actual)
+		}
+	})
+
+	t.Run("Test mainnet", func(t *testing.T) {
+		chain := testChain(interval, bits)
+		// the period took a week, half the target, so the difficulty doubles
+		chain[interval-1].Timestamp = chain[0].Timestamp + 7*24*60*60
+		actual, err := NextBits(chain, interval, chain[interval-1].Timestamp+600, MainNetParams)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := util.CalculateNewBits(bits, 7*24*60*60); !bytes.Equal(actual, expected) {
+			t.Errorf("Expected %x, got %x", expected, actual)
+		}
+		// a late block changes nothing on mainnet
+		if actual, _ := NextBits(chain, interval-1, chain[interval-2].Timestamp+3600, MainNetParams); !bytes.Equal(actual, bits) {
+			t.Errorf("Expected %x, got %x", bits, actual)
+		}
+		if _, err := NextBits(chain, interval+1, 0, MainNetParams); err == nil {
+			t.Errorf("Expected an error for a missing header")
+		}
+		// the adjustment is at most a factor of 4 and never below the minimum difficulty
+		if actual := CalculateBits(bits, 1, MainNetParams); !bytes.Equal(actual, CalculateBits(bits, 302400, MainNetParams)) {
+			t.Errorf("Expected the adjustment to stop at a factor of 4, got %x", actual)
+		}
+		if actual := CalculateBits(powLimitBits, 4*1209600, MainNetParams); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", powLimitBits, actual)
+		}
+	})
+
+	// the testnet fixtures only cover the first blocks, so these chains are made up to follow the
+	// min difficulty and BIP94 rules
+	t.Run("Test testnet min difficulty", func(t *testing.T) {
+		chain := testChain(interval+10, bits)
+		parent := chain[interval+4]
+		if actual, _ := NextBits(chain, interval+5, parent.Timestamp+1200, TestNet3Params); !bytes.Equal(actual, bits) {
+			t.Errorf("Expected %x 20 minutes after the parent, got %x", bits, actual)
+		}
+		if actual, _ := NextBits(chain, interval+5, parent.Timestamp+1201, TestNet3Params); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x more than 20 minutes after the parent, got %x", powLimitBits, actual)
+		}
+		// after min difficulty blocks, back to the bits of the last block that wasn't one
+		for height := interval + 2; height < interval+5; height++ {
+			copy(chain[height].Bits[:], powLimitBits)
+		}
+		if actual, _ := NextBits(chain, interval+5, parent.Timestamp+600, TestNet3Params); !bytes.Equal(actual, bits) {
+			t.Errorf("Expected %x, got %x", bits, actual)
+		}
+		// the walk back stops at the first block of the period
+		for height := interval; height < interval+2; height++ {
+			copy(chain[height].Bits[:], powLimitBits)
+		}
+		if actual, _ := NextBits(chain, interval+5, parent.Timestamp+600, TestNet3Params); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", powLimitBits, actual)
+		}
+		if actual, _ := NextBits(chain, interval+5, parent.Timestamp+600, TestNet4Params); !bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", powLimitBits, actual)
+		}
+		if _, err := NextBits(headers{interval + 4: parent}, interval+5, parent.Timestamp, TestNet3Params); err == nil {
+			t.Errorf("Expected an error for a missing header")
+		}
+	})
+
+	t.Run("Test BIP94", func(t *testing.T) {
+		chain := testChain(interval, bits)
+		// the period ends with a min difficulty block
+		last := chain[interval-1]
+		copy(last.Bits[:], powLimitBits)
+		timespan := int64(last.Timestamp) - int64(chain[0].Timestamp)
+		// testnet3 retargets from the last block's bits, resetting the difficulty
+		actual, err := NextBits(chain, interval, last.Timestamp, TestNet3Params)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := CalculateBits(powLimitBits, timespan, TestNet3Params); !bytes.Equal(actual, expected) {
+			t.Errorf("Expected %x, got %x", expected, actual)
+		}
+		// testnet4 retargets from the first block's
+		actual, err = NextBits(chain, interval, last.Timestamp, TestNet4Params)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := CalculateBits(bits, timespan, TestNet4Params); !bytes.Equal(actual, expected) || bytes.Equal(actual, powLimitBits) {
+			t.Errorf("Expected %x, got %x", expected, actual)
+		}
+		tests := []struct {
+			height    int
+			timestamp uint32
+			params    *Params
+			want      bool
+		}{
+			{interval, last.Timestamp - MaxTimeWarp, TestNet4Params, true},
+			{interval, last.Timestamp - MaxTimeWarp - 1, TestNet4Params, false},
+			{interval - 1, chain[interval-2].Timestamp - MaxTimeWarp - 1, TestNet4Params, true},
+			{interval, last.Timestamp - MaxTimeWarp - 1, TestNet3Params, true},
+		}
+		for _, test := range tests {
+			if ok, err := CheckTimeWarp(chain, test.height, test.timestamp, test.params); ok != test.want || err != nil {
+				t.Errorf("Expected %v at %d, got %v %v", test.want, test.height, ok, err)
+			}
+		}
+	})
+
+	t.Run("Test regtest", func(t *testing.T) {
+		regTestBits := RegTestParams.PowLimitBits()
+		chain := testChain(interval, regTestBits)
+		// blocks come every second on regtest, yet the difficulty stays
+		for height := range chain {
+			chain[height].Timestamp = uint32(1600000000 + height)
+		}
+		if actual, _ := NextBits(chain, interval, chain[interval-1].Timestamp+1, RegTestParams); !bytes.Equal(actual, regTestBits) {
+			t.Errorf("Expected %x, got %x", regTestBits, actual)
+		}
+	})
+}
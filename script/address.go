@@ -0,0 +1,48 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Address returns the address for a p2pkh, p2sh or witness program ScriptPubKey.
+func (scr *Script) Address(testnet bool) (string, error) {
+	switch {
+	case scr.IsP2pkhScriptPubKey():
+		return util.H160ToP2pkhAddress(scr.cmds[2].Data, testnet), nil
+	case scr.IsP2shScriptPubKey():
+		return util.H160ToP2shAddress(scr.cmds[1].Data, testnet), nil
+	}
+	if version, program, ok := scr.WitnessProgram(); ok {
+		return util.EncodeSegwitAddress(version, program, testnet), nil
+	}
+	return "", errors.New("script has no address")
+}
+
+// FromAddress returns the ScriptPubKey that pays to an address.
+func FromAddress(address string, testnet bool) (*Script, error) {
+	if payload, err := util.DecodeBase58Checksum(address); err == nil {
+		if len(payload) != 21 {
+			return nil, fmt.Errorf("invalid address length %d", len(payload))
+		}
+		p2pkhPrefix, p2shPrefix := byte(0x00), byte(0x05)
+		if testnet {
+			p2pkhPrefix, p2shPrefix = 0x6f, 0xc4
+		}
+		switch payload[0] {
+		case p2pkhPrefix:
+			return P2pkhScript(payload[1:]), nil
+		case p2shPrefix:
+			return P2shScript(payload[1:]), nil
+		}
+		return nil, fmt.Errorf("unknown address prefix %x", payload[0])
+	}
+	version, program, err := util.DecodeSegwitAddress(address, testnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", address, err)
+	}
+	result := new(Script).AppendInt(version).AppendData(program)
+	return result, nil
+}
@@ -0,0 +1,743 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Names of the scripts an Engine may run through.
+const (
+	ScriptSigPhase     = "scriptSig"
+	ScriptPubKeyPhase  = "scriptPubKey"
+	RedeemScriptPhase  = "redeemScript"
+	WitnessScriptPhase = "witnessScript"
+	TapscriptPhase     = "tapscript"
+)
+
+// SigVersion selects the algorithm for the hash a signature commits to.
+type SigVersion int
+
+// Signature hash algorithms.
+const (
+	// SigVersionBase is the original algorithm, for legacy and p2sh scripts.
+	SigVersionBase SigVersion = iota
+	// SigVersionWitnessV0 is BIP143, for segwit version 0 scripts.
+	SigVersionWitnessV0
+	// SigVersionTaproot is BIP341, for taproot key path spends.
+	SigVersionTaproot
+	// SigVersionTapscript is BIP341 extended by BIP342, for taproot script path spends.
+	SigVersionTapscript
+)
+
+// annexTag starts the optional last taproot witness item (BIP341).
+const annexTag = 0x50
+
+// Consensus limits on running scripts.
+const (
+	// MaxScriptElementSize is the most bytes a push or witness stack item can have.
+	MaxScriptElementSize = 520
+	// MaxOpsPerScript is the most opcodes other than pushes a script outside tapscript can have,
+	// counting the keys of each OP_CHECKMULTISIG run.
+	MaxOpsPerScript = 201
+	// MaxStackSize is the most elements the stack and altstack can hold together.
+	MaxStackSize = 1000
+	// validationWeightPerSigOp is the tapscript validation weight each signature checked uses up,
+	// of a budget of validationWeightOffset plus the size of the witness (BIP342).
+	validationWeightPerSigOp = 50
+	validationWeightOffset   = 50
+)
+
+// Flags select the soft forks an Engine enforces, like the SCRIPT_VERIFY flags of Bitcoin Core.
+type Flags uint32
+
+// Script verification flags.
+const (
+	// VerifyP2SH runs pay-to-script-hash redeem scripts (BIP16).
+	VerifyP2SH Flags = 1 << iota
+	// VerifyDERSig requires strict DER signatures (BIP66).
+	VerifyDERSig
+	// VerifyCheckLockTime turns OP_NOP2 into OP_CHECKLOCKTIMEVERIFY (BIP65).
+	VerifyCheckLockTime
+	// VerifyCheckSequence turns OP_NOP3 into OP_CHECKSEQUENCEVERIFY (BIP112).
+	VerifyCheckSequence
+	// VerifyWitness runs segwit version 0 witness programs (BIP141, BIP143).
+	VerifyWitness
+	// VerifyNullDummy requires the extra element OP_CHECKMULTISIG pops to be empty (BIP147).
+	VerifyNullDummy
+	// VerifyTaproot runs taproot witness programs (BIP341, BIP342).
+	VerifyTaproot
+
+	// VerifyNone enforces none of the soft forks.
+	VerifyNone Flags = 0
+	// VerifyAll enforces every soft fork, which is how an Engine starts.
+	VerifyAll = VerifyP2SH | VerifyDERSig | VerifyCheckLockTime | VerifyCheckSequence |
+		VerifyWitness | VerifyNullDummy | VerifyTaproot
+)
+
+// State is a snapshot of an Engine taken after a command has run.
+type State struct {
+	// PC is the index of the command that just ran, or -1 before the first step.
+	PC int
+	// Phase is the name of the script the command belongs to.
+	Phase string
+	// Command is the command that just ran.
+	Command Command
+	// Stack and AltStack list their elements bottom first.
+	Stack    [][]byte
+	AltStack [][]byte
+	// CondStack holds one entry per open OP_IF/OP_NOTIF, true if its branch is executing.
+	CondStack []bool
+}
+
+// Checker checks signatures and timelocks against the transaction being verified.
+type Checker interface {
+	// SigHash returns the hash a signature with the given hash type commits to.
+	// scriptCode is the script being run from the last OP_CODESEPARATOR, leafHash is the
+	// tapleaf hash for tapscript and nil otherwise, and codeSepPos is the position of the
+	// last OP_CODESEPARATOR run in tapscript, 0xffffffff if there is none.
+	// Returns an error if the hash type is not valid.
+	SigHash(hashType uint32, sigVersion SigVersion, scriptCode *Script, leafHash []byte, codeSepPos uint32) ([]byte, error)
+	// CheckLockTime returns whether the transaction's lock time satisfies lockTime (BIP65).
+	CheckLockTime(lockTime int64) bool
+	// CheckSequence returns whether the input's sequence satisfies sequence (BIP112).
+	CheckSequence(sequence int64) bool
+}
+
+// Engine evaluates a scriptSig, scriptPubKey and witness one command at a time.
+// Pay-to-script-hash redeem scripts and version 0 witness programs are appended
+// to the program as they are reached, so the program counter keeps counting up.
+type Engine struct {
+	program []Command
+	pc      int
+	start   int
+	end     int
+	// codeSep is where the script signatures commit to starts, after the last OP_CODESEPARATOR,
+	// and codeSepPos is the position of that OP_CODESEPARATOR in tapscript
+	codeSep      int
+	codeSepPos   uint32
+	phase        string
+	flags        Flags
+	sigVersion   SigVersion
+	leafHash     []byte
+	scriptPubKey *Script
+	witness      [][]byte
+	z            []byte
+	checker      Checker
+	stack        *opStack
+	altStack     *opStack
+	condStack    []bool
+	p2shStack    [][]byte
+	// opCount counts the opcodes of the current script towards MaxOpsPerScript
+	opCount int
+	// budget is the tapscript validation weight left
+	budget      int
+	last        *State
+	witnessUsed bool
+	done        bool
+	err         error
+}
+
+// NewEngine initializes an Engine.
+// z is the signature hash checked by the signing operations, whatever their hash type,
+// unless a Checker is set.
+// witness may be nil for transactions without segwit data.
+func NewEngine(scriptSig, scriptPubKey *Script, witness [][]byte, z []byte) *Engine {
+	program := make([]Command, 0, len(scriptSig.cmds)+len(scriptPubKey.cmds))
+	program = append(program, scriptSig.cmds...)
+	program = append(program, scriptPubKey.cmds...)
+	engine := &Engine{
+		program:      program,
+		end:          len(scriptSig.cmds),
+		phase:        ScriptSigPhase,
+		codeSepPos:   0xffffffff,
+		flags:        VerifyAll,
+		scriptPubKey: scriptPubKey,
+		witness:      witness,
+		z:            z,
+		stack:        newOpStack(nil),
+		altStack:     newOpStack(nil),
+		last:         &State{PC: -1, Phase: ScriptSigPhase},
+	}
+	if err := engine.checkScriptSize(); err != nil {
+		engine.fail(fmt.Errorf("%s: %v", ScriptSigPhase, err))
+		return engine
+	}
+	engine.advance()
+	return engine
+}
+
+// SetChecker sets the Checker for the signing and timelock operations.
+// Without one, signatures are checked against z, and
+// OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY fail.
+func (e *Engine) SetChecker(checker Checker) {
+	e.checker = checker
+}
+
+// SetFlags sets the soft forks to enforce, before the first Step. An Engine starts with VerifyAll.
+func (e *Engine) SetFlags(flags Flags) {
+	e.flags = flags
+}
+
+// Done returns whether the evaluation has finished.
+func (e *Engine) Done() bool {
+	return e.done
+}
+
+// Err returns the reason the evaluation failed, or nil.
+func (e *Engine) Err() error {
+	return e.err
+}
+
+// State returns a snapshot of the engine after the last command.
+func (e *Engine) State() *State {
+	result := *e.last
+	result.Stack = e.stack.items()
+	result.AltStack = e.altStack.items()
+	result.CondStack = make([]bool, len(e.condStack))
+	copy(result.CondStack, e.condStack)
+	return &result
+}
+
+// Step runs the next command.
+// Returns an error if the command or, at the end of the program, the script failed.
+func (e *Engine) Step() (err error) {
+	if e.done {
+		return e.err
+	}
+	defer func() {
+		// malformed keys and signatures panic when parsed
+		if r := recover(); r != nil {
+			err = e.fail(fmt.Errorf("pc %d: %v", e.last.PC, r))
+		}
+	}()
+	cmd := e.program[e.pc]
+	e.last = &State{PC: e.pc, Phase: e.phase, Command: cmd}
+	e.pc++
+	if err := e.execute(cmd); err != nil {
+		return e.fail(fmt.Errorf("pc %d: %v", e.last.PC, err))
+	}
+	if e.stack.Length+e.altStack.Length > MaxStackSize {
+		return e.fail(fmt.Errorf("pc %d: more than %d stack elements", e.last.PC, MaxStackSize))
+	}
+	return e.advance()
+}
+
+// Run the remaining commands.
+// Returns nil if the script succeeded and the reason it failed otherwise.
+func (e *Engine) Run() error {
+	for !e.done {
+		e.Step()
+	}
+	return e.err
+}
+
+// Trace runs the remaining commands and returns the state after each one.
+func (e *Engine) Trace() ([]*State, error) {
+	var result []*State
+	for !e.done {
+		e.Step()
+		result = append(result, e.State())
+	}
+	return result, e.err
+}
+
+func (e *Engine) fail(err error) error {
+	e.done = true
+	e.err = err
+	return err
+}
+
+func (e *Engine) executing() bool {
+	for _, cond := range e.condStack {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) execute(cmd Command) error {
+	opcode := int(cmd.Opcode)
+	// these limits apply in branches that aren't run too
+	if cmd.IsData() && len(cmd.Data) > MaxScriptElementSize {
+		return fmt.Errorf("push of %d bytes is over %d", len(cmd.Data), MaxScriptElementSize)
+	}
+	if isDisabledOpcode(cmd.Opcode) {
+		return fmt.Errorf("%s is disabled", cmd)
+	}
+	if e.sigVersion != SigVersionTapscript && opcode > Op16 {
+		if e.opCount++; e.opCount > MaxOpsPerScript {
+			return fmt.Errorf("more than %d operations", MaxOpsPerScript)
+		}
+	}
+	switch opcode {
+	case 99, 100:
+		// if, notif
+		value := false
+		if e.executing() {
+			if e.stack.Length < 1 {
+				return errors.New("OP_IF with an empty stack")
+			}
+			element := e.stack.pop()
+			// tapscript only takes an empty element or 1 (BIP342)
+			if e.sigVersion == SigVersionTapscript && (len(element) > 1 || len(element) == 1 && element[0] != 1) {
+				return fmt.Errorf("%s argument must be empty or 1 in tapscript", cmd)
+			}
+			value = castToBool(element)
+			if opcode == 100 {
+				value = !value
+			}
+		}
+		e.condStack = append(e.condStack, value)
+		return nil
+	case 103:
+		// else
+		if len(e.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		e.condStack[len(e.condStack)-1] = !e.condStack[len(e.condStack)-1]
+		return nil
+	case 104:
+		// endif
+		if len(e.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		e.condStack = e.condStack[:len(e.condStack)-1]
+		return nil
+	}
+	if !e.executing() {
+		return nil
+	}
+	if cmd.IsData() {
+		e.stack.push(cmd.Data)
+		return nil
+	}
+	operation, ok := opCodeFunctions[opcode]
+	switch opcode {
+	case 106:
+		return errors.New("OP_RETURN")
+	case 107:
+		// stack to altstack
+		if e.stack.Length < 1 {
+			return errors.New("OP_TOALTSTACK with an empty stack")
+		}
+		e.altStack.push(e.stack.pop())
+	case 108:
+		if e.altStack.Length < 1 {
+			return errors.New("OP_FROMALTSTACK with an empty altstack")
+		}
+		e.stack.push(e.altStack.pop())
+	case 171:
+		// signatures commit to the script from the last OP_CODESEPARATOR run
+		e.codeSep = e.pc
+		e.codeSepPos = uint32(e.last.PC - e.start)
+	case 177, 178:
+		// OP_NOP2 and OP_NOP3 before BIP65 and BIP112
+		if opcode == 177 && e.flags&VerifyCheckLockTime == 0 || opcode == 178 && e.flags&VerifyCheckSequence == 0 {
+			return nil
+		}
+		return e.checkTimelock(cmd)
+	case 172, 173, 174, 175, 186:
+		// Signing operations.
+		if e.sigVersion == SigVersionTapscript {
+			return e.executeTapscriptSigOp(cmd)
+		}
+		sigOperation, ok := sigOpFunctions[opcode]
+		if !ok {
+			return fmt.Errorf("%s is not supported in %s", cmd, e.phase)
+		}
+		if opcode == 174 || opcode == 175 {
+			// each key counts as an operation
+			if e.stack.Length > 0 && len(e.stack.peek()) <= maxNumSize {
+				if n := decodeNum(e.stack.peek()); n >= 0 && n <= MaxMultisigKeys {
+					if e.opCount += n; e.opCount > MaxOpsPerScript {
+						return fmt.Errorf("more than %d operations", MaxOpsPerScript)
+					}
+				}
+			}
+		}
+		if !sigOperation(e.stack, &ecdsaChecker{flags: e.flags, sigHash: e.ecdsaSigHash}) {
+			return fmt.Errorf("%s failed", cmd)
+		}
+	default:
+		if !ok {
+			return fmt.Errorf("%s is not supported", cmd)
+		}
+		if !operation(e.stack) {
+			return fmt.Errorf("%s failed", cmd)
+		}
+	}
+	return nil
+}
+
+// executeTapscriptSigOp runs a signing operation in tapscript,
+// where each signature checked uses up some of the validation weight budget (BIP342).
+func (e *Engine) executeTapscriptSigOp(cmd Command) error {
+	sigOperation, ok := tapscriptSigOpFunctions[int(cmd.Opcode)]
+	if !ok {
+		return fmt.Errorf("%s is not supported in %s", cmd, e.phase)
+	}
+	// the signature is under the key, and under the number for OP_CHECKSIGADD
+	sigDepth := 1
+	if cmd.Opcode == OpCheckSigAdd {
+		sigDepth = 2
+	}
+	if e.stack.Length > sigDepth && len(e.stack.at(sigDepth)) > 0 {
+		if e.budget -= validationWeightPerSigOp; e.budget < 0 {
+			return errors.New("validation weight budget exceeded")
+		}
+	}
+	if !sigOperation(e.stack, e.sigHash) {
+		return fmt.Errorf("%s failed", cmd)
+	}
+	return nil
+}
+
+// sigHash returns the hash a signature with the given hash type commits to,
+// or nil if the hash type is not valid.
+func (e *Engine) sigHash(hashType uint32) []byte {
+	return e.scriptCodeSigHash(hashType, &Script{cmds: e.program[e.codeSep:e.end]})
+}
+
+// ecdsaSigHash returns the hash an ECDSA signature with the given hash type commits to,
+// or nil if the hash type is not valid. Legacy scripts sign their script without
+// the OP_CODESEPARATORs and the signatures being checked.
+func (e *Engine) ecdsaSigHash(hashType uint32, sigs [][]byte) []byte {
+	if e.sigVersion != SigVersionBase {
+		return e.sigHash(hashType)
+	}
+	var cmds []Command
+	for _, cmd := range e.program[e.codeSep:e.end] {
+		if cmd.Opcode == OpCodeSeparator || isSignaturePush(cmd, sigs) {
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+	return e.scriptCodeSigHash(hashType, &Script{cmds: cmds})
+}
+
+func (e *Engine) scriptCodeSigHash(hashType uint32, scriptCode *Script) []byte {
+	if e.checker == nil {
+		return e.z
+	}
+	z, err := e.checker.SigHash(hashType, e.sigVersion, scriptCode, e.leafHash, e.codeSepPos)
+	if err != nil {
+		return nil
+	}
+	return z
+}
+
+// isSignaturePush returns whether a command pushes one of sigs with the shortest push opcode,
+// which is how Bitcoin Core finds the signatures to take out of a legacy script.
+func isSignaturePush(cmd Command, sigs [][]byte) bool {
+	if !cmd.IsData() {
+		return false
+	}
+	length := len(cmd.Data)
+	switch {
+	case length < OpPushData1:
+		if int(cmd.Opcode) != length {
+			return false
+		}
+	case length <= 0xff:
+		if cmd.Opcode != OpPushData1 {
+			return false
+		}
+	case length <= 0xffff:
+		if cmd.Opcode != OpPushData2 {
+			return false
+		}
+	default:
+		if cmd.Opcode != OpPushData4 {
+			return false
+		}
+	}
+	for _, sig := range sigs {
+		if len(sig) > 0 && bytes.Equal(cmd.Data, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTimelock runs OP_CHECKLOCKTIMEVERIFY or OP_CHECKSEQUENCEVERIFY.
+// The value is left on the stack.
+func (e *Engine) checkTimelock(cmd Command) error {
+	if e.stack.Length < 1 {
+		return fmt.Errorf("%s with an empty stack", cmd)
+	}
+	element := e.stack.peek()
+	// lock times are 5 byte numbers so they can go past 2^31
+	if len(element) > 5 {
+		return fmt.Errorf("%s: number too long", cmd)
+	}
+	value := int64(decodeNum(element))
+	if value < 0 {
+		return fmt.Errorf("%s: negative lock time", cmd)
+	}
+	if cmd.Opcode == OpCheckSequenceVerify && value&(1<<31) != 0 {
+		// the disable flag makes it a NOP
+		return nil
+	}
+	if e.checker == nil {
+		return fmt.Errorf("%s needs a transaction to check", cmd)
+	}
+	var satisfied bool
+	if cmd.Opcode == OpCheckLockTimeVerify {
+		satisfied = e.checker.CheckLockTime(value)
+	} else {
+		satisfied = e.checker.CheckSequence(value)
+	}
+	if !satisfied {
+		return fmt.Errorf("%s: lock time not reached", cmd)
+	}
+	return nil
+}
+
+// advance moves on to the next script once the current one is exhausted,
+// finishing the evaluation when there is nothing left to run.
+func (e *Engine) advance() error {
+	for !e.done && e.pc == e.end {
+		if len(e.condStack) > 0 {
+			return e.fail(fmt.Errorf("%s: unbalanced conditional", e.phase))
+		}
+		e.altStack = newOpStack(nil)
+		var err error
+		switch e.phase {
+		case ScriptSigPhase:
+			if e.scriptPubKey.IsP2shScriptPubKey() {
+				e.p2shStack = e.stack.items()
+			}
+			err = e.startPhase(ScriptPubKeyPhase, nil)
+		case ScriptPubKeyPhase:
+			err = e.endScriptPubKey()
+		case RedeemScriptPhase:
+			if err = e.checkResult(); err == nil {
+				e.finish()
+			}
+		case WitnessScriptPhase, TapscriptPhase:
+			if err = e.checkResult(); err == nil && e.stack.Length != 1 {
+				err = errors.New("witness script must leave a clean stack")
+			}
+			if err == nil {
+				e.finish()
+			}
+		}
+		if err != nil {
+			return e.fail(fmt.Errorf("%s: %v", e.phase, err))
+		}
+	}
+	return e.err
+}
+
+func (e *Engine) startPhase(phase string, cmds []Command) error {
+	e.phase = phase
+	e.start = e.pc
+	e.codeSep = e.pc
+	e.codeSepPos = 0xffffffff
+	e.opCount = 0
+	e.program = append(e.program, cmds...)
+	e.end = len(e.program)
+	return e.checkScriptSize()
+}
+
+// checkScriptSize checks the script about to run is at most MaxScriptSize bytes,
+// a limit tapscript doesn't have.
+func (e *Engine) checkScriptSize() error {
+	if e.sigVersion == SigVersionTapscript {
+		return nil
+	}
+	script := &Script{cmds: e.program[e.start:e.end]}
+	if size := len(script.RawSerialize()); size > MaxScriptSize {
+		return fmt.Errorf("script of %d bytes is over %d", size, MaxScriptSize)
+	}
+	return nil
+}
+
+func (e *Engine) finish() {
+	if e.flags&VerifyWitness != 0 && len(e.witness) > 0 && !e.witnessUsed {
+		e.fail(errors.New("witness provided for a non-witness script"))
+		return
+	}
+	e.done = true
+}
+
+func (e *Engine) checkResult() error {
+	if e.stack.Length == 0 || !castToBool(e.stack.peek()) {
+		return errors.New("script evaluated to false")
+	}
+	return nil
+}
+
+func (e *Engine) endScriptPubKey() error {
+	if err := e.checkResult(); err != nil {
+		return err
+	}
+	scriptSigLength := len(e.program) - len(e.scriptPubKey.cmds)
+	if version, program, ok := e.scriptPubKey.WitnessProgram(); ok && e.flags&VerifyWitness != 0 {
+		if scriptSigLength != 0 {
+			return errors.New("scriptSig must be empty for a witness program")
+		}
+		return e.startWitness(version, program, false)
+	}
+	if e.p2shStack == nil || e.flags&VerifyP2SH == 0 {
+		e.finish()
+		return nil
+	}
+	// BIP16: run the redeem script against the stack left by the scriptSig
+	scriptSig := &Script{cmds: e.program[:scriptSigLength]}
+	if !scriptSig.IsPushOnly() {
+		return errors.New("scriptSig must be push only")
+	}
+	if len(e.p2shStack) == 0 {
+		return errors.New("missing redeem script")
+	}
+	e.stack = newOpStack(e.p2shStack)
+	redeemScript, err := ParseRaw(e.stack.pop())
+	if err != nil {
+		return err
+	}
+	if version, program, ok := redeemScript.WitnessProgram(); ok && e.flags&VerifyWitness != 0 {
+		if scriptSigLength != 1 {
+			return errors.New("scriptSig must only push the redeem script for a nested witness program")
+		}
+		return e.startWitness(version, program, true)
+	}
+	return e.startPhase(RedeemScriptPhase, redeemScript.cmds)
+}
+
+// startWitness runs a witness program, nested in p2sh or not.
+func (e *Engine) startWitness(version int, program []byte, nested bool) error {
+	e.witnessUsed = true
+	if version == 1 && len(program) == 32 && !nested && e.flags&VerifyTaproot != 0 {
+		return e.startTaproot(program)
+	}
+	if version != 0 {
+		// unknown witness versions are left for future soft forks
+		e.done = true
+		return nil
+	}
+	e.sigVersion = SigVersionWitnessV0
+	witness := e.witness
+	var cmds []Command
+	switch len(program) {
+	case 20:
+		// p2wpkh: run the witness through the equivalent p2pkh script
+		if len(witness) != 2 {
+			return errors.New("p2wpkh witness must have two items")
+		}
+		cmds = P2pkhScript(program).cmds
+	case 32:
+		// p2wsh: the last witness item is the witness script
+		if len(witness) == 0 {
+			return errors.New("empty p2wsh witness")
+		}
+		witnessScript := witness[len(witness)-1]
+		witness = witness[:len(witness)-1]
+		h := sha256.Sum256(witnessScript)
+		if !bytes.Equal(h[:], program) {
+			return errors.New("witness script does not match the program hash")
+		}
+		parsed, err := ParseRaw(witnessScript)
+		if err != nil {
+			return err
+		}
+		cmds = parsed.cmds
+	default:
+		return fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	if err := checkWitnessElements(witness); err != nil {
+		return err
+	}
+	e.stack = newOpStack(witness)
+	return e.startPhase(WitnessScriptPhase, cmds)
+}
+
+// checkWitnessElements checks the witness stack a script starts with has no element
+// over MaxScriptElementSize.
+func checkWitnessElements(witness [][]byte) error {
+	for _, item := range witness {
+		if len(item) > MaxScriptElementSize {
+			return fmt.Errorf("witness element of %d bytes is over %d", len(item), MaxScriptElementSize)
+		}
+	}
+	return nil
+}
+
+// startTaproot checks a taproot key path spend or starts a script path spend (BIP341).
+func (e *Engine) startTaproot(program []byte) error {
+	witness := e.witness
+	if len(witness) == 0 {
+		return errors.New("empty taproot witness")
+	}
+	if last := witness[len(witness)-1]; len(witness) > 1 && len(last) > 0 && last[0] == annexTag {
+		// the annex is only committed to by the signature hash
+		witness = witness[:len(witness)-1]
+	}
+	if len(witness) == 1 {
+		e.sigVersion = SigVersionTaproot
+		if valid, _ := checkSchnorr(witness[0], program, e.sigHash); !valid {
+			return errors.New("invalid taproot key path signature")
+		}
+		e.done = true
+		return nil
+	}
+	rawScript := witness[len(witness)-2]
+	control := witness[len(witness)-1]
+	if len(control) < 33 || (len(control)-33)%32 != 0 || len(control) > 33+128*32 {
+		return fmt.Errorf("invalid control block length %d", len(control))
+	}
+	leafVersion := control[0] &^ 1
+	leafHash := util.TaggedHash("TapLeaf", append(append([]byte{leafVersion}, util.EncodeVarInt(len(rawScript))...), rawScript...))
+	merkleRoot := leafHash
+	for i := 33; i < len(control); i += 32 {
+		merkleRoot = TapBranchHash(merkleRoot, control[i:i+32])
+	}
+	internalKey, err := ecc.ParseXOnly(control[1:33])
+	if err != nil {
+		return err
+	}
+	outputKey, err := TaprootOutputKey(internalKey, merkleRoot)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(outputKey.XOnly(), program) || outputKey.HasEvenY() != (control[0]&1 == 0) {
+		return errors.New("control block does not commit to the script")
+	}
+	if leafVersion != TapscriptLeafVersion {
+		// unknown leaf versions are left for future soft forks
+		e.done = true
+		return nil
+	}
+	tapscript, err := ParseRaw(rawScript)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range tapscript.cmds {
+		if !cmd.IsData() && isSuccessOpcode(cmd.Opcode) {
+			e.done = true
+			return nil
+		}
+	}
+	stack := witness[:len(witness)-2]
+	if len(stack) > MaxStackSize {
+		return fmt.Errorf("more than %d stack elements", MaxStackSize)
+	}
+	if err := checkWitnessElements(stack); err != nil {
+		return err
+	}
+	e.sigVersion = SigVersionTapscript
+	e.leafHash = leafHash
+	// the budget counts the whole witness, annex included
+	e.budget = validationWeightOffset + len(util.EncodeVarInt(len(e.witness)))
+	for _, item := range e.witness {
+		e.budget += len(util.EncodeVarInt(len(item))) + len(item)
+	}
+	e.stack = newOpStack(stack)
+	return e.startPhase(TapscriptPhase, tapscript.cmds)
+}
@@ -0,0 +1,585 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestEngine(t *testing.T) {
+	t.Run("Test conditional and altstack", func(t *testing.T) {
+		scriptPubKey := new(Script).
+			AppendOp(OpIf).AppendInt(2).AppendOp(OpElse).AppendInt(3).AppendOp(OpEndIf).
+			AppendOp(OpToAltStack).AppendOp(OpFromAltStack).AppendInt(2).AppendOp(OpEqual)
+		engine := NewEngine(new(Script).AppendInt(1), scriptPubKey, nil, nil)
+		trace, err := engine.Trace()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(trace) != 10 {
+			t.Fatalf("Expected 10 steps, got %d", len(trace))
+		}
+		ifState := trace[1]
+		if ifState.Phase != ScriptPubKeyPhase || ifState.Command.Opcode != OpIf {
+			t.Errorf("Expected OP_IF in the scriptPubKey, got %v in %s", ifState.Command, ifState.Phase)
+		}
+		if len(ifState.CondStack) != 1 || !ifState.CondStack[0] {
+			t.Errorf("Expected [true], got %v", ifState.CondStack)
+		}
+		elseState := trace[3]
+		if len(elseState.CondStack) != 1 || elseState.CondStack[0] {
+			t.Errorf("Expected [false], got %v", elseState.CondStack)
+		}
+		altState := trace[6]
+		if len(altState.Stack) != 0 || len(altState.AltStack) != 1 || decodeNum(altState.AltStack[0]) != 2 {
+			t.Errorf("Expected 2 on the altstack, got %v and %v", altState.Stack, altState.AltStack)
+		}
+		if trace[9].PC != 9 {
+			t.Errorf("Expected pc 9, got %d", trace[9].PC)
+		}
+	})
+
+	t.Run("Test unbalanced conditional", func(t *testing.T) {
+		scriptPubKey := new(Script).AppendInt(1).AppendOp(OpIf).AppendInt(1)
+		if err := NewEngine(new(Script), scriptPubKey, nil, nil).Run(); err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+
+	t.Run("Test step", func(t *testing.T) {
+		engine := NewEngine(new(Script).AppendInt(1), new(Script).AppendOp(OpVerify).AppendInt(0), nil, nil)
+		if state := engine.State(); state.PC != -1 || len(state.Stack) != 0 {
+			t.Errorf("Unexpected initial state %v", state)
+		}
+		if err := engine.Step(); err != nil || engine.Done() {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if state := engine.State(); len(state.Stack) != 1 {
+			t.Errorf("Expected 1 item on the stack, got %d", len(state.Stack))
+		}
+		engine.Step()
+		if err := engine.Step(); err == nil || !engine.Done() {
+			t.Errorf("Expected the script to evaluate to false")
+		}
+	})
+
+	t.Run("Test p2sh multisig", func(t *testing.T) {
+		z := util.HexStringToBytes(`e71bfa115715d6fd33796948126f40a8cdd39f187e4afb03896795189fe1423c`)
+		sig1 := util.HexStringToBytes(`3045022100dc92655fe37036f47756db8102e0d7d5e28b3beb83a8fef4f5dc0559bddfb94e02205a36d4e4e6c7fcd16658c50783e00c341609977aed3ad00937bf4ee942a8993701`)
+		sig2 := util.HexStringToBytes(`3045022100da6bee3c93766232079a01639d07fa869598749729ae323eab8eef53577d611b02207bef15429dcadce2121ea07f233115c6f09034c0be68db99980b9a6c5e75402201`)
+		sec1 := util.HexStringToBytes(`022626e955ea6ea6d98850c994f9107b036b1334f18ca8830bfff1295d21cfdb70`)
+		sec2 := util.HexStringToBytes(`03b287eaf122eea69030a0e9feed096bed8045c8b98bec453e1ffac7fbdbd4bb71`)
+		redeemScript := new(Script).AppendInt(2).AppendData(sec1).AppendData(sec2).AppendInt(2).AppendOp(OpCheckMultiSig)
+		scriptSig := new(Script).AppendOp(Op0).AppendData(sig1).AppendData(sig2).AppendData(redeemScript.RawSerialize())
+		scriptPubKey := new(Script).AppendOp(OpHash160).AppendData(util.Hash160(redeemScript.RawSerialize())).AppendOp(OpEqual)
+		engine := NewEngine(scriptSig, scriptPubKey, nil, z)
+		trace, err := engine.Trace()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		last := trace[len(trace)-1]
+		if last.Phase != RedeemScriptPhase || last.Command.Opcode != OpCheckMultiSig {
+			t.Errorf("Expected to finish with OP_CHECKMULTISIG in the redeem script, got %v in %s", last.Command, last.Phase)
+		}
+		badZ := make([]byte, 32)
+		if err := NewEngine(scriptSig, scriptPubKey, nil, badZ).Run(); err == nil {
+			t.Errorf("Expected the signatures to fail")
+		}
+	})
+
+	t.Run("Test p2wpkh and p2wsh", func(t *testing.T) {
+		z := util.HexStringToBytes(`7c076ff316692a3d7eb3c3bb0f8b1488cf72e1afcd929e29307032997a838a3d`)
+		sec := util.HexStringToBytes(`04887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34`)
+		sig := util.HexStringToBytes(`3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab601`)
+		p2wpkh := new(Script).AppendOp(Op0).AppendData(util.Hash160(sec))
+		if err := NewEngine(new(Script), p2wpkh, [][]byte{sig, sec}, z).Run(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		witnessScript := new(Script).AppendData(sec).AppendOp(OpCheckSig).RawSerialize()
+		h := sha256.Sum256(witnessScript)
+		p2wsh := new(Script).AppendOp(Op0).AppendData(h[:])
+		if err := NewEngine(new(Script), p2wsh, [][]byte{sig, witnessScript}, z).Run(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if err := NewEngine(new(Script), p2wsh, [][]byte{sig, sig, witnessScript}, z).Run(); err == nil {
+			t.Errorf("Expected an unclean stack to fail")
+		}
+	})
+
+	t.Run("Test single byte push", func(t *testing.T) {
+		raw := util.HexStringToBytes(`016487`)
+		s, err := ParseRaw(raw)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !s.cmds[0].IsData() || s.cmds[1].IsData() {
+			t.Errorf("Expected a data push followed by an opcode, got %v", s)
+		}
+		if !bytes.Equal(s.RawSerialize(), raw) {
+			t.Errorf("Expected %x, got %x", raw, s.RawSerialize())
+		}
+	})
+
+	t.Run("Test hash types", func(t *testing.T) {
+		checker := &testChecker{}
+		pk := ecc.NewPrivateKey(big.NewInt(4001))
+		sec := pk.Point.Sec(true)
+		scriptPubKey := new(Script).AppendData(sec).AppendOp(OpCheckSig)
+		for _, hashType := range []uint32{util.SigHashAll, util.SigHashSingle | util.SigHashAnyoneCanPay} {
+			z, _ := checker.SigHash(hashType, SigVersionBase, scriptPubKey, nil, 0xffffffff)
+			sig := append(pk.Sign(new(big.Int).SetBytes(z)).Der(), byte(hashType))
+			engine := NewEngine(new(Script).AppendData(sig), scriptPubKey, nil, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); err != nil {
+				t.Errorf("Unexpected error for hash type %d: %v", hashType, err)
+			}
+			// the hash type byte is part of what is signed
+			sig[len(sig)-1] = byte(util.SigHashNone)
+			engine = NewEngine(new(Script).AppendData(sig), scriptPubKey, nil, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); err == nil {
+				t.Errorf("Expected an error for a changed hash type")
+			}
+		}
+	})
+
+	t.Run("Test taproot key path", func(t *testing.T) {
+		checker := &testChecker{}
+		pk := ecc.NewPrivateKey(big.NewInt(4002))
+		scriptPubKey := P2trScript(pk.Point.XOnly())
+		z, _ := checker.SigHash(util.SigHashDefault, SigVersionTaproot, nil, nil, 0xffffffff)
+		sig, _ := pk.SignSchnorr(z, nil)
+		tests := []struct {
+			witness [][]byte
+			valid   bool
+		}{
+			{[][]byte{sig}, true},
+			{[][]byte{sig, {annexTag, 1}}, true},
+			{[][]byte{append(sig, byte(util.SigHashAll))}, false},
+			{[][]byte{append(sig, byte(util.SigHashDefault))}, false},
+			{[][]byte{{}}, false},
+		}
+		for i, test := range tests {
+			engine := NewEngine(new(Script), scriptPubKey, test.witness, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); (err == nil) != test.valid {
+				t.Errorf("Test %d: expected valid %v, got %v", i, test.valid, err)
+			}
+		}
+		z, _ = checker.SigHash(util.SigHashAll, SigVersionTaproot, nil, nil, 0xffffffff)
+		sig, _ = pk.SignSchnorr(z, nil)
+		engine := NewEngine(new(Script), scriptPubKey, [][]byte{append(sig, byte(util.SigHashAll))}, nil)
+		engine.SetChecker(checker)
+		if err := engine.Run(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test taproot script path", func(t *testing.T) {
+		checker := &testChecker{}
+		internal := ecc.NewPrivateKey(big.NewInt(4003))
+		keys := []*ecc.PrivateKey{ecc.NewPrivateKey(big.NewInt(4004)), ecc.NewPrivateKey(big.NewInt(4005))}
+		// 2-of-2 with OP_CHECKSIGADD
+		leaf := new(Script).
+			AppendData(keys[0].Point.XOnly()).AppendOp(OpCheckSig).
+			AppendData(keys[1].Point.XOnly()).AppendOp(OpCheckSigAdd).
+			AppendInt(2).AppendOp(OpNumEqual)
+		other := TapLeafHash(TapscriptLeafVersion, new(Script).AppendOp(Op0))
+		leafHash := TapLeafHash(TapscriptLeafVersion, leaf)
+		outputKey, _ := TaprootOutputKey(internal.Point, TapBranchHash(leafHash, other))
+		scriptPubKey := P2trScript(outputKey.XOnly())
+		control := []byte{TapscriptLeafVersion}
+		if !outputKey.HasEvenY() {
+			control[0]++
+		}
+		control = append(append(control, internal.Point.XOnly()...), other...)
+		z, _ := checker.SigHash(util.SigHashDefault, SigVersionTapscript, nil, leafHash, 0xffffffff)
+		sig0, _ := keys[0].SignSchnorr(z, nil)
+		sig1, _ := keys[1].SignSchnorr(z, nil)
+		tests := []struct {
+			stack [][]byte
+			valid bool
+		}{
+			{[][]byte{sig1, sig0}, true},
+			{[][]byte{{}, sig0}, false},
+			{[][]byte{sig0, sig1}, false},
+		}
+		for i, test := range tests {
+			witness := append(test.stack, leaf.RawSerialize(), control)
+			engine := NewEngine(new(Script), scriptPubKey, witness, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); (err == nil) != test.valid {
+				t.Errorf("Test %d: expected valid %v, got %v", i, test.valid, err)
+			}
+		}
+		control[len(control)-1] ^= 1
+		engine := NewEngine(new(Script), scriptPubKey, [][]byte{sig1, sig0, leaf.RawSerialize(), control}, nil)
+		engine.SetChecker(checker)
+		if err := engine.Run(); err == nil {
+			t.Errorf("Expected an error for a wrong control block")
+		}
+	})
+
+	t.Run("Test timelocks", func(t *testing.T) {
+		checker := &testChecker{lockTime: 600000, sequence: 144}
+		tests := []struct {
+			opcode byte
+			value  int
+			valid  bool
+		}{
+			{OpCheckLockTimeVerify, 600000, true},
+			{OpCheckLockTimeVerify, 600001, false},
+			{OpCheckSequenceVerify, 144, true},
+			{OpCheckSequenceVerify, 145, false},
+			{OpCheckSequenceVerify, -1, false},
+			{OpCheckSequenceVerify, 1 << 31, true},
+		}
+		for _, test := range tests {
+			scriptPubKey := new(Script).AppendInt(test.value).AppendOp(test.opcode)
+			engine := NewEngine(new(Script), scriptPubKey, nil, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); (err == nil) != test.valid {
+				t.Errorf("%s: expected valid %v, got %v", scriptPubKey, test.valid, err)
+			}
+		}
+		scriptPubKey := new(Script).AppendInt(1).AppendOp(OpCheckLockTimeVerify)
+		if err := NewEngine(new(Script), scriptPubKey, nil, nil).Run(); err == nil {
+			t.Errorf("Expected an error without a checker")
+		}
+	})
+
+	t.Run("Test push size limit", func(t *testing.T) {
+		for _, size := range []int{MaxScriptElementSize, MaxScriptElementSize + 1} {
+			// pushes are checked in branches that aren't run too
+			scriptPubKey := new(Script).AppendInt(0).AppendOp(OpIf).
+				AppendData(make([]byte, size)).AppendOp(OpEndIf).AppendInt(1)
+			if err := NewEngine(new(Script), scriptPubKey, nil, nil).Run(); (err == nil) != (size <= MaxScriptElementSize) {
+				t.Errorf("Push of %d bytes: unexpected result %v", size, err)
+			}
+		}
+	})
+
+	t.Run("Test operation limit", func(t *testing.T) {
+		nops := func(scr *Script, n int) *Script {
+			for i := 0; i < n; i++ {
+				scr.AppendOp(OpNop)
+			}
+			return scr
+		}
+		multisig := new(Script).AppendInt(0).AppendInt(0)
+		for i := 0; i < MaxMultisigKeys; i++ {
+			multisig.AppendInt(i + 1)
+		}
+		multisig.AppendInt(MaxMultisigKeys).AppendOp(OpCheckMultiSig)
+		tests := []struct {
+			scriptSig, scriptPubKey *Script
+			valid                   bool
+		}{
+			{new(Script), nops(new(Script), MaxOpsPerScript).AppendInt(1), true},
+			{new(Script), nops(new(Script), MaxOpsPerScript+1).AppendInt(1), false},
+			// OP_IF and OP_ENDIF count, and so do the operations they skip
+			{new(Script), nops(new(Script).AppendInt(0).AppendOp(OpIf), MaxOpsPerScript-2).AppendOp(OpEndIf).AppendInt(1), true},
+			{new(Script), nops(new(Script).AppendInt(0).AppendOp(OpIf), MaxOpsPerScript-1).AppendOp(OpEndIf).AppendInt(1), false},
+			// the keys of OP_CHECKMULTISIG count
+			{new(Script), nops(new(Script), MaxOpsPerScript-MaxMultisigKeys-1).Add(nops(new(Script), MaxOpsPerScript-MaxMultisigKeys-1), multisig), true},
+			{new(Script), nops(new(Script), MaxOpsPerScript-MaxMultisigKeys).Add(nops(new(Script), MaxOpsPerScript-MaxMultisigKeys), multisig), false},
+			// each script has its own count
+			{nops(new(Script), MaxOpsPerScript), nops(new(Script), MaxOpsPerScript).AppendInt(1), true},
+		}
+		for i, test := range tests {
+			if err := NewEngine(test.scriptSig, test.scriptPubKey, nil, nil).Run(); (err == nil) != test.valid {
+				t.Errorf("Test %d: expected valid %v, got %v", i, test.valid, err)
+			}
+		}
+	})
+
+	t.Run("Test stack size limit", func(t *testing.T) {
+		pushes := func(n int) *Script {
+			result := new(Script)
+			for i := 0; i < n; i++ {
+				result.AppendInt(1)
+			}
+			return result
+		}
+		tests := []struct {
+			scriptSig, scriptPubKey *Script
+			valid                   bool
+		}{
+			{pushes(MaxStackSize - 1), new(Script).AppendInt(1), true},
+			{pushes(MaxStackSize), new(Script).AppendInt(1), false},
+			// the altstack counts too
+			{pushes(MaxStackSize - 1), new(Script).AppendOp(OpToAltStack).AppendInt(1).AppendInt(1), false},
+		}
+		for i, test := range tests {
+			if err := NewEngine(test.scriptSig, test.scriptPubKey, nil, nil).Run(); (err == nil) != test.valid {
+				t.Errorf("Test %d: expected valid %v, got %v", i, test.valid, err)
+			}
+		}
+	})
+
+	t.Run("Test script size limit", func(t *testing.T) {
+		for _, last := range []int{41, 42} {
+			scriptPubKey := new(Script)
+			for i := 0; i < 19; i++ {
+				scriptPubKey.AppendData(make([]byte, MaxScriptElementSize)).AppendOp(OpDrop)
+			}
+			scriptPubKey.AppendData(make([]byte, last)).AppendOp(OpDrop).AppendInt(1)
+			size := len(scriptPubKey.RawSerialize())
+			if err := NewEngine(new(Script), scriptPubKey, nil, nil).Run(); (err == nil) != (size <= MaxScriptSize) {
+				t.Errorf("Script of %d bytes: unexpected result %v", size, err)
+			}
+		}
+	})
+
+	t.Run("Test disabled opcodes", func(t *testing.T) {
+		tests := []struct {
+			opcode byte
+			valid  bool
+		}{
+			{0x7e, false}, // OP_CAT
+			{0x8d, false}, // OP_2MUL
+			{0x95, false}, // OP_MUL
+			{0x65, false}, // OP_VERIF
+			{0x50, true},  // OP_RESERVED only fails when run
+		}
+		for _, test := range tests {
+			scriptPubKey := new(Script).AppendInt(0).AppendOp(OpIf).AppendOp(test.opcode).AppendOp(OpEndIf).AppendInt(1)
+			if err := NewEngine(new(Script), scriptPubKey, nil, nil).Run(); (err == nil) != test.valid {
+				t.Errorf("%#x in a branch not run: expected valid %v, got %v", test.opcode, test.valid, err)
+			}
+		}
+		if err := NewEngine(new(Script), new(Script).AppendInt(1).AppendOp(0x50), nil, nil).Run(); err == nil {
+			t.Errorf("Expected OP_RESERVED to fail")
+		}
+	})
+
+	t.Run("Test upgradable nops", func(t *testing.T) {
+		scriptPubKey := new(Script)
+		for _, opcode := range []byte{OpNop1, OpNop4, OpNop5, OpNop6, OpNop7, OpNop8, OpNop9, OpNop10} {
+			scriptPubKey.AppendOp(opcode)
+		}
+		if err := NewEngine(new(Script), scriptPubKey.AppendInt(1), nil, nil).Run(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test flags", func(t *testing.T) {
+		pk := ecc.NewPrivateKey(big.NewInt(4011))
+		z := util.Sha256([]byte("flags"))
+		der := pk.Sign(new(big.Int).SetBytes(z)).Der()
+		// pad R with a zero it doesn't need, which only lax DER parsing takes
+		padded := append([]byte{0x30, der[1] + 1, 0x02, der[3] + 1, 0}, der[4:]...)
+		falseRedeem := new(Script).AppendInt(0).RawSerialize()
+		tests := []struct {
+			name                    string
+			scriptSig, scriptPubKey *Script
+			witness                 [][]byte
+			flag                    Flags
+		}{
+			{"p2sh", new(Script).AppendData(falseRedeem), P2shScript(util.Hash160(falseRedeem)), nil, VerifyP2SH},
+			{"witness", new(Script), P2wshScript(util.Sha256(nil)), nil, VerifyWitness},
+			{"taproot", new(Script), P2trScript(pk.Point.XOnly()), [][]byte{{1}}, VerifyTaproot},
+			{"checklocktimeverify", new(Script), new(Script).AppendInt(1).AppendOp(OpCheckLockTimeVerify), nil, VerifyCheckLockTime},
+			{"checksequenceverify", new(Script), new(Script).AppendInt(1).AppendOp(OpCheckSequenceVerify), nil, VerifyCheckSequence},
+			{"nulldummy", new(Script).AppendInt(1), new(Script).AppendInt(0).AppendInt(0).AppendOp(OpCheckMultiSig), nil, VerifyNullDummy},
+			{"dersig", new(Script).AppendData(append(padded, byte(util.SigHashAll))), new(Script).AppendData(pk.Point.Sec(true)).AppendOp(OpCheckSig), nil, VerifyDERSig},
+		}
+		for _, test := range tests {
+			engine := NewEngine(test.scriptSig, test.scriptPubKey, test.witness, z)
+			engine.SetFlags(VerifyAll &^ test.flag)
+			if err := engine.Run(); err != nil {
+				t.Errorf("%s: unexpected error without the flag: %v", test.name, err)
+			}
+			if err := NewEngine(test.scriptSig, test.scriptPubKey, test.witness, z).Run(); err == nil {
+				t.Errorf("%s: expected an error with the flag", test.name)
+			}
+		}
+	})
+
+	t.Run("Test invalid keys and signatures", func(t *testing.T) {
+		pk := ecc.NewPrivateKey(big.NewInt(4012))
+		z := util.Sha256([]byte("invalid"))
+		sig := append(pk.Sign(new(big.Int).SetBytes(z)).Der(), byte(util.SigHashAll))
+		badKey := append([]byte{5}, pk.Point.XOnly()...)
+		// an invalid key or lax signature that can't be parsed only makes the signature invalid
+		tests := []struct {
+			flags    Flags
+			sig, key []byte
+		}{
+			{VerifyAll, sig, badKey},
+			{VerifyNone, []byte{0x30, 0x01, byte(util.SigHashAll)}, pk.Point.Sec(true)},
+		}
+		for i, test := range tests {
+			scriptPubKey := new(Script).AppendData(test.key).AppendOp(OpCheckSig).AppendOp(OpNot)
+			engine := NewEngine(new(Script).AppendData(test.sig), scriptPubKey, nil, z)
+			engine.SetFlags(test.flags)
+			if err := engine.Run(); err != nil {
+				t.Errorf("Test %d: unexpected error: %v", i, err)
+			}
+		}
+		// a hybrid key is another encoding of an uncompressed key
+		hybrid := pk.Point.Sec(false)
+		hybrid[0] = 6 | hybrid[64]&1
+		scriptPubKey := new(Script).AppendData(hybrid).AppendOp(OpCheckSig)
+		if err := NewEngine(new(Script).AppendData(sig), scriptPubKey, nil, z).Run(); err != nil {
+			t.Errorf("Unexpected error for a hybrid key: %v", err)
+		}
+	})
+
+	t.Run("Test code separator", func(t *testing.T) {
+		checker := &testChecker{}
+		pk := ecc.NewPrivateKey(big.NewInt(4013))
+		sec := pk.Point.Sec(true)
+		sign := func(scriptCode *Script) []byte {
+			z, _ := checker.SigHash(util.SigHashAll, SigVersionBase, scriptCode, nil, 0xffffffff)
+			return append(pk.Sign(new(big.Int).SetBytes(z)).Der(), byte(util.SigHashAll))
+		}
+		// signatures commit to the script after the last OP_CODESEPARATOR run,
+		// without the ones that aren't run
+		scriptPubKey := new(Script).AppendOp(OpCodeSeparator).AppendInt(0).AppendOp(OpIf).AppendOp(OpCodeSeparator).
+			AppendOp(OpEndIf).AppendData(sec).AppendOp(OpCheckSig)
+		scriptCode := new(Script).AppendInt(0).AppendOp(OpIf).AppendOp(OpEndIf).AppendData(sec).AppendOp(OpCheckSig)
+		for _, test := range []struct {
+			scriptCode *Script
+			valid      bool
+		}{{scriptCode, true}, {scriptPubKey, false}} {
+			engine := NewEngine(new(Script).AppendData(sign(test.scriptCode)), scriptPubKey, nil, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); (err == nil) != test.valid {
+				t.Errorf("Signing %s: expected valid %v, got %v", test.scriptCode, test.valid, err)
+			}
+		}
+		// the signature is taken out of the script it signs
+		sig := sign(new(Script).AppendOp(OpDrop).AppendData(sec).AppendOp(OpCheckSig))
+		scriptPubKey = new(Script).AppendData(sig).AppendOp(OpDrop).AppendData(sec).AppendOp(OpCheckSig)
+		engine := NewEngine(new(Script).AppendData(sig), scriptPubKey, nil, nil)
+		engine.SetChecker(checker)
+		if err := engine.Run(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test tapscript limits", func(t *testing.T) {
+		checker := &testChecker{}
+		pk := ecc.NewPrivateKey(big.NewInt(4014))
+		xOnly := pk.Point.XOnly()
+		sign := func(leaf *Script, codeSepPos uint32) []byte {
+			z, _ := checker.SigHash(util.SigHashDefault, SigVersionTapscript, nil, TapLeafHash(TapscriptLeafVersion, leaf), codeSepPos)
+			sig, _ := pk.SignSchnorr(z, nil)
+			return sig
+		}
+		items := func(n, size int) [][]byte {
+			result := make([][]byte, n)
+			for i := range result {
+				result[i] = make([]byte, size)
+			}
+			return result
+		}
+		minimalIf := new(Script).AppendOp(OpIf).AppendOp(OpElse).AppendOp(OpEndIf).AppendInt(1)
+		// drops takes n elements off the stack and leaves 1
+		drops := func(n int) *Script {
+			result := new(Script)
+			if n%2 == 1 {
+				result.AppendOp(OpDrop)
+			}
+			for i := 0; i < n/2; i++ {
+				result.AppendOp(Op2Drop)
+			}
+			return result.AppendInt(1)
+		}
+		codeSep := new(Script).AppendOp(OpCodeSeparator).AppendData(xOnly).AppendOp(OpCheckSig)
+		budget := func(checks int) *Script {
+			result := new(Script)
+			for i := 0; i < checks-1; i++ {
+				result.AppendOp(OpDup).AppendData(xOnly).AppendOp(OpCheckSigVerify)
+			}
+			return result.AppendData(xOnly).AppendOp(OpCheckSig)
+		}
+		tests := []struct {
+			name  string
+			leaf  *Script
+			stack [][]byte
+			valid bool
+		}{
+			{"OP_IF 1", minimalIf, [][]byte{{1}}, true},
+			{"OP_IF empty", minimalIf, [][]byte{{}}, true},
+			{"OP_IF 2", minimalIf, [][]byte{{2}}, false},
+			{"OP_IF 0", minimalIf, [][]byte{{0}}, false},
+			{"full stack", drops(MaxStackSize), items(MaxStackSize, 0), true},
+			{"stack too big", drops(MaxStackSize + 1), items(MaxStackSize+1, 0), false},
+			{"element too big", new(Script).AppendOp(OpSize).AppendOp(OpNip), items(1, MaxScriptElementSize+1), false},
+			{"code separator", codeSep, [][]byte{sign(codeSep, 0)}, true},
+			{"code separator not signed", codeSep, [][]byte{sign(codeSep, 0xffffffff)}, false},
+			{"within budget", budget(5), [][]byte{sign(budget(5), 0xffffffff)}, true},
+			{"over budget", budget(20), [][]byte{sign(budget(20), 0xffffffff)}, false},
+		}
+		for _, test := range tests {
+			scriptPubKey, witness := tapscriptSpend(test.leaf, test.stack)
+			engine := NewEngine(new(Script), scriptPubKey, witness, nil)
+			engine.SetChecker(checker)
+			if err := engine.Run(); (err == nil) != test.valid {
+				t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+			}
+		}
+	})
+
+	t.Run("Test witness element size limit", func(t *testing.T) {
+		witnessScript := new(Script).AppendOp(OpSize).AppendOp(OpNip).RawSerialize()
+		h := sha256.Sum256(witnessScript)
+		for _, size := range []int{MaxScriptElementSize, MaxScriptElementSize + 1} {
+			witness := [][]byte{make([]byte, size), witnessScript}
+			if err := NewEngine(new(Script), P2wshScript(h[:]), witness, nil).Run(); (err == nil) != (size <= MaxScriptElementSize) {
+				t.Errorf("Witness element of %d bytes: unexpected result %v", size, err)
+			}
+		}
+	})
+}
+
+// tapscriptSpend returns the scriptPubKey of a taproot output with leaf as its only script,
+// and the witness spending it with stack.
+func tapscriptSpend(leaf *Script, stack [][]byte) (*Script, [][]byte) {
+	internal := ecc.NewPrivateKey(big.NewInt(4015))
+	outputKey, _ := TaprootOutputKey(internal.Point, TapLeafHash(TapscriptLeafVersion, leaf))
+	control := []byte{TapscriptLeafVersion}
+	if !outputKey.HasEvenY() {
+		control[0]++
+	}
+	control = append(control, internal.Point.XOnly()...)
+	witness := append(append([][]byte{}, stack...), leaf.RawSerialize(), control)
+	return P2trScript(outputKey.XOnly()), witness
+}
+
+// testChecker compares timelocks against fixed values.
+// Signature hashes are the hash of the hash type followed by the script code before taproot,
+// or by the tapleaf hash and OP_CODESEPARATOR position in tapscript.
+type testChecker struct {
+	lockTime, sequence int64
+}
+
+func (c *testChecker) SigHash(hashType uint32, sigVersion SigVersion, scriptCode *Script, leafHash []byte, codeSepPos uint32) ([]byte, error) {
+	if sigVersion >= SigVersionTaproot && hashType > util.SigHashSingle|util.SigHashAnyoneCanPay {
+		return nil, fmt.Errorf("invalid hash type %d", hashType)
+	}
+	msg := []byte{byte(hashType)}
+	switch sigVersion {
+	case SigVersionBase, SigVersionWitnessV0:
+		msg = append(msg, scriptCode.RawSerialize()...)
+	case SigVersionTapscript:
+		msg = append(msg, leafHash...)
+		msg = append(msg, util.Int32ToLittleEndian(codeSepPos)...)
+	}
+	return util.Sha256(msg), nil
+}
+
+func (c *testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c *testChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
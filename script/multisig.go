@@ -0,0 +1,70 @@
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+)
+
+// MaxMultisigKeys is the most public keys OP_CHECKMULTISIG accepts.
+const MaxMultisigKeys = 20
+
+// SortPubKeys returns the public keys in BIP67 order:
+// lexicographically by their compressed SEC serialization.
+func SortPubKeys(pubKeys []*ecc.S256Point) []*ecc.S256Point {
+	result := make([]*ecc.S256Point, len(pubKeys))
+	copy(result, pubKeys)
+	sort.SliceStable(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Sec(true), result[j].Sec(true)) < 0
+	})
+	return result
+}
+
+// MultisigScript returns the m-of-n script OP_m <pubkeys> OP_n OP_CHECKMULTISIG
+// with compressed SEC public keys in the order given.
+func MultisigScript(m int, pubKeys []*ecc.S256Point) (*Script, error) {
+	n := len(pubKeys)
+	if n < 1 || n > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d keys, got %d", MaxMultisigKeys, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("multisig threshold %d out of range for %d keys", m, n)
+	}
+	result := new(Script).AppendInt(m)
+	for _, pubKey := range pubKeys {
+		result.AppendData(pubKey.Sec(true))
+	}
+	return result.AppendInt(n).AppendOp(OpCheckMultiSig), nil
+}
+
+// SortedMultisigScript returns the m-of-n script with the keys in BIP67 order.
+func SortedMultisigScript(m int, pubKeys []*ecc.S256Point) (*Script, error) {
+	return MultisigScript(m, SortPubKeys(pubKeys))
+}
+
+// Multisig returns the threshold and SEC public keys of an m-of-n script.
+// ok is false if the script is not a multisig script.
+func (scr *Script) Multisig() (m int, pubKeys [][]byte, ok bool) {
+	cmds := scr.cmds
+	length := len(cmds)
+	if length < 4 || cmds[length-1].Opcode != OpCheckMultiSig {
+		return 0, nil, false
+	}
+	m, err := cmds[0].Number()
+	if err != nil {
+		return 0, nil, false
+	}
+	n, err := cmds[length-2].Number()
+	if err != nil || n != length-3 || m < 1 || m > n {
+		return 0, nil, false
+	}
+	for _, cmd := range cmds[1 : length-2] {
+		if !cmd.IsData() || (len(cmd.Data) != 33 && len(cmd.Data) != 65) {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, cmd.Data)
+	}
+	return m, pubKeys, true
+}
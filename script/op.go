@@ -2,9 +2,10 @@ package script
 
 import (
 	"bytes"
-	"fmt"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
 	"math/big"
-	"os"
 
 	"github.com/ravdin/programmingbitcoin/ecc"
 	"github.com/ravdin/programmingbitcoin/util"
@@ -15,12 +16,56 @@ func op0(stack *opStack, args ...[][]byte) bool {
 	return true
 }
 
+func op1Negate(stack *opStack, args ...[][]byte) bool {
+	stack.push(encodeNum(-1))
+	return true
+}
+
+// opNumber returns the operation for OP_1 through OP_16.
+func opNumber(n int) opCodeFunction {
+	return func(stack *opStack, args ...[][]byte) bool {
+		stack.push(encodeNum(n))
+		return true
+	}
+}
+
+func opNop(stack *opStack, args ...[][]byte) bool {
+	return true
+}
+
 func opVerify(stack *opStack, args ...[][]byte) bool {
 	if stack.Length < 1 {
 		return false
 	}
 	elem := stack.pop()
-	return decodeNum(elem) != 0
+	return castToBool(elem)
+}
+
+func op2Drop(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	stack.pop()
+	stack.pop()
+	return true
+}
+
+func opIfdup(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	if castToBool(stack.peek()) {
+		stack.push(stack.peek())
+	}
+	return true
+}
+
+func opDrop(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	stack.pop()
+	return true
 }
 
 func opDup(stack *opStack, args ...[][]byte) bool {
@@ -31,6 +76,138 @@ func opDup(stack *opStack, args ...[][]byte) bool {
 	return true
 }
 
+func opSwap(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	item1 := stack.pop()
+	item2 := stack.pop()
+	stack.push(item1)
+	stack.push(item2)
+	return true
+}
+
+func op2Dup(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	stack.push(stack.at(1))
+	stack.push(stack.at(1))
+	return true
+}
+
+func op3Dup(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		stack.push(stack.at(2))
+	}
+	return true
+}
+
+func op2Over(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 4 {
+		return false
+	}
+	stack.push(stack.at(3))
+	stack.push(stack.at(3))
+	return true
+}
+
+func op2Rot(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 6 {
+		return false
+	}
+	stack.push(stack.remove(5))
+	stack.push(stack.remove(5))
+	return true
+}
+
+func op2Swap(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 4 {
+		return false
+	}
+	stack.push(stack.remove(3))
+	stack.push(stack.remove(3))
+	return true
+}
+
+func opDepth(stack *opStack, args ...[][]byte) bool {
+	stack.push(encodeNum(stack.Length))
+	return true
+}
+
+func opNip(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	stack.remove(1)
+	return true
+}
+
+func opOver(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	stack.push(stack.at(1))
+	return true
+}
+
+// opPick copies the element n deep to the top, n being popped from the top.
+func opPick(stack *opStack, args ...[][]byte) bool {
+	return pickOrRoll(stack, false)
+}
+
+// opRoll moves the element n deep to the top, n being popped from the top.
+func opRoll(stack *opStack, args ...[][]byte) bool {
+	return pickOrRoll(stack, true)
+}
+
+func pickOrRoll(stack *opStack, roll bool) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	n, ok := popNum(stack)
+	if !ok || n < 0 || n >= stack.Length {
+		return false
+	}
+	if roll {
+		stack.push(stack.remove(n))
+	} else {
+		stack.push(stack.at(n))
+	}
+	return true
+}
+
+func opRot(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 3 {
+		return false
+	}
+	stack.push(stack.remove(2))
+	return true
+}
+
+func opTuck(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	top := stack.pop()
+	second := stack.pop()
+	stack.push(top)
+	stack.push(second)
+	stack.push(top)
+	return true
+}
+
+func opSize(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	stack.push(encodeNum(len(stack.peek())))
+	return true
+}
+
 func opEqual(stack *opStack, args ...[][]byte) bool {
 	if stack.Length < 2 {
 		return false
@@ -49,6 +226,184 @@ func opEqualverify(stack *opStack, args ...[][]byte) bool {
 	return opEqual(stack) && opVerify(stack)
 }
 
+// maxNumSize is the most bytes a number operand can have, though results can be longer.
+const maxNumSize = 4
+
+// popNum pops a number operand from a stack that isn't empty.
+// Returns false if the number is too long.
+func popNum(stack *opStack) (int, bool) {
+	element := stack.pop()
+	if len(element) > maxNumSize {
+		return 0, false
+	}
+	return decodeNum(element), true
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unaryOp replaces the number on top of the stack with f of it.
+func unaryOp(stack *opStack, f func(a int) int) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	a, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	stack.push(encodeNum(f(a)))
+	return true
+}
+
+// binaryOp replaces the two numbers on top of the stack with f of them,
+// a being the deeper one.
+func binaryOp(stack *opStack, f func(a, b int) int) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	b, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	a, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	stack.push(encodeNum(f(a, b)))
+	return true
+}
+
+// compareOp replaces the two numbers on top of the stack with whether f holds for them,
+// a being the deeper one.
+func compareOp(stack *opStack, f func(a, b int) bool) bool {
+	return binaryOp(stack, func(a, b int) int { return boolToInt(f(a, b)) })
+}
+
+func op1Add(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int { return a + 1 })
+}
+
+func op1Sub(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int { return a - 1 })
+}
+
+func opNegate(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int { return -a })
+}
+
+func opAbs(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int {
+		if a < 0 {
+			return -a
+		}
+		return a
+	})
+}
+
+func opNot(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int { return boolToInt(a == 0) })
+}
+
+func op0Notequal(stack *opStack, args ...[][]byte) bool {
+	return unaryOp(stack, func(a int) int { return boolToInt(a != 0) })
+}
+
+func opAdd(stack *opStack, args ...[][]byte) bool {
+	return binaryOp(stack, func(a, b int) int { return a + b })
+}
+
+func opSub(stack *opStack, args ...[][]byte) bool {
+	return binaryOp(stack, func(a, b int) int { return a - b })
+}
+
+func opBoolAnd(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a != 0 && b != 0 })
+}
+
+func opBoolOr(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a != 0 || b != 0 })
+}
+
+func opNumEqual(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a == b })
+}
+
+func opNumEqualverify(stack *opStack, args ...[][]byte) bool {
+	return opNumEqual(stack) && opVerify(stack)
+}
+
+func opNumNotEqual(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a != b })
+}
+
+func opLessThan(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a < b })
+}
+
+func opGreaterThan(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a > b })
+}
+
+func opLessThanOrEqual(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a <= b })
+}
+
+func opGreaterThanOrEqual(stack *opStack, args ...[][]byte) bool {
+	return compareOp(stack, func(a, b int) bool { return a >= b })
+}
+
+func opMin(stack *opStack, args ...[][]byte) bool {
+	return binaryOp(stack, func(a, b int) int {
+		if b < a {
+			return b
+		}
+		return a
+	})
+}
+
+func opMax(stack *opStack, args ...[][]byte) bool {
+	return binaryOp(stack, func(a, b int) int {
+		if b > a {
+			return b
+		}
+		return a
+	})
+}
+
+// opWithin pushes whether x is at least min and less than max, for x min max on the stack.
+func opWithin(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 3 {
+		return false
+	}
+	max, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	min, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	x, ok := popNum(stack)
+	if !ok {
+		return false
+	}
+	stack.push(encodeNum(boolToInt(min <= x && x < max)))
+	return true
+}
+
+func opRipemd160(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	element := stack.pop()
+	stack.push(util.Ripemd160(element))
+	return true
+}
+
 func opHash160(stack *opStack, args ...[][]byte) bool {
 	if stack.Length < 1 {
 		return false
@@ -59,26 +414,133 @@ func opHash160(stack *opStack, args ...[][]byte) bool {
 	return true
 }
 
+func opSha1(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	h := sha1.Sum(stack.pop())
+	stack.push(h[:])
+	return true
+}
+
 func opSha256(stack *opStack, args ...[][]byte) bool {
-	panic("Not implemented")
+	if stack.Length < 1 {
+		return false
+	}
+	element := stack.pop()
+	h := sha256.Sum256(element)
+	stack.push(h[:])
+	return true
+}
+
+func opHash256(stack *opStack, args ...[][]byte) bool {
+	if stack.Length < 1 {
+		return false
+	}
+	element := stack.pop()
+	stack.push(util.Hash256(element))
+	return true
+}
+
+// sigHashFunc returns the hash signed by a signature with the given hash type,
+// or nil if the hash type is not valid.
+type sigHashFunc func(hashType uint32) []byte
+
+// ecdsaChecker gives the ECDSA signing operations the soft forks to enforce and
+// the hash each signature commits to.
+type ecdsaChecker struct {
+	flags Flags
+	// sigHash returns the hash a signature with the given hash type commits to, or nil if the
+	// hash type is not valid. sigs are the signatures the operation checks, which legacy
+	// scripts take out of the script they sign.
+	sigHash func(hashType uint32, sigs [][]byte) []byte
+}
+
+// check returns whether a DER signature, ending with its hash type byte, is valid for a SEC pubkey.
+// ok is false for a signature that isn't strict DER under BIP66, which fails the script.
+// Other malformed signatures and keys are only invalid.
+func (c *ecdsaChecker) check(sig, secPubkey []byte, sigs [][]byte) (valid bool, ok bool) {
+	if len(sig) == 0 {
+		return false, true
+	}
+	if c.flags&VerifyDERSig != 0 && !isValidSignatureEncoding(sig) {
+		return false, false
+	}
+	point, err := parsePubKey(secPubkey)
+	if err != nil {
+		return false, true
+	}
+	// take off the last byte of the signature as that's the hash_type
+	signature, err := ecc.ParseSignatureLax(sig[:len(sig)-1])
+	if err != nil {
+		return false, true
+	}
+	z := c.sigHash(uint32(sig[len(sig)-1]), sigs)
+	if z == nil {
+		return false, true
+	}
+	return point.Verify(new(big.Int).SetBytes(z), signature), true
+}
+
+// parsePubKey parses a SEC pubkey, also accepting the hybrid form of OpenSSL:
+// an uncompressed key with a 6 or 7 prefix giving the parity of y.
+func parsePubKey(sec []byte) (*ecc.S256Point, error) {
+	if len(sec) == 65 && (sec[0] == 6 || sec[0] == 7) {
+		if sec[0]&1 != sec[64]&1 {
+			return nil, errors.New("hybrid key parity does not match")
+		}
+		sec = append([]byte{4}, sec[1:]...)
+	}
+	return ecc.ParseSec(sec)
+}
+
+// isValidSignatureEncoding returns whether a signature, with its hash type byte,
+// is strict DER (BIP66).
+func isValidSignatureEncoding(sig []byte) bool {
+	// 0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S] [hash type]
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+	// R and S must be positive and not padded with zeros they don't need
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0 {
+		return false
+	}
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
 }
 
-func opChecksig(stack *opStack, args ...[][]byte) bool {
+func opChecksig(stack *opStack, checker *ecdsaChecker) bool {
 	if stack.Length < 2 {
 		return false
 	}
-	z := new(big.Int)
-	z.SetBytes(args[0][0])
 	// the top element of the stack is the SEC pubkey
 	secPubkey := stack.pop()
 	// the next element of the stack is the DER signature
-	// take off the last byte of the signature as that's the hash_type
 	derSignature := stack.pop()
-	derSignature = derSignature[:len(derSignature)-1]
-	// parse the serialized pubkey and signature into objects
-	point := ecc.ParseS256Point(secPubkey)
-	sig := ecc.ParseSignature(derSignature)
-	if point.Verify(z, sig) {
+	valid, ok := checker.check(derSignature, secPubkey, [][]byte{derSignature})
+	if !ok {
+		return false
+	}
+	if valid {
 		stack.push(encodeNum(1))
 	} else {
 		stack.push(encodeNum(0))
@@ -86,49 +548,134 @@ func opChecksig(stack *opStack, args ...[][]byte) bool {
 	return true
 }
 
-func opCheckmultisig(stack *opStack, args ...[][]byte) bool {
-	z := new(big.Int)
-	z.SetBytes(args[0][0])
+func opChecksigverify(stack *opStack, checker *ecdsaChecker) bool {
+	return opChecksig(stack, checker) && opVerify(stack)
+}
+
+func opCheckmultisig(stack *opStack, checker *ecdsaChecker) bool {
 	if stack.Length < 1 {
 		return false
 	}
-	n := decodeNum(stack.pop())
-	if stack.Length < n+1 {
+	n, ok := popNum(stack)
+	if !ok || n < 0 || n > MaxMultisigKeys || stack.Length < n+1 {
 		return false
 	}
 	secPubkeys := make([][]byte, n)
 	for i := 0; i < n; i++ {
 		secPubkeys[i] = stack.pop()
 	}
-	m := decodeNum(stack.pop())
-	if stack.Length < m+1 {
+	m, ok := popNum(stack)
+	if !ok || m < 0 || m > n || stack.Length < m+1 {
 		return false
 	}
 	derSignatures := make([][]byte, m)
 	for i := 0; i < m; i++ {
-		sig := stack.pop()
-		sigLength := len(sig)
-		derSignatures[i] = sig[:sigLength-1]
+		derSignatures[i] = stack.pop()
 	}
-	// OP_CHECKMULTISIG bug
-	stack.pop()
+	// OP_CHECKMULTISIG bug: one more element is popped, which BIP147 requires to be empty
+	if dummy := stack.pop(); checker.flags&VerifyNullDummy != 0 && len(dummy) != 0 {
+		return false
+	}
+	// pubkeys and signatures were both popped in reverse order, so they still line up
 	secIndex := 0
-	for derIndex := 0; derIndex < m; derIndex++ {
-		if secIndex >= n {
-			fmt.Fprintf(os.Stderr, "signatures no good or not in right order\n")
+	matched := true
+	for derIndex := 0; matched && derIndex < m; {
+		valid, ok := checker.check(derSignatures[derIndex], secPubkeys[secIndex], derSignatures)
+		if !ok {
 			return false
 		}
-		sig := ecc.ParseSignature(derSignatures[derIndex])
-		for secIndex < n {
-			point := ecc.ParseS256Point(secPubkeys[secIndex])
-			secIndex++
-			if point.Verify(z, sig) {
-				break
-			}
+		if valid {
+			derIndex++
 		}
+		secIndex++
+		// signatures no good or not in right order
+		matched = m-derIndex <= n-secIndex
+	}
+	if matched {
+		stack.push(encodeNum(1))
+	} else {
+		stack.push(encodeNum(0))
 	}
-	// The signatures are valid, push a 1 to the stack.
-	stack.push(encodeNum(1))
+	return true
+}
+
+func opCheckmultisigverify(stack *opStack, checker *ecdsaChecker) bool {
+	return opCheckmultisig(stack, checker) && opVerify(stack)
+}
+
+// checkSchnorr checks a signature in tapscript or a taproot key path spend (BIP341, BIP342).
+// valid is false for an empty signature, and ok is false for any other invalid
+// signature, which fails the script.
+func checkSchnorr(sig, pubKey []byte, sigHash sigHashFunc) (valid bool, ok bool) {
+	if len(pubKey) == 0 {
+		return false, false
+	}
+	if len(sig) == 0 {
+		return false, true
+	}
+	if len(pubKey) != 32 {
+		// unknown public key types are left for future soft forks
+		return true, true
+	}
+	hashType := util.SigHashDefault
+	if len(sig) == 65 {
+		// an explicit hash type can't be the default
+		if hashType = uint32(sig[64]); hashType == util.SigHashDefault {
+			return false, false
+		}
+		sig = sig[:64]
+	}
+	z := sigHash(hashType)
+	point, err := ecc.ParseXOnly(pubKey)
+	if z == nil || err != nil || !point.VerifySchnorr(z, sig) {
+		return false, false
+	}
+	return true, true
+}
+
+func opChecksigSchnorr(stack *opStack, sigHash sigHashFunc) bool {
+	if stack.Length < 2 {
+		return false
+	}
+	pubKey := stack.pop()
+	sig := stack.pop()
+	valid, ok := checkSchnorr(sig, pubKey, sigHash)
+	if !ok {
+		return false
+	}
+	if valid {
+		stack.push(encodeNum(1))
+	} else {
+		stack.push(encodeNum(0))
+	}
+	return true
+}
+
+func opChecksigverifySchnorr(stack *opStack, sigHash sigHashFunc) bool {
+	return opChecksigSchnorr(stack, sigHash) && opVerify(stack)
+}
+
+// opChecksigadd replaces OP_CHECKMULTISIG in tapscript:
+// it adds one to the number below the signature and key if the signature is valid.
+func opChecksigadd(stack *opStack, sigHash sigHashFunc) bool {
+	if stack.Length < 3 {
+		return false
+	}
+	pubKey := stack.pop()
+	num := stack.pop()
+	sig := stack.pop()
+	if len(num) > 4 {
+		return false
+	}
+	valid, ok := checkSchnorr(sig, pubKey, sigHash)
+	if !ok {
+		return false
+	}
+	n := decodeNum(num)
+	if valid {
+		n++
+	}
+	stack.push(encodeNum(n))
 	return true
 }
 
@@ -162,6 +709,17 @@ func encodeNum(num int) []byte {
 	return result
 }
 
+// castToBool returns false for zero, negative zero or an empty element.
+func castToBool(element []byte) bool {
+	for i, b := range element {
+		if b != 0 {
+			// negative zero is still zero
+			return !(i == len(element)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
 func decodeNum(element []byte) int {
 	length := len(element)
 	if length == 0 {
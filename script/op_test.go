@@ -2,6 +2,7 @@ package script
 
 import (
 	"encoding/hex"
+	"fmt"
 	"testing"
 
 	"github.com/ravdin/programmingbitcoin/util"
@@ -27,7 +28,7 @@ func TestOp(t *testing.T) {
 		sec := util.HexStringToBytes(`04887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34`)
 		sig := util.HexStringToBytes(`3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab601`)
 		stack := newOpStack([][]byte{sig, sec})
-		if !opChecksig(stack, [][]byte{z}) {
+		if !opChecksig(stack, fixedChecker(z)) {
 			t.Errorf("OpCheckSig failed!")
 		}
 		actual := decodeNum(stack.peek())
@@ -43,8 +44,8 @@ func TestOp(t *testing.T) {
 		sig2 := util.HexStringToBytes(`3045022100da6bee3c93766232079a01639d07fa869598749729ae323eab8eef53577d611b02207bef15429dcadce2121ea07f233115c6f09034c0be68db99980b9a6c5e75402201`)
 		sec1 := util.HexStringToBytes(`022626e955ea6ea6d98850c994f9107b036b1334f18ca8830bfff1295d21cfdb70`)
 		sec2 := util.HexStringToBytes(`03b287eaf122eea69030a0e9feed096bed8045c8b98bec453e1ffac7fbdbd4bb71`)
-		stack := newOpStack([][]byte{{0}, sig1, sig2, {2}, sec1, sec2, {2}})
-		if !opCheckmultisig(stack, [][]byte{z}) {
+		stack := newOpStack([][]byte{{}, sig1, sig2, {2}, sec1, sec2, {2}})
+		if !opCheckmultisig(stack, fixedChecker(z)) {
 			t.Errorf("OpCheckSig failed!")
 		}
 		actual := decodeNum(stack.peek())
@@ -53,4 +54,250 @@ func TestOp(t *testing.T) {
 			t.Errorf("Expected %v, got %v", expected, actual)
 		}
 	})
+
+	t.Run("Test arithmetic", func(t *testing.T) {
+		tests := []struct {
+			operation opCodeFunction
+			a, b      int
+			expected  int
+		}{
+			{opAdd, 2, 3, 5},
+			{opAdd, -2, 1, -1},
+			{opBoolAnd, 1, 0, 0},
+			{opBoolAnd, 2, 3, 1},
+			{opBoolOr, 0, 0, 0},
+			{opBoolOr, 0, 7, 1},
+			{opNumEqual, 4, 4, 1},
+			{opNumEqual, 4, 5, 0},
+			{opSub, 2, 5, -3},
+			{opNumNotEqual, 4, 5, 1},
+			{opNumNotEqual, 4, 4, 0},
+			{opLessThan, 4, 5, 1},
+			{opLessThan, 5, 5, 0},
+			{opGreaterThan, 5, 4, 1},
+			{opGreaterThan, 5, 5, 0},
+			{opLessThanOrEqual, 5, 5, 1},
+			{opLessThanOrEqual, 6, 5, 0},
+			{opGreaterThanOrEqual, 5, 5, 1},
+			{opGreaterThanOrEqual, 4, 5, 0},
+			{opMin, 7, -2, -2},
+			{opMax, 7, -2, 7},
+		}
+		for _, test := range tests {
+			stack := newOpStack([][]byte{encodeNum(test.a), encodeNum(test.b)})
+			if !test.operation(stack) {
+				t.Fatalf("Operation failed!")
+			}
+			if actual := decodeNum(stack.pop()); actual != test.expected || stack.Length != 0 {
+				t.Errorf("Expected %v, got %v", test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test unary arithmetic", func(t *testing.T) {
+		tests := []struct {
+			operation opCodeFunction
+			a         int
+			expected  int
+		}{
+			{op1Add, 4, 5},
+			{op1Add, -1, 0},
+			{op1Sub, 4, 3},
+			{op1Sub, 0, -1},
+			{opNegate, 4, -4},
+			{opNegate, -4, 4},
+			{opAbs, -4, 4},
+			{opAbs, 4, 4},
+			{opNot, 0, 1},
+			{opNot, 3, 0},
+			{op0Notequal, 3, 1},
+			{op0Notequal, 0, 0},
+		}
+		for _, test := range tests {
+			stack := newOpStack([][]byte{encodeNum(test.a)})
+			if !test.operation(stack) {
+				t.Fatalf("Operation failed!")
+			}
+			if actual := decodeNum(stack.pop()); actual != test.expected || stack.Length != 0 {
+				t.Errorf("Expected %v, got %v", test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test OpWithin", func(t *testing.T) {
+		tests := []struct {
+			x, min, max int
+			expected    int
+		}{
+			{2, 2, 5, 1},
+			{4, 2, 5, 1},
+			{5, 2, 5, 0},
+			{1, 2, 5, 0},
+		}
+		for _, test := range tests {
+			stack := newOpStack([][]byte{encodeNum(test.x), encodeNum(test.min), encodeNum(test.max)})
+			if !opWithin(stack) {
+				t.Fatalf("OpWithin failed!")
+			}
+			if actual := decodeNum(stack.pop()); actual != test.expected || stack.Length != 0 {
+				t.Errorf("%d within [%d, %d): expected %v, got %v", test.x, test.min, test.max, test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test number operands", func(t *testing.T) {
+		// operands can be at most 4 bytes, results can be longer
+		stack := newOpStack([][]byte{encodeNum(0x7fffffff), encodeNum(0x7fffffff)})
+		if !opAdd(stack) || decodeNum(stack.peek()) != 0xfffffffe {
+			t.Fatalf("Expected 0xfffffffe")
+		}
+		if op1Add(stack) {
+			t.Errorf("Expected a 5 byte operand to fail")
+		}
+		for _, operation := range []opCodeFunction{opAdd, op1Add, opNot, opWithin} {
+			if operation(newOpStack(nil)) {
+				t.Errorf("Expected an empty stack to fail")
+			}
+		}
+	})
+
+	t.Run("Test stack operations", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			operation opCodeFunction
+			stack     []int
+			expected  []int
+		}{
+			{"OP_2DUP", op2Dup, []int{1, 2}, []int{1, 2, 1, 2}},
+			{"OP_3DUP", op3Dup, []int{1, 2, 3}, []int{1, 2, 3, 1, 2, 3}},
+			{"OP_2OVER", op2Over, []int{1, 2, 3, 4}, []int{1, 2, 3, 4, 1, 2}},
+			{"OP_2ROT", op2Rot, []int{1, 2, 3, 4, 5, 6}, []int{3, 4, 5, 6, 1, 2}},
+			{"OP_2SWAP", op2Swap, []int{1, 2, 3, 4}, []int{3, 4, 1, 2}},
+			{"OP_DEPTH", opDepth, []int{7, 7}, []int{7, 7, 2}},
+			{"OP_DEPTH", opDepth, []int{}, []int{0}},
+			{"OP_NIP", opNip, []int{1, 2}, []int{2}},
+			{"OP_OVER", opOver, []int{1, 2}, []int{1, 2, 1}},
+			{"OP_PICK", opPick, []int{1, 2, 3, 2}, []int{1, 2, 3, 1}},
+			{"OP_PICK", opPick, []int{1, 2, 3, 0}, []int{1, 2, 3, 3}},
+			{"OP_ROLL", opRoll, []int{1, 2, 3, 2}, []int{2, 3, 1}},
+			{"OP_ROLL", opRoll, []int{1, 2, 3, 0}, []int{1, 2, 3}},
+			{"OP_ROT", opRot, []int{1, 2, 3}, []int{2, 3, 1}},
+			{"OP_TUCK", opTuck, []int{1, 2}, []int{2, 1, 2}},
+		}
+		for _, test := range tests {
+			items := make([][]byte, len(test.stack))
+			for i, n := range test.stack {
+				items[i] = encodeNum(n)
+			}
+			stack := newOpStack(items)
+			if !test.operation(stack) {
+				t.Fatalf("%s failed!", test.name)
+			}
+			actual := make([]int, stack.Length)
+			for i, item := range stack.items() {
+				actual[i] = decodeNum(item)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(test.expected) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+			}
+		}
+		failures := []struct {
+			name      string
+			operation opCodeFunction
+			stack     []int
+		}{
+			{"OP_2DUP", op2Dup, []int{1}},
+			{"OP_3DUP", op3Dup, []int{1, 2}},
+			{"OP_2OVER", op2Over, []int{1, 2, 3}},
+			{"OP_2ROT", op2Rot, []int{1, 2, 3, 4, 5}},
+			{"OP_2SWAP", op2Swap, []int{1, 2, 3}},
+			{"OP_NIP", opNip, []int{1}},
+			{"OP_OVER", opOver, []int{1}},
+			{"OP_PICK", opPick, []int{1, 2, 2}},
+			{"OP_PICK", opPick, []int{1, 2, -1}},
+			{"OP_ROLL", opRoll, []int{1, 2, 2}},
+			{"OP_ROT", opRot, []int{1, 2}},
+			{"OP_TUCK", opTuck, []int{1}},
+		}
+		for _, test := range failures {
+			items := make([][]byte, len(test.stack))
+			for i, n := range test.stack {
+				items[i] = encodeNum(n)
+			}
+			if test.operation(newOpStack(items)) {
+				t.Errorf("%s: expected %v to fail", test.name, test.stack)
+			}
+		}
+	})
+
+	t.Run("Test OpSha1", func(t *testing.T) {
+		stack := newOpStack([][]byte{[]byte(`hello world`)})
+		if !opSha1(stack) {
+			t.Errorf("OpSha1 failed!")
+		}
+		expected := `2aae6c35c94fcfb415dbe95f408b9ce91ee846ed`
+		if actual := hex.EncodeToString(stack.peek()); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Test OpCheckMultisig limits", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			stack [][]byte
+			valid bool
+		}{
+			{"0 of 0", [][]byte{{}, {}, {}}, true},
+			{"0 of 20", append(append([][]byte{{}, {}}, make([][]byte, MaxMultisigKeys)...), encodeNum(MaxMultisigKeys)), true},
+			{"0 of 21", append(append([][]byte{{}, {}}, make([][]byte, MaxMultisigKeys+1)...), encodeNum(MaxMultisigKeys+1)), false},
+			{"negative keys", [][]byte{{}, {}, encodeNum(-1)}, false},
+			{"negative signatures", [][]byte{{}, encodeNum(-1), {}}, false},
+			{"1 of 0", [][]byte{{}, {}, encodeNum(1), {}}, false},
+			{"dummy not empty", [][]byte{{0}, {}, {}}, false},
+			{"no dummy", [][]byte{{}, {}}, false},
+		}
+		for _, test := range tests {
+			stack := newOpStack(test.stack)
+			if opCheckmultisig(stack, fixedChecker(nil)) != test.valid {
+				t.Errorf("%s: expected valid %v", test.name, test.valid)
+			}
+		}
+	})
+
+	t.Run("Test strict DER", func(t *testing.T) {
+		sig := util.HexStringToBytes(`3045022100dc92655fe37036f47756db8102e0d7d5e28b3beb83a8fef4f5dc0559bddfb94e02205a36d4e4e6c7fcd16658c50783e00c341609977aed3ad00937bf4ee942a8993701`)
+		if !isValidSignatureEncoding(sig) {
+			t.Errorf("Expected %x to be strict DER", sig)
+		}
+		tests := map[string][]byte{
+			"padded R":      append([]byte{0x30, 0x46, 0x02, 0x22, 0x00}, sig[4:]...),
+			"negative R":    append([]byte{0x30, 0x44, 0x02, 0x20}, sig[5:]...),
+			"wrong length":  append([]byte{0x30, 0x46}, sig[2:]...),
+			"trailing data": append(append([]byte{}, sig[:len(sig)-1]...), 0, 1),
+			"too short":     sig[:8],
+		}
+		for name, invalid := range tests {
+			if isValidSignatureEncoding(invalid) {
+				t.Errorf("%s: expected %x not to be strict DER", name, invalid)
+			}
+		}
+	})
+
+	t.Run("Test OpIfdup", func(t *testing.T) {
+		stack := newOpStack([][]byte{encodeNum(0)})
+		if !opIfdup(stack) || stack.Length != 1 {
+			t.Errorf("Expected OP_IFDUP not to duplicate zero")
+		}
+		stack = newOpStack([][]byte{encodeNum(3)})
+		if !opIfdup(stack) || stack.Length != 2 {
+			t.Errorf("Expected OP_IFDUP to duplicate a true value")
+		}
+	})
+}
+
+// fixedChecker returns an ecdsaChecker enforcing every soft fork that gives z for every signature.
+func fixedChecker(z []byte) *ecdsaChecker {
+	return &ecdsaChecker{flags: VerifyAll, sigHash: func(hashType uint32, sigs [][]byte) []byte {
+		return z
+	}}
 }
@@ -1,17 +1,222 @@
 package script
 
+// Opcode values.
+const (
+	Op0                   = 0x00
+	OpPushData1           = 0x4c
+	OpPushData2           = 0x4d
+	OpPushData4           = 0x4e
+	Op1Negate             = 0x4f
+	Op1                   = 0x51
+	Op2                   = 0x52
+	Op3                   = 0x53
+	Op4                   = 0x54
+	Op5                   = 0x55
+	Op6                   = 0x56
+	Op7                   = 0x57
+	Op8                   = 0x58
+	Op9                   = 0x59
+	Op10                  = 0x5a
+	Op11                  = 0x5b
+	Op12                  = 0x5c
+	Op13                  = 0x5d
+	Op14                  = 0x5e
+	Op15                  = 0x5f
+	Op16                  = 0x60
+	OpNop                 = 0x61
+	OpIf                  = 0x63
+	OpNotIf               = 0x64
+	OpElse                = 0x67
+	OpEndIf               = 0x68
+	OpVerify              = 0x69
+	OpReturn              = 0x6a
+	OpToAltStack          = 0x6b
+	OpFromAltStack        = 0x6c
+	Op2Drop               = 0x6d
+	Op2Dup                = 0x6e
+	Op3Dup                = 0x6f
+	Op2Over               = 0x70
+	Op2Rot                = 0x71
+	Op2Swap               = 0x72
+	OpIfDup               = 0x73
+	OpDepth               = 0x74
+	OpDrop                = 0x75
+	OpDup                 = 0x76
+	OpNip                 = 0x77
+	OpOver                = 0x78
+	OpPick                = 0x79
+	OpRoll                = 0x7a
+	OpRot                 = 0x7b
+	OpSwap                = 0x7c
+	OpTuck                = 0x7d
+	OpSize                = 0x82
+	OpEqual               = 0x87
+	OpEqualVerify         = 0x88
+	Op1Add                = 0x8b
+	Op1Sub                = 0x8c
+	OpNegate              = 0x8f
+	OpAbs                 = 0x90
+	OpNot                 = 0x91
+	Op0NotEqual           = 0x92
+	OpAdd                 = 0x93
+	OpSub                 = 0x94
+	OpBoolAnd             = 0x9a
+	OpBoolOr              = 0x9b
+	OpNumEqual            = 0x9c
+	OpNumEqualVerify      = 0x9d
+	OpNumNotEqual         = 0x9e
+	OpLessThan            = 0x9f
+	OpGreaterThan         = 0xa0
+	OpLessThanOrEqual     = 0xa1
+	OpGreaterThanOrEqual  = 0xa2
+	OpMin                 = 0xa3
+	OpMax                 = 0xa4
+	OpWithin              = 0xa5
+	OpRipemd160           = 0xa6
+	OpSha1                = 0xa7
+	OpSha256              = 0xa8
+	OpHash160             = 0xa9
+	OpHash256             = 0xaa
+	OpCodeSeparator       = 0xab
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+	OpNop1                = 0xb0
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+	OpNop4                = 0xb3
+	OpNop5                = 0xb4
+	OpNop6                = 0xb5
+	OpNop7                = 0xb6
+	OpNop8                = 0xb7
+	OpNop9                = 0xb8
+	OpNop10               = 0xb9
+	OpCheckSigAdd         = 0xba
+)
+
 type opCodeFunction func(stack *opStack, args ...[][]byte) bool
 
 var opCodeFunctions = map[int]opCodeFunction{
 	0:   op0,
+	79:  op1Negate,
+	81:  opNumber(1),
+	82:  opNumber(2),
+	83:  opNumber(3),
+	84:  opNumber(4),
+	85:  opNumber(5),
+	86:  opNumber(6),
+	87:  opNumber(7),
+	88:  opNumber(8),
+	89:  opNumber(9),
+	90:  opNumber(10),
+	91:  opNumber(11),
+	92:  opNumber(12),
+	93:  opNumber(13),
+	94:  opNumber(14),
+	95:  opNumber(15),
+	96:  opNumber(16),
+	97:  opNop,
 	105: opVerify,
+	109: op2Drop,
+	110: op2Dup,
+	111: op3Dup,
+	112: op2Over,
+	113: op2Rot,
+	114: op2Swap,
+	115: opIfdup,
+	116: opDepth,
+	117: opDrop,
 	118: opDup,
+	119: opNip,
+	120: opOver,
+	121: opPick,
+	122: opRoll,
+	123: opRot,
+	124: opSwap,
+	125: opTuck,
+	130: opSize,
 	135: opEqual,
 	136: opEqualverify,
+	139: op1Add,
+	140: op1Sub,
+	143: opNegate,
+	144: opAbs,
+	145: opNot,
+	146: op0Notequal,
+	147: opAdd,
+	148: opSub,
+	154: opBoolAnd,
+	155: opBoolOr,
+	156: opNumEqual,
+	157: opNumEqualverify,
+	158: opNumNotEqual,
+	159: opLessThan,
+	160: opGreaterThan,
+	161: opLessThanOrEqual,
+	162: opGreaterThanOrEqual,
+	163: opMin,
+	164: opMax,
+	165: opWithin,
+	166: opRipemd160,
+	167: opSha1,
 	168: opSha256,
 	169: opHash160,
+	170: opHash256,
+	176: opNop,
+	179: opNop,
+	180: opNop,
+	181: opNop,
+	182: opNop,
+	183: opNop,
+	184: opNop,
+	185: opNop,
+}
+
+// sigOpFunctions are the operations that check signatures against the transaction.
+var sigOpFunctions = map[int]func(stack *opStack, checker *ecdsaChecker) bool{
 	172: opChecksig,
+	173: opChecksigverify,
 	174: opCheckmultisig,
+	175: opCheckmultisigverify,
+}
+
+// tapscriptSigOpFunctions replace sigOpFunctions in tapscript (BIP342).
+var tapscriptSigOpFunctions = map[int]func(stack *opStack, sigHash sigHashFunc) bool{
+	172: opChecksigSchnorr,
+	173: opChecksigverifySchnorr,
+	186: opChecksigadd,
+}
+
+// isDisabledOpcode returns whether an opcode fails a script outside tapscript
+// even in a branch that isn't run: the disabled string, bitwise and arithmetic
+// operations, and OP_VERIF and OP_VERNOTIF.
+func isDisabledOpcode(opcode byte) bool {
+	switch {
+	case opcode == 101, opcode == 102:
+		return true
+	case opcode >= 126 && opcode <= 129, opcode >= 131 && opcode <= 134:
+		return true
+	case opcode >= 141 && opcode <= 142, opcode >= 149 && opcode <= 153:
+		return true
+	}
+	return false
+}
+
+// isSuccessOpcode returns whether an opcode is one of the OP_SUCCESSx
+// that make a tapscript succeed, reserved for future soft forks.
+func isSuccessOpcode(opcode byte) bool {
+	switch {
+	case opcode == 80, opcode == 98:
+		return true
+	case opcode >= 126 && opcode <= 129, opcode >= 131 && opcode <= 134:
+		return true
+	case opcode >= 137 && opcode <= 138, opcode >= 141 && opcode <= 142:
+		return true
+	case opcode >= 149 && opcode <= 153, opcode >= 187 && opcode <= 254:
+		return true
+	}
+	return false
 }
 
 var opCodeNames = map[int]string{
@@ -105,4 +310,5 @@ var opCodeNames = map[int]string{
 	183: `OP_NOP8`,
 	184: `OP_NOP9`,
 	185: `OP_NOP10`,
+	186: `OP_CHECKSIGADD`,
 }
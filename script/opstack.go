@@ -41,3 +41,24 @@ func (stack *opStack) pop() []byte {
 func (stack *opStack) peek() []byte {
 	return stack.stack[stack.Length-1]
 }
+
+// at returns the element depth below the top, 0 being the top.
+func (stack *opStack) at(depth int) []byte {
+	return stack.stack[stack.Length-1-depth]
+}
+
+// remove takes out and returns the element depth below the top, 0 being the top.
+func (stack *opStack) remove(depth int) []byte {
+	index := stack.Length - 1 - depth
+	item := stack.stack[index]
+	copy(stack.stack[index:], stack.stack[index+1:stack.Length])
+	stack.Length--
+	return item
+}
+
+// items returns a copy of the stack contents, bottom first.
+func (stack *opStack) items() [][]byte {
+	result := make([][]byte, stack.Length)
+	copy(result, stack.stack[:stack.Length])
+	return result
+}
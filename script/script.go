@@ -3,21 +3,93 @@ package script
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
+// MaxScriptSize is the size of the largest script that can run, larger outputs are unspendable.
+const MaxScriptSize = 10000
+
+// Command is a single element of a script: either an opcode or an element
+// of data to push onto the stack.
+// Data elements keep the push opcode they are serialized with.
+type Command struct {
+	Opcode byte
+	Data   []byte
+}
+
+// IsData returns whether the command pushes a data element.
+func (cmd Command) IsData() bool {
+	return cmd.Opcode >= 1 && cmd.Opcode <= OpPushData4
+}
+
+func (cmd Command) String() string {
+	if cmd.IsData() {
+		return hex.EncodeToString(cmd.Data)
+	}
+	if name, ok := opCodeNames[int(cmd.Opcode)]; ok {
+		return name
+	}
+	return fmt.Sprintf(`OP_[%d]`, cmd.Opcode)
+}
+
+// Number returns the number pushed by the command.
+// Returns an error if the command is not OP_0, OP_1NEGATE, OP_1 to OP_16 or a push of at most 5 bytes.
+func (cmd Command) Number() (int, error) {
+	switch {
+	case cmd.Opcode == Op0:
+		return 0, nil
+	case cmd.Opcode == Op1Negate:
+		return -1, nil
+	case cmd.Opcode >= Op1 && cmd.Opcode <= Op16:
+		return int(cmd.Opcode) - Op1 + 1, nil
+	case cmd.IsData() && len(cmd.Data) <= 5:
+		return decodeNum(cmd.Data), nil
+	}
+	return 0, fmt.Errorf("%s is not a number", cmd)
+}
+
+// DataCommand returns a command that pushes data with the smallest push opcode.
+func DataCommand(data []byte) Command {
+	length := len(data)
+	switch {
+	case length == 0:
+		return Command{Opcode: Op0}
+	case length < 76:
+		return Command{Opcode: byte(length), Data: data}
+	case length < 0x100:
+		return Command{Opcode: OpPushData1, Data: data}
+	case length < 0x10000:
+		return Command{Opcode: OpPushData2, Data: data}
+	default:
+		return Command{Opcode: OpPushData4, Data: data}
+	}
+}
+
+// IntCommand returns a command that pushes a number with the smallest encoding.
+func IntCommand(num int) Command {
+	switch {
+	case num == 0:
+		return Command{Opcode: Op0}
+	case num == -1:
+		return Command{Opcode: Op1Negate}
+	case num >= 1 && num <= 16:
+		return Command{Opcode: byte(Op1 + num - 1)}
+	}
+	return DataCommand(encodeNum(num))
+}
+
 // Script represents a Bitcoin script.
 type Script struct {
-	cmds [][]byte
+	cmds []Command
 }
 
 // Add x to y and return the result.
 func (scr *Script) Add(x, y *Script) *Script {
-	cmds := make([][]byte, len(x.cmds)+len(y.cmds))
+	cmds := make([]Command, len(x.cmds)+len(y.cmds))
 	copy(cmds, x.cmds)
 	copy(cmds[len(x.cmds):], y.cmds)
 	scr.cmds = cmds
@@ -27,23 +99,48 @@ func (scr *Script) Add(x, y *Script) *Script {
 func (scr *Script) String() string {
 	result := make([]string, len(scr.cmds))
 	for i, cmd := range scr.cmds {
-		if len(cmd) == 1 {
-			opcode := int(cmd[0])
-			if name, ok := opCodeNames[opcode]; ok {
-				result[i] = name
-			} else {
-				result[i] = fmt.Sprintf(`OP_[%d]`, opcode)
-			}
-		} else {
-			result[i] = hex.EncodeToString(cmd)
-		}
+		result[i] = cmd.String()
 	}
 	return strings.Join(result, " ")
 }
 
 // NewScript initializes a new Script object.
+// Elements of a single byte are opcodes, anything else is data.
 func NewScript(cmds [][]byte) *Script {
-	return &Script{cmds: cmds}
+	result := &Script{cmds: make([]Command, len(cmds))}
+	for i, cmd := range cmds {
+		if len(cmd) == 1 {
+			result.cmds[i] = Command{Opcode: cmd[0]}
+		} else {
+			result.cmds[i] = DataCommand(cmd)
+		}
+	}
+	return result
+}
+
+// NewScriptFromCommands initializes a new Script object from a list of commands.
+func NewScriptFromCommands(cmds []Command) *Script {
+	result := &Script{cmds: make([]Command, len(cmds))}
+	copy(result.cmds, cmds)
+	return result
+}
+
+// AppendOp appends an opcode to the script and returns the script.
+func (scr *Script) AppendOp(opcode byte) *Script {
+	scr.cmds = append(scr.cmds, Command{Opcode: opcode})
+	return scr
+}
+
+// AppendData appends a data push to the script and returns the script.
+func (scr *Script) AppendData(data []byte) *Script {
+	scr.cmds = append(scr.cmds, DataCommand(data))
+	return scr
+}
+
+// AppendInt appends a number to the script using the smallest encoding and returns the script.
+func (scr *Script) AppendInt(num int) *Script {
+	scr.cmds = append(scr.cmds, IntCommand(num))
+	return scr
 }
 
 // P2pkhScript takes a hash160 and returns the p2pkh ScriptPubKey
@@ -58,77 +155,122 @@ func P2pkhScript(h160 []byte) *Script {
 	return NewScript(cmds)
 }
 
+// P2shScript takes a hash160 and returns the p2sh ScriptPubKey
+func P2shScript(h160 []byte) *Script {
+	return new(Script).AppendOp(OpHash160).AppendData(h160).AppendOp(OpEqual)
+}
+
+// P2wpkhScript takes a hash160 and returns the p2wpkh ScriptPubKey
+func P2wpkhScript(h160 []byte) *Script {
+	return new(Script).AppendOp(Op0).AppendData(h160)
+}
+
+// P2wshScript takes a sha256 and returns the p2wsh ScriptPubKey
+func P2wshScript(s256 []byte) *Script {
+	return new(Script).AppendOp(Op0).AppendData(s256)
+}
+
+// P2trScript takes a 32 byte x-only output key and returns the p2tr ScriptPubKey
+func P2trScript(outputKey []byte) *Script {
+	return new(Script).AppendOp(Op1).AppendData(outputKey)
+}
+
 // Parse a new Script from a byte reader.
 func Parse(s *bytes.Reader) *Script {
 	length := util.ReadVarInt(s)
-	var cmds [][]byte
-	var count int
+	raw := make([]byte, length)
+	if n, _ := s.Read(raw); n != length {
+		panic("parsing script failed")
+	}
+	cmds, err := parseCommands(raw)
+	if err != nil {
+		panic("parsing script failed")
+	}
+	return &Script{cmds: cmds}
+}
+
+// ParseRaw parses a Script from its serialization without the length prefix.
+func ParseRaw(raw []byte) (*Script, error) {
+	cmds, err := parseCommands(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{cmds: cmds}, nil
+}
+
+func parseCommands(raw []byte) ([]Command, error) {
+	var cmds []Command
+	length := len(raw)
+	count := 0
 	for count < length {
-		currentByte, _ := s.ReadByte()
+		currentByte := raw[count]
 		count++
-		if currentByte >= 1 && currentByte <= 75 {
+		var dataLength int
+		switch {
+		case currentByte >= 1 && currentByte <= 75:
 			// we have an cmd set n to be the current byte
-			n := int(currentByte)
-			// add the next n bytes as an cmd
-			buffer := make([]byte, n)
-			s.Read(buffer)
-			cmds = append(cmds, buffer)
-			count += n
-		} else if currentByte == 76 {
-			// op_pushdata1
-			n, _ := s.ReadByte()
-			dataLength := int(n)
-			buffer := make([]byte, dataLength)
-			s.Read(buffer)
-			cmds = append(cmds, buffer)
-			count += dataLength + 1
-		} else if currentByte == 77 {
-			// op_pushdata2
-			data := make([]byte, 2)
-			s.Read(data)
-			dataLength := int(util.LittleEndianToInt16(data))
-			buffer := make([]byte, dataLength)
-			s.Read(buffer)
-			cmds = append(cmds, buffer)
-			count += dataLength + 2
-		} else {
-			// we have an opcode. set the current byte to op_code
-			opCode := currentByte
-			// add the op_code to the list of cmds
-			cmds = append(cmds, []byte{opCode})
+			dataLength = int(currentByte)
+		case currentByte == OpPushData1:
+			if count+1 > length {
+				return nil, errors.New("truncated OP_PUSHDATA1")
+			}
+			dataLength = int(raw[count])
+			count++
+		case currentByte == OpPushData2:
+			if count+2 > length {
+				return nil, errors.New("truncated OP_PUSHDATA2")
+			}
+			dataLength = int(util.LittleEndianToInt16(raw[count : count+2]))
+			count += 2
+		case currentByte == OpPushData4:
+			if count+4 > length {
+				return nil, errors.New("truncated OP_PUSHDATA4")
+			}
+			dataLength = int(util.LittleEndianToInt32(raw[count : count+4]))
+			count += 4
+		default:
+			// we have an opcode. add the op_code to the list of cmds
+			cmds = append(cmds, Command{Opcode: currentByte})
+			continue
 		}
+		if dataLength > length-count {
+			return nil, fmt.Errorf("push of %d bytes exceeds script length", dataLength)
+		}
+		// add the next n bytes as an cmd
+		data := make([]byte, dataLength)
+		copy(data, raw[count:count+dataLength])
+		cmds = append(cmds, Command{Opcode: currentByte, Data: data})
+		count += dataLength
 	}
-	if count != length {
-		panic("parsing script failed")
-	}
-	return &Script{cmds: cmds}
+	return cmds, nil
 }
 
-// Serialize the script as a byte array.
-func (scr *Script) Serialize() []byte {
+// RawSerialize returns the script bytes without the length prefix.
+func (scr *Script) RawSerialize() []byte {
 	var raw []byte
 	for _, cmd := range scr.cmds {
-		length := len(cmd)
-		if length == 1 {
-			// This is an op code
-			raw = append(raw, cmd[0])
-		} else {
-			// Otherwise, this is an element.
-			// for large lengths, we have to use a pushdata opcode
-			if length < 76 {
-				raw = append(raw, byte(length))
-			} else if length >= 76 && length < 0x100 {
-				// 76 is pushdata1
-				raw = append(raw, byte(76))
-				raw = append(raw, byte(length))
-			} else if length >= 0x100 && length <= 520 {
-				// 77 is pushdata2
-				raw = append(raw, byte(77))
-				raw = append(raw, util.Int16ToLittleEndian(uint16(length))...)
-			}
-			raw = append(raw, cmd...)
+		raw = append(raw, cmd.Opcode)
+		if !cmd.IsData() {
+			continue
 		}
+		length := len(cmd.Data)
+		// for large lengths, we have to use a pushdata opcode
+		switch cmd.Opcode {
+		case OpPushData1:
+			raw = append(raw, byte(length))
+		case OpPushData2:
+			raw = append(raw, util.Int16ToLittleEndian(uint16(length))...)
+		case OpPushData4:
+			raw = append(raw, util.Int32ToLittleEndian(uint32(length))...)
+		}
+		raw = append(raw, cmd.Data...)
 	}
+	return raw
+}
+
+// Serialize the script as a byte array.
+func (scr *Script) Serialize() []byte {
+	raw := scr.RawSerialize()
 	total := util.EncodeVarInt(len(raw))
 	result := make([]byte, len(total)+len(raw))
 	copy(result, total)
@@ -137,55 +279,105 @@ func (scr *Script) Serialize() []byte {
 }
 
 // Peek at the stack for a given index.
+// Data elements are returned as-is and opcodes as a single byte.
 func (scr *Script) Peek(index int) []byte {
-	return scr.cmds[index]
+	cmd := scr.cmds[index]
+	if cmd.IsData() {
+		return cmd.Data
+	}
+	return []byte{cmd.Opcode}
 }
 
-// Evaluate the script.
-// Return true if the script execution succeeded and false otherwise.
-func (scr *Script) Evaluate(z []byte) bool {
-	// create a copy as we may need to add to this list if we have a RedeemScript
-	cmds := make([][]byte, len(scr.cmds))
-	copy(cmds, scr.cmds)
-	stack := newOpStack(nil)
-	for len(cmds) > 0 {
-		cmd := cmds[0]
-		cmds = cmds[1:]
-		if len(cmd) == 1 {
-			// This is an opcode, do what it says.
-			opcode := int(cmd[0])
-			operation := opCodeFunctions[opcode]
-			//fmt.Fprintf(os.Stderr, "Running %s...\n", OpCodeNames[opcode])
-			switch opcode {
-			case 99, 100:
-				// if, notif
-				panic("Not implemented")
-			case 107, 108:
-				// stack to altstack
-				panic("Not implemented")
-			case 172, 173, 174, 175:
-				// Signing operations.
-				if !operation(stack, [][]byte{z}) {
-					// TODO: Log output
-					fmt.Fprintf(os.Stderr, "Op %s failed!\n", opCodeNames[opcode])
-					return false
-				}
-			default:
-				if !operation(stack) {
-					// TODO: log output
-					fmt.Fprintf(os.Stderr, "Op %s failed!\n", opCodeNames[opcode])
-					return false
-				}
-			}
-		} else {
-			stack.push(cmd)
-		}
+// Len returns the number of commands in the script.
+func (scr *Script) Len() int {
+	return len(scr.cmds)
+}
+
+// Commands returns a copy of the commands in the script.
+func (scr *Script) Commands() []Command {
+	result := make([]Command, len(scr.cmds))
+	copy(result, scr.cmds)
+	return result
+}
+
+// IsP2pkhScriptPubKey returns whether this follows the
+// OP_DUP OP_HASH160 <20 byte hash> OP_EQUALVERIFY OP_CHECKSIG pattern.
+func (scr *Script) IsP2pkhScriptPubKey() bool {
+	cmds := scr.cmds
+	return len(cmds) == 5 &&
+		cmds[0].Opcode == OpDup &&
+		cmds[1].Opcode == OpHash160 &&
+		cmds[2].IsData() && len(cmds[2].Data) == 20 &&
+		cmds[3].Opcode == OpEqualVerify &&
+		cmds[4].Opcode == OpCheckSig
+}
+
+// IsP2shScriptPubKey returns whether this follows the
+// OP_HASH160 <20 byte hash> OP_EQUAL pattern.
+func (scr *Script) IsP2shScriptPubKey() bool {
+	cmds := scr.cmds
+	return len(cmds) == 3 &&
+		cmds[0].Opcode == OpHash160 &&
+		cmds[1].IsData() && len(cmds[1].Data) == 20 &&
+		cmds[2].Opcode == OpEqual
+}
+
+// WitnessProgram returns the version and program of a segwit ScriptPubKey.
+// ok is false if the script is not a witness program.
+func (scr *Script) WitnessProgram() (version int, program []byte, ok bool) {
+	raw := scr.RawSerialize()
+	if len(raw) < 4 || len(raw) > 42 || len(scr.cmds) != 2 {
+		return 0, nil, false
 	}
-	if stack.Length == 0 {
-		return false
+	versionOp := scr.cmds[0].Opcode
+	if versionOp != Op0 && (versionOp < Op1 || versionOp > Op16) {
+		return 0, nil, false
 	}
-	if bytes.Equal(stack.pop(), []byte{0}) {
-		return false
+	if int(raw[1])+2 != len(raw) {
+		return 0, nil, false
+	}
+	if versionOp != Op0 {
+		version = int(versionOp) - Op1 + 1
+	}
+	return version, scr.cmds[1].Data, true
+}
+
+// IsPushOnly returns whether the script only pushes data onto the stack.
+func (scr *Script) IsPushOnly() bool {
+	for _, cmd := range scr.cmds {
+		if cmd.Opcode > Op16 {
+			return false
+		}
 	}
 	return true
 }
+
+// SigOpCount returns the number of signature operations in the script.
+// OP_CHECKMULTISIG counts as 20 unless accurate is set and it follows OP_1 to OP_16,
+// then it counts as that number, as for p2sh redeem scripts (BIP16).
+func (scr *Script) SigOpCount(accurate bool) int {
+	result := 0
+	for i, cmd := range scr.cmds {
+		switch cmd.Opcode {
+		case OpCheckSig, OpCheckSigVerify:
+			result++
+		case OpCheckMultiSig, OpCheckMultiSigVerify:
+			if accurate && i > 0 && scr.cmds[i-1].Opcode >= Op1 && scr.cmds[i-1].Opcode <= Op16 {
+				result += int(scr.cmds[i-1].Opcode) - Op1 + 1
+			} else {
+				result += MaxMultisigKeys
+			}
+		}
+	}
+	return result
+}
+
+// Evaluate the script.
+// Return true if the script execution succeeded, and false with the reason it failed otherwise.
+func (scr *Script) Evaluate(z []byte) (bool, error) {
+	engine := NewEngine(new(Script), scr, nil, z)
+	if err := engine.Run(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"testing"
 
+	"github.com/ravdin/programmingbitcoin/ecc"
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
@@ -19,13 +20,22 @@ func TestScript(t *testing.T) {
 			`035d5c93d9ac96881f19ba1f686f15f009ded7c62efe85a872e6a19b43c15a2937`,
 		}
 		for i, expected := range cmds {
-			actual := hex.EncodeToString(s.cmds[i])
+			actual := hex.EncodeToString(s.cmds[i].Data)
 			if actual != expected {
 				t.Errorf("Expected %v, got %v", expected, actual)
 			}
 		}
 	})
 
+	t.Run("Test evaluate", func(t *testing.T) {
+		if ok, err := new(Script).AppendInt(2).AppendInt(2).AppendOp(OpEqual).Evaluate(nil); !ok || err != nil {
+			t.Errorf("Expected success, got %v", err)
+		}
+		if ok, err := new(Script).AppendInt(2).AppendInt(3).AppendOp(OpEqualVerify).Evaluate(nil); ok || err == nil {
+			t.Errorf("Expected the engine's error")
+		}
+	})
+
 	t.Run("Test serialize", func(t *testing.T) {
 		serialized := hex.EncodeToString(s.Serialize())
 		if serialized != scriptPubKey {
@@ -33,3 +43,59 @@ func TestScript(t *testing.T) {
 		}
 	})
 }
+
+func TestMultisig(t *testing.T) {
+	// BIP67 test vector
+	keys := []string{
+		"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+		"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+	}
+	var pubKeys []*ecc.S256Point
+	for _, key := range keys {
+		pubKey, err := ecc.ParseSec(util.HexStringToBytes(key))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	t.Run("Test sorted multisig", func(t *testing.T) {
+		s, err := SortedMultisigScript(2, pubKeys)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae"
+		if actual := hex.EncodeToString(s.RawSerialize()); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+		m, secs, ok := s.Multisig()
+		if !ok || m != 2 || len(secs) != 2 || hex.EncodeToString(secs[0]) != keys[1] {
+			t.Errorf("Failed to read the multisig script back")
+		}
+	})
+
+	t.Run("Test invalid multisig", func(t *testing.T) {
+		if _, err := MultisigScript(3, pubKeys); err == nil {
+			t.Errorf("Expected an error for a threshold above the key count")
+		}
+		if _, err := MultisigScript(1, nil); err == nil {
+			t.Errorf("Expected an error without keys")
+		}
+		if _, _, ok := P2pkhScript(make([]byte, 20)).Multisig(); ok {
+			t.Errorf("Expected a p2pkh script not to be multisig")
+		}
+	})
+	t.Run("Test sigop count", func(t *testing.T) {
+		s, _ := MultisigScript(2, pubKeys)
+		if actual := s.SigOpCount(true); actual != 2 {
+			t.Errorf("Expected 2, got %d", actual)
+		}
+		if actual := s.SigOpCount(false); actual != MaxMultisigKeys {
+			t.Errorf("Expected %d, got %d", MaxMultisigKeys, actual)
+		}
+		s = P2pkhScript(make([]byte, 20)).AppendOp(OpCheckSigVerify).AppendOp(OpCheckMultiSig)
+		if actual := s.SigOpCount(true); actual != 22 {
+			t.Errorf("Expected 22, got %d", actual)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package script
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// TapscriptLeafVersion is the BIP342 leaf version.
+const TapscriptLeafVersion byte = 0xc0
+
+// TapLeafHash returns the BIP341 hash of a script tree leaf.
+func TapLeafHash(leafVersion byte, scr *Script) []byte {
+	data := append([]byte{leafVersion}, scr.Serialize()...)
+	return util.TaggedHash("TapLeaf", data)
+}
+
+// TapBranchHash returns the BIP341 hash of two script tree nodes.
+func TapBranchHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	data := make([]byte, 0, 64)
+	data = append(data, a...)
+	data = append(data, b...)
+	return util.TaggedHash("TapBranch", data)
+}
+
+// TapTweak returns the tweak added to an internal key for a script tree merkle root.
+// merkleRoot is nil for a key path only output.
+func TapTweak(internalKey *ecc.S256Point, merkleRoot []byte) *big.Int {
+	data := append(internalKey.XOnly(), merkleRoot...)
+	return new(big.Int).SetBytes(util.TaggedHash("TapTweak", data))
+}
+
+// TaprootOutputKey returns the BIP341 output key for an internal key and merkle root.
+// merkleRoot is nil for a key path only output.
+func TaprootOutputKey(internalKey *ecc.S256Point, merkleRoot []byte) (*ecc.S256Point, error) {
+	// the internal key is always taken with an even y coordinate
+	evenKey, err := ecc.ParseXOnly(internalKey.XOnly())
+	if err != nil {
+		return nil, err
+	}
+	return evenKey.TweakAdd(TapTweak(evenKey, merkleRoot))
+}
@@ -0,0 +1,210 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/psbt"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Client talks to a signer daemon. It implements tx.Signer with the daemon's keys,
+// and the scripts added to it. It is safe for concurrent use.
+type Client struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	encoder   *json.Encoder
+	decoder   *json.Decoder
+	secret    []byte
+	challenge []byte
+	seq       uint64
+	// pubKeys holds the secs and x-only taproot output keys of the daemon's keys,
+	// hash160s the secs by their hash160.
+	pubKeys  map[string]bool
+	hash160s map[string][]byte
+	scripts  map[string]*script.Script
+}
+
+// Dial connects to a signer daemon listening on a Unix socket or TCP address.
+func Dial(network, address string, secret []byte) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	result, err := NewClient(conn, secret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// NewClient returns a client talking to a signer daemon over a connection,
+// after looking up the daemon's public keys.
+func NewClient(conn net.Conn, secret []byte) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		encoder:  json.NewEncoder(conn),
+		decoder:  json.NewDecoder(conn),
+		secret:   secret,
+		pubKeys:  make(map[string]bool),
+		hash160s: make(map[string][]byte),
+		scripts:  make(map[string]*script.Script),
+	}
+	var h hello
+	if err := c.decoder.Decode(&h); err != nil {
+		return nil, err
+	}
+	challenge, err := hex.DecodeString(h.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, errors.New("invalid challenge")
+	}
+	c.challenge = challenge
+	pubKeys, err := c.PubKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, sec := range pubKeys {
+		point := ecc.ParseS256Point(sec)
+		for _, compressed := range []bool{true, false} {
+			sec := point.Sec(compressed)
+			c.pubKeys[hex.EncodeToString(sec)] = true
+			c.hash160s[hex.EncodeToString(util.Hash160(sec))] = sec
+		}
+		c.addScript(script.P2wpkhScript(point.Hash160(true)))
+		if outputKey, err := script.TaprootOutputKey(point, nil); err == nil {
+			c.pubKeys[hex.EncodeToString(outputKey.XOnly())] = true
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a request and decodes the result of the response into result.
+func (c *Client) call(method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	req := &request{Seq: c.seq, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+	req.MAC = hex.EncodeToString(req.mac(c.secret, c.challenge))
+	if err := c.encoder.Encode(req); err != nil {
+		return err
+	}
+	var resp response
+	if err := c.decoder.Decode(&resp); err != nil {
+		return err
+	}
+	received, _ := hex.DecodeString(resp.MAC)
+	if resp.Seq != c.seq || !hmac.Equal(received, resp.mac(c.secret, c.challenge)) {
+		return errors.New("response failed authentication")
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// PubKeys returns the compressed secs of the daemon's keys.
+func (c *Client) PubKeys() ([][]byte, error) {
+	var result pubKeysResult
+	if err := c.call(MethodGetPubKeys, nil, &result); err != nil {
+		return nil, err
+	}
+	pubKeys := make([][]byte, len(result.PubKeys))
+	for i, pubKey := range result.PubKeys {
+		sec, err := hex.DecodeString(pubKey)
+		if err != nil || len(sec) != 33 || (sec[0] != 2 && sec[0] != 3) {
+			return nil, fmt.Errorf("invalid public key %q", pubKey)
+		}
+		pubKeys[i] = sec
+	}
+	return pubKeys, nil
+}
+
+// SignSigHash returns the daemon's signature of a signature hash: DER for a sec public key,
+// or BIP340 for a key path spend of an x-only taproot output key.
+func (c *Client) SignSigHash(pubKey, z []byte, taproot bool) ([]byte, error) {
+	params := &sigHashParams{PubKey: hex.EncodeToString(pubKey), SigHash: hex.EncodeToString(z), Taproot: taproot}
+	var result signatureResult
+	if err := c.call(MethodSignSigHash, params, &result); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(result.Signature)
+}
+
+// SignPSBT returns a PSBT with signatures from the daemon for the inputs it has keys for.
+func (c *Client) SignPSBT(p *psbt.PSBT) (*psbt.PSBT, error) {
+	var result psbtMessage
+	if err := c.call(MethodSignPSBT, &psbtMessage{PSBT: p.Base64()}, &result); err != nil {
+		return nil, err
+	}
+	return psbt.ParseBase64(result.PSBT)
+}
+
+// AddScript adds a p2sh redeem script or p2wsh witness script for signing inputs.
+// A p2wsh script added for p2sh-p2wsh outputs is added as well.
+func (c *Client) AddScript(scr *script.Script) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addScript(scr)
+	if _, _, ok := scr.WitnessProgram(); !ok {
+		c.addScript(script.P2wshScript(util.Sha256(scr.RawSerialize())))
+	}
+}
+
+func (c *Client) addScript(scr *script.Script) {
+	raw := scr.RawSerialize()
+	c.scripts[hex.EncodeToString(util.Hash160(raw))] = scr
+	c.scripts[hex.EncodeToString(util.Sha256(raw))] = scr
+}
+
+// PubKeyForHash160 implements tx.Signer.
+func (c *Client) PubKeyForHash160(h160 []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sec, ok := c.hash160s[hex.EncodeToString(h160)]
+	return sec, ok
+}
+
+// HasPubKey implements tx.Signer.
+func (c *Client) HasPubKey(pubKey []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pubKeys[hex.EncodeToString(pubKey)]
+}
+
+// Script implements tx.Signer.
+func (c *Client) Script(hash []byte) (*script.Script, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scr, ok := c.scripts[hex.EncodeToString(hash)]
+	return scr, ok
+}
+
+// SignECDSA implements tx.Signer.
+func (c *Client) SignECDSA(pubKey, z []byte) ([]byte, error) {
+	return c.SignSigHash(pubKey, z, false)
+}
+
+// SignTaproot implements tx.Signer.
+func (c *Client) SignTaproot(outputKey, z []byte) ([]byte, error) {
+	return c.SignSigHash(outputKey, z, true)
+}
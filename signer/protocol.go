@@ -0,0 +1,94 @@
+// Package signer keeps private keys in a daemon that signs for clients over a socket.
+//
+// The protocol is JSON, one message per line. The daemon greets each connection with a random
+// challenge. Requests carry a sequence number, starting at 1, and an HMAC-SHA256 with a secret
+// shared by the client and the daemon over the challenge, the sequence number, the method and
+// its parameters; responses carry one over the result, so neither side accepts messages replayed
+// from another connection or out of order.
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// Methods of the protocol.
+const (
+	// MethodGetPubKeys returns the compressed secs of the daemon's keys.
+	MethodGetPubKeys = "getpubkeys"
+	// MethodSignSigHash signs a signature hash, if the policy allows it.
+	MethodSignSigHash = "signsighash"
+	// MethodSignPSBT signs the inputs of a PSBT the daemon has keys for, if the policy allows
+	// its outputs. Inputs other than taproot ones need their utxo transaction, and only
+	// SIGHASH_ALL signatures, which commit to the outputs, are made.
+	MethodSignPSBT = "signpsbt"
+)
+
+type hello struct {
+	Challenge string `json:"challenge"`
+}
+
+type request struct {
+	Seq    uint64          `json:"seq"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	MAC    string          `json:"mac"`
+}
+
+type response struct {
+	Seq    uint64          `json:"seq"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	MAC    string          `json:"mac"`
+}
+
+type pubKeysResult struct {
+	PubKeys []string `json:"pubkeys"`
+}
+
+// sigHashParams asks for an ECDSA signature by a sec public key,
+// or with Taproot set a key path signature for an x-only output key.
+type sigHashParams struct {
+	PubKey  string `json:"pubkey"`
+	SigHash string `json:"sighash"`
+	Taproot bool   `json:"taproot,omitempty"`
+}
+
+type signatureResult struct {
+	Signature string `json:"signature"`
+}
+
+// psbtMessage holds a base64 PSBT, the parameter and result of MethodSignPSBT.
+type psbtMessage struct {
+	PSBT string `json:"psbt"`
+}
+
+// mac returns the HMAC of a message: the challenge of the connection, the sequence number,
+// what kind of message it is and its fields, each prefixed with its length.
+func mac(secret, challenge []byte, seq uint64, kind string, fields ...[]byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	var buf [8]byte
+	write := func(data []byte) {
+		binary.BigEndian.PutUint64(buf[:], uint64(len(data)))
+		h.Write(buf[:])
+		h.Write(data)
+	}
+	write(challenge)
+	binary.BigEndian.PutUint64(buf[:], seq)
+	h.Write(buf[:])
+	write([]byte(kind))
+	for _, field := range fields {
+		write(field)
+	}
+	return h.Sum(nil)
+}
+
+func (r *request) mac(secret, challenge []byte) []byte {
+	return mac(secret, challenge, r.Seq, "request", []byte(r.Method), r.Params)
+}
+
+func (r *response) mac(secret, challenge []byte) []byte {
+	return mac(secret, challenge, r.Seq, "response", r.Result, []byte(r.Error))
+}
@@ -0,0 +1,283 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/psbt"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Policy limits what the daemon signs.
+type Policy struct {
+	// Whitelist holds the hex scriptPubKeys payments can go to, nil allows any.
+	// Outputs paying the daemon's own keys are change and always allowed.
+	Whitelist map[string]bool
+	// MaxAmount is the most a PSBT can spend, the payments and the fee, and
+	// MaxDailyAmount the most over 24 hours. 0 is no limit.
+	MaxAmount      uint64
+	MaxDailyAmount uint64
+	// AllowSigHash allows signing bare signature hashes, which the policy can't check.
+	AllowSigHash bool
+}
+
+// NewPolicy returns a policy without limits that only signs PSBTs.
+func NewPolicy() *Policy {
+	return &Policy{}
+}
+
+// AllowAddress adds an address to the whitelist.
+func (p *Policy) AllowAddress(address string, testnet bool) error {
+	scriptPubKey, err := script.FromAddress(address, testnet)
+	if err != nil {
+		return err
+	}
+	if p.Whitelist == nil {
+		p.Whitelist = make(map[string]bool)
+	}
+	p.Whitelist[hex.EncodeToString(scriptPubKey.RawSerialize())] = true
+	return nil
+}
+
+type spend struct {
+	time   time.Time
+	amount uint64
+}
+
+// Server is a signer daemon holding private keys.
+type Server struct {
+	keys     []*ecc.PrivateKey
+	keystore *tx.Keystore
+	secret   []byte
+	policy   *Policy
+	mu       sync.Mutex
+	// spends are the amounts signed for over the last 24 hours.
+	spends []spend
+	now    func() time.Time
+}
+
+// NewServer returns a daemon that signs with keys for clients that know the secret.
+func NewServer(secret []byte, policy *Policy, keys ...*ecc.PrivateKey) *Server {
+	return &Server{
+		keys:     keys,
+		keystore: tx.NewKeystore(keys...),
+		secret:   secret,
+		policy:   policy,
+		now:      time.Now,
+	}
+}
+
+// Serve handles the connections of a listener until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				log.Printf("signer: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn handles the requests of a connection until it is closed or a request
+// fails authentication.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+	if err := encoder.Encode(&hello{Challenge: hex.EncodeToString(challenge)}); err != nil {
+		return err
+	}
+	for seq := uint64(1); ; seq++ {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			return nil
+		}
+		received, _ := hex.DecodeString(req.MAC)
+		if req.Seq != seq || !hmac.Equal(received, req.mac(s.secret, challenge)) {
+			return fmt.Errorf("request %d from %s failed authentication", req.Seq, conn.RemoteAddr())
+		}
+		resp := &response{Seq: seq}
+		result, err := s.handle(req.Method, req.Params)
+		if err == nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.MAC = hex.EncodeToString(resp.mac(s.secret, challenge))
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case MethodGetPubKeys:
+		result := &pubKeysResult{PubKeys: []string{}}
+		for _, pk := range s.keys {
+			result.PubKeys = append(result.PubKeys, hex.EncodeToString(pk.Point.Sec(true)))
+		}
+		return result, nil
+	case MethodSignSigHash:
+		var p sigHashParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.signSigHash(&p)
+	case MethodSignPSBT:
+		var p psbtMessage
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.signPSBT(&p)
+	}
+	return nil, fmt.Errorf("unknown method %q", method)
+}
+
+func (s *Server) signSigHash(p *sigHashParams) (*signatureResult, error) {
+	if !s.policy.AllowSigHash {
+		return nil, errors.New("policy doesn't allow signing signature hashes")
+	}
+	pubKey, err := hex.DecodeString(p.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	z, err := hex.DecodeString(p.SigHash)
+	if err != nil || len(z) != 32 {
+		return nil, errors.New("invalid signature hash")
+	}
+	var sig []byte
+	if p.Taproot {
+		sig, err = s.keystore.SignTaproot(pubKey, z)
+	} else {
+		sig, err = s.keystore.SignECDSA(pubKey, z)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &signatureResult{Signature: hex.EncodeToString(sig)}, nil
+}
+
+func (s *Server) signPSBT(p *psbtMessage) (*psbtMessage, error) {
+	packet, err := psbt.ParseBase64(p.PSBT)
+	if err != nil {
+		return nil, err
+	}
+	// signatures that don't commit to all the outputs would let them be replaced after the checks
+	for i, in := range packet.Inputs {
+		if in.SigHashType != 0 && in.SigHashType != util.SigHashAll && in.SigHashType != util.SigHashDefault {
+			return nil, fmt.Errorf("input %d asks for hash type %#x, only SIGHASH_ALL is signed", i, in.SigHashType)
+		}
+	}
+	amount, err := s.spent(packet)
+	if err != nil {
+		return nil, err
+	}
+	signed := 0
+	for i := range packet.Inputs {
+		for _, pk := range s.keys {
+			// keys that aren't in the input are skipped
+			if err := packet.Sign(i, pk); err == nil {
+				if err := checkUtxo(packet, i); err != nil {
+					return nil, err
+				}
+				signed++
+			}
+		}
+	}
+	if signed == 0 {
+		return nil, errors.New("no inputs to sign")
+	}
+	if err := s.record(amount); err != nil {
+		return nil, err
+	}
+	return &psbtMessage{PSBT: packet.Base64()}, nil
+}
+
+// checkUtxo checks that the amount of an input the daemon signs is proven by the transaction
+// holding the output it spends. A segwit v0 signature only commits to the amount of its own input,
+// so a client could understate amounts, have the inputs signed one at a time, and spend the
+// difference as fee (CVE-2020-14199). Taproot signatures commit to the amounts of every input.
+func checkUtxo(packet *psbt.PSBT, inputIndex int) error {
+	in := packet.Inputs[inputIndex]
+	if in.NonWitnessUtxo == nil {
+		if in.WitnessUtxo != nil {
+			if version, _, ok := in.WitnessUtxo.ScriptPubKey.WitnessProgram(); ok && version == 1 {
+				return nil
+			}
+		}
+		return fmt.Errorf("input %d has no utxo transaction to prove its amount", inputIndex)
+	}
+	// the utxo transaction was checked against the input when the fee was worked out
+	prevIndex := packet.UnsignedTx.Inputs[inputIndex].PrevIndex
+	utxo := in.NonWitnessUtxo.Outputs[prevIndex]
+	if in.WitnessUtxo != nil && (in.WitnessUtxo.Amount != utxo.Amount ||
+		!bytes.Equal(in.WitnessUtxo.ScriptPubKey.RawSerialize(), utxo.ScriptPubKey.RawSerialize())) {
+		return fmt.Errorf("input %d: witness utxo doesn't match the utxo transaction", inputIndex)
+	}
+	return nil
+}
+
+// spent returns how much a PSBT spends: its fee and the outputs that don't pay the daemon's keys,
+// which have to be on the whitelist.
+func (s *Server) spent(packet *psbt.PSBT) (uint64, error) {
+	result, err := packet.Fee()
+	if err != nil {
+		return 0, err
+	}
+	for i, txOut := range packet.UnsignedTx.Outputs {
+		if tx.IsMine(s.keystore, txOut.ScriptPubKey) {
+			continue
+		}
+		if s.policy.Whitelist != nil && !s.policy.Whitelist[hex.EncodeToString(txOut.ScriptPubKey.RawSerialize())] {
+			return 0, fmt.Errorf("output %d pays %s, which isn't on the whitelist", i, txOut.ScriptPubKey)
+		}
+		result += txOut.Amount
+	}
+	if s.policy.MaxAmount > 0 && result > s.policy.MaxAmount {
+		return 0, fmt.Errorf("spends %d, the most is %d", result, s.policy.MaxAmount)
+	}
+	return result, nil
+}
+
+// record adds an amount to the amounts signed for in the last 24 hours, if it stays under the daily limit.
+func (s *Server) record(amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var total uint64
+	var recent []spend
+	for _, sp := range s.spends {
+		if now.Sub(sp.time) < 24*time.Hour {
+			recent = append(recent, sp)
+			total += sp.amount
+		}
+	}
+	s.spends = recent
+	if s.policy.MaxDailyAmount > 0 && total+amount > s.policy.MaxDailyAmount {
+		return fmt.Errorf("spends %d, %d is left for today", amount, s.policy.MaxDailyAmount-total)
+	}
+	s.spends = append(s.spends, spend{now, amount})
+	return nil
+}
@@ -0,0 +1,236 @@
+package signer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/psbt"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+var testSecret = []byte("correct horse battery staple")
+
+// connect returns a client of a daemon over an in-memory connection.
+func connect(server *Server, secret []byte) (*Client, error) {
+	clientConn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client, err := NewClient(clientConn, secret)
+	if err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func TestSigner(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(9001)),
+		ecc.NewPrivateKey(big.NewInt(9002)),
+		ecc.NewPrivateKey(big.NewInt(9003)),
+	}
+	pk := keys[0]
+	p2wpkh := script.P2wpkhScript(pk.Point.Hash160(true))
+	multisig, _ := script.MultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	outputKey, _ := script.TaprootOutputKey(pk.EvenY().Point, nil)
+	prevTx := tx.NewTransaction(1, []*tx.Input{tx.NewInput(make([]byte, 32), 0, nil, 0xffffffff)}, []*tx.Output{
+		tx.NewOutput(100000, p2wpkh),
+		tx.NewOutput(100000, script.P2trScript(outputKey.XOnly())),
+		tx.NewOutput(100000, script.P2wshScript(util.Sha256(multisig.RawSerialize()))),
+	}, 0, true)
+	provider := tx.NewMemoryProvider(prevTx)
+	payee := script.P2pkhScript(util.Hash160([]byte("payee")))
+	payeeAddress, _ := payee.Address(true)
+	unsigned := func(payment uint64) *tx.Transaction {
+		var txIns []*tx.Input
+		for i := range prevTx.Outputs {
+			txIns = append(txIns, tx.NewInput(prevTx.Hash(), i, nil, 0xfffffffd))
+		}
+		txOuts := []*tx.Output{tx.NewOutput(payment, payee), tx.NewOutput(290000-payment, p2wpkh)}
+		return tx.NewTransaction(2, txIns, txOuts, 0, true)
+	}
+	newPSBT := func(payment uint64) *psbt.PSBT {
+		p, err := psbt.New(unsigned(payment))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i := range p.Inputs {
+			if err := p.SetUtxo(i, prevTx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		p.Inputs[1].TapInternalKey = pk.Point.XOnly()
+		p.Inputs[2].WitnessScript = multisig
+		return p
+	}
+
+	t.Run("Test sign all remotely", func(t *testing.T) {
+		policy := NewPolicy()
+		policy.AllowSigHash = true
+		client, err := connect(NewServer(testSecret, policy, keys[:2]...), testSecret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer client.Close()
+		pubKeys, err := client.PubKeys()
+		if err != nil || len(pubKeys) != 2 {
+			t.Fatalf("Expected 2 public keys, got %d %v", len(pubKeys), err)
+		}
+		client.AddScript(multisig)
+		txObj := unsigned(50000)
+		unsignedInputs, err := txObj.SignAll(provider, client)
+		if err != nil || len(unsignedInputs) != 0 {
+			t.Fatalf("Expected every input to be signed, got %v %v", unsignedInputs, err)
+		}
+		if ok, err := txObj.Verify(provider); !ok {
+			t.Errorf("Verify failed: %v", err)
+		}
+	})
+
+	t.Run("Test signature hashes not allowed", func(t *testing.T) {
+		client, err := connect(NewServer(testSecret, NewPolicy(), keys...), testSecret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer client.Close()
+		if _, err := unsigned(50000).SignAll(provider, client); err == nil || !strings.Contains(err.Error(), "policy") {
+			t.Errorf("Expected the policy to refuse, got %v", err)
+		}
+	})
+
+	t.Run("Test sign PSBT", func(t *testing.T) {
+		policy := NewPolicy()
+		if err := policy.AllowAddress(payeeAddress, true); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		policy.MaxAmount = 100000
+		policy.MaxDailyAmount = 150000
+		server := NewServer(testSecret, policy, keys[:2]...)
+		now := time.Now()
+		server.now = func() time.Time { return now }
+		client, err := connect(server, testSecret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer client.Close()
+		signed, err := client.SignPSBT(newPSBT(50000))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := signed.Finalize(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		txObj, err := signed.Extract()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok, err := txObj.Verify(provider); !ok {
+			t.Errorf("Verify failed: %v", err)
+		}
+		if _, err := client.SignPSBT(newPSBT(150000)); err == nil {
+			t.Errorf("Expected an error over the limit per PSBT")
+		}
+		if _, err := client.SignPSBT(newPSBT(90000)); err == nil {
+			t.Errorf("Expected an error over the daily limit")
+		}
+		now = now.Add(25 * time.Hour)
+		if _, err := client.SignPSBT(newPSBT(90000)); err != nil {
+			t.Errorf("Expected the daily limit to reset, got %v", err)
+		}
+		other := newPSBT(50000)
+		other.UnsignedTx.Outputs[0].ScriptPubKey = script.P2pkhScript(util.Hash160([]byte("thief")))
+		if _, err := client.SignPSBT(other); err == nil || !strings.Contains(err.Error(), "whitelist") {
+			t.Errorf("Expected an error for an output off the whitelist, got %v", err)
+		}
+	})
+
+	t.Run("Test understated amounts", func(t *testing.T) {
+		policy := NewPolicy()
+		policy.MaxAmount = 100000
+		client, err := connect(NewServer(testSecret, policy, keys[:2]...), testSecret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer client.Close()
+		// the change is cut by 90000, which goes to the fee, and the p2wpkh input's amount
+		// is understated by as much to hide it
+		lying := newPSBT(50000)
+		lying.UnsignedTx.Outputs[1].Amount -= 90000
+		lying.Inputs[0].NonWitnessUtxo = nil
+		lying.Inputs[0].WitnessUtxo = tx.NewOutput(prevTx.Outputs[0].Amount-90000, p2wpkh)
+		if fee, err := lying.Fee(); err != nil || fee != 10000 {
+			t.Fatalf("Expected a fee of 10000, got %d %v", fee, err)
+		}
+		if _, err := client.SignPSBT(lying); err == nil || !strings.Contains(err.Error(), "utxo") {
+			t.Errorf("Expected an error for a witness utxo without its transaction, got %v", err)
+		}
+		// with the utxo transaction the real fee is over the limit
+		lying.Inputs[0].NonWitnessUtxo = prevTx
+		if _, err := client.SignPSBT(lying); err == nil || !strings.Contains(err.Error(), "spends") {
+			t.Errorf("Expected the real fee to go over the limit, got %v", err)
+		}
+		mismatch := newPSBT(50000)
+		mismatch.Inputs[0].WitnessUtxo = tx.NewOutput(prevTx.Outputs[0].Amount-1, p2wpkh)
+		if _, err := client.SignPSBT(mismatch); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+			t.Errorf("Expected an error for a witness utxo that doesn't match, got %v", err)
+		}
+		// taproot signatures commit to every amount, the witness utxo is enough
+		taproot := newPSBT(50000)
+		taproot.Inputs[1].NonWitnessUtxo = nil
+		if _, err := client.SignPSBT(taproot); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test hash types", func(t *testing.T) {
+		client, err := connect(NewServer(testSecret, NewPolicy(), keys[:2]...), testSecret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer client.Close()
+		// signatures that don't commit to the outputs would let them be swapped after the policy checks
+		for _, hashType := range []uint32{util.SigHashNone, util.SigHashSingle, util.SigHashAll | util.SigHashAnyoneCanPay} {
+			p := newPSBT(50000)
+			p.Inputs[0].SigHashType = hashType
+			if _, err := client.SignPSBT(p); err == nil || !strings.Contains(err.Error(), "hash type") {
+				t.Errorf("Expected an error for hash type %#x, got %v", hashType, err)
+			}
+		}
+		p := newPSBT(50000)
+		p.Inputs[0].SigHashType = util.SigHashAll
+		p.Inputs[1].SigHashType = util.SigHashDefault
+		if _, err := client.SignPSBT(p); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Test authentication", func(t *testing.T) {
+		server := NewServer(testSecret, NewPolicy(), keys...)
+		if _, err := connect(server, []byte("wrong secret")); err == nil {
+			t.Errorf("Expected an error with the wrong secret")
+		}
+		// a request replayed on another connection has the wrong challenge
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		go server.ServeConn(serverConn)
+		decoder := json.NewDecoder(clientConn)
+		var h hello
+		if err := decoder.Decode(&h); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		req := &request{Seq: 1, Method: MethodGetPubKeys}
+		req.MAC = hex.EncodeToString(req.mac(testSecret, []byte("another challenge")))
+		json.NewEncoder(clientConn).Encode(req)
+		var resp response
+		if err := decoder.Decode(&resp); err == nil {
+			t.Errorf("Expected the connection to close, got %+v", resp)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package tx
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TxCache is a TxProvider keeping the transactions another provider returns,
+// up to a number of them, evicting the least recently used.
+// It can write the transactions through to a file so they survive restarts,
+// which is rewritten with just the cached transactions once evictions make it
+// twice the capacity. It is safe for concurrent use.
+// Transactions are kept serialized, each lookup returns a copy the caller can
+// sign or change without changing the cache.
+type TxCache struct {
+	mu       sync.Mutex
+	provider TxProvider
+	capacity int
+	entries  map[cacheKey]*list.Element
+	// order holds the entries, most recently used first
+	order *list.List
+	// filename is the file transactions are written through to, "" for none
+	filename string
+	// records is the number of transactions in the file
+	records int
+}
+
+type cacheKey struct {
+	txID    string
+	testnet bool
+}
+
+type cacheEntry struct {
+	key cacheKey
+	// raw is the serialized transaction
+	raw []byte
+}
+
+// cacheRecord is a line of a cache file.
+type cacheRecord struct {
+	TxID    string `json:"txid"`
+	Testnet bool   `json:"testnet"`
+	// Hex is the serialized transaction, with the witness of a segwit transaction.
+	Hex string `json:"hex"`
+}
+
+// NewTxCache returns an empty cache in front of provider, which may be nil for a cache
+// of added transactions only. capacity is the number of transactions kept, 0 for no limit.
+func NewTxCache(provider TxProvider, capacity int) *TxCache {
+	return &TxCache{
+		provider: provider,
+		capacity: capacity,
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// OpenTxCache returns a cache that loads filename if it exists
+// and writes the transactions it gets through to it.
+func OpenTxCache(provider TxProvider, capacity int, filename string) (*TxCache, error) {
+	result := NewTxCache(provider, capacity)
+	if err := result.Load(filename); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// drop what was evicted or written twice
+	result.filename = filename
+	if err := result.Save(filename); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Add adds a transaction for its network.
+func (c *TxCache) Add(tx *Transaction) error {
+	return c.add(cacheKey{txID: tx.ID(), testnet: tx.Testnet}, tx.Serialize())
+}
+
+func (c *TxCache) add(key cacheKey, raw []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return nil
+	}
+	c.insert(key, raw)
+	if c.filename == "" {
+		return nil
+	}
+	if c.capacity > 0 && c.records >= 2*c.capacity {
+		return c.save(c.filename)
+	}
+	if err := c.append(c.filename, []*cacheEntry{{key: key, raw: raw}}); err != nil {
+		return err
+	}
+	c.records++
+	return nil
+}
+
+func (c *TxCache) insert(key cacheKey, raw []byte) {
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).raw = raw
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, raw: raw})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Transaction returns a copy of a cached transaction, or asks the provider and caches it.
+func (c *TxCache) Transaction(txID string, testnet bool) (*Transaction, error) {
+	key := cacheKey{txID: txID, testnet: testnet}
+	c.mu.Lock()
+	var raw []byte
+	elem, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(elem)
+		// insert can replace the transaction once the lock is released
+		raw = elem.Value.(*cacheEntry).raw
+	}
+	c.mu.Unlock()
+	if ok {
+		return ParseRawTransaction(raw, testnet)
+	}
+	if c.provider == nil {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	// the lock isn't held while fetching so other lookups aren't blocked
+	tx, err := c.provider.Transaction(txID, testnet)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.add(key, tx.Serialize()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Prevout returns an output of a cached or fetched transaction.
+func (c *TxCache) Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	return txPrevout(c, prevTx, prevIndex, testnet)
+}
+
+// Len returns the number of cached transactions.
+func (c *TxCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Load adds the transactions of a file written by Save or write-through.
+// Each line of the file is a JSON object with the txid, network and hex transaction.
+func (c *TxCache) Load(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scanner := bufio.NewScanner(file)
+	// transactions can be much longer than the default line limit
+	scanner.Buffer(nil, 8*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record cacheRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		tx, err := parseHexTransaction(record.Hex, record.TxID, record.Testnet)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		c.insert(cacheKey{txID: record.TxID, testnet: record.Testnet}, tx.Serialize())
+	}
+	return scanner.Err()
+}
+
+// Save writes the cached transactions to a file, least recently used first
+// so loading it keeps the order.
+func (c *TxCache) Save(filename string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save(filename)
+}
+
+func (c *TxCache) save(filename string) error {
+	var entries []*cacheEntry
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		entries = append(entries, elem.Value.(*cacheEntry))
+	}
+	tmp := filename + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := c.append(tmp, entries); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	if filename == c.filename {
+		c.records = len(entries)
+	}
+	return nil
+}
+
+// append writes entries to the end of a file.
+func (c *TxCache) append(filename string, entries []*cacheEntry) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, entry := range entries {
+		record := cacheRecord{TxID: entry.key.txID, Testnet: entry.key.testnet, Hex: hex.EncodeToString(entry.raw)}
+		data, err := json.Marshal(record)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,185 @@
+package tx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Subsidy schedule.
+const (
+	// Coin is the number of satoshis in a bitcoin.
+	Coin = 100000000
+	// InitialSubsidy is the subsidy of the first blocks, halved every HalvingInterval blocks.
+	InitialSubsidy = 50 * Coin
+	// HalvingInterval is the halving interval of mainnet and testnet, regtest halves every 150 blocks.
+	HalvingInterval = 210000
+)
+
+// Coinbase scriptSig limits.
+const (
+	MinCoinbaseScriptSigSize = 2
+	MaxCoinbaseScriptSigSize = 100
+)
+
+// witnessCommitmentHeader starts the data of the witness commitment output (BIP141).
+var witnessCommitmentHeader = []byte{0xaa, 0x21, 0xa9, 0xed}
+
+// BlockSubsidy returns the new coins a block at height can pay out.
+func BlockSubsidy(height, halvingInterval int) uint64 {
+	halvings := height / halvingInterval
+	// shifting by 64 or more is undefined in Bitcoin Core, the subsidy is 0 by then anyway
+	if halvings >= 64 {
+		return 0
+	}
+	return InitialSubsidy >> uint(halvings)
+}
+
+// Coinbase describes the coinbase transaction of a block.
+type Coinbase struct {
+	Height int
+	// ExtraNonce follows the height in the scriptSig, for miners to vary when the nonce runs out.
+	ExtraNonce []byte
+	// Tag is arbitrary data at the end of the scriptSig.
+	Tag          []byte
+	PayoutScript *script.Script
+	// Fees are the fees of the block's other transactions.
+	Fees            uint64
+	HalvingInterval int
+	// Transactions are the block's other transactions, which the witness commitment commits to.
+	Transactions []*Transaction
+	// WitnessCommitment adds the commitment output, which segwit blocks need
+	// when any of their transactions have witness data.
+	WitnessCommitment bool
+	// WitnessReservedValue is the coinbase's witness, 32 zero bytes if nil.
+	WitnessReservedValue []byte
+	Testnet              bool
+}
+
+// NewCoinbase returns the coinbase of a block at height on mainnet or testnet paying out to payoutScript,
+// with a witness commitment.
+func NewCoinbase(height int, payoutScript *script.Script) *Coinbase {
+	return &Coinbase{
+		Height:            height,
+		PayoutScript:      payoutScript,
+		HalvingInterval:   HalvingInterval,
+		WitnessCommitment: true,
+	}
+}
+
+// Value returns what the coinbase pays out: the subsidy and the fees.
+func (c *Coinbase) Value() uint64 {
+	return BlockSubsidy(c.Height, c.HalvingInterval) + c.Fees
+}
+
+// ScriptSig returns the height (BIP34), extra nonce and tag, padded to the minimum size.
+func (c *Coinbase) ScriptSig() (*script.Script, error) {
+	if c.Height < 0 {
+		return nil, fmt.Errorf("invalid height %d", c.Height)
+	}
+	result := new(script.Script).AppendInt(c.Height)
+	if len(c.ExtraNonce) > 0 {
+		result.AppendData(c.ExtraNonce)
+	}
+	if len(c.Tag) > 0 {
+		result.AppendData(c.Tag)
+	}
+	// heights up to 16 are a single opcode
+	if len(result.RawSerialize()) < MinCoinbaseScriptSigSize {
+		result.AppendOp(script.Op0)
+	}
+	if size := len(result.RawSerialize()); size > MaxCoinbaseScriptSigSize {
+		return nil, fmt.Errorf("coinbase ScriptSig length %d over %d", size, MaxCoinbaseScriptSigSize)
+	}
+	return result, nil
+}
+
+// Build returns the coinbase transaction.
+func (c *Coinbase) Build() (*Transaction, error) {
+	if c.PayoutScript == nil {
+		return nil, errors.New("no payout script")
+	}
+	scriptSig, err := c.ScriptSig()
+	if err != nil {
+		return nil, err
+	}
+	txIn := NewInput(make([]byte, 32), 0xffffffff, scriptSig, SequenceFinal)
+	txOuts := []*Output{NewOutput(c.Value(), c.PayoutScript)}
+	if c.WitnessCommitment {
+		reserved := c.WitnessReservedValue
+		if reserved == nil {
+			reserved = make([]byte, 32)
+		}
+		txIn.Witness = [][]byte{reserved}
+		commitment := WitnessCommitment(append([]*Transaction{nil}, c.Transactions...), reserved)
+		txOuts = append(txOuts, NewOutput(0, WitnessCommitmentScript(commitment)))
+	}
+	return NewTransaction(2, []*Input{txIn}, txOuts, 0, c.Testnet), nil
+}
+
+// WitnessMerkleRoot returns the merkle root of the wtxids of a block's transactions,
+// coinbase first, whose wtxid counts as 32 zero bytes.
+func WitnessMerkleRoot(txs []*Transaction) []byte {
+	hashes := make([][]byte, len(txs))
+	hashes[0] = make([]byte, 32)
+	for i, t := range txs[1:] {
+		hashes[i+1] = util.Hash256(t.Serialize())
+	}
+	return util.MerkleRoot(hashes)
+}
+
+// WitnessCommitment returns the hash a block's coinbase commits to (BIP141):
+// the witness merkle root of its transactions and the witness reserved value.
+func WitnessCommitment(txs []*Transaction, reservedValue []byte) []byte {
+	return util.Hash256(append(WitnessMerkleRoot(txs), reservedValue...))
+}
+
+// WitnessCommitmentScript returns the ScriptPubKey of the witness commitment output.
+func WitnessCommitmentScript(commitment []byte) *script.Script {
+	data := append(append([]byte{}, witnessCommitmentHeader...), commitment...)
+	return new(script.Script).AppendOp(script.OpReturn).AppendData(data)
+}
+
+// WitnessCommitment returns the witness commitment of a coinbase,
+// from the last output that has one, or nil if there isn't one.
+func (tx *Transaction) WitnessCommitment() []byte {
+	for i := len(tx.Outputs) - 1; i >= 0; i-- {
+		raw := tx.Outputs[i].ScriptPubKey.RawSerialize()
+		if len(raw) >= 38 && raw[0] == script.OpReturn && raw[1] == 36 && bytes.Equal(raw[2:6], witnessCommitmentHeader) {
+			return raw[6:38]
+		}
+	}
+	return nil
+}
+
+// CoinbaseHeight returns the height of the block a coinbase is in (BIP34):
+// the number its ScriptSig starts with.
+func (tx *Transaction) CoinbaseHeight() (int, error) {
+	if !tx.IsCoinbase() {
+		return 0, errors.New("not a coinbase transaction")
+	}
+	scriptSig := tx.Inputs[0].ScriptSig
+	if scriptSig == nil || scriptSig.Len() == 0 {
+		return 0, errors.New("empty coinbase ScriptSig")
+	}
+	cmd := scriptSig.Commands()[0]
+	switch {
+	case cmd.Opcode == script.Op0:
+		return 0, nil
+	case cmd.Opcode >= script.Op1 && cmd.Opcode <= script.Op16:
+		return int(cmd.Opcode-script.Op1) + 1, nil
+	case !cmd.IsData():
+		return 0, fmt.Errorf("coinbase ScriptSig starts with %s, not a height", cmd)
+	case len(cmd.Data) == 0:
+		// an empty push, as OP_PUSHDATA1 can make, is the number 0
+		return 0, nil
+	case len(cmd.Data) > 4:
+		return 0, fmt.Errorf("coinbase height push of %d bytes", len(cmd.Data))
+	case cmd.Data[len(cmd.Data)-1]&0x80 != 0:
+		return 0, errors.New("negative coinbase height")
+	}
+	return int(util.LittleEndianToBigInt(cmd.Data).Int64()), nil
+}
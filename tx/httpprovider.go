@@ -0,0 +1,69 @@
+package tx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HTTPProvider fetches hex transactions from a REST endpoint.
+type HTTPProvider struct {
+	// MainnetURL and TestnetURL are the base URLs for each network.
+	MainnetURL string
+	TestnetURL string
+	// TxPath is the path of a hex transaction after the base URL, with %s for the txid.
+	TxPath string
+	Client *http.Client
+}
+
+// NewHTTPProvider returns a provider for the programmingbitcoin.com servers.
+func NewHTTPProvider() *HTTPProvider {
+	return &HTTPProvider{
+		MainnetURL: "http://mainnet.programmingbitcoin.com",
+		TestnetURL: "http://testnet.programmingbitcoin.com",
+		TxPath:     "/tx/%s.hex",
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewEsploraProvider returns a provider for an Esplora API,
+// e.g. https://blockstream.info/api and https://blockstream.info/testnet/api.
+func NewEsploraProvider(mainnetURL, testnetURL string) *HTTPProvider {
+	return &HTTPProvider{
+		MainnetURL: mainnetURL,
+		TestnetURL: testnetURL,
+		TxPath:     "/tx/%s/hex",
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Transaction fetches a transaction and checks that it has the requested id.
+func (p *HTTPProvider) Transaction(txID string, testnet bool) (*Transaction, error) {
+	baseURL := p.MainnetURL
+	if testnet {
+		baseURL = p.TestnetURL
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(baseURL + fmt.Sprintf(p.TxPath, txID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching transaction %s: %s", txID, resp.Status)
+	}
+	return parseHexTransaction(string(body), txID, testnet)
+}
+
+// Prevout returns an output of a fetched transaction.
+func (p *HTTPProvider) Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	return txPrevout(p, prevTx, prevIndex, testnet)
+}
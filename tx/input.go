@@ -2,7 +2,6 @@ package tx
 
 import (
 	"bytes"
-	"encoding/hex"
 
 	"github.com/ravdin/programmingbitcoin/script"
 	"github.com/ravdin/programmingbitcoin/util"
@@ -14,6 +13,8 @@ type Input struct {
 	PrevIndex int
 	ScriptSig *script.Script
 	Sequence  uint32
+	// Witness holds the segwit stack items, nil for legacy inputs.
+	Witness [][]byte
 }
 
 // NewInput initializes a new transaction input.
@@ -44,36 +45,72 @@ func ParseInput(s *bytes.Reader) *Input {
 
 // Serialize the transaction input
 func (in *Input) Serialize() []byte {
-	prevTx := make([]byte, len(in.PrevTx))
-	copy(prevTx, in.PrevTx)
-	util.ReverseByteArray(prevTx)
-	prevIndex := util.Int32ToLittleEndian(uint32(in.PrevIndex))
+	outpoint := in.outpoint()
 	scriptSig := in.ScriptSig.Serialize()
 	sequence := util.Int32ToLittleEndian(in.Sequence)
-	result := make([]byte, len(prevTx)+len(prevIndex)+len(scriptSig)+len(sequence))
+	result := make([]byte, len(outpoint)+len(scriptSig)+len(sequence))
 	index := 0
-	for _, item := range [][]byte{prevTx, prevIndex, scriptSig, sequence} {
+	for _, item := range [][]byte{outpoint, scriptSig, sequence} {
 		copy(result[index:], item)
 		index += len(item)
 	}
 	return result
 }
 
-// Value is the output value by looking up the tx hash
-// Returns the amount in satoshi
-func (in *Input) Value(testnet bool) uint64 {
-	tx := in.fetchTx(testnet)
-	return tx.Outputs[in.PrevIndex].Amount
+// serializeWitness serializes the number of witness items followed by each item.
+func (in *Input) serializeWitness() []byte {
+	result := util.EncodeVarInt(len(in.Witness))
+	for _, item := range in.Witness {
+		result = append(result, util.EncodeVarInt(len(item))...)
+		result = append(result, item...)
+	}
+	return result
 }
 
-// ScriptPubKey looks up the tx hash
-// Returns a Script object
-func (in *Input) ScriptPubKey(testnet bool) *script.Script {
-	tx := in.fetchTx(testnet)
-	return tx.Outputs[in.PrevIndex].ScriptPubKey
+// parseWitness parses the witness items of an input.
+func parseWitness(s *bytes.Reader) [][]byte {
+	count := util.ReadVarInt(s)
+	result := make([][]byte, count)
+	for i := range result {
+		result[i] = make([]byte, util.ReadVarInt(s))
+		s.Read(result[i])
+	}
+	return result
+}
+
+// annex returns the last witness item if it is a taproot annex (BIP341), nil otherwise.
+func (in *Input) annex() []byte {
+	count := len(in.Witness)
+	if count < 2 || len(in.Witness[count-1]) == 0 || in.Witness[count-1][0] != 0x50 {
+		return nil
+	}
+	return in.Witness[count-1]
+}
+
+// outpoint returns the serialized previous transaction hash and index.
+func (in *Input) outpoint() []byte {
+	result := make([]byte, len(in.PrevTx), len(in.PrevTx)+4)
+	copy(result, in.PrevTx)
+	util.ReverseByteArray(result)
+	return append(result, util.Int32ToLittleEndian(uint32(in.PrevIndex))...)
 }
 
-func (in *Input) fetchTx(testnet bool) *Transaction {
-	fetcher := newTxFetcher()
-	return fetcher.fetch(hex.EncodeToString(in.PrevTx), testnet, false)
+// Value looks up the output the input spends
+// Returns the amount in satoshi
+func (in *Input) Value(provider PrevoutProvider, testnet bool) (uint64, error) {
+	prevout, err := provider.Prevout(in.PrevTx, in.PrevIndex, testnet)
+	if err != nil {
+		return 0, err
+	}
+	return prevout.Amount, nil
+}
+
+// ScriptPubKey looks up the output the input spends
+// Returns a Script object
+func (in *Input) ScriptPubKey(provider PrevoutProvider, testnet bool) (*script.Script, error) {
+	prevout, err := provider.Prevout(in.PrevTx, in.PrevIndex, testnet)
+	if err != nil {
+		return nil, err
+	}
+	return prevout.ScriptPubKey, nil
 }
@@ -0,0 +1,303 @@
+package tx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Signer signs inputs with keys it may keep out of this process.
+// It looks up its public keys and the scripts inputs spend, and signs signature hashes.
+type Signer interface {
+	// PubKeyForHash160 returns the compressed or uncompressed sec that hashes to h160.
+	PubKeyForHash160(h160 []byte) ([]byte, bool)
+	// HasPubKey returns whether the signer has the key of a sec public key,
+	// or of an x-only taproot output key without a script tree.
+	HasPubKey(pubKey []byte) bool
+	// Script returns the script whose hash160 (p2sh) or sha256 (p2wsh) is hash.
+	Script(hash []byte) (*script.Script, bool)
+	// SignECDSA returns the DER signature of a signature hash by the key of a sec public key.
+	SignECDSA(pubKey, z []byte) ([]byte, error)
+	// SignTaproot returns the BIP340 signature of a signature hash for a key path spend
+	// of an x-only taproot output key.
+	SignTaproot(outputKey, z []byte) ([]byte, error)
+}
+
+type keystoreKey struct {
+	pk         *ecc.PrivateKey
+	compressed bool
+}
+
+// Keystore is a Signer holding keys and scripts in memory. It is safe for concurrent use.
+type Keystore struct {
+	mu      sync.RWMutex
+	hash160 map[string]keystoreKey
+	pubKeys map[string]*ecc.PrivateKey
+	scripts map[string]*script.Script
+}
+
+// NewKeystore returns a keystore holding keys.
+func NewKeystore(keys ...*ecc.PrivateKey) *Keystore {
+	result := &Keystore{
+		hash160: make(map[string]keystoreKey),
+		pubKeys: make(map[string]*ecc.PrivateKey),
+		scripts: make(map[string]*script.Script),
+	}
+	for _, pk := range keys {
+		result.AddKey(pk)
+	}
+	return result
+}
+
+// AddKey adds a key for its compressed and uncompressed public keys, its taproot output key
+// and its p2wpkh script, the redeem script of p2sh-p2wpkh outputs.
+func (ks *Keystore) AddKey(pk *ecc.PrivateKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, compressed := range []bool{true, false} {
+		sec := pk.Point.Sec(compressed)
+		ks.hash160[hex.EncodeToString(util.Hash160(sec))] = keystoreKey{pk, compressed}
+		ks.pubKeys[hex.EncodeToString(sec)] = pk
+	}
+	if outputKey, err := script.TaprootOutputKey(pk.EvenY().Point, nil); err == nil {
+		ks.pubKeys[hex.EncodeToString(outputKey.XOnly())] = pk
+	}
+	ks.addScript(script.P2wpkhScript(pk.Point.Hash160(true)))
+}
+
+// AddScript adds a p2sh redeem script or p2wsh witness script.
+// A p2wsh script added for p2sh-p2wsh outputs is added as well.
+func (ks *Keystore) AddScript(scr *script.Script) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.addScript(scr)
+	if _, _, ok := scr.WitnessProgram(); !ok {
+		ks.addScript(script.P2wshScript(util.Sha256(scr.RawSerialize())))
+	}
+}
+
+func (ks *Keystore) addScript(scr *script.Script) {
+	raw := scr.RawSerialize()
+	ks.scripts[hex.EncodeToString(util.Hash160(raw))] = scr
+	ks.scripts[hex.EncodeToString(util.Sha256(raw))] = scr
+}
+
+// PubKeyForHash160 implements Signer.
+func (ks *Keystore) PubKeyForHash160(h160 []byte) ([]byte, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.hash160[hex.EncodeToString(h160)]
+	if !ok {
+		return nil, false
+	}
+	return key.pk.Point.Sec(key.compressed), true
+}
+
+// HasPubKey implements Signer.
+func (ks *Keystore) HasPubKey(pubKey []byte) bool {
+	_, ok := ks.key(pubKey)
+	return ok
+}
+
+func (ks *Keystore) key(pubKey []byte) (*ecc.PrivateKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	pk, ok := ks.pubKeys[hex.EncodeToString(pubKey)]
+	return pk, ok
+}
+
+// Script implements Signer.
+func (ks *Keystore) Script(hash []byte) (*script.Script, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	scr, ok := ks.scripts[hex.EncodeToString(hash)]
+	return scr, ok
+}
+
+// SignECDSA implements Signer.
+func (ks *Keystore) SignECDSA(pubKey, z []byte) ([]byte, error) {
+	pk, ok := ks.key(pubKey)
+	if !ok || len(pubKey) == 32 {
+		return nil, fmt.Errorf("no key for %x", pubKey)
+	}
+	return pk.Sign(new(big.Int).SetBytes(z)).Der(), nil
+}
+
+// SignTaproot implements Signer.
+func (ks *Keystore) SignTaproot(outputKey, z []byte) ([]byte, error) {
+	pk, ok := ks.key(outputKey)
+	if !ok || len(outputKey) != 32 {
+		return nil, fmt.Errorf("no key for %x", outputKey)
+	}
+	tweaked, err := taprootKeyPathKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	return tweaked.SignSchnorr(z, nil)
+}
+
+// IsMine returns whether a signer has the key of a p2pkh, p2wpkh, p2sh-p2wpkh
+// or taproot key path scriptPubKey.
+func IsMine(signer Signer, scriptPubKey *script.Script) bool {
+	if scriptPubKey.IsP2pkhScriptPubKey() {
+		_, ok := signer.PubKeyForHash160(scriptPubKey.Peek(2))
+		return ok
+	}
+	program := scriptPubKey
+	if scriptPubKey.IsP2shScriptPubKey() {
+		redeemScript, ok := signer.Script(scriptPubKey.Peek(1))
+		if !ok {
+			return false
+		}
+		program = redeemScript
+	}
+	version, witnessProgram, ok := program.WitnessProgram()
+	switch {
+	case ok && version == 0 && len(witnessProgram) == 20:
+		sec, ok := signer.PubKeyForHash160(witnessProgram)
+		return ok && len(sec) == 33
+	case ok && version == 1 && len(witnessProgram) == 32 && program == scriptPubKey:
+		return signer.HasPubKey(witnessProgram)
+	}
+	return false
+}
+
+// SignAll signs every input it finds the keys and scripts for: p2pkh, p2wpkh, p2sh-p2wpkh,
+// multisig through p2sh, p2wsh or p2sh-p2wsh, and taproot key path spends.
+// ECDSA signatures are SIGHASH_ALL, taproot ones SIGHASH_DEFAULT.
+// provider looks up the outputs the inputs spend.
+// Returns the indexes of the inputs that aren't valid afterwards.
+func (tx *Transaction) SignAll(provider PrevoutProvider, signer Signer) ([]int, error) {
+	// look up each output once, taproot signature hashes commit to all of them
+	prevouts := NewMemoryProvider()
+	for _, txIn := range tx.Inputs {
+		prevout, err := provider.Prevout(txIn.PrevTx, txIn.PrevIndex, tx.Testnet)
+		if err != nil {
+			return nil, err
+		}
+		prevouts.AddOutput(txIn.PrevTx, txIn.PrevIndex, prevout)
+	}
+	var unsigned []int
+	for i := range tx.Inputs {
+		ok, err := tx.signWith(prevouts, i, signer)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			unsigned = append(unsigned, i)
+		}
+	}
+	return unsigned, nil
+}
+
+// signWith signs an input with the keys and scripts of a signer.
+// Returns whether the input is valid.
+func (tx *Transaction) signWith(provider PrevoutProvider, inputIndex int, signer Signer) (bool, error) {
+	txIn := tx.Inputs[inputIndex]
+	scriptPubKey, err := txIn.ScriptPubKey(provider, tx.Testnet)
+	if err != nil {
+		return false, err
+	}
+	program := scriptPubKey
+	if scriptPubKey.IsP2shScriptPubKey() {
+		redeemScript, ok := signer.Script(scriptPubKey.Peek(1))
+		if !ok {
+			return false, nil
+		}
+		program = redeemScript
+	}
+	hashType := uint32(util.SigHashAll)
+	version, witnessProgram, segwit := program.WitnessProgram()
+	switch {
+	case scriptPubKey.IsP2pkhScriptPubKey():
+		sec, ok := signer.PubKeyForHash160(scriptPubKey.Peek(2))
+		if !ok {
+			return false, nil
+		}
+		der, err := signer.SignECDSA(sec, tx.SigHash(inputIndex, scriptPubKey, hashType))
+		if err != nil {
+			return false, err
+		}
+		txIn.ScriptSig = script.NewScript([][]byte{append(der, byte(hashType)), sec})
+		txIn.Witness = nil
+	case segwit && version == 0 && len(witnessProgram) == 20:
+		sec, ok := signer.PubKeyForHash160(witnessProgram)
+		// segwit only allows compressed keys
+		if !ok || len(sec) != 33 {
+			return false, nil
+		}
+		z, err := tx.SigHashBip143(provider, inputIndex, script.P2pkhScript(witnessProgram), hashType)
+		if err != nil {
+			return false, err
+		}
+		der, err := signer.SignECDSA(sec, z)
+		if err != nil {
+			return false, err
+		}
+		txIn.ScriptSig = new(script.Script)
+		if program != scriptPubKey {
+			txIn.ScriptSig.AppendData(program.RawSerialize())
+		}
+		txIn.Witness = [][]byte{append(der, byte(hashType)), sec}
+	case segwit && version == 0 && len(witnessProgram) == 32:
+		witnessScript, ok := signer.Script(witnessProgram)
+		if !ok {
+			return false, nil
+		}
+		return tx.signMultisigWith(provider, inputIndex, witnessScript, signer)
+	case segwit && version == 1 && len(witnessProgram) == 32 && program == scriptPubKey:
+		if !signer.HasPubKey(witnessProgram) {
+			return false, nil
+		}
+		z, err := tx.SigHashTaproot(provider, inputIndex, util.SigHashDefault, nil)
+		if err != nil {
+			return false, err
+		}
+		sig, err := signer.SignTaproot(witnessProgram, z)
+		if err != nil {
+			return false, err
+		}
+		txIn.ScriptSig = new(script.Script)
+		txIn.Witness = [][]byte{sig}
+	case program != scriptPubKey:
+		return tx.signMultisigWith(provider, inputIndex, program, signer)
+	default:
+		return false, nil
+	}
+	return tx.signedInput(provider, inputIndex)
+}
+
+// signMultisigWith adds signatures from the keys of a signer to an input spending a multisig script
+// until there are enough. Returns whether the input is valid.
+func (tx *Transaction) signMultisigWith(provider PrevoutProvider, inputIndex int, multisigScript *script.Script, signer Signer) (bool, error) {
+	if _, _, ok := multisigScript.Multisig(); !ok {
+		return false, nil
+	}
+	in, err := tx.newMultisigInput(provider, inputIndex, multisigScript)
+	if err != nil {
+		return false, err
+	}
+	z, err := tx.sigHashForInput(provider, inputIndex, in.redeemScript, in.spendScript, util.SigHashAll)
+	if err != nil {
+		return false, err
+	}
+	txIn := tx.Inputs[inputIndex]
+	sigs := in.signatures(txIn)
+	for i, pubKey := range in.pubKeys {
+		if _, ok := sigs[i]; ok || len(sigs) >= in.m || !signer.HasPubKey(pubKey) {
+			continue
+		}
+		der, err := signer.SignECDSA(pubKey, z)
+		if err != nil {
+			return false, err
+		}
+		sigs[i] = append(der, byte(util.SigHashAll))
+	}
+	in.applySignatures(txIn, sigs)
+	return tx.signedInput(provider, inputIndex)
+}
@@ -0,0 +1,139 @@
+package tx
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+)
+
+// multisigInput describes how an input spends a multisig script.
+type multisigInput struct {
+	*scriptInput
+	m       int
+	pubKeys [][]byte
+}
+
+// newMultisigInput matches a multisig script against the output spent by an input.
+func (tx *Transaction) newMultisigInput(provider PrevoutProvider, inputIndex int, multisigScript *script.Script) (*multisigInput, error) {
+	m, pubKeys, ok := multisigScript.Multisig()
+	if !ok {
+		return nil, errors.New("not a multisig script")
+	}
+	in, err := tx.newScriptInput(provider, inputIndex, multisigScript)
+	if err != nil {
+		return nil, err
+	}
+	return &multisigInput{scriptInput: in, m: m, pubKeys: pubKeys}, nil
+}
+
+// signatures returns the signatures found in the input, indexed by the position of their key.
+func (in *multisigInput) signatures(txIn *Input) map[int][]byte {
+	result := make(map[int][]byte)
+	for _, candidate := range in.items(txIn) {
+		if i := in.keyIndex(candidate); i >= 0 {
+			result[i] = candidate
+		}
+	}
+	return result
+}
+
+// keyIndex returns the position of the key a signature is valid for, or -1.
+func (in *multisigInput) keyIndex(sig []byte) (result int) {
+	if len(sig) < 9 || sig[0] != 0x30 {
+		return -1
+	}
+	defer func() {
+		// malformed signatures panic when parsed
+		if recover() != nil {
+			result = -1
+		}
+	}()
+	z, err := in.sigHash(uint32(sig[len(sig)-1]))
+	if err != nil {
+		return -1
+	}
+	parsed := ecc.ParseSignature(sig[:len(sig)-1])
+	for i, pubKey := range in.pubKeys {
+		if ecc.ParseS256Point(pubKey).Verify(z, parsed) {
+			return i
+		}
+	}
+	return -1
+}
+
+// applySignatures puts the signatures into the input in key order, after the OP_CHECKMULTISIG dummy.
+// Only the first m signatures are used.
+func (in *multisigInput) applySignatures(txIn *Input, sigs map[int][]byte) {
+	stack := [][]byte{{}}
+	for i := range in.pubKeys {
+		if sig, ok := sigs[i]; ok && len(stack) <= in.m {
+			stack = append(stack, sig)
+		}
+	}
+	in.apply(txIn, stack)
+}
+
+// SignMultisigInput adds a signature from pk to an input spending a p2sh,
+// p2wsh or p2sh-p2wsh multisig script, keeping any signatures already there.
+// Returns whether the input has enough signatures to be valid.
+func (tx *Transaction) SignMultisigInput(provider PrevoutProvider, inputIndex int, multisigScript *script.Script, pk *ecc.PrivateKey, hashType uint32) (bool, error) {
+	in, err := tx.newMultisigInput(provider, inputIndex, multisigScript)
+	if err != nil {
+		return false, err
+	}
+	keyIndex := -1
+	for _, compressed := range []bool{true, false} {
+		for i, pubKey := range in.pubKeys {
+			if bytes.Equal(pubKey, pk.Point.Sec(compressed)) {
+				keyIndex = i
+			}
+		}
+	}
+	if keyIndex < 0 {
+		return false, errors.New("private key is not in the multisig script")
+	}
+	txIn := tx.Inputs[inputIndex]
+	z, err := in.sigHash(hashType)
+	if err != nil {
+		return false, err
+	}
+	sigs := in.signatures(txIn)
+	der := pk.Sign(z).Der()
+	sigs[keyIndex] = append(der, byte(hashType))
+	in.applySignatures(txIn, sigs)
+	return tx.signedInput(provider, inputIndex)
+}
+
+// CombineMultisigInput merges the signatures other copies of this transaction
+// hold for a multisig input into this one.
+// Returns whether the input has enough signatures to be valid.
+func (tx *Transaction) CombineMultisigInput(provider PrevoutProvider, inputIndex int, multisigScript *script.Script, others ...*Transaction) (bool, error) {
+	in, err := tx.newMultisigInput(provider, inputIndex, multisigScript)
+	if err != nil {
+		return false, err
+	}
+	txIn := tx.Inputs[inputIndex]
+	sigs := in.signatures(txIn)
+	for _, other := range others {
+		if !bytes.Equal(other.unsignedHash(), tx.unsignedHash()) {
+			return false, errors.New("cannot combine signatures from a different transaction")
+		}
+		for i, sig := range in.signatures(other.Inputs[inputIndex]) {
+			sigs[i] = sig
+		}
+	}
+	in.applySignatures(txIn, sigs)
+	return tx.signedInput(provider, inputIndex)
+}
+
+// unsignedHash returns the hash of the transaction with its signatures removed.
+func (tx *Transaction) unsignedHash() []byte {
+	unsigned := *tx
+	unsigned.Inputs = make([]*Input, len(tx.Inputs))
+	for i, txIn := range tx.Inputs {
+		unsigned.Inputs[i] = NewInput(txIn.PrevTx, txIn.PrevIndex, nil, txIn.Sequence)
+	}
+	return unsigned.Hash()
+}
@@ -0,0 +1,197 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PrevoutProvider looks up the outputs that transaction inputs spend,
+// which signing and verifying need for the amounts and ScriptPubKeys.
+type PrevoutProvider interface {
+	// Prevout returns output prevIndex of the transaction with hash prevTx.
+	Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error)
+}
+
+// TxProvider is a PrevoutProvider that can look up whole transactions.
+type TxProvider interface {
+	PrevoutProvider
+	// Transaction returns the transaction with the hex id txID.
+	Transaction(txID string, testnet bool) (*Transaction, error)
+}
+
+// txPrevout returns an output of a transaction from a TxProvider.
+func txPrevout(provider TxProvider, prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	tx, err := provider.Transaction(hex.EncodeToString(prevTx), testnet)
+	if err != nil {
+		return nil, err
+	}
+	if prevIndex < 0 || prevIndex >= len(tx.Outputs) {
+		return nil, fmt.Errorf("transaction %s has no output %d", tx.ID(), prevIndex)
+	}
+	return tx.Outputs[prevIndex], nil
+}
+
+// ParseRawTransaction parses a serialized transaction, returning an error
+// if it is malformed or has bytes left over.
+func ParseRawTransaction(raw []byte, testnet bool) (result *Transaction, err error) {
+	defer func() {
+		// the parser panics on data that runs out
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed transaction: %v", r)
+		}
+	}()
+	s := bytes.NewReader(raw)
+	result = ParseTransaction(s, testnet)
+	if s.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the transaction", s.Len())
+	}
+	return result, nil
+}
+
+// parseHexTransaction parses a hex transaction and checks that it has the expected id.
+func parseHexTransaction(rawHex, txID string, testnet bool) (*Transaction, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(rawHex))
+	if err != nil {
+		return nil, err
+	}
+	result, err := ParseRawTransaction(raw, testnet)
+	if err != nil {
+		return nil, err
+	}
+	if result.ID() != txID {
+		return nil, fmt.Errorf("not the same id: %s vs %s", txID, result.ID())
+	}
+	return result, nil
+}
+
+// MemoryProvider holds transactions and outputs in memory, e.g. ones that aren't broadcast yet.
+// It is safe for concurrent use.
+type MemoryProvider struct {
+	mu           sync.RWMutex
+	transactions map[string]*Transaction
+	// outputs are known outputs of transactions that aren't, keyed by outpoint
+	outputs map[string]*Output
+}
+
+// NewMemoryProvider returns a MemoryProvider holding the transactions.
+func NewMemoryProvider(txs ...*Transaction) *MemoryProvider {
+	result := &MemoryProvider{
+		transactions: make(map[string]*Transaction),
+		outputs:      make(map[string]*Output),
+	}
+	for _, tx := range txs {
+		result.AddTransaction(tx)
+	}
+	return result
+}
+
+func outpointKey(prevTx []byte, prevIndex int) string {
+	return fmt.Sprintf("%x:%d", prevTx, prevIndex)
+}
+
+// AddTransaction adds a transaction so inputs spending it can be signed and verified.
+func (p *MemoryProvider) AddTransaction(tx *Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transactions[tx.ID()] = tx
+}
+
+// AddOutput adds the output at prevTx:prevIndex, for inputs where only
+// the output being spent is known, e.g. a PSBT witness UTXO.
+func (p *MemoryProvider) AddOutput(prevTx []byte, prevIndex int, output *Output) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.outputs[outpointKey(prevTx, prevIndex)] = output
+}
+
+// LoadJSON adds the transactions of a JSON file mapping txids to hex transactions, like tx.cache.
+func (p *MemoryProvider) LoadJSON(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var v map[string]string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	for txID, rawHex := range v {
+		tx, err := parseHexTransaction(rawHex, txID, false)
+		if err != nil {
+			return fmt.Errorf("%s: %v", txID, err)
+		}
+		p.AddTransaction(tx)
+	}
+	return nil
+}
+
+// Transaction returns a transaction that was added.
+// The network is ignored, txids don't collide across networks.
+func (p *MemoryProvider) Transaction(txID string, testnet bool) (*Transaction, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	tx, ok := p.transactions[txID]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	return tx, nil
+}
+
+// Prevout returns an output that was added, or an output of a transaction that was added.
+func (p *MemoryProvider) Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	p.mu.RLock()
+	output, ok := p.outputs[outpointKey(prevTx, prevIndex)]
+	p.mu.RUnlock()
+	if ok {
+		return output, nil
+	}
+	return txPrevout(p, prevTx, prevIndex, testnet)
+}
+
+// DirProvider reads transactions from a directory of files named by txid,
+// either <txid>.hex holding the hex transaction, or <txid>.json holding an object
+// with the hex transaction in its "hex" field, as bitcoin-cli getrawtransaction <txid> true prints.
+type DirProvider struct {
+	Dir string
+}
+
+// NewDirProvider returns a DirProvider for the directory.
+func NewDirProvider(dir string) *DirProvider {
+	return &DirProvider{Dir: dir}
+}
+
+// Transaction reads the transaction's file.
+func (p *DirProvider) Transaction(txID string, testnet bool) (*Transaction, error) {
+	if _, err := hex.DecodeString(txID); err != nil || len(txID) != 64 {
+		return nil, fmt.Errorf("invalid txid %q", txID)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(p.Dir, txID+".hex"))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(filepath.Join(p.Dir, txID+".json"))
+		if err == nil {
+			var v struct {
+				Hex string `json:"hex"`
+			}
+			err = json.Unmarshal(data, &v)
+			data = []byte(v.Hex)
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseHexTransaction(string(data), txID, testnet)
+}
+
+// Prevout returns an output of a transaction read from its file.
+func (p *DirProvider) Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	return txPrevout(p, prevTx, prevIndex, testnet)
+}
@@ -0,0 +1,118 @@
+package tx
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// scriptInput describes how an input spends a script through p2sh, p2wsh or p2sh-p2wsh.
+type scriptInput struct {
+	spendScript *script.Script
+	// redeemScript is the p2sh redeem script, nil for a native p2wsh output.
+	redeemScript *script.Script
+	// witness is true for p2wsh and p2sh-p2wsh outputs.
+	witness    bool
+	tx         *Transaction
+	inputIndex int
+	provider   PrevoutProvider
+}
+
+// newScriptInput matches a script against the output spent by an input.
+func (tx *Transaction) newScriptInput(provider PrevoutProvider, inputIndex int, spendScript *script.Script) (*scriptInput, error) {
+	result := &scriptInput{spendScript: spendScript, tx: tx, inputIndex: inputIndex, provider: provider}
+	raw := spendScript.RawSerialize()
+	p2sh := script.P2shScript(util.Hash160(raw))
+	p2wsh := script.P2wshScript(util.Sha256(raw))
+	p2shP2wsh := script.P2shScript(util.Hash160(p2wsh.RawSerialize()))
+	prevScript, err := tx.Inputs[inputIndex].ScriptPubKey(provider, tx.Testnet)
+	if err != nil {
+		return nil, err
+	}
+	scriptPubKey := prevScript.RawSerialize()
+	switch {
+	case bytes.Equal(scriptPubKey, p2sh.RawSerialize()):
+		result.redeemScript = spendScript
+	case bytes.Equal(scriptPubKey, p2wsh.RawSerialize()):
+		result.witness = true
+	case bytes.Equal(scriptPubKey, p2shP2wsh.RawSerialize()):
+		result.redeemScript = p2wsh
+		result.witness = true
+	default:
+		return nil, fmt.Errorf("input %d does not spend the script", inputIndex)
+	}
+	return result, nil
+}
+
+// sigHash returns the hash a signature with the given hash type commits to.
+func (in *scriptInput) sigHash(hashType uint32) (*big.Int, error) {
+	z, err := in.tx.sigHashForInput(in.provider, in.inputIndex, in.redeemScript, in.spendScript, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(z), nil
+}
+
+// items returns the stack items the input pushes before the script.
+func (in *scriptInput) items(txIn *Input) [][]byte {
+	if in.witness {
+		return txIn.Witness
+	}
+	var result [][]byte
+	for i := 0; i < txIn.ScriptSig.Len(); i++ {
+		result = append(result, txIn.ScriptSig.Peek(i))
+	}
+	return result
+}
+
+// apply sets the input's ScriptSig and witness to push the stack items followed by the script.
+func (in *scriptInput) apply(txIn *Input, stack [][]byte) {
+	items := make([][]byte, len(stack), len(stack)+1)
+	copy(items, stack)
+	items = append(items, in.spendScript.RawSerialize())
+	if !in.witness {
+		scriptSig := new(script.Script)
+		for _, item := range items {
+			scriptSig.AppendData(item)
+		}
+		txIn.ScriptSig = scriptSig
+		txIn.Witness = nil
+		return
+	}
+	txIn.Witness = items
+	txIn.ScriptSig = new(script.Script)
+	if in.redeemScript != nil {
+		txIn.ScriptSig.AppendData(in.redeemScript.RawSerialize())
+	}
+}
+
+// SignScriptInput returns a signature from pk, with its hash type byte,
+// for an input spending a p2sh, p2wsh or p2sh-p2wsh script.
+func (tx *Transaction) SignScriptInput(provider PrevoutProvider, inputIndex int, spendScript *script.Script, pk *ecc.PrivateKey, hashType uint32) ([]byte, error) {
+	in, err := tx.newScriptInput(provider, inputIndex, spendScript)
+	if err != nil {
+		return nil, err
+	}
+	z, err := in.sigHash(hashType)
+	if err != nil {
+		return nil, err
+	}
+	der := pk.Sign(z).Der()
+	return append(der, byte(hashType)), nil
+}
+
+// FinalizeScriptInput sets the ScriptSig and witness of an input spending a p2sh,
+// p2wsh or p2sh-p2wsh script, given the stack items that satisfy the script, bottom first.
+// Returns whether the input is valid.
+func (tx *Transaction) FinalizeScriptInput(provider PrevoutProvider, inputIndex int, spendScript *script.Script, stack [][]byte) (bool, error) {
+	in, err := tx.newScriptInput(provider, inputIndex, spendScript)
+	if err != nil {
+		return false, err
+	}
+	in.apply(tx.Inputs[inputIndex], stack)
+	return tx.signedInput(provider, inputIndex)
+}
@@ -0,0 +1,207 @@
+package tx
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// sigHashMask selects the SIGHASH_ALL, SIGHASH_NONE or SIGHASH_SINGLE part of a legacy or BIP143 hash type.
+const sigHashMask = 0x1f
+
+// SigHash returns the legacy hash that needs to get signed for index inputIndex.
+// scriptCode is the ScriptPubKey of the output being spent, or the redeem script for p2sh inputs.
+func (tx *Transaction) SigHash(inputIndex int, scriptCode *script.Script, hashType uint32) []byte {
+	base := hashType & sigHashMask
+	anyoneCanPay := hashType&util.SigHashAnyoneCanPay != 0
+	if base == util.SigHashSingle && inputIndex >= len(tx.Outputs) {
+		// SIGHASH_SINGLE without a matching output signs the number one
+		result := make([]byte, 32)
+		result[0] = 1
+		return result
+	}
+	var inputs []*Input
+	for i, txIn := range tx.Inputs {
+		if anyoneCanPay && i != inputIndex {
+			continue
+		}
+		var scriptSig *script.Script
+		sequence := txIn.Sequence
+		if i == inputIndex {
+			scriptSig = scriptCode
+		} else if base == util.SigHashNone || base == util.SigHashSingle {
+			// the other inputs can be replaced
+			sequence = 0
+		}
+		inputs = append(inputs, NewInput(txIn.PrevTx, txIn.PrevIndex, scriptSig, sequence))
+	}
+	var outputs []*Output
+	switch base {
+	case util.SigHashNone:
+	case util.SigHashSingle:
+		for i := 0; i < inputIndex; i++ {
+			outputs = append(outputs, NewOutput(math.MaxUint64, new(script.Script)))
+		}
+		outputs = append(outputs, tx.Outputs[inputIndex])
+	default:
+		outputs = tx.Outputs
+	}
+	unsigned := NewTransaction(tx.Version, inputs, outputs, tx.Locktime, tx.Testnet)
+	serialized := unsigned.SerializeLegacy()
+	serialized = append(serialized, util.Int32ToLittleEndian(hashType)...)
+	return util.Hash256(serialized)
+}
+
+// SigHashBip143 returns the BIP143 hash that needs to get signed for the
+// segwit v0 input at inputIndex.
+// scriptCode is the p2pkh script of a p2wpkh input or the witness script of a p2wsh input.
+// provider looks up the amount being spent.
+func (tx *Transaction) SigHashBip143(provider PrevoutProvider, inputIndex int, scriptCode *script.Script, hashType uint32) ([]byte, error) {
+	txIn := tx.Inputs[inputIndex]
+	amount, err := txIn.Value(provider, tx.Testnet)
+	if err != nil {
+		return nil, err
+	}
+	base := hashType & sigHashMask
+	anyoneCanPay := hashType&util.SigHashAnyoneCanPay != 0
+	var prevouts, sequences, outputs []byte
+	for _, txIn := range tx.Inputs {
+		prevouts = append(prevouts, txIn.outpoint()...)
+		sequences = append(sequences, util.Int32ToLittleEndian(txIn.Sequence)...)
+	}
+	for _, txOut := range tx.Outputs {
+		outputs = append(outputs, txOut.Serialize()...)
+	}
+	// the parts the hash type leaves out are zeros
+	hashPrevouts, hashSequence, hashOutputs := make([]byte, 32), make([]byte, 32), make([]byte, 32)
+	if !anyoneCanPay {
+		hashPrevouts = util.Hash256(prevouts)
+	}
+	if !anyoneCanPay && base != util.SigHashSingle && base != util.SigHashNone {
+		hashSequence = util.Hash256(sequences)
+	}
+	if base != util.SigHashSingle && base != util.SigHashNone {
+		hashOutputs = util.Hash256(outputs)
+	} else if base == util.SigHashSingle && inputIndex < len(tx.Outputs) {
+		hashOutputs = util.Hash256(tx.Outputs[inputIndex].Serialize())
+	}
+	serialized := util.Int32ToLittleEndian(tx.Version)
+	serialized = append(serialized, hashPrevouts...)
+	serialized = append(serialized, hashSequence...)
+	serialized = append(serialized, txIn.outpoint()...)
+	serialized = append(serialized, scriptCode.Serialize()...)
+	serialized = append(serialized, util.Int64ToLittleEndian(amount)...)
+	serialized = append(serialized, util.Int32ToLittleEndian(txIn.Sequence)...)
+	serialized = append(serialized, hashOutputs...)
+	serialized = append(serialized, util.Int32ToLittleEndian(tx.Locktime)...)
+	serialized = append(serialized, util.Int32ToLittleEndian(hashType)...)
+	return util.Hash256(serialized), nil
+}
+
+// SigHashTaproot returns the BIP341 hash that needs to get signed for the taproot input at inputIndex.
+// leafHash is the tapleaf hash of the script for a script path spend (BIP342), nil for a key path spend.
+// provider looks up the outputs being spent, all of them unless the hash type has SIGHASH_ANYONECANPAY.
+// Returns an error for an unknown hash type, SIGHASH_SINGLE without a matching output,
+// or an output that can't be looked up.
+func (tx *Transaction) SigHashTaproot(provider PrevoutProvider, inputIndex int, hashType uint32, leafHash []byte) ([]byte, error) {
+	return tx.sigHashTaproot(provider, inputIndex, hashType, leafHash, 0xffffffff)
+}
+
+// sigHashTaproot is SigHashTaproot for a script that has run the OP_CODESEPARATOR at
+// position codeSepPos, 0xffffffff if it has run none.
+func (tx *Transaction) sigHashTaproot(provider PrevoutProvider, inputIndex int, hashType uint32, leafHash []byte, codeSepPos uint32) ([]byte, error) {
+	switch hashType {
+	case util.SigHashDefault, util.SigHashAll, util.SigHashNone, util.SigHashSingle,
+		util.SigHashAll | util.SigHashAnyoneCanPay, util.SigHashNone | util.SigHashAnyoneCanPay, util.SigHashSingle | util.SigHashAnyoneCanPay:
+	default:
+		return nil, fmt.Errorf("invalid taproot hash type %#x", hashType)
+	}
+	base := hashType & 3
+	anyoneCanPay := hashType&util.SigHashAnyoneCanPay != 0
+	// epoch 0 followed by the hash type
+	msg := []byte{0, byte(hashType)}
+	msg = append(msg, util.Int32ToLittleEndian(tx.Version)...)
+	msg = append(msg, util.Int32ToLittleEndian(tx.Locktime)...)
+	if !anyoneCanPay {
+		var prevouts, amounts, scriptPubKeys, sequences []byte
+		for _, txIn := range tx.Inputs {
+			prevout, err := provider.Prevout(txIn.PrevTx, txIn.PrevIndex, tx.Testnet)
+			if err != nil {
+				return nil, err
+			}
+			prevouts = append(prevouts, txIn.outpoint()...)
+			amounts = append(amounts, util.Int64ToLittleEndian(prevout.Amount)...)
+			scriptPubKeys = append(scriptPubKeys, prevout.ScriptPubKey.Serialize()...)
+			sequences = append(sequences, util.Int32ToLittleEndian(txIn.Sequence)...)
+		}
+		msg = append(msg, util.Sha256(prevouts)...)
+		msg = append(msg, util.Sha256(amounts)...)
+		msg = append(msg, util.Sha256(scriptPubKeys)...)
+		msg = append(msg, util.Sha256(sequences)...)
+	}
+	if base != util.SigHashNone && base != util.SigHashSingle {
+		var outputs []byte
+		for _, txOut := range tx.Outputs {
+			outputs = append(outputs, txOut.Serialize()...)
+		}
+		msg = append(msg, util.Sha256(outputs)...)
+	}
+	txIn := tx.Inputs[inputIndex]
+	annex := txIn.annex()
+	var spendType byte
+	if leafHash != nil {
+		spendType |= 2
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	msg = append(msg, spendType)
+	if anyoneCanPay {
+		prevout, err := provider.Prevout(txIn.PrevTx, txIn.PrevIndex, tx.Testnet)
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, txIn.outpoint()...)
+		msg = append(msg, util.Int64ToLittleEndian(prevout.Amount)...)
+		msg = append(msg, prevout.ScriptPubKey.Serialize()...)
+		msg = append(msg, util.Int32ToLittleEndian(txIn.Sequence)...)
+	} else {
+		msg = append(msg, util.Int32ToLittleEndian(uint32(inputIndex))...)
+	}
+	if annex != nil {
+		msg = append(msg, util.Sha256(append(util.EncodeVarInt(len(annex)), annex...))...)
+	}
+	if base == util.SigHashSingle {
+		if inputIndex >= len(tx.Outputs) {
+			return nil, fmt.Errorf("no output %d for SIGHASH_SINGLE", inputIndex)
+		}
+		msg = append(msg, util.Sha256(tx.Outputs[inputIndex].Serialize())...)
+	}
+	if leafHash != nil {
+		// key version 0
+		msg = append(msg, leafHash...)
+		msg = append(msg, 0)
+		msg = append(msg, util.Int32ToLittleEndian(codeSepPos)...)
+	}
+	return util.TaggedHash("TapSighash", msg), nil
+}
+
+// sigHashForInput returns the hash a signature with the given hash type commits to
+// for an input spending a p2sh, p2wsh or p2sh-p2wsh script.
+// redeemScript is nil for a native p2wsh output, and witnessScript is used for p2wsh.
+func (tx *Transaction) sigHashForInput(provider PrevoutProvider, inputIndex int, redeemScript, witnessScript *script.Script, hashType uint32) ([]byte, error) {
+	program := redeemScript
+	if program == nil {
+		scriptPubKey, err := tx.Inputs[inputIndex].ScriptPubKey(provider, tx.Testnet)
+		if err != nil {
+			return nil, err
+		}
+		program = scriptPubKey
+	}
+	if version, _, ok := program.WitnessProgram(); ok && version == 0 {
+		return tx.SigHashBip143(provider, inputIndex, witnessScript, hashType)
+	}
+	return tx.SigHash(inputIndex, program, hashType), nil
+}
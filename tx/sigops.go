@@ -0,0 +1,77 @@
+package tx
+
+import (
+	"github.com/ravdin/programmingbitcoin/script"
+)
+
+// WitnessScaleFactor is how much more non-witness data counts than witness data (BIP141).
+const WitnessScaleFactor = 4
+
+// SigOpCost returns the signature operation cost of the transaction as BIP141 counts it:
+// legacy and p2sh signature operations cost 4 each and witness ones 1 each.
+// provider looks up the outputs the inputs spend, it isn't used for a coinbase transaction.
+func (tx *Transaction) SigOpCost(provider PrevoutProvider) (int, error) {
+	result := 0
+	for _, txIn := range tx.Inputs {
+		result += txIn.ScriptSig.SigOpCount(false)
+	}
+	for _, txOut := range tx.Outputs {
+		result += txOut.ScriptPubKey.SigOpCount(false)
+	}
+	result *= WitnessScaleFactor
+	if tx.IsCoinbase() {
+		return result, nil
+	}
+	for _, txIn := range tx.Inputs {
+		scriptPubKey, err := txIn.ScriptPubKey(provider, tx.Testnet)
+		if err != nil {
+			return 0, err
+		}
+		if scriptPubKey.IsP2shScriptPubKey() {
+			if redeemScript := p2shRedeemScript(txIn.ScriptSig); redeemScript != nil {
+				result += redeemScript.SigOpCount(true) * WitnessScaleFactor
+			}
+		}
+		result += witnessSigOpCount(txIn, scriptPubKey)
+	}
+	return result, nil
+}
+
+// p2shRedeemScript returns the script in the last push of a push only ScriptSig,
+// nil if there isn't one.
+func p2shRedeemScript(scriptSig *script.Script) *script.Script {
+	if scriptSig.Len() == 0 || !scriptSig.IsPushOnly() {
+		return nil
+	}
+	result, err := script.ParseRaw(scriptSig.Peek(scriptSig.Len() - 1))
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// witnessSigOpCount returns the signature operations of a version 0 witness program,
+// which only count the witness script of p2wsh accurately.
+// Other versions have no signature operation cost.
+func witnessSigOpCount(txIn *Input, scriptPubKey *script.Script) int {
+	if scriptPubKey.IsP2shScriptPubKey() {
+		if scriptPubKey = p2shRedeemScript(txIn.ScriptSig); scriptPubKey == nil {
+			return 0
+		}
+	}
+	version, program, ok := scriptPubKey.WitnessProgram()
+	if !ok || version != 0 {
+		return 0
+	}
+	switch {
+	case len(program) == 20:
+		return 1
+	case len(program) == 32 && len(txIn.Witness) > 0:
+		witnessScript, err := script.ParseRaw(txIn.Witness[len(txIn.Witness)-1])
+		if err != nil {
+			return 0
+		}
+		return witnessScript.SigOpCount(true)
+	}
+	return 0
+}
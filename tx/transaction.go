@@ -3,6 +3,8 @@ package tx
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/ravdin/programmingbitcoin/ecc"
@@ -10,6 +12,28 @@ import (
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
+// Lock time and sequence values from BIP65, BIP68 and BIP112.
+const (
+	// LockTimeThreshold separates block heights from unix times in lock times.
+	LockTimeThreshold = 500000000
+	// SequenceFinal disables the lock time for an input.
+	SequenceFinal = 0xffffffff
+	// SequenceLockTimeDisableFlag disables the relative lock time.
+	SequenceLockTimeDisableFlag = 1 << 31
+	// SequenceLockTimeTypeFlag makes the relative lock time count units of 512 seconds instead of blocks.
+	SequenceLockTimeTypeFlag = 1 << 22
+	// SequenceLockTimeMask is the part of the sequence holding the relative lock time.
+	SequenceLockTimeMask = 0xffff
+)
+
+// Consensus limits on transactions.
+const (
+	// MaxMoney is the most satoshis there can ever be, and the most an output or a sum of them can hold.
+	MaxMoney = 21000000 * 100000000
+	// MaxBlockWeight is the most weight a block, and so a transaction, can have (BIP141).
+	MaxBlockWeight = 4000000
+)
+
 // Transaction represents a bitcoin transaction.
 type Transaction struct {
 	Version  uint32
@@ -37,15 +61,54 @@ func (tx *Transaction) ID() string {
 
 // Hash of the legacy serialization
 func (tx *Transaction) Hash() []byte {
-	hash := util.Hash256(tx.Serialize())
+	hash := util.Hash256(tx.SerializeLegacy())
 	// reverse the array
 	return util.ReverseByteArray(hash)
 }
 
-// Serialize the transaction.
+// Wtxid is a human-readable hexadecimal of the hash including the witness data (BIP141).
+// It is the same as the ID for transactions without witness data.
+func (tx *Transaction) Wtxid() string {
+	hash := util.Hash256(tx.Serialize())
+	return hex.EncodeToString(util.ReverseByteArray(hash))
+}
+
+// HasWitness returns whether any input has witness data.
+func (tx *Transaction) HasWitness() bool {
+	for _, txIn := range tx.Inputs {
+		if len(txIn.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialize the transaction, in the BIP144 format if it has witness data.
 func (tx *Transaction) Serialize() []byte {
+	if !tx.HasWitness() {
+		return tx.SerializeLegacy()
+	}
 	result := util.Int32ToLittleEndian(tx.Version)
-	result = append(result, util.EncodeVarInt(len(tx.Inputs))...)
+	// marker and flag
+	result = append(result, 0, 1)
+	result = append(result, tx.serializeInputsAndOutputs()...)
+	for _, txIn := range tx.Inputs {
+		result = append(result, txIn.serializeWitness()...)
+	}
+	result = append(result, util.Int32ToLittleEndian(tx.Locktime)...)
+	return result
+}
+
+// SerializeLegacy serializes the transaction without witness data.
+func (tx *Transaction) SerializeLegacy() []byte {
+	result := util.Int32ToLittleEndian(tx.Version)
+	result = append(result, tx.serializeInputsAndOutputs()...)
+	result = append(result, util.Int32ToLittleEndian(tx.Locktime)...)
+	return result
+}
+
+func (tx *Transaction) serializeInputsAndOutputs() []byte {
+	result := util.EncodeVarInt(len(tx.Inputs))
 	for _, txIn := range tx.Inputs {
 		result = append(result, txIn.Serialize()...)
 	}
@@ -53,15 +116,36 @@ func (tx *Transaction) Serialize() []byte {
 	for _, txOut := range tx.Outputs {
 		result = append(result, txOut.Serialize()...)
 	}
-	result = append(result, util.Int32ToLittleEndian(tx.Locktime)...)
 	return result
 }
 
+// Weight returns the transaction weight (BIP141):
+// the size without witness data times three plus the full size.
+func (tx *Transaction) Weight() int {
+	return len(tx.SerializeLegacy())*3 + len(tx.Serialize())
+}
+
+// VSize returns the virtual size, the weight divided by four rounded up.
+func (tx *Transaction) VSize() int {
+	return (tx.Weight() + 3) / 4
+}
+
 // ParseTransaction parses a transaction from a byte reader.
+// Both the legacy and the BIP144 witness formats are accepted.
 func ParseTransaction(s *bytes.Reader, testnet bool) *Transaction {
 	buffer := make([]byte, 4)
 	s.Read(buffer)
 	version := util.LittleEndianToInt32(buffer)
+	// a transaction can't have zero inputs, so a zero here is the segwit marker
+	segwit := false
+	if marker, err := s.ReadByte(); err == nil && marker == 0 {
+		if flag, _ := s.ReadByte(); flag != 1 {
+			panic(fmt.Sprintf("unexpected segwit flag %d", flag))
+		}
+		segwit = true
+	} else {
+		s.UnreadByte()
+	}
 	numInputs := util.ReadVarInt(s)
 	inputs := make([]*Input, numInputs)
 	for i := 0; i < numInputs; i++ {
@@ -72,93 +156,292 @@ func ParseTransaction(s *bytes.Reader, testnet bool) *Transaction {
 	for i := 0; i < numOutputs; i++ {
 		outputs[i] = ParseOutput(s)
 	}
+	if segwit {
+		for _, txIn := range inputs {
+			txIn.Witness = parseWitness(s)
+		}
+	}
 	s.Read(buffer)
 	locktime := util.LittleEndianToInt32(buffer)
 	return NewTransaction(version, inputs, outputs, locktime, testnet)
 }
 
 // Fee returns the fee of this transaction in satoshi
-func (tx *Transaction) Fee() uint64 {
-	// initialize input sum and output sum
-	// use Input.value() to sum up the input amounts
-	// use Output.amount to sum up the output amounts
-	// fee is input sum - output sum
-	var result uint64
+// provider looks up the amounts of the outputs the inputs spend.
+// Returns an error if the amounts are out of range or the outputs spend more than the inputs.
+func (tx *Transaction) Fee(provider PrevoutProvider) (uint64, error) {
+	var inputSum uint64
 	for _, txIn := range tx.Inputs {
-		result += txIn.Value(false)
+		value, err := txIn.Value(provider, tx.Testnet)
+		if err != nil {
+			return 0, err
+		}
+		// both are at most MaxMoney so the sum can't overflow
+		if value > MaxMoney || inputSum+value > MaxMoney {
+			return 0, errors.New("input values out of range")
+		}
+		inputSum += value
 	}
-	for _, txOut := range tx.Outputs {
-		result -= txOut.Amount
+	outputSum, err := tx.outputSum()
+	if err != nil {
+		return 0, err
 	}
-	return result
+	if outputSum > inputSum {
+		return 0, fmt.Errorf("outputs of %d exceed inputs of %d", outputSum, inputSum)
+	}
+	return inputSum - outputSum, nil
+}
+
+// outputSum returns the total of the output amounts,
+// or an error if an amount or the total is more than MaxMoney.
+func (tx *Transaction) outputSum() (uint64, error) {
+	var result uint64
+	for i, txOut := range tx.Outputs {
+		// amounts are unsigned, a negative int64 amount is more than MaxMoney here
+		if txOut.Amount > MaxMoney {
+			return 0, fmt.Errorf("output %d amount %d out of range", i, txOut.Amount)
+		}
+		result += txOut.Amount
+		if result > MaxMoney {
+			return 0, errors.New("output total out of range")
+		}
+	}
+	return result, nil
 }
 
-// SigHash rturns the integer representation of the hash that needs to get
-// signed for index inputIndex
-func (tx *Transaction) SigHash(inputIndex int) []byte {
-	serialized := util.Int32ToLittleEndian(tx.Version)
-	serialized = append(serialized, util.EncodeVarInt(len(tx.Inputs))...)
+// Check runs the consensus checks that don't need the outputs being spent
+// or the chain, like CheckTransaction in Bitcoin Core.
+// They are cheap so they run before any script.
+func (tx *Transaction) Check() error {
+	if len(tx.Inputs) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	if len(tx.Outputs) == 0 {
+		return errors.New("transaction has no outputs")
+	}
+	if len(tx.SerializeLegacy())*4 > MaxBlockWeight {
+		return errors.New("transaction is too large")
+	}
+	if _, err := tx.outputSum(); err != nil {
+		return err
+	}
+	spent := make(map[string]bool, len(tx.Inputs))
+	for _, txIn := range tx.Inputs {
+		key := outpointKey(txIn.PrevTx, txIn.PrevIndex)
+		if spent[key] {
+			return fmt.Errorf("input %x:%d is spent twice", txIn.PrevTx, txIn.PrevIndex)
+		}
+		spent[key] = true
+	}
+	if tx.IsCoinbase() {
+		size := len(tx.Inputs[0].ScriptSig.RawSerialize())
+		if size < MinCoinbaseScriptSigSize || size > MaxCoinbaseScriptSigSize {
+			return fmt.Errorf("coinbase ScriptSig length %d out of range", size)
+		}
+		return nil
+	}
 	for i, txIn := range tx.Inputs {
-		var scriptSig *script.Script
-		if i == inputIndex {
-			scriptSig = txIn.ScriptPubKey(tx.Testnet)
+		if txIn.PrevIndex == 0xffffffff && bytes.Equal(txIn.PrevTx, make([]byte, 32)) {
+			return fmt.Errorf("input %d spends the null outpoint", i)
 		}
-		serialized = append(serialized, NewInput(
-			txIn.PrevTx,
-			txIn.PrevIndex,
-			scriptSig,
-			txIn.Sequence,
-		).Serialize()...)
-	}
-	serialized = append(serialized, util.EncodeVarInt(len(tx.Outputs))...)
-	for _, txOut := range tx.Outputs {
-		serialized = append(serialized, txOut.Serialize()...)
 	}
-	serialized = append(serialized, util.Int32ToLittleEndian(tx.Locktime)...)
-	serialized = append(serialized, util.Int32ToLittleEndian(util.SigHashAll)...)
-	return util.Hash256(serialized)
+	return nil
 }
 
-// Returns whether the input has a valid signature
-func (tx *Transaction) verifyInput(inputIndex int) bool {
+// InputEngine returns the script engine that verifies an input, ready to step through.
+// provider looks up the outputs being spent, taproot signature hashes need all of them.
+func (tx *Transaction) InputEngine(provider PrevoutProvider, inputIndex int) (*script.Engine, error) {
+	if inputIndex < 0 || inputIndex >= len(tx.Inputs) {
+		return nil, fmt.Errorf("input %d out of range", inputIndex)
+	}
 	txIn := tx.Inputs[inputIndex]
-	scriptPubKey := txIn.ScriptPubKey(tx.Testnet)
-	z := tx.SigHash(inputIndex)
-	// combine the current ScriptSig and the previous ScriptPubKey
-	script := new(script.Script)
-	script.Add(txIn.ScriptSig, scriptPubKey)
-	// evaluate the combined script
-	return script.Evaluate(z)
+	scriptPubKey, err := txIn.ScriptPubKey(provider, tx.Testnet)
+	if err != nil {
+		return nil, err
+	}
+	// evaluate the ScriptSig and witness against the previous ScriptPubKey,
+	// the checker computes the signature hash for each signature
+	engine := script.NewEngine(txIn.ScriptSig, scriptPubKey, txIn.Witness, nil)
+	engine.SetChecker(&inputChecker{tx: tx, inputIndex: inputIndex, provider: provider})
+	return engine, nil
 }
 
-// Verify this transaction
-func (tx *Transaction) Verify() bool {
-	if tx.Fee() < 0 {
+// Returns whether the input has a valid signature under flags, and if not, why its scripts fail
+func (tx *Transaction) verifyInput(provider PrevoutProvider, inputIndex int, flags script.Flags) (bool, error) {
+	engine, err := tx.InputEngine(provider, inputIndex)
+	if err != nil {
+		return false, err
+	}
+	engine.SetFlags(flags)
+	if err := engine.Run(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// signedInput returns whether an input is valid after signing it.
+// Failing scripts only make it invalid, the error is for outputs that can't be looked up.
+func (tx *Transaction) signedInput(provider PrevoutProvider, inputIndex int) (bool, error) {
+	if _, err := tx.Inputs[inputIndex].ScriptPubKey(provider, tx.Testnet); err != nil {
+		return false, err
+	}
+	ok, _ := tx.verifyInput(provider, inputIndex, script.VerifyAll)
+	return ok, nil
+}
+
+// inputChecker checks signatures and timelocks against an input of a transaction.
+type inputChecker struct {
+	tx         *Transaction
+	inputIndex int
+	provider   PrevoutProvider
+}
+
+func (c *inputChecker) SigHash(hashType uint32, sigVersion script.SigVersion, scriptCode *script.Script, leafHash []byte, codeSepPos uint32) ([]byte, error) {
+	switch sigVersion {
+	case script.SigVersionWitnessV0:
+		return c.tx.SigHashBip143(c.provider, c.inputIndex, scriptCode, hashType)
+	case script.SigVersionTaproot:
+		return c.tx.SigHashTaproot(c.provider, c.inputIndex, hashType, nil)
+	case script.SigVersionTapscript:
+		return c.tx.sigHashTaproot(c.provider, c.inputIndex, hashType, leafHash, codeSepPos)
+	}
+	return c.tx.SigHash(c.inputIndex, scriptCode, hashType), nil
+}
+
+func (c *inputChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := int64(c.tx.Locktime)
+	// both must be heights or both must be times
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
 		return false
 	}
+	if lockTime > txLockTime {
+		return false
+	}
+	// the lock time is ignored when the input is final
+	return c.tx.Inputs[c.inputIndex].Sequence != SequenceFinal
+}
+
+func (c *inputChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.tx.Inputs[c.inputIndex].Sequence)
+	// relative lock times need version 2
+	if c.tx.Version < 2 || txSequence&SequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+	const mask = SequenceLockTimeTypeFlag | SequenceLockTimeMask
+	if (sequence & SequenceLockTimeTypeFlag) != (txSequence & SequenceLockTimeTypeFlag) {
+		return false
+	}
+	return sequence&mask <= txSequence&mask
+}
+
+// Verify this transaction
+// provider looks up the outputs the inputs spend, returns an error if one can't be looked up,
+// the transaction fails Check or the amount checks, or the scripts of an input fail.
+func (tx *Transaction) Verify(provider PrevoutProvider) (bool, error) {
+	return tx.VerifyFlags(provider, script.VerifyAll)
+}
+
+// VerifyFlags is Verify enforcing only the soft forks in flags,
+// for transactions in blocks from before some of them activated.
+func (tx *Transaction) VerifyFlags(provider PrevoutProvider, flags script.Flags) (bool, error) {
+	if err := tx.Check(); err != nil {
+		return false, err
+	}
+	// look up each output once, taproot signature hashes commit to all of them
+	prevouts := NewMemoryProvider()
+	for _, txIn := range tx.Inputs {
+		prevout, err := provider.Prevout(txIn.PrevTx, txIn.PrevIndex, tx.Testnet)
+		if err != nil {
+			return false, err
+		}
+		prevouts.AddOutput(txIn.PrevTx, txIn.PrevIndex, prevout)
+	}
+	if _, err := tx.Fee(prevouts); err != nil {
+		return false, err
+	}
 	for i := range tx.Inputs {
-		if !tx.verifyInput(i) {
-			return false
+		if ok, err := tx.verifyInput(prevouts, i, flags); !ok {
+			return false, fmt.Errorf("input %d: %v", i, err)
 		}
 	}
-	return true
+	return true, nil
 }
 
-// SignInput signs a transaction input with a private key.
-func (tx *Transaction) SignInput(inputIndex int, pk *ecc.PrivateKey) bool {
-	z := new(big.Int)
-	z.SetBytes(tx.SigHash(inputIndex))
-	// get der signature of z from private key
-	der := pk.Sign(z).Der()
-	der = append(der, byte(util.SigHashAll))
+// SignInput signs a p2pkh, p2wpkh, p2sh-p2wpkh or taproot key path input with a private key.
+// provider looks up the outputs being spent.
+// Returns whether the input is valid.
+func (tx *Transaction) SignInput(provider PrevoutProvider, inputIndex int, pk *ecc.PrivateKey, hashType uint32) (bool, error) {
+	txIn := tx.Inputs[inputIndex]
+	scriptPubKey, err := txIn.ScriptPubKey(provider, tx.Testnet)
+	if err != nil {
+		return false, err
+	}
 	// calculate the sec
 	sec := pk.Point.Sec(true)
-	// initialize a new script with [sig, sec] as the cmds
-	script := script.NewScript([][]byte{der, sec})
-	// change input's scriptSig to new script
-	tx.Inputs[inputIndex].ScriptSig = script
-	// return whether sig is valid using tx.verifyInput
-	return tx.verifyInput(inputIndex)
+	p2wpkh := script.P2wpkhScript(util.Hash160(sec))
+	version, program, _ := scriptPubKey.WitnessProgram()
+	switch {
+	case version == 1 && len(program) == 32:
+		return tx.signTaprootInput(provider, inputIndex, pk, hashType)
+	case bytes.Equal(scriptPubKey.RawSerialize(), p2wpkh.RawSerialize()):
+		txIn.ScriptSig = new(script.Script)
+	case bytes.Equal(scriptPubKey.RawSerialize(), script.P2shScript(util.Hash160(p2wpkh.RawSerialize())).RawSerialize()):
+		txIn.ScriptSig = new(script.Script).AppendData(p2wpkh.RawSerialize())
+	default:
+		return tx.signP2pkhInput(provider, inputIndex, scriptPubKey, pk, true, hashType)
+	}
+	hash, err := tx.SigHashBip143(provider, inputIndex, script.P2pkhScript(util.Hash160(sec)), hashType)
+	if err != nil {
+		return false, err
+	}
+	z := new(big.Int).SetBytes(hash)
+	der := append(pk.Sign(z).Der(), byte(hashType))
+	txIn.Witness = [][]byte{der, sec}
+	return tx.signedInput(provider, inputIndex)
+}
+
+// signP2pkhInput signs a p2pkh input with the compressed or uncompressed sec of a private key.
+func (tx *Transaction) signP2pkhInput(provider PrevoutProvider, inputIndex int, scriptPubKey *script.Script, pk *ecc.PrivateKey, compressed bool, hashType uint32) (bool, error) {
+	z := new(big.Int).SetBytes(tx.SigHash(inputIndex, scriptPubKey, hashType))
+	// get der signature of z from private key
+	der := pk.Sign(z).Der()
+	der = append(der, byte(hashType))
+	// change input's scriptSig to [sig, sec]
+	txIn := tx.Inputs[inputIndex]
+	txIn.ScriptSig = script.NewScript([][]byte{der, pk.Point.Sec(compressed)})
+	txIn.Witness = nil
+	// return whether sig is valid using tx.signedInput
+	return tx.signedInput(provider, inputIndex)
+}
+
+// taprootKeyPathKey returns the key that signs for the taproot output key of an internal key
+// without a script tree.
+func taprootKeyPathKey(pk *ecc.PrivateKey) (*ecc.PrivateKey, error) {
+	even := pk.EvenY()
+	return even.TweakAdd(script.TapTweak(even.Point, nil))
+}
+
+// signTaprootInput signs a key path spend of a taproot output without a script tree.
+func (tx *Transaction) signTaprootInput(provider PrevoutProvider, inputIndex int, pk *ecc.PrivateKey, hashType uint32) (bool, error) {
+	tweaked, err := taprootKeyPathKey(pk)
+	if err != nil {
+		return false, err
+	}
+	z, err := tx.SigHashTaproot(provider, inputIndex, hashType, nil)
+	if err != nil {
+		return false, err
+	}
+	sig, err := tweaked.SignSchnorr(z, nil)
+	if err != nil {
+		return false, err
+	}
+	if hashType != util.SigHashDefault {
+		sig = append(sig, byte(hashType))
+	}
+	txIn := tx.Inputs[inputIndex]
+	txIn.ScriptSig = new(script.Script)
+	txIn.Witness = [][]byte{sig}
+	return tx.signedInput(provider, inputIndex)
 }
 
 // IsCoinbase returns whether this transaction is a coinbase transaction or not
@@ -169,12 +452,3 @@ func (tx *Transaction) IsCoinbase() bool {
 	txIn := tx.Inputs[0]
 	return bytes.Equal(txIn.PrevTx, make([]byte, 32)) && txIn.PrevIndex == 0xffffffff
 }
-
-// Returns the height of the block this coinbase transaction is in
-func (tx *Transaction) coinbaseHeight() *big.Int {
-	if !tx.IsCoinbase() {
-		return nil
-	}
-	cmd := tx.Inputs[0].ScriptSig.Peek(0)
-	return util.LittleEndianToBigInt(cmd)
-}
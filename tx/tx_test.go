@@ -3,20 +3,30 @@ package tx
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
 	"github.com/ravdin/programmingbitcoin/util"
 )
 
 const serializedTx string = `0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600`
 
+// testProvider holds the transactions in tx.cache.
+var testProvider = NewMemoryProvider()
+
 func init() {
-	fetcher := newTxFetcher()
-	fetcher.loadCache("tx.cache")
-	if len(fetcher.cache) == 0 {
-		panic("Failed to load cache!")
+	if err := testProvider.LoadJSON("tx.cache"); err != nil {
+		panic(err)
 	}
 }
 
@@ -80,52 +90,230 @@ func TestParseLocktime(t *testing.T) {
 	}
 }
 
+func TestSegwit(t *testing.T) {
+	data, err := ioutil.ReadFile("tx.cache")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var cache map[string]string
+	json.Unmarshal(data, &cache)
+
+	t.Run("Test round trip", func(t *testing.T) {
+		for txID, rawHex := range cache {
+			txObj := deserialize(rawHex)
+			if actual := hex.EncodeToString(txObj.Serialize()); actual != rawHex {
+				t.Errorf("Expected %v, got %v", rawHex, actual)
+			}
+			if txObj.ID() != txID {
+				t.Errorf("Expected id %v, got %v", txID, txObj.ID())
+			}
+		}
+	})
+
+	t.Run("Test witness", func(t *testing.T) {
+		txObj := deserialize(cache["d869f854e1f8788bcff294cc83b280942a8c728de71eb709a2c29d10bfe21b7c"])
+		if !txObj.HasWitness() || len(txObj.Inputs[0].Witness) == 0 {
+			t.Fatalf("Expected witness data")
+		}
+		expected := "976015741ba2fc60804dd63167326b1a1f7e94af2b66f4a0fd95b38c18ee729b"
+		if actual := txObj.Wtxid(); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+		if len(txObj.SerializeLegacy()) != 85 {
+			t.Errorf("Expected 85 bytes without witness data, got %d", len(txObj.SerializeLegacy()))
+		}
+		if txObj.Weight() != 450 || txObj.VSize() != 113 {
+			t.Errorf("Expected weight 450 and vsize 113, got %d and %d", txObj.Weight(), txObj.VSize())
+		}
+	})
+
+	t.Run("Test legacy", func(t *testing.T) {
+		txObj := deserialize(serializedTx)
+		if txObj.HasWitness() || txObj.Wtxid() != txObj.ID() {
+			t.Errorf("Expected the wtxid of a legacy transaction to be its id")
+		}
+		size := len(txObj.Serialize())
+		if txObj.Weight() != size*4 || txObj.VSize() != size {
+			t.Errorf("Expected weight %d, got %d", size*4, txObj.Weight())
+		}
+	})
+}
+
 func TestFee(t *testing.T) {
 	testTx := deserialize(serializedTx)
 	var expected uint64 = 40000
-	if testTx.Fee() != expected {
-		t.Errorf("Expected %v, got %v", expected, testTx.Fee())
+	if actual, err := testTx.Fee(testProvider); err != nil || actual != expected {
+		t.Errorf("Expected %v, got %v %v", expected, actual, err)
 	}
 
 	serialized2 := `010000000456919960ac691763688d3d3bcea9ad6ecaf875df5339e148a1fc61c6ed7a069e010000006a47304402204585bcdef85e6b1c6af5c2669d4830ff86e42dd205c0e089bc2a821657e951c002201024a10366077f87d6bce1f7100ad8cfa8a064b39d4e8fe4ea13a7b71aa8180f012102f0da57e85eec2934a82a585ea337ce2f4998b50ae699dd79f5880e253dafafb7feffffffeb8f51f4038dc17e6313cf831d4f02281c2a468bde0fafd37f1bf882729e7fd3000000006a47304402207899531a52d59a6de200179928ca900254a36b8dff8bb75f5f5d71b1cdc26125022008b422690b8461cb52c3cc30330b23d574351872b7c361e9aae3649071c1a7160121035d5c93d9ac96881f19ba1f686f15f009ded7c62efe85a872e6a19b43c15a2937feffffff567bf40595119d1bb8a3037c356efd56170b64cbcc160fb028fa10704b45d775000000006a47304402204c7c7818424c7f7911da6cddc59655a70af1cb5eaf17c69dadbfc74ffa0b662f02207599e08bc8023693ad4e9527dc42c34210f7a7d1d1ddfc8492b654a11e7620a0012102158b46fbdff65d0172b7989aec8850aa0dae49abfb84c81ae6e5b251a58ace5cfeffffffd63a5e6c16e620f86f375925b21cabaf736c779f88fd04dcad51d26690f7f345010000006a47304402200633ea0d3314bea0d95b3cd8dadb2ef79ea8331ffe1e61f762c0f6daea0fabde022029f23b3e9c30f080446150b23852028751635dcee2be669c2a1686a4b5edf304012103ffd6f4a67e94aba353a00882e563ff2722eb4cff0ad6006e86ee20dfe7520d55feffffff0251430f00000000001976a914ab0c0b2e98b1ab6dbf67d4750b0a56244948a87988ac005a6202000000001976a9143c82d7df364eb6c75be8c80df2b3eda8db57397088ac46430600`
 	testTx2 := deserialize(serialized2)
 	expected = 140500
-	if expected != testTx2.Fee() {
-		t.Errorf("Expected %v, got %v", expected, testTx2.Fee())
+	if actual, err := testTx2.Fee(testProvider); err != nil || actual != expected {
+		t.Errorf("Expected %v, got %v %v", expected, actual, err)
+	}
+	if _, err := testTx.Fee(NewMemoryProvider()); err == nil {
+		t.Errorf("Expected an error for an unknown prevout")
 	}
 }
 
 func TestSigHash(t *testing.T) {
-	fetcher := newTxFetcher()
-	tx := fetcher.fetch("452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03", false, false)
+	tx, _ := testProvider.Transaction("452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03", false)
+	scriptPubKey, err := tx.Inputs[0].ScriptPubKey(testProvider, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	expected := util.HexStringToBytes("27e0c5994dec7824e56dec6b2fcb342eb7cdb0d0957c2fce9882f715e85d81a6")
-	actual := tx.SigHash(0)
+	actual := tx.SigHash(0, scriptPubKey, util.SigHashAll)
 	if !bytes.Equal(actual, expected) {
 		t.Errorf("Expected %x, got %x", expected, actual)
 	}
 }
 
+func TestSigHashTypes(t *testing.T) {
+	provider := NewMemoryProvider()
+	// cachePrevout makes the output spent by an input available to the provider
+	cachePrevout := func(txIn *Input, amount uint64, scriptPubKey *script.Script) {
+		provider.AddOutput(txIn.PrevTx, txIn.PrevIndex, NewOutput(amount, scriptPubKey))
+	}
+
+	t.Run("Test BIP143 p2wpkh", func(t *testing.T) {
+		txObj := deserialize("0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000")
+		program := util.HexStringToBytes("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+		cachePrevout(txObj.Inputs[1], 600000000, script.P2wpkhScript(program))
+		expected := "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670"
+		z, err := txObj.SigHashBip143(provider, 1, script.P2pkhScript(program), util.SigHashAll)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual := hex.EncodeToString(z); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Test BIP143 hash types", func(t *testing.T) {
+		txObj := deserialize("010000000136641869ca081e70f394c6948e8af409e18b619df2ed74aa106c1ca29787b96e0100000000ffffffff0200e9a435000000001976a914389ffce9cd9ae88dcc0631e88a821ffdbe9bfe2688acc0832f05000000001976a9147480a33f950689af511e6e84c138dbbd3c3ee41588ac00000000")
+		witnessScript, _ := script.ParseRaw(util.HexStringToBytes("56210307b8ae49ac90a048e9b53357a2354b3334e9c8bee813ecb98e99a7e07e8c3ba32103b28f0c28bfab54554ae8c658ac5c3e0ce6e79ad336331f78c428dd43eea8449b21034b8113d703413d57761b8b9781957b8c0ac1dfe69f492580ca4195f50376ba4a21033400f6afecb833092a9a21cfdf1ed1376e58c5d1f47de74683123987e967a8f42103a6d48b1131e94ba04d9737d61acdaa1322008af9602b3b14862c07a1789aac162102d8b661b0b3302ee2f162b09e07a55ad5dfbe673a9f01d9f0c19617681024306b56ae"))
+		p2wsh := script.P2wshScript(util.Sha256(witnessScript.RawSerialize()))
+		cachePrevout(txObj.Inputs[0], 987654321, script.P2shScript(util.Hash160(p2wsh.RawSerialize())))
+		tests := []struct {
+			hashType uint32
+			expected string
+		}{
+			{util.SigHashAll, "185c0be5263dce5b4bb50a047973c1b6272bfbd0103a89444597dc40b248ee7c"},
+			{util.SigHashNone, "e9733bc60ea13c95c6527066bb975a2ff29a925e80aa14c213f686cbae5d2f36"},
+			{util.SigHashSingle, "1e1f1c303dc025bd664acb72e583e933fae4cff9148bf78c157d1e8f78530aea"},
+			{util.SigHashAll | util.SigHashAnyoneCanPay, "2a67f03e63a6a422125878b40b82da593be8d4efaafe88ee528af6e5a9955c6e"},
+			{util.SigHashNone | util.SigHashAnyoneCanPay, "781ba15f3779d5542ce8ecb5c18716733a5ee42a6f51488ec96154934e2c890a"},
+			{util.SigHashSingle | util.SigHashAnyoneCanPay, "511e8e52ed574121fc1b654970395502128263f62662e076dc6baf05c2e6a99b"},
+		}
+		for _, test := range tests {
+			z, _ := txObj.SigHashBip143(provider, 0, witnessScript, test.hashType)
+			if actual := hex.EncodeToString(z); actual != test.expected {
+				t.Errorf("Hash type %#x: expected %v, got %v", test.hashType, test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("Test SIGHASH_SINGLE bug", func(t *testing.T) {
+		txObj := deserialize(serializedTx)
+		txObj.Outputs = txObj.Outputs[:0]
+		expected := "0100000000000000000000000000000000000000000000000000000000000000"
+		if actual := hex.EncodeToString(txObj.SigHash(0, new(script.Script), util.SigHashSingle)); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("Test sign with hash types", func(t *testing.T) {
+		pk := ecc.NewPrivateKey(big.NewInt(5001))
+		even := pk.EvenY()
+		outputKey, _ := script.TaprootOutputKey(even.Point, nil)
+		h160 := pk.Point.Hash160(true)
+		scriptPubKeys := []*script.Script{
+			script.P2pkhScript(h160),
+			script.P2wpkhScript(h160),
+			script.P2shScript(util.Hash160(script.P2wpkhScript(h160).RawSerialize())),
+			script.P2trScript(outputKey.XOnly()),
+		}
+		var txIns []*Input
+		for i, scriptPubKey := range scriptPubKeys {
+			txIn := NewInput(util.Hash256([]byte{byte(i)}), i, nil, SequenceFinal)
+			cachePrevout(txIn, 10000, scriptPubKey)
+			txIns = append(txIns, txIn)
+		}
+		txOuts := []*Output{
+			NewOutput(20000, script.P2pkhScript(h160)),
+			NewOutput(15000, script.P2wpkhScript(h160)),
+		}
+		hashTypes := []uint32{
+			util.SigHashAll,
+			util.SigHashNone,
+			util.SigHashSingle | util.SigHashAnyoneCanPay,
+			util.SigHashAll | util.SigHashAnyoneCanPay,
+		}
+		for _, hashType := range append(hashTypes, util.SigHashSingle) {
+			txObj := NewTransaction(2, txIns, txOuts, 0, false)
+			for i := range txIns {
+				if ok, _ := txObj.SignInput(provider, i, pk, hashType); !ok && (i < len(txOuts) || hashType&3 != util.SigHashSingle) {
+					t.Errorf("Input %d: sign with hash type %#x failed", i, hashType)
+				}
+			}
+		}
+		// an anyone can pay signature stays valid when another input is added
+		txObj := NewTransaction(2, txIns[:3], txOuts[:1], 0, false)
+		for i := range txObj.Inputs {
+			txObj.SignInput(provider, i, pk, util.SigHashAll|util.SigHashAnyoneCanPay)
+		}
+		txObj.Inputs = append(txObj.Inputs, txIns[3])
+		txObj.SignInput(provider, 3, pk, util.SigHashDefault)
+		if ok, err := txObj.Verify(provider); err != nil || !ok {
+			t.Errorf("Verify failed!")
+		}
+		if _, err := txObj.SigHashTaproot(provider, 3, 4, nil); err == nil {
+			t.Errorf("Expected an error for an invalid hash type")
+		}
+	})
+}
+
 func TestVerifyp2pkh(t *testing.T) {
-	fetcher := newTxFetcher()
 	txIds := []string{
 		"452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03",
 		"5418099cc755cb9dd3ebc6cf1a7888ad53a1a3beb5a025bce89eb1bf7f1650a2",
 	}
 	for i, txID := range txIds {
 		testnet := i == 1
-		tx := fetcher.fetch(txID, testnet, false)
-		if !tx.Verify() {
+		tx, _ := testProvider.Transaction(txID, testnet)
+		tx.Testnet = testnet
+		if ok, err := tx.Verify(testProvider); err != nil || !ok {
 			t.Errorf("Verify failed!")
 		}
 	}
 }
 
 func TestVerifyp2sh(t *testing.T) {
-	fetcher := newTxFetcher()
-	tx := fetcher.fetch("46df1a9484d0a81d03ce0ee543ab6e1a23ed06175c104a178268fad381216c2b", false, false)
-	if !tx.Verify() {
+	tx, _ := testProvider.Transaction("46df1a9484d0a81d03ce0ee543ab6e1a23ed06175c104a178268fad381216c2b", false)
+	if ok, err := tx.Verify(testProvider); err != nil || !ok {
 		t.Errorf("Verify failed!")
 	}
+	// the engine Verify runs can be stepped through
+	engine, err := tx.InputEngine(testProvider, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := engine.Step(); err != nil || engine.Done() {
+		t.Errorf("Expected the first step to succeed, got %v", err)
+	}
+	if err := engine.Run(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := tx.InputEngine(testProvider, len(tx.Inputs)); err == nil {
+		t.Errorf("Expected an error for an input out of range")
+	}
+	// the error says which input fails and why
+	tx.Locktime++
+	if ok, err := tx.Verify(testProvider); ok || err == nil || !strings.HasPrefix(err.Error(), "input 0: ") || !strings.Contains(err.Error(), "evaluated to false") {
+		t.Errorf("Expected input 0 to evaluate to false, got %v", err)
+	}
 }
 
 func TestPrivateKey(t *testing.T) {
@@ -133,7 +321,7 @@ func TestPrivateKey(t *testing.T) {
 	data := util.HexStringToBytes("010000000199a24308080ab26e6fb65c4eccfadf76749bb5bfa8cb08f291320b3c21e56f0d0d00000000ffffffff02408af701000000001976a914d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f88ac80969800000000001976a914507b27411ccf7f16f10297de6cef3f291623eddf88ac00000000")
 	reader := bytes.NewReader(data)
 	txObj := ParseTransaction(reader, true)
-	if !txObj.SignInput(0, pk) {
+	if ok, err := txObj.SignInput(testProvider, 0, pk, util.SigHashAll); err != nil || !ok {
 		t.Errorf("Private key sign failed!")
 	}
 	expected := `010000000199a24308080ab26e6fb65c4eccfadf76749bb5bfa8cb08f291320b3c21e56f0d0d0000006b4830450221008ed46aa2cf12d6d81065bfabe903670165b538f65ee9a3385e6327d80c66d3b502203124f804410527497329ec4715e18558082d489b218677bd029e7fa306a72236012103935581e52c354cd2f484fe8ed83af7a3097005b2f9c60bff71d35bd795f54b67ffffffff02408af701000000001976a914d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f88ac80969800000000001976a914507b27411ccf7f16f10297de6cef3f291623eddf88ac00000000`
@@ -150,16 +338,346 @@ func TestCoinbase(t *testing.T) {
 	if !txObj.IsCoinbase() {
 		t.Errorf("Expected true")
 	}
-	expectedHeight := big.NewInt(465879)
-	actualHeight := txObj.coinbaseHeight()
-	if actualHeight.Cmp(expectedHeight) != 0 {
-		t.Errorf("Expected %d, got %d", expectedHeight, actualHeight)
+	actualHeight, err := txObj.CoinbaseHeight()
+	if err != nil || actualHeight != 465879 {
+		t.Errorf("Expected 465879, got %d %v", actualHeight, err)
 	}
 	data = util.HexStringToBytes(`0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600`)
 	reader = bytes.NewReader(data)
 	txObj = ParseTransaction(reader, true)
-	if txObj.coinbaseHeight() != nil {
-		t.Errorf("Expected nil")
+	if _, err := txObj.CoinbaseHeight(); err == nil {
+		t.Errorf("Expected an error for a transaction that isn't a coinbase")
+	}
+}
+
+func TestNewCoinbase(t *testing.T) {
+	payout := script.P2wpkhScript(make([]byte, 20))
+	tests := []struct {
+		height   int
+		subsidy  uint64
+		interval int
+	}{
+		{1, 50 * Coin, HalvingInterval},
+		{209999, 50 * Coin, HalvingInterval},
+		{210000, 25 * Coin, HalvingInterval},
+		{840000, 3.125 * Coin, HalvingInterval},
+		{64 * HalvingInterval, 0, HalvingInterval},
+		{150, 25 * Coin, 150},
+	}
+	for _, test := range tests {
+		c := NewCoinbase(test.height, payout)
+		c.HalvingInterval = test.interval
+		c.Fees = 1000
+		c.ExtraNonce = []byte{1, 2, 3, 4}
+		coinbase, err := c.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual := coinbase.Outputs[0].Amount; actual != test.subsidy+1000 {
+			t.Errorf("Height %d: expected %d, got %d", test.height, test.subsidy+1000, actual)
+		}
+		if actual, err := coinbase.CoinbaseHeight(); err != nil || actual != test.height {
+			t.Errorf("Expected height %d, got %d %v", test.height, actual, err)
+		}
+		if err := coinbase.Check(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	t.Run("Test script sig", func(t *testing.T) {
+		// the first block after BIP34 activated on mainnet
+		c := NewCoinbase(227931, payout)
+		scriptSig, _ := c.ScriptSig()
+		if actual := hex.EncodeToString(scriptSig.RawSerialize()); actual != "035b7a03" {
+			t.Errorf("Expected 035b7a03, got %s", actual)
+		}
+		// small heights are padded to the minimum length
+		scriptSig, _ = NewCoinbase(1, payout).ScriptSig()
+		if actual := hex.EncodeToString(scriptSig.RawSerialize()); actual != "5100" {
+			t.Errorf("Expected 5100, got %s", actual)
+		}
+		c.Tag = make([]byte, 100)
+		if _, err := c.Build(); err == nil {
+			t.Errorf("Expected an error for a ScriptSig over 100 bytes")
+		}
+		// OP_PUSHDATA1 with no data is a height of 0
+		coinbase, _ := NewCoinbase(1, payout).Build()
+		coinbase.Inputs[0].ScriptSig, _ = script.ParseRaw(util.HexStringToBytes(`4c00`))
+		if err := coinbase.Check(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if actual, err := coinbase.CoinbaseHeight(); err != nil || actual != 0 {
+			t.Errorf("Expected height 0, got %d %v", actual, err)
+		}
+	})
+
+	t.Run("Test witness commitment", func(t *testing.T) {
+		// block 481824, the first segwit block on mainnet, committed to its only other transaction
+		var spend *Transaction
+		for _, txObj := range testProvider.transactions {
+			if txObj.HasWitness() && !txObj.IsCoinbase() {
+				spend = txObj
+				break
+			}
+		}
+		if spend == nil {
+			t.Skip("no segwit transaction in tx.cache")
+		}
+		c := NewCoinbase(481824, payout)
+		c.Transactions = []*Transaction{spend}
+		coinbase, err := c.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := util.Hash256(append(util.MerkleParent(make([]byte, 32), util.Hash256(spend.Serialize())), make([]byte, 32)...))
+		if actual := coinbase.WitnessCommitment(); !bytes.Equal(actual, expected) {
+			t.Errorf("Expected %x, got %x", expected, actual)
+		}
+		if len(coinbase.Inputs[0].Witness) != 1 || len(coinbase.Inputs[0].Witness[0]) != 32 {
+			t.Errorf("Expected the witness reserved value")
+		}
+		c.WitnessCommitment = false
+		if coinbase, _ := c.Build(); coinbase.WitnessCommitment() != nil || coinbase.HasWitness() {
+			t.Errorf("Expected no witness commitment")
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	p2pkh := script.P2pkhScript(make([]byte, 20))
+	prevTx := util.Hash256([]byte("made up"))
+	valid := func() *Transaction {
+		txIns := []*Input{NewInput(prevTx, 0, nil, SequenceFinal), NewInput(prevTx, 1, nil, SequenceFinal)}
+		return NewTransaction(1, txIns, []*Output{NewOutput(1000, p2pkh)}, 0, false)
+	}
+	if err := valid().Check(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := deserialize(serializedTx).Check(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	coinbase := func(scriptSig *script.Script) *Transaction {
+		txIn := NewInput(make([]byte, 32), 0xffffffff, scriptSig, SequenceFinal)
+		return NewTransaction(1, []*Input{txIn}, []*Output{NewOutput(MaxMoney, p2pkh)}, 0, false)
+	}
+	if err := coinbase(new(script.Script).AppendData([]byte{1, 2})).Check(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	tests := []struct {
+		name string
+		tx   *Transaction
+	}{
+		{"no inputs", NewTransaction(1, nil, valid().Outputs, 0, false)},
+		{"no outputs", NewTransaction(1, valid().Inputs, nil, 0, false)},
+		{"too large", NewTransaction(1, valid().Inputs, []*Output{NewOutput(0, new(script.Script).AppendData(make([]byte, MaxBlockWeight/4)))}, 0, false)},
+		{"output over MaxMoney", NewTransaction(1, valid().Inputs, []*Output{NewOutput(MaxMoney+1, p2pkh)}, 0, false)},
+		{"negative output", NewTransaction(1, valid().Inputs, []*Output{NewOutput(1<<63, p2pkh)}, 0, false)},
+		{"total over MaxMoney", NewTransaction(1, valid().Inputs, []*Output{NewOutput(MaxMoney, p2pkh), NewOutput(1, p2pkh)}, 0, false)},
+		{"duplicate inputs", NewTransaction(1, []*Input{valid().Inputs[0], valid().Inputs[0]}, valid().Outputs, 0, false)},
+		{"coinbase ScriptSig too short", coinbase(new(script.Script).AppendOp(0x51))},
+		{"coinbase ScriptSig too long", coinbase(new(script.Script).AppendData(make([]byte, 100)))},
+		{"null prevout", NewTransaction(1, []*Input{valid().Inputs[0], coinbase(new(script.Script).AppendData([]byte{1, 2})).Inputs[0]}, valid().Outputs, 0, false)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.tx.Check(); err == nil {
+				t.Errorf("Expected an error")
+			}
+			if ok, err := test.tx.Verify(NewMemoryProvider()); ok || err == nil {
+				t.Errorf("Expected Verify to fail")
+			}
+		})
+	}
+
+	t.Run("Test fee ranges", func(t *testing.T) {
+		provider := NewMemoryProvider()
+		provider.AddOutput(prevTx, 0, NewOutput(MaxMoney, p2pkh))
+		provider.AddOutput(prevTx, 1, NewOutput(1000, p2pkh))
+		txObj := valid()
+		// the inputs add up to more than MaxMoney
+		if fee, err := txObj.Fee(provider); err == nil {
+			t.Errorf("Expected an error, got fee %d", fee)
+		}
+		txObj.Inputs = txObj.Inputs[1:]
+		if fee, err := txObj.Fee(provider); err != nil || fee != 0 {
+			t.Errorf("Expected fee 0, got %d %v", fee, err)
+		}
+		txObj.Outputs[0].Amount = 1001
+		if fee, err := txObj.Fee(provider); err == nil {
+			t.Errorf("Expected an error, got fee %d", fee)
+		}
+		if ok, err := txObj.Verify(provider); ok || err == nil {
+			t.Errorf("Expected Verify to fail")
+		}
+	})
+}
+
+func TestSigOpCost(t *testing.T) {
+	point := ecc.NewPrivateKey(big.NewInt(5002)).Point
+	pubKeys := []*ecc.S256Point{point, point, point}
+	redeemScript, _ := script.MultisigScript(2, pubKeys)
+	redeem := redeemScript.RawSerialize()
+	p2wpkh := script.P2wpkhScript(make([]byte, 20))
+	prevTx := util.Hash256([]byte("sigops"))
+	provider := NewMemoryProvider()
+	provider.AddOutput(prevTx, 0, NewOutput(1000, script.P2pkhScript(make([]byte, 20))))
+	provider.AddOutput(prevTx, 1, NewOutput(1000, script.P2shScript(util.Hash160(redeem))))
+	provider.AddOutput(prevTx, 2, NewOutput(1000, script.P2wshScript(util.Sha256(redeem))))
+	provider.AddOutput(prevTx, 3, NewOutput(1000, p2wpkh))
+	provider.AddOutput(prevTx, 4, NewOutput(1000, script.P2shScript(util.Hash160(p2wpkh.RawSerialize()))))
+	txIns := []*Input{
+		NewInput(prevTx, 0, script.NewScript([][]byte{make([]byte, 72), make([]byte, 33)}), SequenceFinal),
+		NewInput(prevTx, 1, script.NewScript([][]byte{{0}, make([]byte, 72), make([]byte, 72), redeem}), SequenceFinal),
+		NewInput(prevTx, 2, nil, SequenceFinal),
+		NewInput(prevTx, 3, nil, SequenceFinal),
+		NewInput(prevTx, 4, new(script.Script).AppendData(p2wpkh.RawSerialize()), SequenceFinal),
+	}
+	txIns[2].Witness = [][]byte{{}, make([]byte, 72), make([]byte, 72), redeem}
+	txIns[3].Witness = [][]byte{make([]byte, 72), make([]byte, 33)}
+	txIns[4].Witness = txIns[3].Witness
+	txObj := NewTransaction(1, txIns, []*Output{NewOutput(4000, script.P2pkhScript(make([]byte, 20)))}, 0, false)
+	// 4 for the p2pkh output, 4 times 3 for the p2sh multisig, 3 for the p2wsh multisig, 1 for each p2wpkh
+	if cost, err := txObj.SigOpCost(provider); err != nil || cost != 21 {
+		t.Errorf("Expected 21, got %d %v", cost, err)
+	}
+	if _, err := txObj.SigOpCost(NewMemoryProvider()); err == nil {
+		t.Errorf("Expected an error for an unknown prevout")
+	}
+}
+
+func TestMultisig(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(2001)),
+		ecc.NewPrivateKey(big.NewInt(2002)),
+		ecc.NewPrivateKey(big.NewInt(2003)),
+	}
+	redeemScript, err := script.SortedMultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	raw := redeemScript.RawSerialize()
+	p2wsh := script.P2wshScript(util.Sha256(raw))
+	// a made up transaction paying to the multisig as p2sh, p2wsh and p2sh-p2wsh
+	prevTx := NewTransaction(1, []*Input{NewInput(make([]byte, 32), 0, nil, 0xffffffff)}, []*Output{
+		NewOutput(100000, script.P2shScript(util.Hash160(raw))),
+		NewOutput(200000, p2wsh),
+		NewOutput(300000, script.P2shScript(util.Hash160(p2wsh.RawSerialize()))),
+	}, 0, true)
+	provider := NewMemoryProvider(prevTx)
+	unsigned := func() *Transaction {
+		var txIns []*Input
+		for i := range prevTx.Outputs {
+			txIns = append(txIns, NewInput(prevTx.Hash(), i, nil, 0xffffffff))
+		}
+		txOut := NewOutput(590000, script.P2pkhScript(keys[0].Point.Hash160(true)))
+		return NewTransaction(1, txIns, []*Output{txOut}, 0, true)
+	}
+
+	t.Run("Test sign multisig", func(t *testing.T) {
+		txObj := unsigned()
+		for i := range txObj.Inputs {
+			if ok, err := txObj.SignMultisigInput(provider, i, redeemScript, keys[2], util.SigHashAll); err != nil || ok {
+				t.Errorf("Input %d: expected one signature not to be enough, got %v %v", i, ok, err)
+			}
+			if ok, err := txObj.SignMultisigInput(provider, i, redeemScript, keys[0], util.SigHashAll); err != nil || !ok {
+				t.Errorf("Input %d: expected two signatures to be valid, got %v %v", i, ok, err)
+			}
+		}
+		if ok, err := txObj.Verify(provider); err != nil || !ok {
+			t.Errorf("Verify failed!")
+		}
+		// the signatures are in key order after the dummy element
+		m, _, _ := redeemScript.Multisig()
+		witness := txObj.Inputs[1].Witness
+		if len(witness) != m+2 || len(witness[0]) != 0 || !bytes.Equal(witness[m+1], raw) {
+			t.Fatalf("Unexpected witness %x", witness)
+		}
+	})
+
+	t.Run("Test combine multisig", func(t *testing.T) {
+		txObj, other := unsigned(), unsigned()
+		for i := range txObj.Inputs {
+			txObj.SignMultisigInput(provider, i, redeemScript, keys[1], util.SigHashAll)
+			other.SignMultisigInput(provider, i, redeemScript, keys[2], util.SigHashAll)
+			if ok, err := txObj.CombineMultisigInput(provider, i, redeemScript, other); err != nil || !ok {
+				t.Errorf("Input %d: expected the combined signatures to be valid, got %v %v", i, ok, err)
+			}
+		}
+		if ok, err := txObj.Verify(provider); err != nil || !ok {
+			t.Errorf("Verify failed!")
+		}
+		different := unsigned()
+		different.Locktime = 1
+		if _, err := txObj.CombineMultisigInput(provider, 0, redeemScript, different); err == nil {
+			t.Errorf("Expected an error combining a different transaction")
+		}
+	})
+
+	t.Run("Test sign with an unknown key", func(t *testing.T) {
+		txObj := unsigned()
+		if _, err := txObj.SignMultisigInput(provider, 0, redeemScript, ecc.NewPrivateKey(big.NewInt(2004)), util.SigHashAll); err == nil {
+			t.Errorf("Expected an error for a key not in the script")
+		}
+		if _, err := txObj.SignMultisigInput(provider, 0, script.P2pkhScript(keys[0].Point.Hash160(true)), keys[0], util.SigHashAll); err == nil {
+			t.Errorf("Expected an error for a script that is not multisig")
+		}
+	})
+}
+
+func TestSignAll(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(2101)),
+		ecc.NewPrivateKey(big.NewInt(2102)),
+		ecc.NewPrivateKey(big.NewInt(2103)),
+	}
+	pk := keys[0]
+	h160 := pk.Point.Hash160(true)
+	p2wpkh := script.P2wpkhScript(h160)
+	multisig, _ := script.MultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	raw := multisig.RawSerialize()
+	p2wsh := script.P2wshScript(util.Sha256(raw))
+	outputKey, _ := script.TaprootOutputKey(pk.EvenY().Point, nil)
+	// a made up transaction paying to every type SignAll signs, and to a key it doesn't have
+	prevTx := NewTransaction(1, []*Input{NewInput(make([]byte, 32), 0, nil, 0xffffffff)}, []*Output{
+		NewOutput(10000, script.P2pkhScript(h160)),
+		NewOutput(10000, script.P2pkhScript(pk.Point.Hash160(false))),
+		NewOutput(10000, p2wpkh),
+		NewOutput(10000, script.P2shScript(util.Hash160(p2wpkh.RawSerialize()))),
+		NewOutput(10000, script.P2shScript(util.Hash160(raw))),
+		NewOutput(10000, p2wsh),
+		NewOutput(10000, script.P2shScript(util.Hash160(p2wsh.RawSerialize()))),
+		NewOutput(10000, script.P2trScript(outputKey.XOnly())),
+		NewOutput(10000, script.P2wpkhScript(keys[2].Point.Hash160(true))),
+	}, 0, true)
+	provider := NewMemoryProvider(prevTx)
+	var txIns []*Input
+	for i := range prevTx.Outputs {
+		txIns = append(txIns, NewInput(prevTx.Hash(), i, nil, 0xffffffff))
+	}
+	txOut := NewOutput(80000, p2wpkh)
+	txObj := NewTransaction(1, txIns, []*Output{txOut}, 0, true)
+	keystore := NewKeystore(keys[0], keys[1])
+	keystore.AddScript(multisig)
+	unsigned, err := txObj.SignAll(provider, keystore)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(unsigned) != 1 || unsigned[0] != len(txIns)-1 {
+		t.Fatalf("Expected only the last input to be unsigned, got %v", unsigned)
+	}
+	// the uncompressed key signs with its own sec
+	if sec := txObj.Inputs[1].ScriptSig.Peek(1); !bytes.Equal(sec, pk.Point.Sec(false)) {
+		t.Errorf("Expected the uncompressed sec, got %x", sec)
+	}
+	keystore.AddKey(keys[2])
+	if unsigned, err := txObj.SignAll(provider, keystore); err != nil || len(unsigned) != 0 {
+		t.Fatalf("Expected every input to be signed, got %v %v", unsigned, err)
+	}
+	if ok, err := txObj.Verify(provider); err != nil || !ok {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if _, err := txObj.SignAll(NewMemoryProvider(), keystore); err == nil {
+		t.Errorf("Expected an error for unknown prevouts")
 	}
 }
 
@@ -168,3 +686,283 @@ func deserialize(s string) *Transaction {
 	reader := bytes.NewReader(raw)
 	return ParseTransaction(reader, false)
 }
+
+func TestProviders(t *testing.T) {
+	txID := "452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03"
+	known, _ := testProvider.Transaction(txID, false)
+	rawHex := hex.EncodeToString(known.Serialize())
+
+	t.Run("Test memory provider", func(t *testing.T) {
+		provider := NewMemoryProvider(known)
+		prevTx := util.Hash256([]byte("made up"))
+		provider.AddOutput(prevTx, 3, NewOutput(5000, new(script.Script)))
+		if output, err := provider.Prevout(prevTx, 3, false); err != nil || output.Amount != 5000 {
+			t.Errorf("Expected the added output, got %v", err)
+		}
+		if output, err := provider.Prevout(known.Hash(), 1, false); err != nil || output != known.Outputs[1] {
+			t.Errorf("Expected an output of the added transaction, got %v", err)
+		}
+		if _, err := provider.Prevout(known.Hash(), len(known.Outputs), false); err == nil {
+			t.Errorf("Expected an error for an output index out of range")
+		}
+		if _, err := provider.Prevout(prevTx, 0, false); err == nil {
+			t.Errorf("Expected an error for an unknown output")
+		}
+	})
+
+	t.Run("Test directory provider", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "txprovider")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		segwitID := "d869f854e1f8788bcff294cc83b280942a8c728de71eb709a2c29d10bfe21b7c"
+		segwit, _ := testProvider.Transaction(segwitID, false)
+		verbose, _ := json.Marshal(map[string]interface{}{"txid": segwitID, "hex": hex.EncodeToString(segwit.Serialize())})
+		wrongID := "0d6fe5213c0b3291f208cba8bfb59b7476dffacc4e5cb66f6eb20a080843a299"
+		files := map[string]string{
+			txID + ".hex":      rawHex + "\n",
+			segwitID + ".json": string(verbose),
+			wrongID + ".hex":   rawHex,
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		provider := NewDirProvider(dir)
+		if tx, err := provider.Transaction(txID, false); err != nil || tx.ID() != txID {
+			t.Errorf("Unexpected error reading the hex file: %v", err)
+		}
+		tx, err := provider.Transaction(segwitID, true)
+		if err != nil || !tx.HasWitness() || !tx.Testnet {
+			t.Errorf("Unexpected error reading the JSON file: %v", err)
+		}
+		if _, err := provider.Prevout(known.Hash(), 0, false); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		for _, id := range []string{wrongID, "0000000000000000000000000000000000000000000000000000000000000000", "../" + txID} {
+			if _, err := provider.Transaction(id, false); err == nil {
+				t.Errorf("Expected an error for %s", id)
+			}
+		}
+	})
+
+	t.Run("Test HTTP provider", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/testnet/tx/" + txID + "/hex":
+				rw.Write([]byte(rawHex))
+			case "/tx/" + txID + "/hex":
+				rw.Write([]byte("not hex"))
+			default:
+				http.NotFound(rw, req)
+			}
+		}))
+		defer server.Close()
+		provider := NewEsploraProvider(server.URL, server.URL+"/testnet")
+		if output, err := provider.Prevout(known.Hash(), 0, true); err != nil || output.Amount != known.Outputs[0].Amount {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if _, err := provider.Transaction(txID, false); err == nil {
+			t.Errorf("Expected an error for a malformed response")
+		}
+		if _, err := provider.Transaction(strings.Repeat("0", 64), true); err == nil {
+			t.Errorf("Expected an error for a transaction that isn't found")
+		}
+	})
+
+	t.Run("Test UTXO store", func(t *testing.T) {
+		store := NewUtxoStore()
+		prevTx := util.Hash256([]byte("made up"))
+		store.Add(prevTx, 0, NewOutput(10000, script.P2pkhScript(make([]byte, 20))))
+		spend := NewTransaction(1, []*Input{NewInput(prevTx, 0, nil, SequenceFinal)}, []*Output{
+			NewOutput(4000, script.P2pkhScript(make([]byte, 20))),
+			NewOutput(5000, script.P2wpkhScript(make([]byte, 20))),
+		}, 0, false)
+		if err := store.Apply(spend); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := store.Apply(spend); err == nil {
+			t.Errorf("Expected an error spending an output twice")
+		}
+		if _, err := store.Prevout(prevTx, 0, false); err == nil {
+			t.Errorf("Expected the spent output to be gone")
+		}
+		if fee, err := spend.Fee(NewMemoryProvider()); err == nil {
+			t.Errorf("Expected an error, got fee %d", fee)
+		}
+		file, err := ioutil.TempFile("", "utxos")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+		if err := store.Save(file.Name()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		loaded, err := LoadUtxoStore(file.Name())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output, err := loaded.Prevout(spend.Hash(), 1, false)
+		if loaded.Len() != 2 || err != nil || output.Amount != 5000 {
+			t.Errorf("Unexpected store after loading: %d outputs, %v", loaded.Len(), err)
+		}
+	})
+}
+
+// countingProvider counts the lookups that reach a provider.
+type countingProvider struct {
+	TxProvider
+	mu    sync.Mutex
+	count int
+}
+
+func (p *countingProvider) Transaction(txID string, testnet bool) (*Transaction, error) {
+	p.mu.Lock()
+	p.count++
+	p.mu.Unlock()
+	return p.TxProvider.Transaction(txID, testnet)
+}
+
+func TestTxCache(t *testing.T) {
+	ids := []string{
+		"452c629d67e41baec3ac6f04fe744b4b9617f8f859c63b3002f8684e7a4fee03",
+		"d869f854e1f8788bcff294cc83b280942a8c728de71eb709a2c29d10bfe21b7c",
+		"0d6fe5213c0b3291f208cba8bfb59b7476dffacc4e5cb66f6eb20a080843a299",
+	}
+
+	t.Run("Test lookups", func(t *testing.T) {
+		provider := &countingProvider{TxProvider: testProvider}
+		cache := NewTxCache(provider, 2)
+		for i := 0; i < 2; i++ {
+			if _, err := cache.Transaction(ids[0], false); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if provider.count != 1 {
+			t.Errorf("Expected 1 lookup, got %d", provider.count)
+		}
+		// the networks are cached apart
+		cache.Transaction(ids[0], true)
+		cache.Transaction(ids[1], false)
+		if provider.count != 3 || cache.Len() != 2 {
+			t.Errorf("Expected 3 lookups and 2 transactions, got %d and %d", provider.count, cache.Len())
+		}
+		// the mainnet transaction was least recently used
+		cache.Transaction(ids[0], false)
+		if provider.count != 4 {
+			t.Errorf("Expected the evicted transaction to be looked up, got %d lookups", provider.count)
+		}
+		if _, err := cache.Prevout(util.Hash256([]byte("made up")), 0, false); err == nil {
+			t.Errorf("Expected an error for an unknown transaction")
+		}
+		if _, err := NewTxCache(nil, 0).Transaction(ids[0], false); err == nil {
+			t.Errorf("Expected an error without a provider")
+		}
+	})
+
+	t.Run("Test copies", func(t *testing.T) {
+		cache := NewTxCache(testProvider, 2)
+		if _, err := cache.Transaction(ids[0], false); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		cached, err := cache.Transaction(ids[0], false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// changing a transaction that was looked up or added doesn't change the cached one
+		cached.Inputs[0].ScriptSig = new(script.Script)
+		cached.Locktime++
+		fetched, _ := testProvider.Transaction(ids[1], false)
+		added, _ := ParseRawTransaction(fetched.Serialize(), false)
+		cache.Add(added)
+		added.Locktime++
+		for _, id := range ids[:2] {
+			if cached, err := cache.Transaction(id, false); err != nil || cached.ID() != id {
+				t.Errorf("Expected transaction %s to be unchanged, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("Test concurrent lookups", func(t *testing.T) {
+		cache := NewTxCache(testProvider, 2)
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := cache.Transaction(ids[i%len(ids)], i%2 == 0); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if cache.Len() != 2 {
+			t.Errorf("Expected 2 transactions, got %d", cache.Len())
+		}
+	})
+
+	t.Run("Test persistence", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "txcache")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "tx.cache")
+		cache, err := OpenTxCache(testProvider, 0, filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i, id := range ids {
+			if _, err := cache.Transaction(id, i == 0); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		// reopening reads what was written through, with the witnesses
+		provider := &countingProvider{TxProvider: testProvider}
+		reopened, err := OpenTxCache(provider, 2, filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if reopened.Len() != 2 {
+			t.Errorf("Expected 2 transactions, got %d", reopened.Len())
+		}
+		segwit, err := reopened.Transaction(ids[1], false)
+		if err != nil || !segwit.HasWitness() || provider.count != 0 {
+			t.Errorf("Expected the cached segwit transaction, got %v", err)
+		}
+		// the file was compacted to what was kept
+		loaded := NewTxCache(nil, 0)
+		if err := loaded.Load(filename); err != nil || loaded.Len() != 2 {
+			t.Errorf("Expected 2 saved transactions, got %d, %v", loaded.Len(), err)
+		}
+		if _, err := loaded.Transaction(ids[0], true); err == nil {
+			t.Errorf("Expected the evicted transaction to be gone")
+		}
+		// evictions don't grow the file past twice the capacity
+		small, err := OpenTxCache(testProvider, 1, filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := small.Transaction(ids[i%len(ids)], i%len(ids) == 0); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if lines := bytes.Count(data, []byte("\n")); lines > 2 {
+			t.Errorf("Expected at most 2 transactions in the file, got %d", lines)
+		}
+		if err := ioutil.WriteFile(filename, []byte(`{"txid":"`+ids[0]+`","hex":"00"}`), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := OpenTxCache(nil, 0, filename); err == nil {
+			t.Errorf("Expected an error for a malformed file")
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// UtxoStore is a local store of unspent outputs, kept up to date by applying
+// the transactions of each block in order and saved to a file between runs.
+// It is safe for concurrent use.
+type UtxoStore struct {
+	mu    sync.RWMutex
+	utxos map[string]*Output
+}
+
+// NewUtxoStore returns an empty store.
+func NewUtxoStore() *UtxoStore {
+	return &UtxoStore{utxos: make(map[string]*Output)}
+}
+
+// LoadUtxoStore reads a store saved with Save.
+func LoadUtxoStore(filename string) (*UtxoStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	result := NewUtxoStore()
+	for outpoint, rawHex := range v {
+		raw, err := hex.DecodeString(rawHex)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", outpoint, err)
+		}
+		output, err := parseRawOutput(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", outpoint, err)
+		}
+		result.utxos[outpoint] = output
+	}
+	return result, nil
+}
+
+func parseRawOutput(raw []byte) (result *Output, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed output: %v", r)
+		}
+	}()
+	result = ParseOutput(bytes.NewReader(raw))
+	if !bytes.Equal(result.Serialize(), raw) {
+		return nil, errors.New("malformed output")
+	}
+	return result, nil
+}
+
+// Save writes the store to a JSON file mapping outpoints to serialized outputs.
+func (s *UtxoStore) Save(filename string) error {
+	s.mu.RLock()
+	v := make(map[string]string, len(s.utxos))
+	for outpoint, output := range s.utxos {
+		v[outpoint] = hex.EncodeToString(output.Serialize())
+	}
+	s.mu.RUnlock()
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// Add adds an unspent output.
+func (s *UtxoStore) Add(prevTx []byte, prevIndex int, output *Output) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.utxos[outpointKey(prevTx, prevIndex)] = output
+}
+
+// Apply spends the outputs a transaction's inputs spend and adds its outputs.
+// Returns an error, leaving the store as it was, if an input spends an output that isn't in the store.
+func (s *UtxoStore) Apply(tx *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !tx.IsCoinbase() {
+		for _, txIn := range tx.Inputs {
+			if _, ok := s.utxos[outpointKey(txIn.PrevTx, txIn.PrevIndex)]; !ok {
+				return fmt.Errorf("%x:%d is not unspent", txIn.PrevTx, txIn.PrevIndex)
+			}
+		}
+		for _, txIn := range tx.Inputs {
+			delete(s.utxos, outpointKey(txIn.PrevTx, txIn.PrevIndex))
+		}
+	}
+	hash := tx.Hash()
+	for i, txOut := range tx.Outputs {
+		s.utxos[outpointKey(hash, i)] = txOut
+	}
+	return nil
+}
+
+// Len returns the number of unspent outputs.
+func (s *UtxoStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.utxos)
+}
+
+// Prevout returns an unspent output.
+func (s *UtxoStore) Prevout(prevTx []byte, prevIndex int, testnet bool) (*Output, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	output, ok := s.utxos[outpointKey(prevTx, prevIndex)]
+	if !ok {
+		return nil, fmt.Errorf("%x:%d is not unspent", prevTx, prevIndex)
+	}
+	return output, nil
+}
@@ -0,0 +1,152 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	bech32Charset    string = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const      uint32 = 1
+	bech32mConst     uint32 = 0x2bc830a3
+	mainnetSegwitHrp string = "bc"
+	testnetSegwitHrp string = "tb"
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range []byte(hrp) {
+		result = append(result, c>>5)
+	}
+	result = append(result, 0)
+	for _, c := range []byte(hrp) {
+		result = append(result, c&31)
+	}
+	return result
+}
+
+func bech32Encode(hrp string, data []byte, constant uint32) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ constant
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// bech32Decode returns the hrp, the data without the checksum and the checksum constant.
+func bech32Decode(s string) (string, []byte, uint32, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, errors.New("mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) || len(s) > 90 {
+		return "", nil, 0, fmt.Errorf("invalid bech32 string %s", s)
+	}
+	hrp := s[:pos]
+	data := make([]byte, len(s)-pos-1)
+	for i, c := range []byte(s[pos+1:]) {
+		index := strings.IndexByte(bech32Charset, c)
+		if index < 0 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		data[i] = byte(index)
+	}
+	constant := bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, errors.New("invalid bech32 checksum")
+	}
+	return hrp, data[:len(data)-6], constant, nil
+}
+
+// convertBits regroups a sequence of fromBits-bit values into toBits-bit values.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<toBits - 1
+	var result []byte
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
+
+func segwitHrp(testnet bool) string {
+	if testnet {
+		return testnetSegwitHrp
+	}
+	return mainnetSegwitHrp
+}
+
+// EncodeSegwitAddress returns the bech32 (version 0) or bech32m (version 1+) address of a witness program.
+func EncodeSegwitAddress(version int, program []byte, testnet bool) string {
+	data, _ := convertBits(program, 8, 5, true)
+	constant := bech32Const
+	if version > 0 {
+		constant = bech32mConst
+	}
+	return bech32Encode(segwitHrp(testnet), append([]byte{byte(version)}, data...), constant)
+}
+
+// DecodeSegwitAddress returns the witness version and program of a bech32 or bech32m address.
+func DecodeSegwitAddress(address string, testnet bool) (int, []byte, error) {
+	hrp, data, constant, err := bech32Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if hrp != segwitHrp(testnet) {
+		return 0, nil, fmt.Errorf("unexpected address prefix %s", hrp)
+	}
+	if len(data) == 0 || data[0] > 16 {
+		return 0, nil, errors.New("invalid witness version")
+	}
+	version := int(data[0])
+	if (version == 0) != (constant == bech32Const) {
+		return 0, nil, errors.New("wrong checksum for witness version")
+	}
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	return version, program, nil
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSegwitAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		testnet bool
+		version int
+		program string
+	}{
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", false, 0, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", true, 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", false, 1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, test := range tests {
+		version, program, err := DecodeSegwitAddress(test.address, test.testnet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if version != test.version || hex.EncodeToString(program) != test.program {
+			t.Errorf("Expected %d %s, got %d %x", test.version, test.program, version, program)
+		}
+		actual := EncodeSegwitAddress(version, program, test.testnet)
+		if actual != test.address {
+			t.Errorf("Expected %s, got %s", test.address, actual)
+		}
+	}
+	invalid := []string{
+		// bech32 checksum on a version 1 program
+		"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7k7grplx",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+	}
+	for _, address := range invalid {
+		if _, _, err := DecodeSegwitAddress(address, false); err == nil {
+			t.Errorf("Expected %s to be invalid", address)
+		}
+	}
+}
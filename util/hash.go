@@ -18,7 +18,26 @@ func Hash160(buf []byte) []byte {
 	return calcHash(calcHash(buf, sha256.New()), ripemd160.New())
 }
 
+// Ripemd160 is a single round of ripemd160
+func Ripemd160(buf []byte) []byte {
+	return calcHash(buf, ripemd160.New())
+}
+
 // Hash256 is two rounds of sha256
 func Hash256(buf []byte) []byte {
 	return calcHash(calcHash(buf, sha256.New()), sha256.New())
 }
+
+// Sha256 is a single round of sha256
+func Sha256(buf []byte) []byte {
+	return calcHash(buf, sha256.New())
+}
+
+// TaggedHash is the BIP340 hash of buf with a domain separation tag.
+func TaggedHash(tag string, buf []byte) []byte {
+	tagHash := Sha256([]byte(tag))
+	hasher := sha256.New()
+	hasher.Write(tagHash)
+	hasher.Write(tagHash)
+	return calcHash(buf, hasher)
+}
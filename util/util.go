@@ -10,12 +10,14 @@ import (
 
 // Useful constants
 const (
-	SigHashAll     uint32 = 1
-	SigHashNone    uint32 = 2
-	SigHashSingle  uint32 = 3
-	base58Alphabet string = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
-	twoWeeks       int    = 60 * 60 * 24 * 14
-	maxTarget      string = `ffff0000000000000000000000000000000000000000000000000000`
+	SigHashDefault      uint32 = 0
+	SigHashAll          uint32 = 1
+	SigHashNone         uint32 = 2
+	SigHashSingle       uint32 = 3
+	SigHashAnyoneCanPay uint32 = 0x80
+	base58Alphabet      string = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	twoWeeks            int    = 60 * 60 * 24 * 14
+	maxTarget           string = `ffff0000000000000000000000000000000000000000000000000000`
 )
 
 // HexStringToBytes converts a hex string to a byte array.
@@ -84,22 +86,45 @@ func EncodeBase58Checksum(b []byte) string {
 }
 
 // DecodeBase58 decodes a base58 string and verifies the checksum.
+// The leading version byte is dropped.
 func DecodeBase58(encoded string) []byte {
+	payload, err := DecodeBase58Checksum(encoded)
+	if err != nil {
+		panic(err)
+	}
+	return payload[1:]
+}
+
+// DecodeBase58Checksum decodes a base58 string and verifies the checksum.
+// Returns the payload without the checksum.
+func DecodeBase58Checksum(encoded string) ([]byte, error) {
 	num := big.NewInt(0)
 	b58 := big.NewInt(58)
 	alphabet := []byte(base58Alphabet)
 	chars := []byte(encoded)
+	// every leading 1 is a leading zero byte
+	zeros := 0
+	for zeros < len(chars) && chars[zeros] == alphabet[0] {
+		zeros++
+	}
 	for _, c := range chars {
+		index := bytes.IndexByte(alphabet, c)
+		if index < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
 		num.Mul(num, b58)
-		num.Add(num, big.NewInt(int64(bytes.IndexByte(alphabet, c))))
+		num.Add(num, big.NewInt(int64(index)))
 	}
-	combined := num.Bytes()
+	combined := append(make([]byte, zeros), num.Bytes()...)
 	length := len(combined)
+	if length < 5 {
+		return nil, fmt.Errorf("base58 string %s is too short", encoded)
+	}
 	checksum := combined[length-4:]
 	if !bytes.Equal(Hash256(combined[:length-4])[:4], checksum) {
-		panic(fmt.Sprintf("Bad address: %x %x", checksum, Hash256(combined[:length-4])[:4]))
+		return nil, fmt.Errorf("Bad address: %x %x", checksum, Hash256(combined[:length-4])[:4])
 	}
-	return combined[1 : length-4]
+	return combined[:length-4], nil
 }
 
 // IntToBytes returns a byte array of a given size from a big.Int.
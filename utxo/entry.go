@@ -0,0 +1,155 @@
+// Package utxo tracks the unspent outputs of a chain as blocks connect and disconnect,
+// with a cache of the outputs in use over a store holding the rest.
+package utxo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// CoinbaseMaturity is the number of blocks before coinbase outputs can be spent.
+const CoinbaseMaturity = 100
+
+// outpointSize is the size of a serialized outpoint.
+const outpointSize = 36
+
+// Outpoint identifies an output: the hash of its transaction, as tx.Hash returns it, and its index.
+type Outpoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+// NewOutpoint returns the outpoint an input spends.
+func NewOutpoint(prevTx []byte, prevIndex int) Outpoint {
+	result := Outpoint{Index: uint32(prevIndex)}
+	copy(result.Hash[:], prevTx)
+	return result
+}
+
+func (op Outpoint) String() string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(op.Hash[:]), op.Index)
+}
+
+func (op Outpoint) serialize() []byte {
+	return append(append([]byte{}, op.Hash[:]...), util.Int32ToLittleEndian(op.Index)...)
+}
+
+func parseOutpoint(raw []byte) Outpoint {
+	var result Outpoint
+	copy(result.Hash[:], raw[:32])
+	result.Index = util.LittleEndianToInt32(raw[32:36])
+	return result
+}
+
+// Entry is an unspent output, with the height of the block that created it.
+type Entry struct {
+	Output   *tx.Output
+	Height   int
+	Coinbase bool
+}
+
+// Mature returns whether the output can be spent in a block at height:
+// coinbase outputs have to wait CoinbaseMaturity blocks.
+func (e *Entry) Mature(height int) bool {
+	return !e.Coinbase || height-e.Height >= CoinbaseMaturity
+}
+
+// Serialize returns the height, the coinbase flag and the output.
+func (e *Entry) Serialize() []byte {
+	result := util.Int32ToLittleEndian(uint32(e.Height))
+	if e.Coinbase {
+		result = append(result, 1)
+	} else {
+		result = append(result, 0)
+	}
+	return append(result, e.Output.Serialize()...)
+}
+
+// ParseEntry parses a serialized entry.
+func ParseEntry(raw []byte) (result *Entry, err error) {
+	defer func() {
+		// the output parser panics on data that runs out
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed entry: %v", r)
+		}
+	}()
+	if len(raw) < 5 || raw[4] > 1 {
+		return nil, errors.New("malformed entry")
+	}
+	s := bytes.NewReader(raw[5:])
+	output := tx.ParseOutput(s)
+	if s.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the entry", s.Len())
+	}
+	return &Entry{Output: output, Height: int(util.LittleEndianToInt32(raw[:4])), Coinbase: raw[4] == 1}, nil
+}
+
+// isUnspendable returns whether no script can spend an output, which then isn't added to the set.
+func isUnspendable(scriptPubKey *script.Script) bool {
+	raw := scriptPubKey.RawSerialize()
+	return (len(raw) > 0 && raw[0] == script.OpReturn) || len(raw) > script.MaxScriptSize
+}
+
+// BlockUndo holds the outputs a block spent, to restore them when it disconnects:
+// for each transaction after the coinbase, the entries its inputs spent.
+type BlockUndo struct {
+	Spent [][]*Entry
+}
+
+// Serialize returns the number of transactions and, for each, the number of entries and the entries.
+func (u *BlockUndo) Serialize() []byte {
+	result := util.EncodeVarInt(len(u.Spent))
+	for _, entries := range u.Spent {
+		result = append(result, util.EncodeVarInt(len(entries))...)
+		for _, entry := range entries {
+			raw := entry.Serialize()
+			result = append(result, util.EncodeVarInt(len(raw))...)
+			result = append(result, raw...)
+		}
+	}
+	return result
+}
+
+// ParseBlockUndo parses serialized undo data.
+func ParseBlockUndo(raw []byte) (result *BlockUndo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed undo data: %v", r)
+		}
+	}()
+	s := bytes.NewReader(raw)
+	numTxs := util.ReadVarInt(s)
+	if numTxs > s.Len() {
+		return nil, errors.New("malformed undo data")
+	}
+	result = &BlockUndo{Spent: make([][]*Entry, numTxs)}
+	for i := range result.Spent {
+		numEntries := util.ReadVarInt(s)
+		if numEntries > s.Len() {
+			return nil, errors.New("malformed undo data")
+		}
+		entries := make([]*Entry, numEntries)
+		for j := range entries {
+			size := util.ReadVarInt(s)
+			if size > s.Len() {
+				return nil, errors.New("malformed undo data")
+			}
+			buffer := make([]byte, size)
+			s.Read(buffer)
+			if entries[j], err = ParseEntry(buffer); err != nil {
+				return nil, err
+			}
+		}
+		result.Spent[i] = entries
+	}
+	if s.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the undo data", s.Len())
+	}
+	return result, nil
+}
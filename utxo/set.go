@@ -0,0 +1,284 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// DefaultCacheSize is the number of entries the cache holds before it flushes to the store.
+const DefaultCacheSize = 100000
+
+// bip30Exceptions are the heights and hashes of the two mainnet blocks whose coinbases overwrote
+// the unspent outputs of earlier coinbases, at heights 91812 and 91722, before BIP30 forbade it.
+var bip30Exceptions = map[int]string{
+	91842: "00000000000a4d0a398161ffc163c503763b1f4360639393e0e4c8e300e0caec",
+	91880: "00000000000743f190a18c5577a3c2d2a1f610ae9601ac046a38084ccb7cd721",
+}
+
+// cacheEntry is an entry in the cache: an unspent output, or nil if it's spent or not in the store.
+type cacheEntry struct {
+	entry *Entry
+	// dirty entries differ from the store.
+	dirty bool
+	// fresh entries aren't in the store, so spending them doesn't need a delete there.
+	fresh bool
+}
+
+// Set is the set of unspent outputs up to the tip of a chain,
+// held in a cache over a store until Flush writes the changes.
+// It implements tx.PrevoutProvider and is safe for concurrent use.
+type Set struct {
+	mu     sync.Mutex
+	store  Store
+	cache  map[Outpoint]*cacheEntry
+	tip    []byte
+	height int
+	// CacheSize is the number of entries the cache holds before connecting or disconnecting a block
+	// flushes it.
+	CacheSize int
+}
+
+// NewSet returns the set of a store, up to the store's tip.
+func NewSet(store Store) (*Set, error) {
+	tip, height, err := store.Tip()
+	if err != nil {
+		return nil, err
+	}
+	return &Set{
+		store:     store,
+		cache:     make(map[Outpoint]*cacheEntry),
+		tip:       tip,
+		height:    height,
+		CacheSize: DefaultCacheSize,
+	}, nil
+}
+
+// Tip returns the hash and height of the last block connected, nil and -1 if there isn't one.
+func (s *Set) Tip() ([]byte, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tip, s.height
+}
+
+// get returns an entry from the cache, or from the store and caches it.
+// Outputs that aren't in the store are cached as fresh misses.
+func (s *Set) get(op Outpoint) (*cacheEntry, error) {
+	if ce, ok := s.cache[op]; ok {
+		return ce, nil
+	}
+	entry, err := s.store.Get(op)
+	if err != nil {
+		return nil, err
+	}
+	ce := &cacheEntry{entry: entry, fresh: entry == nil}
+	s.cache[op] = ce
+	return ce, nil
+}
+
+// Get returns an unspent output, nil if it isn't in the set.
+func (s *Set) Get(op Outpoint) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ce, err := s.get(op)
+	if err != nil {
+		return nil, err
+	}
+	return ce.entry, nil
+}
+
+// Prevout implements tx.PrevoutProvider with the unspent outputs.
+func (s *Set) Prevout(prevTx []byte, prevIndex int, testnet bool) (*tx.Output, error) {
+	op := NewOutpoint(prevTx, prevIndex)
+	entry, err := s.Get(op)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%s is not unspent", op)
+	}
+	return entry.Output, nil
+}
+
+// view holds the changes of a block until it has connected or disconnected,
+// so a block that fails leaves the set as it was: the entries added, and nil for the ones spent.
+type view struct {
+	set     *Set
+	changes map[Outpoint]*Entry
+}
+
+func (v *view) get(op Outpoint) (*Entry, error) {
+	if entry, ok := v.changes[op]; ok {
+		return entry, nil
+	}
+	ce, err := v.set.get(op)
+	if err != nil {
+		return nil, err
+	}
+	return ce.entry, nil
+}
+
+// apply moves the changes of a view to the cache.
+func (s *Set) apply(changes map[Outpoint]*Entry) {
+	for op, entry := range changes {
+		// get cached every outpoint the view changed
+		ce := s.cache[op]
+		if entry == nil && ce.fresh {
+			delete(s.cache, op)
+			continue
+		}
+		ce.entry = entry
+		ce.dirty = true
+	}
+}
+
+// ConnectBlock spends the outputs the transactions of the block after the tip spend and adds
+// their outputs, except the unspendable ones. Returns the outputs spent, to disconnect the block,
+// or an error, leaving the set as it was, if the block doesn't extend the tip, an input spends
+// an output that isn't unspent, or a coinbase output that hasn't matured.
+// The block's scripts and amounts are up to the validation package, with the set as the provider.
+// If flushing a full cache fails, the block stays connected and the undo data is returned with the error.
+func (s *Set) ConnectBlock(b *block.Block) (*BlockUndo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tip != nil && !bytes.Equal(b.PrevBlock[:], s.tip) {
+		return nil, fmt.Errorf("block %x doesn't extend the tip %x", b.Hash(), s.tip)
+	}
+	height := s.height + 1
+	blockHash := b.Hash()
+	exception := bip30Exceptions[height] == hex.EncodeToString(blockHash)
+	v := &view{set: s, changes: make(map[Outpoint]*Entry)}
+	undo := &BlockUndo{}
+	for i, t := range b.Transactions {
+		coinbase := t.IsCoinbase()
+		if !coinbase {
+			spent := make([]*Entry, len(t.Inputs))
+			for j, txIn := range t.Inputs {
+				op := NewOutpoint(txIn.PrevTx, txIn.PrevIndex)
+				entry, err := v.get(op)
+				if err != nil {
+					return nil, err
+				}
+				if entry == nil {
+					return nil, fmt.Errorf("tx %d input %d spends %s, which is not unspent", i, j, op)
+				}
+				if !entry.Mature(height) {
+					return nil, fmt.Errorf("tx %d input %d spends coinbase output %s from height %d before it matures", i, j, op, entry.Height)
+				}
+				v.changes[op] = nil
+				spent[j] = entry
+			}
+			undo.Spent = append(undo.Spent, spent)
+		}
+		hash := t.Hash()
+		for j, txOut := range t.Outputs {
+			if isUnspendable(txOut.ScriptPubKey) {
+				continue
+			}
+			op := NewOutpoint(hash, j)
+			existing, err := v.get(op)
+			if err != nil {
+				return nil, err
+			}
+			// an unspent output can't be overwritten (BIP30), except by the two coinbases
+			// that duplicated earlier ones at heights 91842 and 91880
+			if existing != nil && !(coinbase && exception) {
+				return nil, fmt.Errorf("tx %d output %d overwrites unspent %s", i, j, op)
+			}
+			v.changes[op] = &Entry{Output: txOut, Height: height, Coinbase: coinbase}
+		}
+	}
+	s.apply(v.changes)
+	s.tip, s.height = blockHash, height
+	return undo, s.flushIfFull()
+}
+
+// DisconnectBlock undoes ConnectBlock for the block at the tip, with the outputs it spent.
+// Returns an error, leaving the set as it was, if the block isn't the tip
+// or the undo data doesn't match it.
+func (s *Set) DisconnectBlock(b *block.Block, undo *BlockUndo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := b.Hash()
+	if !bytes.Equal(hash, s.tip) {
+		return fmt.Errorf("block %x isn't the tip %x", hash, s.tip)
+	}
+	v := &view{set: s, changes: make(map[Outpoint]*Entry)}
+	spent := len(undo.Spent)
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		t := b.Transactions[i]
+		txHash := t.Hash()
+		for j, txOut := range t.Outputs {
+			if isUnspendable(txOut.ScriptPubKey) {
+				continue
+			}
+			op := NewOutpoint(txHash, j)
+			entry, err := v.get(op)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				return fmt.Errorf("tx %d output %d %s is not unspent", i, j, op)
+			}
+			v.changes[op] = nil
+		}
+		if t.IsCoinbase() {
+			continue
+		}
+		if spent--; spent < 0 || len(undo.Spent[spent]) != len(t.Inputs) {
+			return fmt.Errorf("undo data doesn't match tx %d", i)
+		}
+		for j, txIn := range t.Inputs {
+			op := NewOutpoint(txIn.PrevTx, txIn.PrevIndex)
+			entry, err := v.get(op)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				return fmt.Errorf("tx %d input %d restores %s, which is unspent", i, j, op)
+			}
+			v.changes[op] = undo.Spent[spent][j]
+		}
+	}
+	if spent != 0 {
+		return fmt.Errorf("undo data has %d transactions too many", spent)
+	}
+	s.apply(v.changes)
+	s.tip, s.height = append([]byte{}, b.PrevBlock[:]...), s.height-1
+	if s.height < 0 {
+		s.tip = nil
+	}
+	return s.flushIfFull()
+}
+
+func (s *Set) flushIfFull() error {
+	if len(s.cache) <= s.CacheSize {
+		return nil
+	}
+	return s.flush()
+}
+
+// Flush writes the changes in the cache to the store and empties the cache.
+func (s *Set) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *Set) flush() error {
+	batch := make(map[Outpoint]*Entry)
+	for op, ce := range s.cache {
+		if ce.dirty {
+			batch[op] = ce.entry
+		}
+	}
+	if err := s.store.Write(batch, s.tip, s.height); err != nil {
+		return err
+	}
+	s.cache = make(map[Outpoint]*cacheEntry)
+	return nil
+}
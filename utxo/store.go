@@ -0,0 +1,337 @@
+package utxo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Store holds the entries the cache doesn't, and the block they are up to.
+type Store interface {
+	// Get returns an entry, nil if there isn't one.
+	Get(op Outpoint) (*Entry, error)
+	// Tip returns the hash and height of the last block connected, nil and -1 if there isn't one.
+	Tip() ([]byte, int, error)
+	// Write adds the entries of a batch, deletes the ones that are nil and sets the tip, all at once.
+	Write(batch map[Outpoint]*Entry, tip []byte, height int) error
+}
+
+// MemoryStore is a Store that doesn't persist. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[Outpoint]*Entry
+	tip     []byte
+	height  int
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[Outpoint]*Entry), height: -1}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(op Outpoint) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[op], nil
+}
+
+// Tip implements Store.
+func (s *MemoryStore) Tip() ([]byte, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip, s.height, nil
+}
+
+// Write implements Store.
+func (s *MemoryStore) Write(batch map[Outpoint]*Entry, tip []byte, height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for op, entry := range batch {
+		if entry == nil {
+			delete(s.entries, op)
+		} else {
+			s.entries[op] = entry
+		}
+	}
+	s.tip, s.height = tip, height
+	return nil
+}
+
+// Len returns the number of entries.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Kinds of FileStore records.
+const (
+	recordPut    = 1
+	recordDelete = 2
+	recordTip    = 3
+)
+
+// maxRecordSize is the size of the largest record, a put of an entry whose script is as large
+// as spendable scripts get.
+const maxRecordSize = 1 + outpointSize + 5 + 8 + 3 + script.MaxScriptSize
+
+// FileStore is a Store in a file, a log of records that each write appends to.
+// Only the offsets of the entries are kept in memory.
+// Each record is its length, its kind and its data: an outpoint and an entry for a put,
+// an outpoint for a delete, the hash and height for a tip. A tip ends each write,
+// so records after the last tip, from a write that didn't finish, are dropped on opening.
+// It is safe for concurrent use.
+type FileStore struct {
+	mu       sync.RWMutex
+	filename string
+	file     *os.File
+	size     int64
+	// offsets holds the offset of the put record of each entry.
+	offsets map[Outpoint]int64
+	tip     []byte
+	height  int
+}
+
+// OpenFileStore opens a store, creating the file if it doesn't exist.
+func OpenFileStore(filename string) (*FileStore, error) {
+	s := &FileStore{filename: filename}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) open() error {
+	file, err := os.OpenFile(s.filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.offsets = make(map[Outpoint]int64)
+	s.tip, s.height = nil, -1
+	if err := s.load(); err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+// load reads the records and drops the ones after the last tip.
+func (s *FileStore) load() error {
+	r := bufio.NewReader(s.file)
+	var offset, committed int64
+	pending := make(map[Outpoint]int64)
+	deleted := make(map[Outpoint]bool)
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			// the end of the file, or a record cut short
+			break
+		}
+		switch record[0] {
+		case recordPut:
+			op := parseOutpoint(record[1:])
+			pending[op] = offset
+			delete(deleted, op)
+		case recordDelete:
+			op := parseOutpoint(record[1:])
+			deleted[op] = true
+			delete(pending, op)
+		case recordTip:
+			for op, offset := range pending {
+				s.offsets[op] = offset
+			}
+			for op := range deleted {
+				delete(s.offsets, op)
+			}
+			pending = make(map[Outpoint]int64)
+			deleted = make(map[Outpoint]bool)
+			s.tip, s.height = nil, int(int32(util.LittleEndianToInt32(record[33:37])))
+			if s.height >= 0 {
+				s.tip = append([]byte{}, record[1:33]...)
+			}
+			committed = offset + int64(4+len(record))
+		default:
+			return fmt.Errorf("%s: unknown record kind %d at %d", s.filename, record[0], offset)
+		}
+		offset += int64(4 + len(record))
+	}
+	if err := s.file.Truncate(committed); err != nil {
+		return err
+	}
+	s.size = committed
+	return nil
+}
+
+// readRecord reads the kind and data of a record.
+func readRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := util.LittleEndianToInt32(header)
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes is over %d", size, maxRecordSize)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, err
+	}
+	// every kind starts with 36 bytes, an outpoint or a hash and a height
+	if len(record) < 1+outpointSize || (record[0] == recordTip && len(record) != 1+outpointSize) {
+		return nil, errors.New("malformed record")
+	}
+	return record, nil
+}
+
+func appendRecord(buf []byte, kind byte, data ...[]byte) []byte {
+	size := 1
+	for _, d := range data {
+		size += len(d)
+	}
+	buf = append(buf, util.Int32ToLittleEndian(uint32(size))...)
+	buf = append(buf, kind)
+	for _, d := range data {
+		buf = append(buf, d...)
+	}
+	return buf
+}
+
+// Get implements Store.
+func (s *FileStore) Get(op Outpoint) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offset, ok := s.offsets[op]
+	if !ok {
+		return nil, nil
+	}
+	record, err := readRecord(io.NewSectionReader(s.file, offset, s.size-offset))
+	if err != nil {
+		return nil, err
+	}
+	if record[0] != recordPut || parseOutpoint(record[1:]) != op {
+		return nil, fmt.Errorf("%s: no entry for %s at %d", s.filename, op, offset)
+	}
+	return ParseEntry(record[1+outpointSize:])
+}
+
+// Tip implements Store.
+func (s *FileStore) Tip() ([]byte, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip, s.height, nil
+}
+
+// Write implements Store, appending the records and syncing the file.
+func (s *FileStore) Write(batch map[Outpoint]*Entry, tip []byte, height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf []byte
+	offsets := make(map[Outpoint]int64)
+	for op, entry := range batch {
+		if entry == nil {
+			buf = appendRecord(buf, recordDelete, op.serialize())
+		} else {
+			raw := entry.Serialize()
+			if 1+outpointSize+len(raw) > maxRecordSize {
+				return fmt.Errorf("%s: entry of %d bytes is too large to store", op, len(raw))
+			}
+			offsets[op] = s.size + int64(len(buf))
+			buf = appendRecord(buf, recordPut, op.serialize(), raw)
+		}
+	}
+	tipHash := make([]byte, 32)
+	copy(tipHash, tip)
+	buf = appendRecord(buf, recordTip, tipHash, util.Int32ToLittleEndian(uint32(height)))
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(buf))
+	for op, entry := range batch {
+		if entry == nil {
+			delete(s.offsets, op)
+		} else {
+			s.offsets[op] = offsets[op]
+		}
+	}
+	if tip == nil {
+		s.tip = nil
+	} else {
+		s.tip = tipHash
+	}
+	s.height = height
+	return nil
+}
+
+// Len returns the number of entries.
+func (s *FileStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.offsets)
+}
+
+// Compact rewrites the file with only the entries in the store,
+// dropping the records of spent and overwritten ones.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := s.filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for op, offset := range s.offsets {
+		record, err := readRecord(io.NewSectionReader(s.file, offset, s.size-offset))
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := w.Write(appendRecord(nil, record[0], record[1:])); err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %v", op, err)
+		}
+	}
+	tipHash := make([]byte, 32)
+	copy(tipHash, s.tip)
+	w.Write(appendRecord(nil, recordTip, tipHash, util.Int32ToLittleEndian(uint32(s.height))))
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, s.filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the old file stays open, and the store as it was, until the new one has loaded
+	compacted := &FileStore{filename: s.filename}
+	if err := compacted.open(); err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file, s.size, s.offsets = compacted.file, compacted.size, compacted.offsets
+	s.tip, s.height = compacted.tip, compacted.height
+	return nil
+}
+
+// Close closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,269 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func TestEntry(t *testing.T) {
+	entry := &Entry{Output: tx.NewOutput(5000, script.P2pkhScript(make([]byte, 20))), Height: 700000, Coinbase: true}
+	parsed, err := ParseEntry(entry.Serialize())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.Height != 700000 || !parsed.Coinbase || !bytes.Equal(parsed.Output.Serialize(), entry.Output.Serialize()) {
+		t.Errorf("Expected %+v, got %+v", entry, parsed)
+	}
+	if entry.Mature(700099) || !entry.Mature(700100) {
+		t.Errorf("Expected coinbase outputs to mature after %d blocks", CoinbaseMaturity)
+	}
+	raw := entry.Serialize()
+	if _, err := ParseEntry(raw[:len(raw)-1]); err == nil {
+		t.Errorf("Expected an error for a truncated entry")
+	}
+	undo := &BlockUndo{Spent: [][]*Entry{{entry}, {entry, {Output: entry.Output, Height: 1}}}}
+	parsedUndo, err := ParseBlockUndo(undo.Serialize())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(parsedUndo.Serialize(), undo.Serialize()) {
+		t.Errorf("Expected %x, got %x", undo.Serialize(), parsedUndo.Serialize())
+	}
+	if _, err := ParseBlockUndo(append(undo.Serialize(), 0)); err == nil {
+		t.Errorf("Expected an error for bytes after the undo data")
+	}
+}
+
+// testBlocks builds blocks on top of each other, each with a coinbase paying payout
+// and the transactions passed for its height.
+type testBlocks struct {
+	payout *script.Script
+	blocks []*block.Block
+}
+
+func (c *testBlocks) next(txs ...*tx.Transaction) *block.Block {
+	height := len(c.blocks)
+	prevBlock := make([]byte, 32)
+	if height > 0 {
+		prevBlock = c.blocks[height-1].Hash()
+	}
+	coinbase, _ := tx.NewCoinbase(height, c.payout).Build()
+	b := block.NewBlock(0x20000000, prevBlock, nil, uint32(1600000000+height*600), util.HexStringToBytes(`ffff7f20`), nil, nil)
+	b.SetTransactions(append([]*tx.Transaction{coinbase}, txs...))
+	c.blocks = append(c.blocks, b)
+	return b
+}
+
+func testSet(t *testing.T, newStore func() Store) {
+	pk := ecc.NewPrivateKey(big.NewInt(8001))
+	p2wpkh := script.P2wpkhScript(pk.Point.Hash160(true))
+	chain := &testBlocks{payout: p2wpkh}
+	store := newStore()
+	set, err := NewSet(store)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	set.CacheSize = 10
+	undos := make([]*BlockUndo, 0)
+	connect := func(b *block.Block) {
+		undo, err := set.ConnectBlock(b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		undos = append(undos, undo)
+	}
+	connect(chain.next())
+	firstCoinbase := chain.blocks[0].Transactions[0]
+	// spend returns a transaction spending the first output of prevTx, with an OP_RETURN output as well
+	spend := func(prevTx *tx.Transaction) *tx.Transaction {
+		txIn := tx.NewInput(prevTx.Hash(), 0, nil, tx.SequenceFinal)
+		txOuts := []*tx.Output{
+			tx.NewOutput(prevTx.Outputs[0].Amount-1000, p2wpkh),
+			tx.NewOutput(0, new(script.Script).AppendOp(script.OpReturn)),
+		}
+		result := tx.NewTransaction(2, []*tx.Input{txIn}, txOuts, 0, false)
+		if ok, err := result.SignInput(set, 0, pk, util.SigHashAll); !ok {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return result
+	}
+
+	t.Run("Test coinbase maturity", func(t *testing.T) {
+		immature := chain.next(spend(firstCoinbase))
+		if _, err := set.ConnectBlock(immature); err == nil {
+			t.Fatalf("Expected an error spending an immature coinbase output")
+		}
+		chain.blocks = chain.blocks[:1]
+		for height := 1; height < CoinbaseMaturity; height++ {
+			connect(chain.next())
+		}
+		connect(chain.next(spend(firstCoinbase)))
+		if hash, height := set.Tip(); height != CoinbaseMaturity || !bytes.Equal(hash, chain.blocks[CoinbaseMaturity].Hash()) {
+			t.Errorf("Expected the tip at %d, got %x %d", CoinbaseMaturity, hash, height)
+		}
+	})
+
+	spendTx := chain.blocks[CoinbaseMaturity].Transactions[1]
+
+	t.Run("Test spent outputs", func(t *testing.T) {
+		if entry, err := set.Get(NewOutpoint(firstCoinbase.Hash(), 0)); entry != nil || err != nil {
+			t.Errorf("Expected the coinbase output to be spent, got %+v %v", entry, err)
+		}
+		entry, err := set.Get(NewOutpoint(spendTx.Hash(), 0))
+		if err != nil || entry == nil || entry.Height != CoinbaseMaturity || entry.Coinbase {
+			t.Fatalf("Expected an unspent output at height %d, got %+v %v", CoinbaseMaturity, entry, err)
+		}
+		if entry, _ := set.Get(NewOutpoint(spendTx.Hash(), 1)); entry != nil {
+			t.Errorf("Expected the OP_RETURN output not to be added")
+		}
+		if _, err := set.Prevout(spendTx.Hash(), 0, false); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		// a block spending an output twice changes nothing
+		double := chain.next(spend(spendTx), spend(spendTx))
+		double.Transactions[2].Outputs[0].Amount--
+		if _, err := set.ConnectBlock(double); err == nil {
+			t.Errorf("Expected an error for a double spend")
+		}
+		if entry, _ := set.Get(NewOutpoint(spendTx.Hash(), 0)); entry == nil {
+			t.Errorf("Expected the output to stay unspent")
+		}
+		chain.blocks = chain.blocks[:len(chain.blocks)-1]
+		// a coinbase can't overwrite an unspent output (BIP30), outside the two blocks exempted
+		duplicate := chain.next()
+		duplicate.SetTransactions([]*tx.Transaction{chain.blocks[1].Transactions[0]})
+		if _, err := set.ConnectBlock(duplicate); err == nil {
+			t.Errorf("Expected an error for a coinbase overwriting an unspent output")
+		}
+		bip30Exceptions[CoinbaseMaturity+1] = hex.EncodeToString(duplicate.Hash())
+		undo, err := set.ConnectBlock(duplicate)
+		delete(bip30Exceptions, CoinbaseMaturity+1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := set.DisconnectBlock(duplicate, undo); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		chain.blocks = chain.blocks[:len(chain.blocks)-1]
+		// a block has to extend the tip
+		if _, err := set.ConnectBlock(chain.blocks[1]); err == nil {
+			t.Errorf("Expected an error for a block that doesn't extend the tip")
+		}
+	})
+
+	t.Run("Test disconnect", func(t *testing.T) {
+		child := spend(spendTx)
+		b := chain.next(child)
+		connect(b)
+		if err := set.Flush(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := set.DisconnectBlock(chain.blocks[1], undos[1]); err == nil {
+			t.Errorf("Expected an error disconnecting a block that isn't the tip")
+		}
+		if err := set.DisconnectBlock(b, &BlockUndo{}); err == nil {
+			t.Errorf("Expected an error for undo data that doesn't match")
+		}
+		if err := set.DisconnectBlock(b, undos[len(undos)-1]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if entry, _ := set.Get(NewOutpoint(child.Hash(), 0)); entry != nil {
+			t.Errorf("Expected the block's outputs to be removed")
+		}
+		if entry, _ := set.Get(NewOutpoint(spendTx.Hash(), 0)); entry == nil || entry.Height != CoinbaseMaturity {
+			t.Errorf("Expected the spent output to be restored, got %+v", entry)
+		}
+		if _, height := set.Tip(); height != CoinbaseMaturity {
+			t.Errorf("Expected %d, got %d", CoinbaseMaturity, height)
+		}
+		if err := set.Flush(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		hash, height, _ := store.Tip()
+		if height != CoinbaseMaturity || !bytes.Equal(hash, chain.blocks[CoinbaseMaturity].Hash()) {
+			t.Errorf("Expected the store's tip at %d, got %x %d", CoinbaseMaturity, hash, height)
+		}
+	})
+}
+
+func TestSet(t *testing.T) {
+	t.Run("Test memory store", func(t *testing.T) {
+		testSet(t, func() Store { return NewMemoryStore() })
+	})
+
+	t.Run("Test file store", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "utxo")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "utxos")
+		var store *FileStore
+		testSet(t, func() Store {
+			store, err = OpenFileStore(filename)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return store
+		})
+		size := store.Len()
+		hash, height, _ := store.Tip()
+		if err := store.Compact(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		store.Close()
+		// a write cut short is dropped on opening
+		file, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		file.Write(appendRecord(nil, recordDelete, make([]byte, outpointSize)))
+		file.Write([]byte{1, 2})
+		file.Close()
+		// as is a record whose length is corrupt
+		file, _ = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		file.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		file.Close()
+		reopened, err := OpenFileStore(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer reopened.Close()
+		reopenedHash, reopenedHeight, _ := reopened.Tip()
+		if reopened.Len() != size || reopenedHeight != height || !bytes.Equal(reopenedHash, hash) {
+			t.Errorf("Expected %d entries up to %x, got %d up to %x", size, hash, reopened.Len(), reopenedHash)
+		}
+		set, err := NewSet(reopened)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, h := set.Tip(); h != height {
+			t.Errorf("Expected %d, got %d", height, h)
+		}
+		for op := range reopened.offsets {
+			if entry, err := set.Get(op); entry == nil || err != nil {
+				t.Errorf("Expected an entry for %s, got %v", op, err)
+			}
+		}
+		// a compaction that can't replace the file leaves the store as it was
+		os.Remove(filename)
+		os.Mkdir(filename, 0755)
+		ioutil.WriteFile(filepath.Join(filename, "blocker"), nil, 0644)
+		if err := reopened.Compact(); err == nil {
+			t.Errorf("Expected an error replacing the file")
+		}
+		for op := range reopened.offsets {
+			if entry, err := reopened.Get(op); entry == nil || err != nil {
+				t.Errorf("Expected an entry for %s, got %v", op, err)
+			}
+		}
+	})
+}
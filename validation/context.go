@@ -0,0 +1,158 @@
+package validation
+
+import (
+	"bytes"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/retarget"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// ancestor returns the header at a height below the block's, or an error if the chain doesn't have it.
+func (ctx *Context) ancestor(height int) (*block.Block, error) {
+	return block.Ancestor(ctx.Chain, height)
+}
+
+// MedianTimePast returns the median timestamp of the block.MedianTimeSpan blocks before the block,
+// which its timestamp has to be above (BIP113 uses it for lock times as well).
+func (ctx *Context) MedianTimePast() (uint32, error) {
+	return block.MedianTimePast(ctx.Chain, ctx.Height)
+}
+
+// NextBits returns the bits a block with timestamp needs, by the retarget rules of the network.
+func (ctx *Context) NextBits(timestamp uint32) ([]byte, error) {
+	return retarget.NextBits(ctx.Chain, ctx.Height, timestamp, ctx.Params.Retarget)
+}
+
+// CheckHeader checks a header against the chain, like ContextualCheckBlockHeader in Bitcoin Core:
+// the parent, the bits and the timestamps. The first block has nothing to check against.
+func CheckHeader(b *block.Block, ctx *Context) error {
+	if ctx.Height == 0 {
+		return nil
+	}
+	parent, err := ctx.ancestor(ctx.Height - 1)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b.PrevBlock[:], parent.Hash()) {
+		return reject(RejectBadPrevBlock, -1, "%x isn't the parent", b.PrevBlock)
+	}
+	bits, err := ctx.NextBits(b.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b.Bits[:], bits) {
+		return reject(RejectBadDiffBits, -1, "bits %x, expected %x", b.Bits, bits)
+	}
+	ok, err := retarget.CheckTimeWarp(ctx.Chain, ctx.Height, b.Timestamp, ctx.Params.Retarget)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return reject(RejectTimeWarp, -1, "%d is more than %d seconds before the parent", b.Timestamp, retarget.MaxTimeWarp)
+	}
+	mtp, err := ctx.MedianTimePast()
+	if err != nil {
+		return err
+	}
+	if b.Timestamp <= mtp {
+		return reject(RejectTimeTooOld, -1, "%d isn't after the median time past %d", b.Timestamp, mtp)
+	}
+	if !ctx.Now.IsZero() && int64(b.Timestamp) > ctx.Now.Add(MaxFutureBlockTime).Unix() {
+		return reject(RejectTimeTooNew, -1, "%d is too far in the future", b.Timestamp)
+	}
+	return nil
+}
+
+// CheckContext checks the transactions against the height of the block,
+// like ContextualCheckBlock in Bitcoin Core: lock times, the BIP34 height in the coinbase,
+// the witness commitment and the weight. The block has to have passed CheckBlock.
+func CheckContext(b *block.Block, ctx *Context) error {
+	// lock times count from the median time past once BIP113 is active
+	lockTimeCutoff := b.Timestamp
+	if ctx.Height >= ctx.Params.CSVHeight {
+		mtp, err := ctx.MedianTimePast()
+		if err != nil {
+			return err
+		}
+		lockTimeCutoff = mtp
+	}
+	for i, t := range b.Transactions {
+		if !isFinal(t, ctx.Height, lockTimeCutoff) {
+			return reject(RejectNonFinal, i, "lock time %d", t.Locktime)
+		}
+	}
+	coinbase := b.Transactions[0]
+	if ctx.Height >= ctx.Params.BIP34Height {
+		if err := checkCoinbaseHeight(coinbase, ctx.Height); err != nil {
+			return err
+		}
+	}
+	if err := checkWitness(b, ctx.Height >= ctx.Params.SegwitHeight); err != nil {
+		return err
+	}
+	if weight := b.Weight(); weight > tx.MaxBlockWeight {
+		return reject(RejectBadWeight, -1, "weight %d is over %d", weight, tx.MaxBlockWeight)
+	}
+	return nil
+}
+
+// isFinal returns whether a transaction's lock time has passed in a block at height
+// whose lock times count from cutoff, or all its inputs are final.
+func isFinal(t *tx.Transaction, height int, cutoff uint32) bool {
+	if t.Locktime == 0 {
+		return true
+	}
+	limit := int64(height)
+	if t.Locktime >= tx.LockTimeThreshold {
+		limit = int64(cutoff)
+	}
+	if int64(t.Locktime) < limit {
+		return true
+	}
+	for _, txIn := range t.Inputs {
+		if txIn.Sequence != tx.SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCoinbaseHeight checks that the coinbase ScriptSig starts with the height, minimally encoded (BIP34).
+func checkCoinbaseHeight(coinbase *tx.Transaction, height int) error {
+	actual, err := coinbase.CoinbaseHeight()
+	if err != nil {
+		return reject(RejectCoinbaseHeight, 0, "%v", err)
+	}
+	if actual != height {
+		return reject(RejectCoinbaseHeight, 0, "height %d, expected %d", actual, height)
+	}
+	expected := new(script.Script).AppendInt(height).RawSerialize()
+	if !bytes.HasPrefix(coinbase.Inputs[0].ScriptSig.RawSerialize(), expected) {
+		return reject(RejectCoinbaseHeight, 0, "height %d isn't minimally encoded", height)
+	}
+	return nil
+}
+
+// checkWitness checks the witness commitment of the coinbase, if it has one (BIP141).
+// Without one, or before segwit is active, no transaction can have witness data.
+func checkWitness(b *block.Block, segwit bool) error {
+	coinbase := b.Transactions[0]
+	if commitment := coinbase.WitnessCommitment(); segwit && commitment != nil {
+		witness := coinbase.Inputs[0].Witness
+		if len(witness) != 1 || len(witness[0]) != 32 {
+			return reject(RejectWitnessNonceSize, 0, "")
+		}
+		if expected := tx.WitnessCommitment(b.Transactions, witness[0]); !bytes.Equal(commitment, expected) {
+			return reject(RejectWitnessMerkleMatch, 0, "commitment %x, expected %x", commitment, expected)
+		}
+		return nil
+	}
+	for i, t := range b.Transactions {
+		if t.HasWitness() {
+			return reject(RejectUnexpectedWitness, i, "")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// blockView looks up outputs for the transactions of a block: the ones created earlier in the block,
+// then the unspent outputs of the chain, less the ones spent earlier in the block.
+type blockView struct {
+	utxos   tx.PrevoutProvider
+	outputs map[string]*tx.Output
+	spent   map[string]bool
+}
+
+func newBlockView(utxos tx.PrevoutProvider) *blockView {
+	return &blockView{utxos: utxos, outputs: make(map[string]*tx.Output), spent: make(map[string]bool)}
+}
+
+func outpoint(prevTx []byte, prevIndex int) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(prevTx), prevIndex)
+}
+
+// Prevout implements tx.PrevoutProvider.
+func (v *blockView) Prevout(prevTx []byte, prevIndex int, testnet bool) (*tx.Output, error) {
+	key := outpoint(prevTx, prevIndex)
+	if v.spent[key] {
+		return nil, fmt.Errorf("%s is spent earlier in the block", key)
+	}
+	if output, ok := v.outputs[key]; ok {
+		return output, nil
+	}
+	if v.utxos == nil {
+		return nil, fmt.Errorf("%s not found", key)
+	}
+	return v.utxos.Prevout(prevTx, prevIndex, testnet)
+}
+
+// apply spends the inputs of a transaction and adds its outputs.
+func (v *blockView) apply(t *tx.Transaction) {
+	if !t.IsCoinbase() {
+		for _, txIn := range t.Inputs {
+			key := outpoint(txIn.PrevTx, txIn.PrevIndex)
+			v.spent[key] = true
+			delete(v.outputs, key)
+		}
+	}
+	hash := t.Hash()
+	for i, txOut := range t.Outputs {
+		v.outputs[outpoint(hash, i)] = txOut
+	}
+}
+
+// CheckInputs checks the transactions against the outputs they spend, like ConnectBlock in Bitcoin Core:
+// the outputs are unspent, the amounts add up, the signature operation cost of the block, the scripts,
+// and the coinbase paying at most the subsidy and the fees.
+// The block has to have passed CheckBlock. Returns the fees of the block.
+func CheckInputs(b *block.Block, ctx *Context) (uint64, error) {
+	view := newBlockView(ctx.Utxos)
+	flags := ctx.Params.ScriptFlags(ctx.Height, b.Hash())
+	var fees uint64
+	sigOpCost := 0
+	for i, t := range b.Transactions {
+		if i > 0 {
+			for j, txIn := range t.Inputs {
+				if _, err := view.Prevout(txIn.PrevTx, txIn.PrevIndex, t.Testnet); err != nil {
+					return 0, reject(RejectInputsMissingOrSpent, i, "input %d: %v", j, err)
+				}
+			}
+			fee, err := t.Fee(view)
+			if err != nil {
+				return 0, reject(RejectInputsBelowOutputs, i, "%v", err)
+			}
+			// fees are at most MaxMoney each, so the sum can't overflow before it's over
+			if fees += fee; fees > tx.MaxMoney {
+				return 0, reject(RejectInputsBelowOutputs, i, "fees out of range")
+			}
+		}
+		cost, err := t.SigOpCost(view)
+		if err != nil {
+			return 0, reject(RejectInputsMissingOrSpent, i, "%v", err)
+		}
+		if sigOpCost += cost; sigOpCost > MaxBlockSigOpsCost {
+			return 0, reject(RejectBadSigOps, i, "signature operation cost %d", sigOpCost)
+		}
+		if i > 0 {
+			if ok, err := t.VerifyFlags(view, flags); !ok {
+				return 0, reject(RejectScriptVerify, i, "%v", err)
+			}
+		}
+		view.apply(t)
+	}
+	coinbase := b.Transactions[0]
+	var value uint64
+	for _, txOut := range coinbase.Outputs {
+		value += txOut.Amount
+	}
+	if limit := tx.BlockSubsidy(ctx.Height, ctx.Params.HalvingInterval) + fees; value > limit {
+		return 0, reject(RejectCoinbaseAmount, 0, "pays %d, the limit is %d", value, limit)
+	}
+	return fees, nil
+}
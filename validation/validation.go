@@ -0,0 +1,266 @@
+// Package validation checks blocks against the consensus rules, in the order Bitcoin Core does:
+// the checks of a block on its own, then the ones against the chain it extends,
+// then the transactions against the outputs they spend.
+package validation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/retarget"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+// Consensus limits on blocks.
+const (
+	// MaxBlockSigOpsCost is the most signature operation cost of a block (BIP141).
+	MaxBlockSigOpsCost = 80000
+	// MaxFutureBlockTime is how far ahead of the clock a block's timestamp can be.
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+// Reject reasons, as Bitcoin Core reports them.
+const (
+	RejectHighHash             = "high-hash"
+	RejectBadPrevBlock         = "bad-prevblk"
+	RejectBadDiffBits          = "bad-diffbits"
+	RejectTimeTooOld           = "time-too-old"
+	RejectTimeTooNew           = "time-too-new"
+	RejectTimeWarp             = "time-timewarp-attack"
+	RejectBadMerkleRoot        = "bad-txnmrklroot"
+	RejectDuplicateTxs         = "bad-txns-duplicate"
+	RejectBadLength            = "bad-blk-length"
+	RejectBadWeight            = "bad-blk-weight"
+	RejectBadSigOps            = "bad-blk-sigops"
+	RejectCoinbaseMissing      = "bad-cb-missing"
+	RejectCoinbaseMultiple     = "bad-cb-multiple"
+	RejectCoinbaseHeight       = "bad-cb-height"
+	RejectCoinbaseAmount       = "bad-cb-amount"
+	RejectBadTx                = "bad-txns"
+	RejectNonFinal             = "bad-txns-nonfinal"
+	RejectWitnessNonceSize     = "bad-witness-nonce-size"
+	RejectWitnessMerkleMatch   = "bad-witness-merkle-match"
+	RejectUnexpectedWitness    = "unexpected-witness"
+	RejectInputsMissingOrSpent = "bad-txns-inputs-missingorspent"
+	RejectInputsBelowOutputs   = "bad-txns-in-belowout"
+	RejectScriptVerify         = "mandatory-script-verify-flag-failed"
+)
+
+// RuleError is the consensus rule a block breaks.
+type RuleError struct {
+	// Reason is one of the Reject constants.
+	Reason string
+	// Index is the transaction the reason applies to, -1 for the whole block.
+	Index  int
+	Detail string
+}
+
+func (e *RuleError) Error() string {
+	result := e.Reason
+	if e.Index >= 0 {
+		result += fmt.Sprintf(" (tx %d)", e.Index)
+	}
+	if e.Detail != "" {
+		result += ": " + e.Detail
+	}
+	return result
+}
+
+func reject(reason string, index int, format string, a ...interface{}) *RuleError {
+	return &RuleError{Reason: reason, Index: index, Detail: fmt.Sprintf(format, a...)}
+}
+
+// Params are the consensus rules that differ between networks.
+type Params struct {
+	HalvingInterval int
+	// BIP34Height, BIP65Height, BIP66Height, CSVHeight and SegwitHeight are the heights
+	// BIP34, BIP65, BIP66, BIP68/112/113 and BIP141 activated at.
+	BIP34Height  int
+	BIP65Height  int
+	BIP66Height  int
+	CSVHeight    int
+	SegwitHeight int
+	// ScriptFlagExceptions are the blocks, by hash in hex, that don't enforce
+	// the script flags every other block does, only the ones given.
+	ScriptFlagExceptions map[string]script.Flags
+	// Retarget are the proof of work rules.
+	Retarget *retarget.Params
+}
+
+// Params of the networks.
+var (
+	MainNetParams = &Params{
+		HalvingInterval: tx.HalvingInterval,
+		BIP34Height:     227931,
+		BIP65Height:     388381,
+		BIP66Height:     363725,
+		CSVHeight:       419328,
+		SegwitHeight:    481824,
+		ScriptFlagExceptions: map[string]script.Flags{
+			// the block with the only transaction breaking BIP16
+			"00000000000002dc756eebf4f49723ed8d30cc28a5f108eb94b1ba88ac4f9c22": script.VerifyNone,
+			// the block with the only transaction breaking taproot
+			"0000000000000000000f14c35b2d841e986ab5441de8c585d5ffe55ea1e395ad": script.VerifyP2SH | script.VerifyWitness,
+		},
+		Retarget: retarget.MainNetParams,
+	}
+	TestNet3Params = &Params{
+		HalvingInterval: tx.HalvingInterval,
+		BIP34Height:     21111,
+		BIP65Height:     581885,
+		BIP66Height:     330776,
+		CSVHeight:       770112,
+		SegwitHeight:    834624,
+		ScriptFlagExceptions: map[string]script.Flags{
+			// the block with the only transaction breaking BIP16
+			"00000000dd30457c001f4095d208cc1296b0eed002427aa599874af7a432b105": script.VerifyNone,
+		},
+		Retarget: retarget.TestNet3Params,
+	}
+	TestNet4Params = &Params{
+		HalvingInterval: tx.HalvingInterval,
+		BIP34Height:     1,
+		BIP65Height:     1,
+		BIP66Height:     1,
+		CSVHeight:       1,
+		SegwitHeight:    1,
+		Retarget:        retarget.TestNet4Params,
+	}
+	RegTestParams = &Params{
+		HalvingInterval: 150,
+		BIP34Height:     1,
+		BIP65Height:     1,
+		BIP66Height:     1,
+		CSVHeight:       1,
+		SegwitHeight:    0,
+		Retarget:        retarget.RegTestParams,
+	}
+)
+
+// ScriptFlags returns the soft forks the scripts of the block with the given hash enforce,
+// like GetBlockScriptFlags in Bitcoin Core. BIP16, segwit and taproot are enforced from the
+// genesis block, which only a few exception blocks break, the other soft forks from their heights.
+func (p *Params) ScriptFlags(height int, hash []byte) script.Flags {
+	flags := script.VerifyP2SH | script.VerifyWitness | script.VerifyTaproot
+	if exception, ok := p.ScriptFlagExceptions[hex.EncodeToString(hash)]; ok {
+		flags = exception
+	}
+	if height >= p.BIP66Height {
+		flags |= script.VerifyDERSig
+	}
+	if height >= p.BIP65Height {
+		flags |= script.VerifyCheckLockTime
+	}
+	if height >= p.CSVHeight {
+		flags |= script.VerifyCheckSequence
+	}
+	if height >= p.SegwitHeight {
+		flags |= script.VerifyNullDummy
+	}
+	return flags
+}
+
+// Context is what a block is checked against.
+type Context struct {
+	Params *Params
+	// Height is the height of the block, Chain holds its ancestors.
+	Height int
+	Chain  block.Chain
+	// Utxos looks up the outputs the block spends that aren't created in the block.
+	// Coinbase maturity is up to it, a provider of unspent outputs only.
+	Utxos tx.PrevoutProvider
+	// Now is the time blocks can't be too far ahead of, no check if zero.
+	Now time.Time
+}
+
+// NewContext returns the context of a block at height on a chain.
+func NewContext(params *Params, height int, chain block.Chain, utxos tx.PrevoutProvider) *Context {
+	return &Context{Params: params, Height: height, Chain: chain, Utxos: utxos}
+}
+
+// Validate runs all the checks on a block: CheckBlock, CheckHeader, CheckContext and CheckInputs.
+// Returns a *RuleError if the block breaks a consensus rule,
+// or another error if the chain is missing a header.
+func Validate(b *block.Block, ctx *Context) error {
+	if err := CheckBlock(b); err != nil {
+		return err
+	}
+	if err := CheckHeader(b, ctx); err != nil {
+		return err
+	}
+	if err := CheckContext(b, ctx); err != nil {
+		return err
+	}
+	_, err := CheckInputs(b, ctx)
+	return err
+}
+
+// CheckBlock runs the checks that don't need the chain, like CheckBlock in Bitcoin Core:
+// the proof of work, the merkle root, the coinbase, the size, the legacy signature operations
+// and the consensus checks of each transaction.
+func CheckBlock(b *block.Block) error {
+	if !b.CheckPow() {
+		return reject(RejectHighHash, -1, "")
+	}
+	txs := b.Transactions
+	hashes := make([][]byte, len(txs))
+	for i, t := range txs {
+		hashes[i] = util.ReverseByteArray(t.Hash())
+	}
+	root, mutated := merkleRoot(hashes)
+	if root == nil || !bytes.Equal(util.ReverseByteArray(root), b.MerkleRoot[:]) {
+		return reject(RejectBadMerkleRoot, -1, "")
+	}
+	// a block with duplicated transactions has the same merkle root as the block without them (CVE-2012-2459)
+	if mutated {
+		return reject(RejectDuplicateTxs, -1, "")
+	}
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return reject(RejectCoinbaseMissing, -1, "")
+	}
+	if size := b.StrippedSize(); size*tx.WitnessScaleFactor > tx.MaxBlockWeight {
+		return reject(RejectBadLength, -1, "size %d without witness data", size)
+	}
+	sigOps := 0
+	for i, t := range txs {
+		if i > 0 && t.IsCoinbase() {
+			return reject(RejectCoinbaseMultiple, i, "")
+		}
+		if err := t.Check(); err != nil {
+			return reject(RejectBadTx, i, "%v", err)
+		}
+		for _, txIn := range t.Inputs {
+			sigOps += txIn.ScriptSig.SigOpCount(false)
+		}
+		for _, txOut := range t.Outputs {
+			sigOps += txOut.ScriptPubKey.SigOpCount(false)
+		}
+	}
+	if sigOps*tx.WitnessScaleFactor > MaxBlockSigOpsCost {
+		return reject(RejectBadSigOps, -1, "%d legacy signature operations", sigOps)
+	}
+	return nil
+}
+
+// merkleRoot returns the merkle root of hashes in internal byte order, nil if there are none,
+// and whether two hashes paired at any level are the same, which makes the root ambiguous.
+func merkleRoot(hashes [][]byte) ([]byte, bool) {
+	if len(hashes) == 0 {
+		return nil, false
+	}
+	mutated := false
+	for len(hashes) > 1 {
+		for i := 0; i+1 < len(hashes); i += 2 {
+			if bytes.Equal(hashes[i], hashes[i+1]) {
+				mutated = true
+			}
+		}
+		hashes = util.MerkleParentLevel(hashes)
+	}
+	return hashes[0], mutated
+}
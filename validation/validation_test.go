@@ -0,0 +1,340 @@
+package validation
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ravdin/programmingbitcoin/block"
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+var regTestBits = util.HexStringToBytes(`ffff7f20`)
+
+// ruleReason returns the reason of a *RuleError, "" for nil or another error.
+func ruleReason(err error) string {
+	if ruleErr, ok := err.(*RuleError); ok {
+		return ruleErr.Reason
+	}
+	return ""
+}
+
+// mine sets the nonce of a block to one that satisfies proof of work.
+func mine(b *block.Block) {
+	for nonce := uint32(0); ; nonce++ {
+		copy(b.Nonce[:], util.Int32ToLittleEndian(nonce))
+		if b.CheckPow() {
+			return
+		}
+	}
+}
+
+// testChain returns a regtest chain of headers ten minutes apart.
+func testChain(length int, start uint32) block.Headers {
+	var result block.Headers
+	prevBlock := make([]byte, 32)
+	for i := 0; i < length; i++ {
+		header := block.NewBlock(0x20000000, prevBlock, make([]byte, 32), start+uint32(i)*600, regTestBits, nil, nil)
+		result = append(result, header)
+		prevBlock = header.Hash()
+	}
+	return result
+}
+
+func TestMedianTimePast(t *testing.T) {
+	chain := testChain(20, 1000)
+	// out of order timestamps, the median of heights 9 to 19 is now height 14's
+	chain[15].Timestamp = 100000
+	ctx := NewContext(RegTestParams, 20, chain, nil)
+	mtp, err := ctx.MedianTimePast()
+	if err != nil || mtp != 1000+14*600 {
+		t.Errorf("Expected %d, got %d %v", 1000+14*600, mtp, err)
+	}
+	ctx.Height = 3
+	if mtp, _ := ctx.MedianTimePast(); mtp != 1600 {
+		t.Errorf("Expected 1600, got %d", mtp)
+	}
+	ctx.Height = 0
+	if mtp, _ := ctx.MedianTimePast(); mtp != 0 {
+		t.Errorf("Expected 0, got %d", mtp)
+	}
+	ctx.Height = 25
+	if _, err := ctx.MedianTimePast(); err == nil {
+		t.Errorf("Expected an error for a missing header")
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	interval := MainNetParams.Retarget.Interval()
+	chain := testChain(interval+1, 1000)
+	bits := util.HexStringToBytes(`ffff001d`)
+	for _, header := range chain {
+		copy(header.Bits[:], bits)
+	}
+	// the last interval took a week, half of two weeks
+	chain[interval-1].Timestamp = chain[0].Timestamp + 7*24*60*60
+	ctx := NewContext(MainNetParams, interval, chain, nil)
+	actual, err := ctx.NextBits(chain[interval-1].Timestamp + 600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := util.CalculateNewBits(bits, 7*24*60*60)
+	if string(actual) != string(expected) || string(actual) == string(bits) {
+		t.Errorf("Expected %x, got %x", expected, actual)
+	}
+	ctx.Height = interval + 1
+	if actual, _ := ctx.NextBits(chain[interval].Timestamp + 600); string(actual) != string(bits) {
+		t.Errorf("Expected %x, got %x", bits, actual)
+	}
+	ctx = NewContext(RegTestParams, interval, chain, nil)
+	if actual, _ := ctx.NextBits(chain[interval-1].Timestamp + 600); string(actual) != string(bits) {
+		t.Errorf("Expected %x, got %x", bits, actual)
+	}
+	// on testnet4 the first block of a period can't be timestamped much before its parent (BIP94)
+	chain = testChain(interval, 1000)
+	ctx = NewContext(TestNet4Params, interval, chain, nil)
+	parent := chain[interval-1]
+	testNetBits, _ := ctx.NextBits(parent.Timestamp)
+	header := block.NewBlock(0x20000000, parent.Hash(), make([]byte, 32), parent.Timestamp-601, testNetBits, nil, nil)
+	if err := CheckHeader(header, ctx); ruleReason(err) != RejectTimeWarp {
+		t.Errorf("Expected %s, got %v", RejectTimeWarp, err)
+	}
+	header.Timestamp++
+	if err := CheckHeader(header, ctx); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestScriptFlags(t *testing.T) {
+	always := script.VerifyP2SH | script.VerifyWitness | script.VerifyTaproot
+	hash := make([]byte, 32)
+	tests := []struct {
+		params   *Params
+		height   int
+		hash     string
+		expected script.Flags
+	}{
+		{MainNetParams, 0, "", always},
+		{MainNetParams, 363724, "", always},
+		{MainNetParams, 363725, "", always | script.VerifyDERSig},
+		{MainNetParams, 388381, "", always | script.VerifyDERSig | script.VerifyCheckLockTime},
+		{MainNetParams, 419328, "", always | script.VerifyDERSig | script.VerifyCheckLockTime | script.VerifyCheckSequence},
+		{MainNetParams, 481824, "", script.VerifyAll},
+		{MainNetParams, 170060, "00000000000002dc756eebf4f49723ed8d30cc28a5f108eb94b1ba88ac4f9c22", script.VerifyNone},
+		{MainNetParams, 692261, "0000000000000000000f14c35b2d841e986ab5441de8c585d5ffe55ea1e395ad", script.VerifyAll &^ script.VerifyTaproot},
+		{TestNet3Params, 330775, "", always},
+		{TestNet3Params, 581885, "", always | script.VerifyDERSig | script.VerifyCheckLockTime},
+		{TestNet3Params, 834624, "", script.VerifyAll},
+		{TestNet3Params, 1, "00000000dd30457c001f4095d208cc1296b0eed002427aa599874af7a432b105", script.VerifyNone},
+		{TestNet4Params, 1, "", script.VerifyAll},
+		{RegTestParams, 0, "", always | script.VerifyNullDummy},
+		{RegTestParams, 1, "", script.VerifyAll},
+	}
+	for _, test := range tests {
+		h := hash
+		if test.hash != "" {
+			h = util.HexStringToBytes(test.hash)
+		}
+		if actual := test.params.ScriptFlags(test.height, h); actual != test.expected {
+			t.Errorf("Height %d %s: expected %#x, got %#x", test.height, test.hash, test.expected, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const height = 11
+	chain := testChain(height, 1600000000)
+	parent := chain[height-1]
+	pk := ecc.NewPrivateKey(big.NewInt(7001))
+	p2wpkh := script.P2wpkhScript(pk.Point.Hash160(true))
+	payout := script.P2pkhScript(util.Hash160([]byte("miner")))
+	funding := tx.NewTransaction(1, []*tx.Input{tx.NewInput(make([]byte, 32), 0, nil, tx.SequenceFinal)}, []*tx.Output{
+		tx.NewOutput(100000, p2wpkh),
+		tx.NewOutput(100000, p2wpkh),
+	}, 0, false)
+	utxos := tx.NewMemoryProvider(funding)
+	const fee = 1000
+	// spend returns a signed transaction spending an output of the funding transaction
+	spend := func(prevIndex int, locktime uint32) *tx.Transaction {
+		txIn := tx.NewInput(funding.Hash(), prevIndex, nil, 0xfffffffd)
+		txObj := tx.NewTransaction(2, []*tx.Input{txIn}, []*tx.Output{tx.NewOutput(100000-fee, payout)}, locktime, false)
+		if ok, err := txObj.SignInput(utxos, 0, pk, util.SigHashAll); !ok {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return txObj
+	}
+	// newBlock returns a mined block on top of the chain with a coinbase and txs
+	newBlock := func(txs []*tx.Transaction, modify func(c *tx.Coinbase, b *block.Block)) *block.Block {
+		c := tx.NewCoinbase(height, payout)
+		c.HalvingInterval = RegTestParams.HalvingInterval
+		c.Fees = uint64(len(txs)) * fee
+		c.Transactions = txs
+		b := block.NewBlock(0x20000000, parent.Hash(), nil, parent.Timestamp+600, regTestBits, nil, nil)
+		if modify != nil {
+			modify(c, b)
+		}
+		coinbase, err := c.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		b.SetTransactions(append([]*tx.Transaction{coinbase}, txs...))
+		mine(b)
+		return b
+	}
+	validate := func(b *block.Block) error {
+		ctx := NewContext(RegTestParams, height, chain, utxos)
+		ctx.Now = time.Unix(int64(parent.Timestamp), 0)
+		return Validate(b, ctx)
+	}
+
+	t.Run("Test valid block", func(t *testing.T) {
+		b := newBlock([]*tx.Transaction{spend(0, 0), spend(1, height-1)}, nil)
+		if err := validate(b); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fees, err := CheckInputs(b, NewContext(RegTestParams, height, chain, utxos))
+		if err != nil || fees != 2*fee {
+			t.Errorf("Expected %d, got %d %v", 2*fee, fees, err)
+		}
+		// a transaction can spend the outputs of an earlier one in the block
+		first := spend(0, 0)
+		first.Outputs[0].ScriptPubKey = p2wpkh
+		if ok, err := first.SignInput(utxos, 0, pk, util.SigHashAll); !ok {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		child := tx.NewTransaction(2, []*tx.Input{tx.NewInput(first.Hash(), 0, nil, tx.SequenceFinal)},
+			[]*tx.Output{tx.NewOutput(100000-2*fee, payout)}, 0, false)
+		if ok, err := child.SignInput(tx.NewMemoryProvider(first), 0, pk, util.SigHashAll); !ok {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		if err := validate(newBlock([]*tx.Transaction{first, child}, nil)); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if err := validate(newBlock([]*tx.Transaction{child, first}, nil)); ruleReason(err) != RejectInputsMissingOrSpent {
+			t.Errorf("Expected %s, got %v", RejectInputsMissingOrSpent, err)
+		}
+	})
+
+	t.Run("Test script flags", func(t *testing.T) {
+		// OP_CHECKLOCKTIMEVERIFY is OP_NOP2 before BIP65
+		lock := new(script.Script).AppendInt(500000).AppendOp(script.OpCheckLockTimeVerify)
+		locked := tx.NewTransaction(1, []*tx.Input{tx.NewInput(make([]byte, 32), 1, nil, tx.SequenceFinal)},
+			[]*tx.Output{tx.NewOutput(100000, lock)}, 0, false)
+		txObj := tx.NewTransaction(2, []*tx.Input{tx.NewInput(locked.Hash(), 0, nil, 0xfffffffd)},
+			[]*tx.Output{tx.NewOutput(100000-fee, payout)}, 0, false)
+		b := newBlock([]*tx.Transaction{txObj}, nil)
+		params := *RegTestParams
+		params.BIP65Height = height + 1
+		if _, err := CheckInputs(b, NewContext(&params, height, chain, tx.NewMemoryProvider(locked))); err != nil {
+			t.Errorf("Unexpected error before BIP65: %v", err)
+		}
+		_, err := CheckInputs(b, NewContext(RegTestParams, height, chain, tx.NewMemoryProvider(locked)))
+		if ruleReason(err) != RejectScriptVerify {
+			t.Errorf("Expected %s, got %v", RejectScriptVerify, err)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		block    func() *block.Block
+		expected string
+	}{
+		{"high hash", func() *block.Block {
+			b := newBlock(nil, nil)
+			for nonce := uint32(0); b.CheckPow(); nonce++ {
+				copy(b.Nonce[:], util.Int32ToLittleEndian(nonce))
+			}
+			return b
+		}, RejectHighHash},
+		{"merkle root", func() *block.Block {
+			b := newBlock(nil, nil)
+			b.MerkleRoot[0] ^= 1
+			mine(b)
+			return b
+		}, RejectBadMerkleRoot},
+		{"duplicate transactions", func() *block.Block {
+			duplicate := spend(1, 0)
+			b := newBlock([]*tx.Transaction{spend(0, 0), duplicate}, nil)
+			// the merkle root of three transactions duplicates the last one
+			b.Transactions = append(b.Transactions, duplicate)
+			b.TxHashes = append(b.TxHashes, duplicate.Hash())
+			return b
+		}, RejectDuplicateTxs},
+		{"legacy signature operations", func() *block.Block {
+			txObj := spend(0, 0)
+			raw := make([]byte, MaxBlockSigOpsCost/tx.WitnessScaleFactor+1)
+			for i := range raw {
+				raw[i] = script.OpCheckSig
+			}
+			scriptPubKey, _ := script.ParseRaw(raw)
+			txObj.Outputs = append(txObj.Outputs, tx.NewOutput(0, scriptPubKey))
+			return newBlock([]*tx.Transaction{txObj}, nil)
+		}, RejectBadSigOps},
+		{"parent", func() *block.Block {
+			return newBlock(nil, func(c *tx.Coinbase, b *block.Block) { b.PrevBlock = chain[height-2].PrevBlock })
+		}, RejectBadPrevBlock},
+		{"bits", func() *block.Block {
+			return newBlock(nil, func(c *tx.Coinbase, b *block.Block) { b.Bits[0] = 0xfe })
+		}, RejectBadDiffBits},
+		{"time too old", func() *block.Block {
+			return newBlock(nil, func(c *tx.Coinbase, b *block.Block) { b.Timestamp = chain[height-6].Timestamp })
+		}, RejectTimeTooOld},
+		{"time too new", func() *block.Block {
+			return newBlock(nil, func(c *tx.Coinbase, b *block.Block) { b.Timestamp = parent.Timestamp + 3*60*60 })
+		}, RejectTimeTooNew},
+		{"non-final transaction", func() *block.Block {
+			return newBlock([]*tx.Transaction{spend(0, height)}, nil)
+		}, RejectNonFinal},
+		{"coinbase height", func() *block.Block {
+			return newBlock(nil, func(c *tx.Coinbase, b *block.Block) { c.Height = height + 1 })
+		}, RejectCoinbaseHeight},
+		{"unexpected witness", func() *block.Block {
+			return newBlock([]*tx.Transaction{spend(0, 0)}, func(c *tx.Coinbase, b *block.Block) { c.WitnessCommitment = false })
+		}, RejectUnexpectedWitness},
+		{"witness commitment", func() *block.Block {
+			return newBlock([]*tx.Transaction{spend(0, 0)}, func(c *tx.Coinbase, b *block.Block) { c.Transactions = nil })
+		}, RejectWitnessMerkleMatch},
+		{"witness nonce size", func() *block.Block {
+			return newBlock([]*tx.Transaction{spend(0, 0)}, func(c *tx.Coinbase, b *block.Block) { c.WitnessReservedValue = make([]byte, 31) })
+		}, RejectWitnessNonceSize},
+		{"weight", func() *block.Block {
+			txObj := spend(0, 0)
+			txObj.Inputs[0].Witness = append(txObj.Inputs[0].Witness, make([]byte, tx.MaxBlockWeight))
+			return newBlock([]*tx.Transaction{txObj}, nil)
+		}, RejectBadWeight},
+		{"missing input", func() *block.Block {
+			txObj := spend(0, 0)
+			txObj.Inputs[0].PrevIndex = 2
+			return newBlock([]*tx.Transaction{txObj}, nil)
+		}, RejectInputsMissingOrSpent},
+		{"double spend", func() *block.Block {
+			other := spend(0, 0)
+			other.Outputs[0].Amount--
+			return newBlock([]*tx.Transaction{spend(0, 0), other}, nil)
+		}, RejectInputsMissingOrSpent},
+		{"invalid signature", func() *block.Block {
+			txObj := spend(0, 0)
+			txObj.Outputs[0].Amount--
+			return newBlock([]*tx.Transaction{txObj}, nil)
+		}, RejectScriptVerify},
+		{"coinbase amount", func() *block.Block {
+			return newBlock([]*tx.Transaction{spend(0, 0)}, func(c *tx.Coinbase, b *block.Block) { c.Fees++ })
+		}, RejectCoinbaseAmount},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			if err := validate(test.block()); ruleReason(err) != test.expected {
+				t.Errorf("Expected %s, got %v", test.expected, err)
+			}
+		})
+	}
+	// a script failure says why
+	txObj := spend(0, 0)
+	txObj.Outputs[0].Amount--
+	if err, ok := validate(newBlock([]*tx.Transaction{txObj}, nil)).(*RuleError); !ok || err.Detail == "" {
+		t.Errorf("Expected the script failure in the error, got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Package versionbits tracks soft fork deployments signalled with the version bits of headers
+// (BIP9), through the states a deployment goes through a period of blocks at a time.
+package versionbits
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ravdin/programmingbitcoin/block"
+)
+
+// State is where a deployment is for the blocks of a period.
+type State int
+
+// The states of a deployment: DEFINED until its start, STARTED while blocks signal for it,
+// LOCKED_IN for the period after enough did, then ACTIVE for good, or FAILED at its timeout.
+const (
+	Defined State = iota
+	Started
+	LockedIn
+	Active
+	Failed
+)
+
+func (s State) String() string {
+	switch s {
+	case Defined:
+		return "DEFINED"
+	case Started:
+		return "STARTED"
+	case LockedIn:
+		return "LOCKED_IN"
+	case Active:
+		return "ACTIVE"
+	case Failed:
+		return "FAILED"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// StartTime values for deployments that aren't signalled for, as on regtest.
+const (
+	// AlwaysActive deployments are ACTIVE from the first block.
+	AlwaysActive int64 = -1
+	// NeverActive deployments are FAILED from the first block.
+	NeverActive int64 = -2
+)
+
+// Deployment is a soft fork signalled for with a version bit.
+type Deployment struct {
+	Name string
+	Bit  uint
+	// StartTime and Timeout are median times past (BIP9): the deployment starts with the first period
+	// after one ending at StartTime or later, and fails with the first period after one ending at Timeout
+	// or later without locking in.
+	StartTime int64
+	Timeout   int64
+	// StartHeight and TimeoutHeight schedule the deployment by height instead (BIP8), if TimeoutHeight is set.
+	// Both are the first blocks of periods.
+	StartHeight   int
+	TimeoutHeight int
+	// MinActivationHeight is the lowest height the deployment can be active at, after locking in earlier.
+	MinActivationHeight int
+	// Threshold is the number of blocks of a period that have to signal to lock in.
+	Threshold int
+	Period    int
+}
+
+// Deployments of mainnet.
+var (
+	CSVDeployment = &Deployment{
+		Name:      "csv",
+		Bit:       0,
+		StartTime: 1462060800,
+		Timeout:   1493596800,
+		Threshold: 1916,
+		Period:    2016,
+	}
+	SegwitDeployment = &Deployment{
+		Name:      "segwit",
+		Bit:       1,
+		StartTime: 1479168000,
+		Timeout:   1510704000,
+		Threshold: 1916,
+		Period:    2016,
+	}
+	TaprootDeployment = &Deployment{
+		Name:                "taproot",
+		Bit:                 2,
+		StartTime:           1619222400,
+		Timeout:             1628640000,
+		MinActivationHeight: 709632,
+		Threshold:           1815,
+		Period:              2016,
+	}
+)
+
+// Stats are how the blocks of a period signalled for a deployment, up to a height.
+type Stats struct {
+	Period    int
+	Threshold int
+	// Elapsed is the number of blocks of the period up to the height, Count the number that signalled.
+	Elapsed int
+	Count   int
+	// Possible is whether the period can still reach the threshold.
+	Possible bool
+}
+
+// PeriodStats are the state of a deployment for the blocks of a period and how they signalled.
+type PeriodStats struct {
+	// StartHeight is the height of the first block of the period.
+	StartHeight int
+	State       State
+	Stats
+}
+
+// Tracker evaluates a deployment over a chain. It caches the state of each period by the hash of
+// the block before it, so it can be used across branches and reorgs, and is safe for concurrent use.
+type Tracker struct {
+	Deployment *Deployment
+	mu         sync.Mutex
+	cache      map[string]State
+}
+
+// NewTracker returns a tracker for a deployment.
+func NewTracker(d *Deployment) *Tracker {
+	return &Tracker{Deployment: d, cache: make(map[string]State)}
+}
+
+// State returns the state of the deployment for the block at height, from the headers below it,
+// like GetStateFor in Bitcoin Core.
+func (t *Tracker) State(chain block.Chain, height int) (State, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.Deployment
+	switch d.StartTime {
+	case AlwaysActive:
+		return Active, nil
+	case NeverActive:
+		return Failed, nil
+	}
+	// walk back to a period whose state is known, then forward through the ones after it
+	var starts []int
+	var keys []string
+	state := Defined
+	for start := height - height%d.Period; start > 0; start -= d.Period {
+		last, err := block.Ancestor(chain, start-1)
+		if err != nil {
+			return Defined, err
+		}
+		key := hex.EncodeToString(last.Hash())
+		if cached, ok := t.cache[key]; ok {
+			state = cached
+			break
+		}
+		started, err := d.started(chain, start)
+		if err != nil {
+			return Defined, err
+		}
+		if !started {
+			// not started yet, nor in any period before
+			t.cache[key] = Defined
+			break
+		}
+		starts = append(starts, start)
+		keys = append(keys, key)
+	}
+	for i := len(starts) - 1; i >= 0; i-- {
+		next, err := d.transition(chain, state, starts[i])
+		if err != nil {
+			return Defined, err
+		}
+		state = next
+		t.cache[keys[i]] = state
+	}
+	return state, nil
+}
+
+// started returns whether the period from start is at or after the deployment's start.
+func (d *Deployment) started(chain block.Chain, start int) (bool, error) {
+	if d.TimeoutHeight > 0 {
+		return start >= d.StartHeight, nil
+	}
+	mtp, err := block.MedianTimePast(chain, start)
+	if err != nil {
+		return false, err
+	}
+	return int64(mtp) >= d.StartTime, nil
+}
+
+// timedOut returns whether the period from start is at or after the deployment's timeout.
+func (d *Deployment) timedOut(chain block.Chain, start int) (bool, error) {
+	if d.TimeoutHeight > 0 {
+		return start >= d.TimeoutHeight, nil
+	}
+	mtp, err := block.MedianTimePast(chain, start)
+	if err != nil {
+		return false, err
+	}
+	return int64(mtp) >= d.Timeout, nil
+}
+
+// transition returns the state for the period from start, after a period in state.
+func (d *Deployment) transition(chain block.Chain, state State, start int) (State, error) {
+	switch state {
+	case Defined:
+		started, err := d.started(chain, start)
+		if err != nil || !started {
+			return Defined, err
+		}
+		return Started, nil
+	case Started:
+		count, err := d.count(chain, start-d.Period, start)
+		if err != nil {
+			return Started, err
+		}
+		// a period that reaches the threshold locks in even if it ends after the timeout
+		if count >= d.Threshold {
+			return LockedIn, nil
+		}
+		timedOut, err := d.timedOut(chain, start)
+		if err != nil || !timedOut {
+			return Started, err
+		}
+		return Failed, nil
+	case LockedIn:
+		if start >= d.MinActivationHeight {
+			return Active, nil
+		}
+	}
+	return state, nil
+}
+
+// count returns the number of blocks from start up to end that signal for the deployment.
+func (d *Deployment) count(chain block.Chain, start, end int) (int, error) {
+	result := 0
+	for height := start; height < end; height++ {
+		header, err := block.Ancestor(chain, height)
+		if err != nil {
+			return 0, err
+		}
+		if header.Signals(d.Bit) {
+			result++
+		}
+	}
+	return result, nil
+}
+
+// Stats returns how the blocks of the period of height signalled up to and including it.
+func (t *Tracker) Stats(chain block.Chain, height int) (*Stats, error) {
+	d := t.Deployment
+	start := height - height%d.Period
+	count, err := d.count(chain, start, height+1)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := height - start + 1
+	return &Stats{
+		Period:    d.Period,
+		Threshold: d.Threshold,
+		Elapsed:   elapsed,
+		Count:     count,
+		Possible:  d.Period-d.Threshold >= elapsed-count,
+	}, nil
+}
+
+// History returns the state and stats of each period up to the one of height, oldest first.
+// The stats of the last period go up to height.
+func (t *Tracker) History(chain block.Chain, height int) ([]*PeriodStats, error) {
+	var result []*PeriodStats
+	for start := 0; start <= height; start += t.Deployment.Period {
+		state, err := t.State(chain, start)
+		if err != nil {
+			return nil, err
+		}
+		end := start + t.Deployment.Period - 1
+		if end > height {
+			end = height
+		}
+		stats, err := t.Stats(chain, end)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &PeriodStats{StartHeight: start, State: state, Stats: *stats})
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package versionbits
+
+import (
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/block"
+)
+
+const start = 1600000000
+
+// extend returns chain with length headers ten minutes apart, the ones signals returns true for
+// signalling on bit.
+func extend(chain block.Headers, length int, bit uint, signals func(height int) bool) block.Headers {
+	result := append(block.Headers{}, chain...)
+	prevBlock := make([]byte, 32)
+	if len(result) > 0 {
+		prevBlock = result[len(result)-1].Hash()
+	}
+	for height := len(result); height < length; height++ {
+		version := uint32(0x20000000)
+		if signals(height) {
+			version |= 1 << bit
+		}
+		header := block.NewBlock(version, prevBlock, make([]byte, 32), uint32(start+height*600), nil, nil, nil)
+		result = append(result, header)
+		prevBlock = header.Hash()
+	}
+	return result
+}
+
+// inPeriods returns a signals function for extend, for count blocks of each of the periods.
+func inPeriods(period, count int, periods ...int) func(int) bool {
+	return func(height int) bool {
+		for _, p := range periods {
+			if height >= p*period && height < p*period+count {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// states returns the state of each period of chain.
+func states(t *testing.T, tracker *Tracker, chain block.Headers) []State {
+	var result []State
+	for height := 0; height < len(chain); height += tracker.Deployment.Period {
+		state, err := tracker.State(chain, height)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result = append(result, state)
+	}
+	return result
+}
+
+func expectStates(t *testing.T, expected, actual []State) {
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, actual)
+			return
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	const period = 20
+	// the median time past reaches the start at height 26 and the timeout at height 86,
+	// so the deployment starts with the third period and times out with the sixth
+	d := &Deployment{Name: "test", Bit: 3, StartTime: start + 20*600, Timeout: start + 80*600, Threshold: 15, Period: period}
+
+	t.Run("Test activation", func(t *testing.T) {
+		// signalling before the start doesn't count, the third period is one short
+		chain := extend(nil, 2*period+14, d.Bit, inPeriods(period, 15, 0, 1, 2))
+		chain = extend(chain, 7*period, d.Bit, inPeriods(period, 15, 3))
+		expectStates(t, []State{Defined, Defined, Started, Started, LockedIn, Active, Active}, states(t, NewTracker(d), chain))
+		// every block of a period has its state
+		if state, _ := NewTracker(d).State(chain, 5*period-1); state != LockedIn {
+			t.Errorf("Expected %v, got %v", LockedIn, state)
+		}
+		// a minimum activation height keeps it locked in
+		delayed := *d
+		delayed.MinActivationHeight = 6 * period
+		expectStates(t, []State{Defined, Defined, Started, Started, LockedIn, LockedIn, Active}, states(t, NewTracker(&delayed), chain))
+	})
+
+	t.Run("Test timeout", func(t *testing.T) {
+		chain := extend(nil, 7*period, d.Bit, inPeriods(period, 14, 2, 3, 4, 5))
+		expectStates(t, []State{Defined, Defined, Started, Started, Started, Failed, Failed}, states(t, NewTracker(d), chain))
+		// a period reaching the threshold locks in even if it ends after the timeout
+		chain = extend(nil, 7*period, d.Bit, inPeriods(period, 15, 4))
+		expectStates(t, []State{Defined, Defined, Started, Started, Started, LockedIn, Active}, states(t, NewTracker(d), chain))
+	})
+
+	t.Run("Test heights", func(t *testing.T) {
+		byHeight := *d
+		byHeight.StartTime, byHeight.Timeout = 0, 0
+		byHeight.StartHeight, byHeight.TimeoutHeight = period, 3*period
+		chain := extend(nil, 5*period, d.Bit, inPeriods(period, 15, 0))
+		expectStates(t, []State{Defined, Started, Started, Failed, Failed}, states(t, NewTracker(&byHeight), chain))
+		chain = extend(nil, 5*period, d.Bit, inPeriods(period, 15, 1))
+		expectStates(t, []State{Defined, Started, LockedIn, Active, Active}, states(t, NewTracker(&byHeight), chain))
+	})
+
+	t.Run("Test always and never active", func(t *testing.T) {
+		always, never := *d, *d
+		always.StartTime, never.StartTime = AlwaysActive, NeverActive
+		if state, _ := NewTracker(&always).State(nil, 0); state != Active {
+			t.Errorf("Expected %v, got %v", Active, state)
+		}
+		if state, _ := NewTracker(&never).State(nil, 100); state != Failed {
+			t.Errorf("Expected %v, got %v", Failed, state)
+		}
+	})
+
+	t.Run("Test branches", func(t *testing.T) {
+		tracker := NewTracker(d)
+		signalling := extend(nil, 5*period, d.Bit, inPeriods(period, 15, 2))
+		quiet := extend(signalling[:2*period+10], 5*period, d.Bit, inPeriods(period, 0))
+		expectStates(t, []State{Defined, Defined, Started, LockedIn, Active}, states(t, tracker, signalling))
+		// the branches share the first periods' states, and not the later ones
+		expectStates(t, []State{Defined, Defined, Started, Started, Started}, states(t, tracker, quiet))
+		if _, err := tracker.State(signalling[:4*period], 5*period); err == nil {
+			t.Errorf("Expected an error for a missing header")
+		}
+	})
+}
+
+func TestStats(t *testing.T) {
+	const period = 20
+	d := &Deployment{Name: "test", Bit: 1, StartTime: start + 20*600, Timeout: start + 80*600, Threshold: 15, Period: period}
+	tracker := NewTracker(d)
+	chain := extend(nil, 3*period+10, d.Bit, func(height int) bool { return height%2 == 0 })
+	stats, err := tracker.Stats(chain, 2*period+11)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// only 5 of the 20 blocks can miss for 15 to signal, and 6 have
+	expected := Stats{Period: period, Threshold: 15, Elapsed: 12, Count: 6, Possible: false}
+	if *stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *stats)
+	}
+	if stats, _ := tracker.Stats(chain, 2*period+10); !stats.Possible || stats.Elapsed != 11 || stats.Count != 6 {
+		t.Errorf("Expected 6 of 11 to still be possible, got %+v", *stats)
+	}
+	history, err := tracker.History(chain, len(chain)-1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("Expected 4 periods, got %d", len(history))
+	}
+	last := history[3]
+	if last.StartHeight != 3*period || last.State != Started || last.Elapsed != 10 || last.Count != 5 {
+		t.Errorf("Expected the last period started with 5 of 10, got %+v", *last)
+	}
+	if history[1].State != Defined || history[1].Elapsed != period || history[1].Count != period/2 {
+		t.Errorf("Expected the second period defined with 10 of 20, got %+v", *history[1])
+	}
+	if Started.String() != "STARTED" || LockedIn.String() != "LOCKED_IN" {
+		t.Errorf("Expected BIP9 names, got %v %v", Started, LockedIn)
+	}
+}
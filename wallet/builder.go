@@ -0,0 +1,238 @@
+// Package wallet builds and signs transactions from a set of spendable outputs.
+package wallet
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/fees"
+	"github.com/ravdin/programmingbitcoin/policy"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// Default fee rates of Bitcoin Core's wallet.
+const (
+	// DefaultLongTermFeeRate is the fee rate coins are expected to be spent at eventually.
+	DefaultLongTermFeeRate = 10000
+	// DefaultDiscardFeeRate is the fee rate change is expected to be spent at.
+	DefaultDiscardFeeRate = 10000
+)
+
+// Sequences of the inputs of built transactions, which don't disable the lock time.
+const (
+	// SequenceReplaceable signals replacement (BIP125).
+	SequenceReplaceable = tx.SequenceFinal - 2
+	// SequenceNonReplaceable doesn't.
+	SequenceNonReplaceable = tx.SequenceFinal - 1
+)
+
+// Utxo is an unspent output that a Builder can spend.
+type Utxo struct {
+	PrevTx    []byte
+	PrevIndex int
+	Output    *tx.Output
+	// RedeemScript is the redeem script of a p2sh output or the witness script of a p2wsh output.
+	RedeemScript *script.Script
+}
+
+// Builder builds transactions paying outputs from a set of UTXOs:
+// it selects the coins, adds change and signs every input.
+type Builder struct {
+	Utxos   []*Utxo
+	Outputs []*tx.Output
+	// FeeRate is the fee rate to pay, LongTermFeeRate and DiscardFeeRate
+	// are the rates of spending the coins and the change later.
+	FeeRate         fees.FeeRate
+	LongTermFeeRate fees.FeeRate
+	DiscardFeeRate  fees.FeeRate
+	// ChangeScript is the ScriptPubKey of the change output.
+	ChangeScript *script.Script
+	// Keys sign the inputs.
+	Keys    []*ecc.PrivateKey
+	Version uint32
+	// Height is the height of the best block, the lock time discourages fee sniping
+	// by making the transaction invalid in a block replacing it. 0 leaves the lock time at 0.
+	Height      int
+	Replaceable bool
+	Testnet     bool
+	// Policy decides which outputs are dust.
+	Policy *policy.Policy
+	Rand   *rand.Rand
+}
+
+// NewBuilder returns a builder with Bitcoin Core's defaults:
+// version 2 transactions that signal replacement.
+func NewBuilder(utxos []*Utxo, outputs []*tx.Output, feeRate fees.FeeRate, changeScript *script.Script, keys ...*ecc.PrivateKey) *Builder {
+	var seed [8]byte
+	crand.Read(seed[:])
+	return &Builder{
+		Utxos:           utxos,
+		Outputs:         outputs,
+		FeeRate:         feeRate,
+		LongTermFeeRate: DefaultLongTermFeeRate,
+		DiscardFeeRate:  DefaultDiscardFeeRate,
+		ChangeScript:    changeScript,
+		Keys:            keys,
+		Version:         2,
+		Replaceable:     true,
+		Policy:          policy.NewPolicy(),
+		Rand:            rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+	}
+}
+
+// inputWeight returns the weight of a signed input spending a UTXO,
+// with the empty witness a legacy input has in a segwit transaction.
+func inputWeight(utxo *Utxo) (int, error) {
+	in, err := fees.InputFor(utxo.Output.ScriptPubKey, utxo.RedeemScript)
+	if err != nil {
+		return 0, err
+	}
+	result := in.Weight()
+	if !in.HasWitness() {
+		result++
+	}
+	return result, nil
+}
+
+func vsize(weight int) int {
+	return (weight + tx.WitnessScaleFactor - 1) / tx.WitnessScaleFactor
+}
+
+// Build selects coins, adds change if it isn't dust, and returns the signed transaction
+// with the inputs and outputs in random order.
+func (b *Builder) Build() (*tx.Transaction, error) {
+	if len(b.Outputs) == 0 {
+		return nil, errors.New("no outputs")
+	}
+	var payment int64
+	for i, txOut := range b.Outputs {
+		if b.Policy.IsDust(txOut) {
+			return nil, fmt.Errorf("output %d is dust", i)
+		}
+		payment += int64(txOut.Amount)
+	}
+	coins := make([]*coin, len(b.Utxos))
+	for i, utxo := range b.Utxos {
+		weight, err := inputWeight(utxo)
+		if err != nil {
+			return nil, fmt.Errorf("utxo %x:%d: %v", utxo.PrevTx, utxo.PrevIndex, err)
+		}
+		fee := int64(b.FeeRate.Fee(vsize(weight)))
+		coins[i] = &coin{
+			utxo:           utxo,
+			fee:            fee,
+			longTermFee:    int64(b.LongTermFeeRate.Fee(vsize(weight))),
+			effectiveValue: int64(utxo.Output.Amount) - fee,
+		}
+	}
+	// everything but the inputs, with the segwit marker and flag in case
+	notInputWeight := fees.EstimateWeight(nil, b.Outputs) + 2
+	target := payment + int64(b.FeeRate.Fee(vsize(notInputWeight)))
+	change := tx.NewOutput(0, b.ChangeScript)
+	changeFee := int64(b.FeeRate.Fee(len(change.Serialize())))
+	changeWeight, err := inputWeight(&Utxo{Output: change})
+	if err != nil {
+		return nil, fmt.Errorf("change: %v", err)
+	}
+	changeSpendFee := int64(b.DiscardFeeRate.Fee(vsize(changeWeight)))
+	minViableChange := changeSpendFee + 1
+	if dust := int64(b.Policy.DustThreshold(change)); dust > minViableChange {
+		minViableChange = dust
+	}
+	selected, err := selectCoins(coins, target, payment, changeFee, changeFee+changeSpendFee, minViableChange, b.Rand)
+	if err != nil {
+		return nil, err
+	}
+	return b.assemble(selected, change, minViableChange)
+}
+
+// assemble builds and signs the transaction spending the selected coins.
+func (b *Builder) assemble(selected *selection, change *tx.Output, minViableChange int64) (*tx.Transaction, error) {
+	b.Rand.Shuffle(len(selected.coins), func(i, j int) {
+		selected.coins[i], selected.coins[j] = selected.coins[j], selected.coins[i]
+	})
+	sequence := uint32(SequenceNonReplaceable)
+	if b.Replaceable {
+		sequence = SequenceReplaceable
+	}
+	var txIns []*tx.Input
+	var inputs []fees.Input
+	var total uint64
+	for _, c := range selected.coins {
+		txIns = append(txIns, tx.NewInput(c.utxo.PrevTx, c.utxo.PrevIndex, nil, sequence))
+		in, _ := fees.InputFor(c.utxo.Output.ScriptPubKey, c.utxo.RedeemScript)
+		inputs = append(inputs, in)
+		total += c.utxo.Output.Amount
+	}
+	txOuts := make([]*tx.Output, len(b.Outputs))
+	var payment uint64
+	for i, txOut := range b.Outputs {
+		txOuts[i] = tx.NewOutput(txOut.Amount, txOut.ScriptPubKey)
+		payment += txOut.Amount
+	}
+	fee := b.FeeRate.Fee(fees.EstimateVSize(inputs, txOuts))
+	if total < payment+fee {
+		return nil, errInsufficientFunds
+	}
+	feeWithChange := b.FeeRate.Fee(fees.EstimateVSize(inputs, append(txOuts, change)))
+	if total >= payment+feeWithChange && int64(total-payment-feeWithChange) >= minViableChange {
+		change.Amount = total - payment - feeWithChange
+		txOuts = append(txOuts, change)
+	}
+	b.Rand.Shuffle(len(txOuts), func(i, j int) { txOuts[i], txOuts[j] = txOuts[j], txOuts[i] })
+	result := tx.NewTransaction(b.Version, txIns, txOuts, b.lockTime(), b.Testnet)
+	utxos := make([]*Utxo, len(selected.coins))
+	for i, c := range selected.coins {
+		utxos[i] = c.utxo
+	}
+	if err := signAll(b.Keys, result, utxos); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lockTime returns the height of the best block, or sometimes up to 100 blocks earlier,
+// so transactions that are slow to confirm don't stand out (anti-fee-sniping).
+func (b *Builder) lockTime() uint32 {
+	if b.Height <= 0 {
+		return 0
+	}
+	result := b.Height
+	if b.Rand.Intn(10) == 0 {
+		result -= b.Rand.Intn(100)
+		if result < 0 {
+			result = 0
+		}
+	}
+	return uint32(result)
+}
+
+// isMine returns whether a key can sign for a single key scriptPubKey.
+func isMine(keys []*ecc.PrivateKey, scriptPubKey *script.Script) bool {
+	return tx.IsMine(tx.NewKeystore(keys...), scriptPubKey)
+}
+
+// signAll signs every input of a transaction spending utxos with the keys.
+func signAll(keys []*ecc.PrivateKey, txObj *tx.Transaction, utxos []*Utxo) error {
+	keystore := tx.NewKeystore(keys...)
+	provider := tx.NewMemoryProvider()
+	for _, utxo := range utxos {
+		provider.AddOutput(utxo.PrevTx, utxo.PrevIndex, utxo.Output)
+		if utxo.RedeemScript != nil {
+			keystore.AddScript(utxo.RedeemScript)
+		}
+	}
+	unsigned, err := txObj.SignAll(provider, keystore)
+	if err != nil {
+		return err
+	}
+	if len(unsigned) > 0 {
+		return fmt.Errorf("inputs %v aren't signed", unsigned)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/fees"
+	"github.com/ravdin/programmingbitcoin/policy"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+)
+
+// Bumper raises the fee rate of stuck transactions, by replacing them (BIP125)
+// or by spending their change in a child that pays for both (CPFP).
+type Bumper struct {
+	// Provider looks up the outputs the transactions spend.
+	Provider tx.PrevoutProvider
+	Keys     []*ecc.PrivateKey
+	// Confirmed are coins to add when the change can't pay the fee. They have to be confirmed:
+	// a replacement can't spend unconfirmed outputs its original doesn't (BIP125 rule 2).
+	Confirmed []*Utxo
+	// ChangeScript receives the output of a child, and the change of a replacement without any.
+	ChangeScript *script.Script
+	Policy       *policy.Policy
+}
+
+// NewBumper returns a bumper with the default policy.
+func NewBumper(provider tx.PrevoutProvider, changeScript *script.Script, keys ...*ecc.PrivateKey) *Bumper {
+	return &Bumper{
+		Provider:     provider,
+		Keys:         keys,
+		ChangeScript: changeScript,
+		Policy:       policy.NewPolicy(),
+	}
+}
+
+// ReplaceByFee returns a replacement of a transaction paying at least feeRate, re-signed.
+// The fee comes out of the change, the last output the keys can spend, and confirmed coins
+// are added if there isn't enough. The replacement pays a higher fee and fee rate than the original
+// and the relay of its own size (BIP125 rules 3, 4 and 6).
+func (b *Bumper) ReplaceByFee(original *tx.Transaction, feeRate fees.FeeRate) (*tx.Transaction, error) {
+	if !policy.SignalsReplacement(original) {
+		return nil, errors.New("transaction doesn't signal replacement")
+	}
+	originalFee, err := original.Fee(b.Provider)
+	if err != nil {
+		return nil, err
+	}
+	originalVSize := original.VSize()
+	utxos, err := b.spent(original)
+	if err != nil {
+		return nil, err
+	}
+	// the fee for a size that meets the fee rate and BIP125
+	required := func(vsize int) uint64 {
+		fee := feeRate.Fee(vsize)
+		if replaced := originalFee + fees.FeeRate(b.Policy.IncrementalRelayFeeRate).Fee(vsize); replaced > fee {
+			fee = replaced
+		}
+		if higherRate := originalFee*uint64(vsize)/uint64(originalVSize) + 1; higherRate > fee {
+			fee = higherRate
+		}
+		return fee
+	}
+	changeIndex := b.changeIndex(original.Outputs)
+	var txOuts []*tx.Output
+	var payment uint64
+	for i, txOut := range original.Outputs {
+		if i != changeIndex {
+			txOuts = append(txOuts, tx.NewOutput(txOut.Amount, txOut.ScriptPubKey))
+			payment += txOut.Amount
+		}
+	}
+	change := tx.NewOutput(0, b.ChangeScript)
+	if changeIndex >= 0 {
+		change.ScriptPubKey = original.Outputs[changeIndex].ScriptPubKey
+	} else {
+		changeIndex = len(txOuts)
+	}
+	withChange := make([]*tx.Output, len(txOuts)+1)
+	copy(withChange, txOuts[:changeIndex])
+	withChange[changeIndex] = change
+	copy(withChange[changeIndex+1:], txOuts[changeIndex:])
+	confirmed := b.confirmed(utxos)
+	for {
+		inputs, total, err := estimateInputs(utxos)
+		if err != nil {
+			return nil, err
+		}
+		if change.ScriptPubKey != nil {
+			fee := required(fees.EstimateVSize(inputs, withChange))
+			if total >= payment+fee && total-payment-fee >= b.Policy.DustThreshold(change) {
+				change.Amount = total - payment - fee
+				return b.replacement(original, originalFee, utxos, withChange)
+			}
+		}
+		// without change the excess goes to the fee
+		if total >= payment+required(fees.EstimateVSize(inputs, txOuts)) {
+			return b.replacement(original, originalFee, utxos, txOuts)
+		}
+		if len(confirmed) == 0 {
+			return nil, errInsufficientFunds
+		}
+		utxos, confirmed = append(utxos, confirmed[0]), confirmed[1:]
+	}
+}
+
+// replacement signs the replacement of a transaction and checks it against BIP125.
+func (b *Bumper) replacement(original *tx.Transaction, originalFee uint64, utxos []*Utxo, txOuts []*tx.Output) (*tx.Transaction, error) {
+	txIns := make([]*tx.Input, len(utxos))
+	for i, utxo := range utxos {
+		sequence := uint32(SequenceReplaceable)
+		if i < len(original.Inputs) {
+			sequence = original.Inputs[i].Sequence
+		}
+		txIns[i] = tx.NewInput(utxo.PrevTx, utxo.PrevIndex, nil, sequence)
+	}
+	result := tx.NewTransaction(original.Version, txIns, txOuts, original.Locktime, original.Testnet)
+	fee, err := b.signAll(result, utxos)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Policy.CheckReplacement(result, fee, []*policy.Replaced{{Tx: original, Fee: originalFee}}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ChildPaysForParent returns a transaction spending the change of a parent so that
+// the two pay feeRate together, adding confirmed coins if the change isn't enough.
+// Its output pays to the change script.
+func (b *Bumper) ChildPaysForParent(parent *tx.Transaction, feeRate fees.FeeRate) (*tx.Transaction, error) {
+	if b.ChangeScript == nil {
+		return nil, errors.New("no change script")
+	}
+	parentFee, err := parent.Fee(b.Provider)
+	if err != nil {
+		return nil, err
+	}
+	parentVSize := parent.VSize()
+	if fees.NewFeeRate(parentFee, parentVSize) >= feeRate {
+		return nil, fmt.Errorf("parent already pays %s", fees.NewFeeRate(parentFee, parentVSize))
+	}
+	changeIndex := b.changeIndex(parent.Outputs)
+	if changeIndex < 0 {
+		return nil, errors.New("parent has no output to spend")
+	}
+	utxos := []*Utxo{{PrevTx: parent.Hash(), PrevIndex: changeIndex, Output: parent.Outputs[changeIndex]}}
+	output := tx.NewOutput(0, b.ChangeScript)
+	confirmed := b.confirmed(nil)
+	for {
+		inputs, total, err := estimateInputs(utxos)
+		if err != nil {
+			return nil, err
+		}
+		vsize := fees.EstimateVSize(inputs, []*tx.Output{output})
+		// the child pays for the package, and at least relays on its own
+		fee := fees.FeeRate(b.Policy.MinRelayFeeRate).Fee(vsize)
+		if packageFee := feeRate.Fee(parentVSize + vsize); packageFee > parentFee+fee {
+			fee = packageFee - parentFee
+		}
+		if total >= fee && total-fee >= b.Policy.DustThreshold(output) {
+			output.Amount = total - fee
+			break
+		}
+		if len(confirmed) == 0 {
+			return nil, errInsufficientFunds
+		}
+		utxos, confirmed = append(utxos, confirmed[0]), confirmed[1:]
+	}
+	txIns := make([]*tx.Input, len(utxos))
+	for i, utxo := range utxos {
+		txIns[i] = tx.NewInput(utxo.PrevTx, utxo.PrevIndex, nil, SequenceReplaceable)
+	}
+	result := tx.NewTransaction(2, txIns, []*tx.Output{output}, 0, parent.Testnet)
+	if _, err := b.signAll(result, utxos); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// spent returns the outputs a transaction spends, with the redeem scripts its inputs reveal.
+func (b *Bumper) spent(t *tx.Transaction) ([]*Utxo, error) {
+	result := make([]*Utxo, len(t.Inputs))
+	for i, txIn := range t.Inputs {
+		output, err := b.Provider.Prevout(txIn.PrevTx, txIn.PrevIndex, t.Testnet)
+		if err != nil {
+			return nil, err
+		}
+		redeemScript, err := revealedScript(txIn, output.ScriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		result[i] = &Utxo{PrevTx: txIn.PrevTx, PrevIndex: txIn.PrevIndex, Output: output, RedeemScript: redeemScript}
+	}
+	return result, nil
+}
+
+// revealedScript returns the redeem script of a p2sh input or the witness script of a p2wsh input.
+func revealedScript(txIn *tx.Input, scriptPubKey *script.Script) (*script.Script, error) {
+	var raw []byte
+	if version, program, ok := scriptPubKey.WitnessProgram(); ok {
+		if version != 0 || len(program) != 32 {
+			return nil, nil
+		}
+		if len(txIn.Witness) > 0 {
+			raw = txIn.Witness[len(txIn.Witness)-1]
+		}
+	} else if scriptPubKey.IsP2shScriptPubKey() {
+		if txIn.ScriptSig != nil && txIn.ScriptSig.Len() > 0 {
+			raw = txIn.ScriptSig.Peek(txIn.ScriptSig.Len() - 1)
+		}
+	} else {
+		return nil, nil
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("input isn't signed")
+	}
+	return script.ParseRaw(raw)
+}
+
+// changeIndex returns the index of the last output the keys can spend, or -1 if there isn't one.
+func (b *Bumper) changeIndex(txOuts []*tx.Output) int {
+	for i := len(txOuts) - 1; i >= 0; i-- {
+		if isMine(b.Keys, txOuts[i].ScriptPubKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+// confirmed returns the confirmed coins that aren't already spent, largest first.
+func (b *Bumper) confirmed(spent []*Utxo) []*Utxo {
+	spends := make(map[string]bool, len(spent))
+	for _, utxo := range spent {
+		spends[fmt.Sprintf("%x:%d", utxo.PrevTx, utxo.PrevIndex)] = true
+	}
+	var result []*Utxo
+	for _, utxo := range b.Confirmed {
+		if !spends[fmt.Sprintf("%x:%d", utxo.PrevTx, utxo.PrevIndex)] {
+			result = append(result, utxo)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Output.Amount > result[j].Output.Amount
+	})
+	return result
+}
+
+// signAll signs every input of a transaction spending utxos and returns its fee.
+func (b *Bumper) signAll(t *tx.Transaction, utxos []*Utxo) (uint64, error) {
+	if err := signAll(b.Keys, t, utxos); err != nil {
+		return 0, err
+	}
+	provider := tx.NewMemoryProvider()
+	for _, utxo := range utxos {
+		provider.AddOutput(utxo.PrevTx, utxo.PrevIndex, utxo.Output)
+	}
+	return t.Fee(provider)
+}
+
+// estimateInputs returns the inputs for estimating the size of a transaction spending utxos,
+// and their total.
+func estimateInputs(utxos []*Utxo) ([]fees.Input, uint64, error) {
+	inputs := make([]fees.Input, len(utxos))
+	var total uint64
+	for i, utxo := range utxos {
+		in, err := fees.InputFor(utxo.Output.ScriptPubKey, utxo.RedeemScript)
+		if err != nil {
+			return nil, 0, fmt.Errorf("utxo %x:%d: %v", utxo.PrevTx, utxo.PrevIndex, err)
+		}
+		inputs[i] = in
+		total += utxo.Output.Amount
+	}
+	return inputs, total, nil
+}
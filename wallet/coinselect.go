@@ -0,0 +1,279 @@
+package wallet
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// Settings of Bitcoin Core's coin selection.
+const (
+	// bnbTotalTries is how many branches Branch and Bound explores.
+	bnbTotalTries = 100000
+	// knapsackIterations is how many random subsets the knapsack solver tries.
+	knapsackIterations = 1000
+	// changeLower and changeUpper bound the random change target of the knapsack solver
+	// and single random draw.
+	changeLower = 50000
+	changeUpper = 1000000
+)
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+// coin is a UTXO with the fees to spend it at the fee rate and at the long term fee rate.
+type coin struct {
+	utxo           *Utxo
+	fee            int64
+	longTermFee    int64
+	effectiveValue int64
+}
+
+// selection is a set of coins picked by one of the algorithms.
+type selection struct {
+	coins     []*coin
+	algorithm string
+	waste     int64
+}
+
+func (s *selection) effectiveValue() int64 {
+	var result int64
+	for _, c := range s.coins {
+		result += c.effectiveValue
+	}
+	return result
+}
+
+// computeWaste sets the waste metric of a selection, like Bitcoin Core:
+// the cost of spending the coins now rather than at the long term fee rate,
+// plus the cost of the change output if there is one, or the excess given to the fee if there isn't.
+// target is the amount to pay with the fees of everything but the inputs and change.
+func (s *selection) computeWaste(target, changeFee, costOfChange, minViableChange int64) {
+	s.waste = 0
+	for _, c := range s.coins {
+		s.waste += c.fee - c.longTermFee
+	}
+	if excess := s.effectiveValue() - target; excess-changeFee >= minViableChange {
+		s.waste += costOfChange
+	} else {
+		s.waste += excess
+	}
+}
+
+// selectBnB runs Branch and Bound: a depth first search for a set of coins that pays
+// the target without change, at most costOfChange over it, with the least waste.
+// The coins must have positive effective values.
+func selectBnB(coins []*coin, target, costOfChange int64) (*selection, error) {
+	pool := make([]*coin, len(coins))
+	copy(pool, coins)
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].effectiveValue > pool[j].effectiveValue
+	})
+	var availableValue int64
+	for _, c := range pool {
+		availableValue += c.effectiveValue
+	}
+	if availableValue < target {
+		return nil, errInsufficientFunds
+	}
+	if len(pool) == 0 {
+		return nil, errors.New("no solution without change")
+	}
+	// when fees are high it pays to spend fewer coins, so more waste can be pruned
+	feeRateHigh := pool[0].fee > pool[0].longTermFee
+	var currentValue, currentWaste int64
+	bestWaste := int64(-1)
+	var current, best []int
+	index := 0
+	for try := 0; try < bnbTotalTries; try, index = try+1, index+1 {
+		backtrack := false
+		switch {
+		case currentValue+availableValue < target,
+			currentValue > target+costOfChange,
+			bestWaste >= 0 && currentWaste > bestWaste && feeRateHigh:
+			backtrack = true
+		case currentValue >= target:
+			// the excess is wasted on the fee
+			if waste := currentWaste + currentValue - target; bestWaste < 0 || waste <= bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+		if backtrack {
+			if len(current) == 0 {
+				break
+			}
+			// put the coins after the last included one back, and exclude it instead
+			for index--; index > current[len(current)-1]; index-- {
+				availableValue += pool[index].effectiveValue
+			}
+			c := pool[index]
+			currentValue -= c.effectiveValue
+			currentWaste -= c.fee - c.longTermFee
+			current = current[:len(current)-1]
+			continue
+		}
+		c := pool[index]
+		availableValue -= c.effectiveValue
+		// including a coin equal to an excluded previous one is a branch already explored
+		if len(current) == 0 || index-1 == current[len(current)-1] ||
+			c.effectiveValue != pool[index-1].effectiveValue || c.fee != pool[index-1].fee {
+			current = append(current, index)
+			currentValue += c.effectiveValue
+			currentWaste += c.fee - c.longTermFee
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no solution without change")
+	}
+	result := &selection{algorithm: "bnb"}
+	for _, i := range best {
+		result.coins = append(result.coins, pool[i])
+	}
+	return result, nil
+}
+
+// selectKnapsack runs the knapsack solver: an exact match if there is one,
+// otherwise the smallest coin over the target and change target,
+// or the closest random subset of the smaller coins.
+func selectKnapsack(coins []*coin, target, changeTarget int64, rng *rand.Rand) (*selection, error) {
+	pool := make([]*coin, len(coins))
+	copy(pool, coins)
+	rng.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	var applicable []*coin
+	var totalLower int64
+	var lowestLarger *coin
+	for _, c := range pool {
+		switch {
+		case c.effectiveValue == target:
+			return &selection{coins: []*coin{c}, algorithm: "knapsack"}, nil
+		case c.effectiveValue < target+changeTarget:
+			applicable = append(applicable, c)
+			totalLower += c.effectiveValue
+		case lowestLarger == nil || c.effectiveValue < lowestLarger.effectiveValue:
+			lowestLarger = c
+		}
+	}
+	if totalLower == target {
+		return &selection{coins: applicable, algorithm: "knapsack"}, nil
+	}
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil, errInsufficientFunds
+		}
+		return &selection{coins: []*coin{lowestLarger}, algorithm: "knapsack"}, nil
+	}
+	sort.SliceStable(applicable, func(i, j int) bool {
+		return applicable[i].effectiveValue > applicable[j].effectiveValue
+	})
+	included, bestValue := approximateBestSubset(applicable, totalLower, target, rng)
+	if bestValue != target && totalLower >= target+changeTarget {
+		included, bestValue = approximateBestSubset(applicable, totalLower, target+changeTarget, rng)
+	}
+	// the larger coin wins if no subset is close enough or it is closer
+	if lowestLarger != nil && ((bestValue != target && bestValue < target+changeTarget) || lowestLarger.effectiveValue <= bestValue) {
+		return &selection{coins: []*coin{lowestLarger}, algorithm: "knapsack"}, nil
+	}
+	result := &selection{algorithm: "knapsack"}
+	for i, c := range applicable {
+		if included[i] {
+			result.coins = append(result.coins, c)
+		}
+	}
+	return result, nil
+}
+
+// approximateBestSubset returns the subset of coins found over a number of random tries
+// with the smallest total that reaches the target.
+func approximateBestSubset(coins []*coin, totalLower, target int64, rng *rand.Rand) ([]bool, int64) {
+	best := make([]bool, len(coins))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := totalLower
+	for rep := 0; rep < knapsackIterations && bestValue != target; rep++ {
+		included := make([]bool, len(coins))
+		var total int64
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, c := range coins {
+				// a random subset on the first pass, everything left on the second
+				if (pass == 0 && rng.Intn(2) == 0) || (pass == 1 && !included[i]) {
+					total += c.effectiveValue
+					included[i] = true
+					if total >= target {
+						reachedTarget = true
+						if total < bestValue {
+							bestValue = total
+							copy(best, included)
+						}
+						total -= c.effectiveValue
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+	return best, bestValue
+}
+
+// selectSRD runs single random draw: coins in random order until they pay the target,
+// the change fee and the lowest change.
+func selectSRD(coins []*coin, target, changeFee int64, rng *rand.Rand) (*selection, error) {
+	target += changeFee + changeLower
+	result := &selection{algorithm: "srd"}
+	var value int64
+	for _, i := range rng.Perm(len(coins)) {
+		result.coins = append(result.coins, coins[i])
+		value += coins[i].effectiveValue
+		if value >= target {
+			return result, nil
+		}
+	}
+	return nil, errInsufficientFunds
+}
+
+// changeTarget returns a random amount of change to aim for, so change outputs
+// don't give away which output is the payment.
+func changeTarget(payment, changeFee int64, rng *rand.Rand) int64 {
+	if payment <= changeLower/2 {
+		return changeFee + changeLower
+	}
+	upper := payment * 2
+	if upper > changeUpper {
+		upper = changeUpper
+	}
+	return changeFee + rng.Int63n(upper-changeLower) + changeLower
+}
+
+// selectCoins runs the three algorithms and returns the selection with the least waste,
+// the one with more coins if they are even.
+func selectCoins(coins []*coin, target, payment, changeFee, costOfChange, minViableChange int64, rng *rand.Rand) (*selection, error) {
+	var positive []*coin
+	for _, c := range coins {
+		if c.effectiveValue > 0 {
+			positive = append(positive, c)
+		}
+	}
+	var results []*selection
+	if result, err := selectBnB(positive, target, costOfChange); err == nil {
+		results = append(results, result)
+	}
+	if result, err := selectKnapsack(positive, target+changeFee, changeTarget(payment, changeFee, rng), rng); err == nil {
+		results = append(results, result)
+	}
+	if result, err := selectSRD(positive, target, changeFee, rng); err == nil {
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, errInsufficientFunds
+	}
+	var best *selection
+	for _, result := range results {
+		result.computeWaste(target, changeFee, costOfChange, minViableChange)
+		if best == nil || result.waste < best.waste || (result.waste == best.waste && len(result.coins) > len(best.coins)) {
+			best = result
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,331 @@
+package wallet
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ravdin/programmingbitcoin/ecc"
+	"github.com/ravdin/programmingbitcoin/fees"
+	"github.com/ravdin/programmingbitcoin/policy"
+	"github.com/ravdin/programmingbitcoin/script"
+	"github.com/ravdin/programmingbitcoin/tx"
+	"github.com/ravdin/programmingbitcoin/util"
+)
+
+func testCoins(values ...int64) []*coin {
+	result := make([]*coin, len(values))
+	for i, value := range values {
+		result[i] = &coin{effectiveValue: value, fee: 100, longTermFee: 100}
+	}
+	return result
+}
+
+func selectedValues(s *selection) map[int64]int {
+	result := make(map[int64]int)
+	for _, c := range s.coins {
+		result[c.effectiveValue]++
+	}
+	return result
+}
+
+func TestCoinSelection(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	t.Run("Test branch and bound", func(t *testing.T) {
+		coins := testCoins(100000, 200000, 300000, 400000, 1000)
+		result, err := selectBnB(coins, 501000, 500)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual := result.effectiveValue(); actual != 501000 {
+			t.Errorf("Expected an exact match, got %d", actual)
+		}
+		if _, err := selectBnB(coins, 150000, 500); err == nil {
+			t.Errorf("Expected no solution without change")
+		}
+		if _, err := selectBnB(coins, 2000000, 500); err != errInsufficientFunds {
+			t.Errorf("Expected insufficient funds, got %v", err)
+		}
+		// the excess over the target counts as waste
+		result, err = selectBnB(testCoins(100300, 100100), 100000, 500)
+		if err != nil || result.effectiveValue() != 100100 {
+			t.Errorf("Expected the coin closer to the target, got %v", err)
+		}
+	})
+
+	t.Run("Test knapsack", func(t *testing.T) {
+		coins := testCoins(30000, 70000, 150000, 5000000)
+		result, err := selectKnapsack(coins, 100000, 50000, rng)
+		if err != nil || result.effectiveValue() != 100000 {
+			t.Fatalf("Expected an exact subset, got %v", err)
+		}
+		result, err = selectKnapsack(coins, 70000, 50000, rng)
+		if err != nil || result.effectiveValue() != 70000 {
+			t.Fatalf("Expected an exact coin, got %v", err)
+		}
+		result, err = selectKnapsack(testCoins(10000, 20000, 5000000), 100000, 50000, rng)
+		if err != nil || result.effectiveValue() != 5000000 {
+			t.Fatalf("Expected the larger coin, got %v", err)
+		}
+		result, err = selectKnapsack(testCoins(60000, 70000, 80000, 5000000), 130000, 50000, rng)
+		if err != nil || result.effectiveValue() != 130000 {
+			t.Fatalf("Expected the subset closest to the target, got %v", err)
+		}
+		if _, err := selectKnapsack(testCoins(10000, 20000), 100000, 50000, rng); err != errInsufficientFunds {
+			t.Errorf("Expected insufficient funds, got %v", err)
+		}
+	})
+
+	t.Run("Test single random draw", func(t *testing.T) {
+		coins := testCoins(10000, 20000, 30000, 100000)
+		result, err := selectSRD(coins, 50000, 100, rng)
+		if err != nil || result.effectiveValue() < 100100 {
+			t.Fatalf("Expected enough for the change, got %v", err)
+		}
+		if _, err := selectSRD(coins, 120000, 100, rng); err != errInsufficientFunds {
+			t.Errorf("Expected insufficient funds, got %v", err)
+		}
+	})
+
+	t.Run("Test least waste", func(t *testing.T) {
+		coins := testCoins(100000, 200000, 300000, 400000, 1000, -50)
+		result, err := selectCoins(coins, 501000, 500000, 300, 800, 400, rng)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.algorithm != "bnb" || result.waste != 0 {
+			t.Errorf("Expected the exact match without waste, got %s with %d", result.algorithm, result.waste)
+		}
+		for value := range selectedValues(result) {
+			if value <= 0 {
+				t.Errorf("Expected no coins with a negative effective value")
+			}
+		}
+		if _, err := selectCoins(testCoins(1000, -50), 5000, 4000, 300, 800, 400, rng); err != errInsufficientFunds {
+			t.Errorf("Expected insufficient funds, got %v", err)
+		}
+	})
+}
+
+func TestBuilder(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(8001)),
+		ecc.NewPrivateKey(big.NewInt(8002)),
+		ecc.NewPrivateKey(big.NewInt(8003)),
+	}
+	pk := keys[0]
+	h160 := pk.Point.Hash160(true)
+	p2wpkh := script.P2wpkhScript(h160)
+	multisig, _ := script.MultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	outputKey, _ := script.TaprootOutputKey(pk.EvenY().Point, nil)
+	prevTx := util.Hash256([]byte("builder test"))
+	utxos := []*Utxo{
+		{prevTx, 0, tx.NewOutput(30000, script.P2pkhScript(h160)), nil},
+		{prevTx, 1, tx.NewOutput(40000, p2wpkh), nil},
+		{prevTx, 2, tx.NewOutput(50000, script.P2shScript(util.Hash160(p2wpkh.RawSerialize()))), p2wpkh},
+		{prevTx, 3, tx.NewOutput(60000, script.P2trScript(outputKey.XOnly())), nil},
+		{prevTx, 4, tx.NewOutput(70000, script.P2shScript(util.Hash160(multisig.RawSerialize()))), multisig},
+		{prevTx, 5, tx.NewOutput(80000, script.P2wshScript(util.Sha256(multisig.RawSerialize()))), multisig},
+	}
+	provider := tx.NewMemoryProvider()
+	for _, utxo := range utxos {
+		provider.AddOutput(utxo.PrevTx, utxo.PrevIndex, utxo.Output)
+	}
+	payee := script.P2wpkhScript(keys[2].Point.Hash160(true))
+	changeScript := script.P2trScript(outputKey.XOnly())
+	newBuilder := func(amount uint64, seed int64) *Builder {
+		b := NewBuilder(utxos, []*tx.Output{tx.NewOutput(amount, payee)}, fees.SatPerVByte(5), changeScript, keys[:2]...)
+		b.Rand = rand.New(rand.NewSource(seed))
+		b.Height = 800000
+		return b
+	}
+
+	t.Run("Test signing every input", func(t *testing.T) {
+		b := newBuilder(300000, 1)
+		txObj, err := b.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(txObj.Inputs) != len(utxos) {
+			t.Fatalf("Expected every coin to be spent, got %d inputs", len(txObj.Inputs))
+		}
+		if ok, err := txObj.Verify(provider); !ok {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		rate, err := fees.TxFeeRate(txObj, provider)
+		if err != nil || rate < fees.SatPerVByte(5) {
+			t.Errorf("Expected at least 5 sat/vB, got %s %v", rate, err)
+		}
+		for _, txIn := range txObj.Inputs {
+			if txIn.Sequence != SequenceReplaceable {
+				t.Errorf("Expected sequence %x, got %x", SequenceReplaceable, txIn.Sequence)
+			}
+		}
+		if txObj.Locktime > 800000 || txObj.Locktime < 800000-100 {
+			t.Errorf("Expected a lock time near the height, got %d", txObj.Locktime)
+		}
+	})
+
+	t.Run("Test change", func(t *testing.T) {
+		for seed := int64(0); seed < 3; seed++ {
+			b := newBuilder(100000, seed)
+			txObj, err := b.Build()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ok, err := txObj.Verify(provider); !ok {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			fee, _ := txObj.Fee(provider)
+			for _, txOut := range txObj.Outputs {
+				if b.Policy.IsDust(txOut) {
+					t.Errorf("Expected no dust outputs")
+				}
+			}
+			// without change the fee can't be more than what change would cost
+			if len(txObj.Outputs) == 1 && fee > b.FeeRate.Fee(txObj.VSize())+20000 {
+				t.Errorf("Expected change instead of a fee of %d", fee)
+			}
+		}
+		// the smallest coins pay the largest payment that leaves no change
+		b := newBuilder(30000+40000-fees.SatPerVByte(5).Fee(300), 1)
+		b.Utxos = utxos[:2]
+		b.Replaceable = false
+		b.Height = 0
+		txObj, err := b.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(txObj.Outputs) != 1 {
+			t.Errorf("Expected the change to go to the fee, got %d outputs", len(txObj.Outputs))
+		}
+		if txObj.Locktime != 0 || txObj.Inputs[0].Sequence != SequenceNonReplaceable {
+			t.Errorf("Expected no lock time and no replacement")
+		}
+	})
+
+	t.Run("Test errors", func(t *testing.T) {
+		if _, err := newBuilder(1000000, 1).Build(); err != errInsufficientFunds {
+			t.Errorf("Expected insufficient funds, got %v", err)
+		}
+		if _, err := newBuilder(100, 1).Build(); err == nil {
+			t.Errorf("Expected an error for a dust output")
+		}
+		b := newBuilder(100000, 1)
+		b.Keys = keys[2:]
+		if _, err := b.Build(); err == nil {
+			t.Errorf("Expected an error without the keys")
+		}
+	})
+}
+
+func TestBumper(t *testing.T) {
+	keys := []*ecc.PrivateKey{
+		ecc.NewPrivateKey(big.NewInt(8101)),
+		ecc.NewPrivateKey(big.NewInt(8102)),
+		ecc.NewPrivateKey(big.NewInt(8103)),
+	}
+	p2wpkh := script.P2wpkhScript(keys[0].Point.Hash160(true))
+	multisig, _ := script.MultisigScript(2, []*ecc.S256Point{keys[0].Point, keys[1].Point, keys[2].Point})
+	prevTx := util.Hash256([]byte("bumper test"))
+	utxos := []*Utxo{
+		{prevTx, 0, tx.NewOutput(40000, p2wpkh), nil},
+		{prevTx, 1, tx.NewOutput(70000, script.P2shScript(util.Hash160(multisig.RawSerialize()))), multisig},
+	}
+	confirmed := &Utxo{prevTx, 2, tx.NewOutput(80000, script.P2wshScript(util.Sha256(multisig.RawSerialize()))), multisig}
+	provider := tx.NewMemoryProvider()
+	for _, utxo := range append(utxos, confirmed) {
+		provider.AddOutput(utxo.PrevTx, utxo.PrevIndex, utxo.Output)
+	}
+	payee := tx.NewOutput(100000, script.P2wpkhScript(keys[2].Point.Hash160(true)))
+	build := func(replaceable bool) *tx.Transaction {
+		b := NewBuilder(utxos, []*tx.Output{payee}, fees.SatPerVByte(2), p2wpkh, keys[:2]...)
+		b.Rand = rand.New(rand.NewSource(1))
+		b.Replaceable = replaceable
+		original, err := b.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(original.Outputs) != 2 {
+			t.Fatalf("Expected change, got %d outputs", len(original.Outputs))
+		}
+		return original
+	}
+	original := build(true)
+	originalFee, _ := original.Fee(provider)
+	bumper := NewBumper(provider, p2wpkh, keys[:2]...)
+
+	t.Run("Test replace by fee", func(t *testing.T) {
+		replacement, err := bumper.ReplaceByFee(original, fees.SatPerVByte(20))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ok, err := replacement.Verify(provider); !ok {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if len(replacement.Inputs) != len(original.Inputs) || !policy.SignalsReplacement(replacement) {
+			t.Errorf("Expected the same inputs, signaling replacement")
+		}
+		if rate, _ := fees.TxFeeRate(replacement, provider); rate < fees.SatPerVByte(20) {
+			t.Errorf("Expected at least 20 sat/vB, got %s", rate)
+		}
+		paid := false
+		for _, txOut := range replacement.Outputs {
+			paid = paid || (txOut.Amount == payee.Amount && txOut.ScriptPubKey.String() == payee.ScriptPubKey.String())
+		}
+		if !paid {
+			t.Errorf("Expected the payment to stay the same")
+		}
+		// a fee rate the original already pays still has to go up
+		replacement, err = bumper.ReplaceByFee(original, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if fee, _ := replacement.Fee(provider); fee <= originalFee {
+			t.Errorf("Expected a fee over %d, got %d", originalFee, fee)
+		}
+	})
+
+	t.Run("Test adding confirmed coins", func(t *testing.T) {
+		if _, err := bumper.ReplaceByFee(original, fees.SatPerVByte(100)); err != errInsufficientFunds {
+			t.Fatalf("Expected insufficient funds, got %v", err)
+		}
+		bumper.Confirmed = []*Utxo{utxos[0], confirmed}
+		defer func() { bumper.Confirmed = nil }()
+		replacement, err := bumper.ReplaceByFee(original, fees.SatPerVByte(100))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(replacement.Inputs) != 3 {
+			t.Errorf("Expected the confirmed coin to be added, got %d inputs", len(replacement.Inputs))
+		}
+		if ok, err := replacement.Verify(provider); !ok {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	})
+
+	t.Run("Test child pays for parent", func(t *testing.T) {
+		child, err := bumper.ChildPaysForParent(original, fees.SatPerVByte(15))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		childProvider := tx.NewMemoryProvider(original)
+		if ok, err := child.Verify(childProvider); !ok {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		childFee, _ := child.Fee(childProvider)
+		if rate := fees.NewFeeRate(originalFee+childFee, original.VSize()+child.VSize()); rate < fees.SatPerVByte(15) {
+			t.Errorf("Expected a package fee rate of at least 15 sat/vB, got %s", rate)
+		}
+		if _, err := bumper.ChildPaysForParent(original, fees.SatPerVByte(1)); err == nil {
+			t.Errorf("Expected an error for a parent paying enough")
+		}
+	})
+
+	t.Run("Test no replacement", func(t *testing.T) {
+		if _, err := bumper.ReplaceByFee(build(false), fees.SatPerVByte(20)); err == nil {
+			t.Errorf("Expected an error for a transaction that doesn't signal replacement")
+		}
+	})
+}